@@ -1,19 +1,35 @@
 package main
 
 import (
+	"flag"
+	"net"
 	"net/http"
 	"strconv"
 	"time"
 
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
 	"github.com/golang/glog"
+	"google.golang.org/grpc"
 
 	"imanager/pkg/config"
-	_ "imanager/pkg/db"
+	"imanager/pkg/controllers"
+	"imanager/pkg/db"
+	"imanager/pkg/encrypt"
 	"imanager/pkg/filter"
 	"imanager/pkg/router"
+	authsvc "imanager/pkg/services/auth"
 )
 
 func main() {
+	flag.Parse()
+	config.ApplyFlags()
+	encrypt.Init()
+	// the keys are loaded from the default dir when the packages are initialized
+	if err := authsvc.ReloadSigningKeys(); err != nil {
+		glog.Errorf("load token signing keys failed, err: %v", err)
+	}
+	db.Init()
+
 	port, err := config.GetConfig().Int(config.HttpPortKey)
 	if err != nil {
 		glog.Fatalf("can't get port in config")
@@ -25,6 +41,19 @@ func main() {
 		ReadTimeout:  15 * time.Second,
 	}
 
+	if grpcPort, err := config.GetConfig().Int(config.GrpcPortKey); err == nil {
+		lis, err := net.Listen("tcp", ":"+strconv.Itoa(grpcPort))
+		if err != nil {
+			glog.Fatalf("listen grpc port %v failed, err: %v", grpcPort, err)
+		}
+		grpcServer := grpc.NewServer()
+		authv3.RegisterAuthorizationServer(grpcServer, controllers.ExtAuthzServer{})
+		glog.Infof("Imanager Serve Envoy External Authorization On %v", lis.Addr())
+		go func() {
+			glog.Fatal(grpcServer.Serve(lis))
+		}()
+	}
+
 	glog.Info("Imanager Listen On " + server.Addr)
 	glog.Fatal(server.ListenAndServe())
 }
@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"time"
+
+	"imanager/pkg/api/util"
+)
+
+// PersonalAccessTokenPrefix is used to tell personal access tokens from jwt
+const PersonalAccessTokenPrefix = "imp_"
+
+const (
+	// TokenType of RespToken
+	PersonalAccessTokenType = "personal_access_token"
+)
+
+type PersonalAccessToken struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// Token is only returned when it's created
+	Token       string     `json:"token,omitempty"`
+	TokenPrefix string     `json:"token_prefix"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+	// Role restricts the token to a subset of the user's roles, all roles of the user are used if it's empty
+	Role           []RoleInUser `json:"role,omitempty"`
+	util.BaseModel `json:",inline"`
+}
+
+type PersonalAccessTokenList struct {
+	Count int64                 `json:"count"`
+	Item  []PersonalAccessToken `json:"item,omitempty"`
+}
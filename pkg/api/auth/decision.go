@@ -0,0 +1,70 @@
+package auth
+
+// DecisionURL decides whether the subject is allowed to do the action on the resource, and DecisionsURL decides
+// a batch of requests of the same subject, e.g. all the buttons of a page
+const DecisionURL = "/v1/auth/decision"
+const DecisionsURL = "/v1/auth/decisions"
+
+// the kinds of the rules which make the decisions
+const (
+	// TokenRule denies the action if the token is invalid or its scope doesn't allow the action
+	TokenRule = "token"
+	// OwnerRule allows the subject to do anything on the resource which he owns
+	OwnerRule = "owner"
+	// RoleRule allows the action by a permission of a role of the subject
+	RoleRule = "role"
+	// UserRule denies the action if the user can't login, e.g. he is locked or removed from the identity provider
+	UserRule = "user"
+)
+
+// DecisionSubject is the token, or the name of the user. The token of the caller is used if both are empty
+type DecisionSubject struct {
+	Token string `json:"token,omitempty"`
+	User  string `json:"user,omitempty"`
+}
+
+// DecisionResource is what the action is done on
+type DecisionResource struct {
+	// Type is the resource in the permissions, e.g. user
+	Type string `json:"type"`
+	// Group owns the resource, only the permissions of all groups apply if it's empty
+	Group string `json:"group,omitempty"`
+	// OwnerID is the uuid of the user who owns the resource, not the name which may be reused by a new user
+	OwnerID string `json:"owner_id,omitempty"`
+}
+
+type DecisionItem struct {
+	// Action is the verb in the permissions, e.g. update
+	Action   string           `json:"action"`
+	Resource DecisionResource `json:"resource"`
+}
+
+type ReqDecision struct {
+	Subject DecisionSubject `json:"subject"`
+	DecisionItem
+}
+
+type ReqDecisions struct {
+	Subject DecisionSubject `json:"subject"`
+	Items   []DecisionItem  `json:"items"`
+}
+
+// MatchedRule is the rule which makes the decision
+type MatchedRule struct {
+	Kind       string `json:"kind"`
+	Role       string `json:"role,omitempty"`
+	Permission string `json:"permission,omitempty"`
+	// AllGroups is true if the permission of the role applies to all groups
+	AllGroups bool `json:"all_groups,omitempty"`
+}
+
+type Decision struct {
+	Allowed bool         `json:"allowed"`
+	Reason  string       `json:"reason"`
+	Rule    *MatchedRule `json:"rule,omitempty"`
+}
+
+type DecisionList struct {
+	Subject string     `json:"subject"`
+	Items   []Decision `json:"items"`
+}
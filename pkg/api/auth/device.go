@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+)
+
+// the device authorization grant, see rfc8628
+const OAuthDeviceAuthorizationURL = "/v1/auth/oauth/device_authorization"
+const OAuthDeviceAuthorizationMethod = http.MethodPost
+
+// OAuthDeviceVerificationURL is the page where the user enters the user code and approves the device
+const OAuthDeviceVerificationURL = "/v1/auth/oauth/device"
+
+// OAuthDeviceApprovalURL approves or denies the device by the token of the logged-in user
+const OAuthDeviceApprovalURL = "/v1/auth/oauth/device/approval"
+const OAuthDeviceApprovalMethod = http.MethodPost
+
+const DeviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// the error codes of polling the token endpoint by the device code
+const (
+	AuthorizationPendingError = "authorization_pending"
+	SlowDownError             = "slow_down"
+	ExpiredTokenError         = "expired_token"
+)
+
+// UserCodeCharset is the characters of the user code, the vowels are excluded to avoid words and
+// the ambiguous characters are excluded to be typed easily, see rfc8628 section 6.1
+const UserCodeCharset = "BCDFGHJKLMNPQRSTVWXZ"
+
+// UserCodeLength is the length of the user code without the separator
+const UserCodeLength = 8
+
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// ReqDeviceApproval is the decision of the logged-in user on the device of the user code
+type ReqDeviceApproval struct {
+	UserCode string `json:"user_code"`
+	Approve  bool   `json:"approve"`
+}
+
+// FormatUserCode splits the user code in the middle by a dash, such as WDJB-MJHT
+func FormatUserCode(code string) string {
+	if len(code) != UserCodeLength {
+		return code
+	}
+	return code[:UserCodeLength/2] + "-" + code[UserCodeLength/2:]
+}
+
+// NormalizeUserCode returns the user code typed by the user in upper case without the dashes and spaces
+func NormalizeUserCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, code)
+}
@@ -0,0 +1,20 @@
+package auth
+
+import "testing"
+
+func TestUserCode(t *testing.T) {
+	if FormatUserCode("WDJBMJHT") != "WDJB-MJHT" {
+		t.Logf("format user code failed: %v", FormatUserCode("WDJBMJHT"))
+		t.Fail()
+	}
+	if FormatUserCode("WDJ") != "WDJ" {
+		t.Logf("short user code shouldn't be formatted: %v", FormatUserCode("WDJ"))
+		t.Fail()
+	}
+	for _, v := range []string{"WDJB-MJHT", "wdjb-mjht", " wdjb mjht ", "WDJBMJHT"} {
+		if NormalizeUserCode(v) != "WDJBMJHT" {
+			t.Logf("normalize user code %q failed: %v", v, NormalizeUserCode(v))
+			t.Fail()
+		}
+	}
+}
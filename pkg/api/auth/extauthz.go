@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ExtAuthzURL is the auth_request of nginx to authenticate the requests of the ingress, the original request
+// is passed by the headers. The envoy ext_authz is served by grpc on the grpc port
+const ExtAuthzURL = "/v1/auth/extauthz"
+
+// ExtAuthzMethod is the method of the subrequest of nginx, the method of the original request is in the header
+const ExtAuthzMethod = http.MethodGet
+
+// the headers of the original request in the auth_request of nginx, e.g. proxy_set_header X-Original-URI $request_uri
+const (
+	OriginalURIHeader    = "X-Original-URI"
+	OriginalMethodHeader = "X-Original-Method"
+	OriginalHostHeader   = "X-Original-Host"
+)
+
+// RouteRule allows the requests of the routes, the first rule which matches the route decides the request.
+// The empty hosts or methods match any one, and the empty groups and roles allow any authenticated user
+type RouteRule struct {
+	// Hosts are the hosts without port or the prefixes ending with "*"
+	Hosts []string `json:"hosts,omitempty"`
+	// Paths are the paths or the prefixes ending with "*", e.g. /api/v1/*
+	Paths []string `json:"paths"`
+	// Methods are in upper case, e.g. GET
+	Methods []string `json:"methods,omitempty"`
+	// Public routes needn't the token, the identity is still passed if the token is valid
+	Public bool     `json:"public,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+}
+
+type RouteRules struct {
+	Rules []RouteRule `json:"rules"`
+}
+
+// DefaultRouteRules are used if no rule is configured, any authenticated user can access all the routes
+var DefaultRouteRules = []RouteRule{{Paths: []string{AnyScope}}}
+
+// NormalizeRoutePath returns the cleaned path of the request uri, so that the prefixes of the rules can't be
+// bypassed by the escaped or relative path such as /public/..%2Fadmin
+func NormalizeRoutePath(uri string) (string, error) {
+	if i := strings.IndexAny(uri, "?#"); i >= 0 {
+		uri = uri[:i]
+	}
+	p, err := url.PathUnescape(uri)
+	if err != nil {
+		return "", err
+	}
+	res := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && res != "/" {
+		res += "/"
+	}
+	return res, nil
+}
+
+func (r RouteRule) matchRoute(host, method, path string) bool {
+	if len(r.Hosts) != 0 && !matchPattern(r.Hosts, host) {
+		return false
+	}
+	if len(r.Methods) != 0 && !matchPattern(r.Methods, strings.ToUpper(method)) {
+		return false
+	}
+	return matchPattern(r.Paths, path)
+}
+
+// MatchRoute returns the index of the first rule which matches the route, -1 if there is none
+func MatchRoute(rules []RouteRule, host, method, path string) int {
+	for i, v := range rules {
+		if v.matchRoute(host, method, path) {
+			return i
+		}
+	}
+	return -1
+}
+
+// AllowsSubject is true if the user of the token is in the groups and has any of the roles of the rule
+func (r RouteRule) AllowsSubject(info *RespToken) bool {
+	if len(r.Groups) != 0 && (info.Group == nil || !matchPattern(r.Groups, info.Group.Name)) {
+		return false
+	}
+	if len(r.Roles) == 0 {
+		return true
+	}
+	for _, v := range info.Role {
+		if matchPattern(r.Roles, v.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExtAuthzResult is the result of the request of the ingress, Info is passed to the upstream in the ParseInfo header
+type ExtAuthzResult struct {
+	Status int
+	Reason string
+	Info   *RespToken
+}
+
+func (r ExtAuthzResult) Allowed() bool {
+	return r.Status == http.StatusOK
+}
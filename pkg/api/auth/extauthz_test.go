@@ -0,0 +1,74 @@
+package auth
+
+import "testing"
+
+func TestNormalizeRoutePath(t *testing.T) {
+	cases := map[string]string{
+		"/api/v1/users?name=a":  "/api/v1/users",
+		"/public/../admin":      "/admin",
+		"/public/..%2Fadmin":    "/admin",
+		"//api//v1/":            "/api/v1/",
+		"api":                   "/api",
+		"/":                     "/",
+		"/static/%61pp.js#main": "/static/app.js",
+	}
+	for uri, expect := range cases {
+		if res, err := NormalizeRoutePath(uri); err != nil || res != expect {
+			t.Logf("normalize %v: %v, err: %v, expect: %v", uri, res, err, expect)
+			t.Fail()
+		}
+	}
+	if _, err := NormalizeRoutePath("/api/%zz"); err == nil {
+		t.Logf("invalid escaped path should fail")
+		t.Fail()
+	}
+}
+
+func TestMatchRoute(t *testing.T) {
+	rules := []RouteRule{
+		{Paths: []string{"/healthz", "/static/*"}, Public: true},
+		{Hosts: []string{"admin.*"}, Paths: []string{"*"}, Roles: []string{"admin", "op_service"}},
+		{Paths: []string{"/api/*"}, Methods: []string{"GET"}, Groups: []string{"g1"}},
+	}
+	cases := []struct {
+		host, method, path string
+		expect             int
+	}{
+		{"example.com", "GET", "/healthz", 0},
+		{"example.com", "GET", "/static/app.js", 0},
+		{"admin.example.com", "POST", "/api/users", 1},
+		{"example.com", "get", "/api/users", 2},
+		{"example.com", "POST", "/api/users", -1},
+		{"example.com", "GET", "/healthz/ready", -1},
+	}
+	for _, c := range cases {
+		if i := MatchRoute(rules, c.host, c.method, c.path); i != c.expect {
+			t.Logf("route %v %v%v matches rule %v, expect: %v", c.method, c.host, c.path, i, c.expect)
+			t.Fail()
+		}
+	}
+}
+
+func TestRouteRuleAllowsSubject(t *testing.T) {
+	info := &RespToken{Group: &GroupInUser{Name: "g1"}, Role: []RoleInUser{{Name: "user"}}}
+	cases := []struct {
+		rule   RouteRule
+		expect bool
+	}{
+		{RouteRule{}, true},
+		{RouteRule{Groups: []string{"g1"}}, true},
+		{RouteRule{Groups: []string{"g2"}}, false},
+		{RouteRule{Roles: []string{"admin"}}, false},
+		{RouteRule{Groups: []string{"g*"}, Roles: []string{"admin", "user"}}, true},
+	}
+	for _, c := range cases {
+		if c.rule.AllowsSubject(info) != c.expect {
+			t.Logf("rule: %+v, expect: %v", c.rule, c.expect)
+			t.Fail()
+		}
+	}
+	if (RouteRule{Groups: []string{"*"}}).AllowsSubject(&RespToken{}) {
+		t.Logf("user without group shouldn't be allowed by the groups")
+		t.Fail()
+	}
+}
@@ -7,9 +7,14 @@ type Group struct {
 	Name           string        `json:"name"`
 	Annotation     string        `json:"annotation"`
 	Builtin        bool          `json:"builtin"`
+	MFAPolicy      string        `json:"mfa_policy,omitempty"`
+	TokenPolicy    *TokenPolicy  `json:"token_policy,omitempty"`
 	User           []UserInGroup `json:"user,omitempty"`
 	Role           []RoleInGroup `json:"role,omitempty"`
 	util.BaseModel `json:",inline"`
+
+	// PasswordMaxAge is the days after which the passwords expire, PasswordMaxAge of the config is used if it's 0
+	PasswordMaxAge int `json:"password_max_age,omitempty"`
 }
 
 type GroupList struct {
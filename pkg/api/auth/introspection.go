@@ -0,0 +1,30 @@
+package auth
+
+import "net/http"
+
+const IntrospectTokenURL = "/v1/auth/tokens/introspect"
+const IntrospectTokenMethod = http.MethodPost
+
+const (
+	AccessTokenTypeHint  = "access_token"
+	RefreshTokenTypeHint = "refresh_token"
+)
+
+// IntrospectionResponse is the response of token introspection, see rfc7662
+type IntrospectionResponse struct {
+	Active    bool         `json:"active"`
+	Scope     string       `json:"scope,omitempty"`
+	ClientID  string       `json:"client_id,omitempty"`
+	Username  string       `json:"username,omitempty"`
+	TokenType string       `json:"token_type,omitempty"`
+	Exp       int64        `json:"exp,omitempty"`
+	Iat       int64        `json:"iat,omitempty"`
+	Nbf       int64        `json:"nbf,omitempty"`
+	Sub       string       `json:"sub,omitempty"`
+	Aud       []string     `json:"aud,omitempty"`
+	Iss       string       `json:"iss,omitempty"`
+	Jti       string       `json:"jti,omitempty"`
+	Group     *GroupInUser `json:"group,omitempty"`
+	Roles     []RoleInUser `json:"roles,omitempty"`
+	Act       *Actor       `json:"act,omitempty"`
+}
@@ -0,0 +1,267 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+)
+
+// TokenReviewURL is the webhook of kube-apiserver to authenticate the bearer tokens by imanager,
+// see --authentication-token-webhook-config-file
+const TokenReviewURL = "/v1/auth/kubernetes/tokenreview"
+const TokenReviewMethod = http.MethodPost
+
+const (
+	TokenReviewKind = "TokenReview"
+	// the api version of the review is returned as it's requested
+	AuthenticationV1      = "authentication.k8s.io/v1"
+	AuthenticationV1beta1 = "authentication.k8s.io/v1beta1"
+)
+
+// the prefixes of the kubernetes groups of the imanager group and roles, so that an imanager group can't be
+// named as a kubernetes builtin group such as system:masters
+const (
+	KubernetesGroupPrefix = "imanager:group:"
+	KubernetesRolePrefix  = "imanager:role:"
+)
+
+// the extra info of the kubernetes user
+const (
+	KubernetesTokenIDExtra  = "imanager.io/token-id"
+	KubernetesClientIDExtra = "imanager.io/client-id"
+)
+
+// TokenReview is the TokenReview of authentication.k8s.io, only the fields used by the webhook are defined
+type TokenReview struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Spec       TokenReviewSpec   `json:"spec"`
+	Status     TokenReviewStatus `json:"status"`
+}
+
+type TokenReviewSpec struct {
+	Token     string   `json:"token"`
+	Audiences []string `json:"audiences,omitempty"`
+}
+
+type TokenReviewStatus struct {
+	Authenticated bool           `json:"authenticated"`
+	User          KubernetesUser `json:"user,omitempty"`
+	// Audiences are the requested audiences which the token is valid for
+	Audiences []string `json:"audiences,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// KubernetesUser is the UserInfo of authentication.k8s.io
+type KubernetesUser struct {
+	Username string              `json:"username,omitempty"`
+	UID      string              `json:"uid,omitempty"`
+	Groups   []string            `json:"groups,omitempty"`
+	Extra    map[string][]string `json:"extra,omitempty"`
+}
+
+// KubernetesGroups returns the kubernetes groups of the imanager group and roles
+func KubernetesGroups(group *GroupInUser, roles []RoleInUser) []string {
+	res := make([]string, 0, len(roles)+1)
+	if group != nil {
+		res = append(res, KubernetesGroupPrefix+group.Name)
+	}
+	for _, v := range roles {
+		res = append(res, KubernetesRolePrefix+v.Name)
+	}
+	return res
+}
+
+// SubjectAccessReviewURL is the webhook of kube-apiserver to authorize the requests by the imanager groups and roles,
+// see --authorization-webhook-config-file
+const SubjectAccessReviewURL = "/v1/auth/kubernetes/subjectaccessreview"
+const SubjectAccessReviewMethod = http.MethodPost
+
+const (
+	SubjectAccessReviewKind = "SubjectAccessReview"
+	AuthorizationV1         = "authorization.k8s.io/v1"
+	AuthorizationV1beta1    = "authorization.k8s.io/v1beta1"
+)
+
+// KubernetesGroupPlaceholder in the namespaces of the rules is replaced by the imanager group of the user
+const KubernetesGroupPlaceholder = "{group}"
+
+// KubernetesNamespaceSeparator separates the group from the rest of the namespaces owned by the group,
+// e.g. team--dev. A namespace belongs to the group before its first separator, so that the namespaces of
+// team aren't matched by the rules of team-b
+const KubernetesNamespaceSeparator = "--"
+
+// SubjectAccessReview is the SubjectAccessReview of authorization.k8s.io, only the fields used by the webhook are defined
+type SubjectAccessReview struct {
+	APIVersion string                    `json:"apiVersion"`
+	Kind       string                    `json:"kind"`
+	Spec       SubjectAccessReviewSpec   `json:"spec"`
+	Status     SubjectAccessReviewStatus `json:"status"`
+}
+
+type SubjectAccessReviewSpec struct {
+	ResourceAttributes    *KubernetesResourceAttributes    `json:"resourceAttributes,omitempty"`
+	NonResourceAttributes *KubernetesNonResourceAttributes `json:"nonResourceAttributes,omitempty"`
+	User                  string                           `json:"user,omitempty"`
+	Groups                []string                         `json:"groups,omitempty"`
+	// Group is the groups of the user in v1beta1, which names the field group instead of groups
+	Group []string `json:"group,omitempty"`
+	UID   string   `json:"uid,omitempty"`
+}
+
+type KubernetesResourceAttributes struct {
+	Namespace   string `json:"namespace,omitempty"`
+	Verb        string `json:"verb,omitempty"`
+	Group       string `json:"group,omitempty"`
+	Version     string `json:"version,omitempty"`
+	Resource    string `json:"resource,omitempty"`
+	Subresource string `json:"subresource,omitempty"`
+	Name        string `json:"name,omitempty"`
+}
+
+type KubernetesNonResourceAttributes struct {
+	Path string `json:"path,omitempty"`
+	Verb string `json:"verb,omitempty"`
+}
+
+// SubjectAccessReviewStatus has no opinion if it's neither allowed nor denied, so that the other authorizers
+// of kube-apiserver such as rbac can still allow the request
+type SubjectAccessReviewStatus struct {
+	Allowed bool   `json:"allowed"`
+	Denied  bool   `json:"denied,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// KubernetesRule allows the users of the groups and roles to do the verbs on the resources in the namespaces.
+// The empty groups or roles match any imanager user, and "*" matches anything in the other fields
+type KubernetesRule struct {
+	Groups []string `json:"groups,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+	// Namespaces are the names or the prefixes ending with "*", "{group}" is replaced by the group of the user.
+	// The cluster scoped resources are only matched by "*"
+	Namespaces []string `json:"namespaces,omitempty"`
+	Verbs      []string `json:"verbs"`
+	APIGroups  []string `json:"apiGroups,omitempty"`
+	// Resources are the resources or the subresources such as pods/log
+	Resources []string `json:"resources,omitempty"`
+	// NonResourcePaths are the paths or the prefixes ending with "*", e.g. /healthz
+	NonResourcePaths []string `json:"nonResourcePaths,omitempty"`
+}
+
+type KubernetesRules struct {
+	Rules []KubernetesRule `json:"rules"`
+}
+
+// DefaultKubernetesRules are used if no rule is configured: a group owns the namespaces named as the group or
+// prefixed by "<group>--", and the admins can edit everything in them
+var DefaultKubernetesRules = []KubernetesRule{
+	{
+		Roles:      []string{AdminRole.String()},
+		Namespaces: []string{KubernetesGroupPlaceholder, KubernetesGroupPlaceholder + KubernetesNamespaceSeparator + "*"},
+		Verbs:      []string{AnyScope},
+		APIGroups:  []string{AnyScope},
+		Resources:  []string{AnyScope},
+	},
+}
+
+// KubernetesSubject is the imanager group and roles of the kubernetes user
+type KubernetesSubject struct {
+	Group string
+	Roles []string
+}
+
+// GetKubernetesSubject returns the subject of the kubernetes groups, false if the user isn't authenticated by imanager
+func GetKubernetesSubject(groups []string) (KubernetesSubject, bool) {
+	res := KubernetesSubject{}
+	ok := false
+	for _, v := range groups {
+		switch {
+		case strings.HasPrefix(v, KubernetesGroupPrefix):
+			res.Group, ok = strings.TrimPrefix(v, KubernetesGroupPrefix), true
+		case strings.HasPrefix(v, KubernetesRolePrefix):
+			res.Roles, ok = append(res.Roles, strings.TrimPrefix(v, KubernetesRolePrefix)), true
+		}
+	}
+	return res, ok
+}
+
+// matchPattern matches the value by the name or the prefix ending with "*"
+func matchPattern(patterns []string, value string) bool {
+	for _, v := range patterns {
+		if v == AnyScope || v == value || (strings.HasSuffix(v, "*") && strings.HasPrefix(value, strings.TrimSuffix(v, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, values []string) bool {
+	for _, v := range values {
+		if matchPattern(patterns, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r KubernetesRule) matchSubject(subject KubernetesSubject) bool {
+	if len(r.Groups) != 0 && !matchPattern(r.Groups, subject.Group) {
+		return false
+	}
+	return len(r.Roles) == 0 || matchAny(r.Roles, subject.Roles)
+}
+
+// namespaceGroup returns the group before the first separator of the namespace
+func namespaceGroup(namespace string) string {
+	if i := strings.Index(namespace, KubernetesNamespaceSeparator); i >= 0 {
+		return namespace[:i]
+	}
+	return ""
+}
+
+func (r KubernetesRule) matchNamespace(subject KubernetesSubject, namespace string) bool {
+	for _, v := range r.Namespaces {
+		if v == AnyScope {
+			return true
+		}
+		if len(namespace) == 0 {
+			continue
+		}
+		if strings.Contains(v, KubernetesGroupPlaceholder) {
+			// the user without group owns no namespace, neither does the group containing the separator
+			// which is created before the names are validated, since its namespaces are also owned by the
+			// group before the separator
+			if len(subject.Group) == 0 || strings.Contains(subject.Group, KubernetesNamespaceSeparator) {
+				continue
+			}
+			// the group may be prefixed by the other group, e.g. team and team-b
+			if strings.HasPrefix(v, KubernetesGroupPlaceholder+KubernetesNamespaceSeparator) &&
+				namespaceGroup(namespace) != subject.Group {
+				continue
+			}
+			v = strings.ReplaceAll(v, KubernetesGroupPlaceholder, subject.Group)
+		}
+		if matchPattern([]string{v}, namespace) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows is true if the rule allows the subject to do the request
+func (r KubernetesRule) Allows(subject KubernetesSubject, spec SubjectAccessReviewSpec) bool {
+	if !r.matchSubject(subject) {
+		return false
+	}
+	if attrs := spec.ResourceAttributes; attrs != nil {
+		resource := attrs.Resource
+		if len(attrs.Subresource) != 0 {
+			resource += "/" + attrs.Subresource
+		}
+		return r.matchNamespace(subject, attrs.Namespace) && matchPattern(r.Verbs, attrs.Verb) &&
+			matchPattern(r.APIGroups, attrs.Group) && matchPattern(r.Resources, resource)
+	}
+	if attrs := spec.NonResourceAttributes; attrs != nil {
+		return matchPattern(r.Verbs, attrs.Verb) && matchPattern(r.NonResourcePaths, attrs.Path)
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKubernetesGroups(t *testing.T) {
+	groups := KubernetesGroups(&GroupInUser{Name: "system:masters"}, []RoleInUser{{Name: "admin"}, {Name: "user"}})
+	expect := []string{"imanager:group:system:masters", "imanager:role:admin", "imanager:role:user"}
+	if !reflect.DeepEqual(groups, expect) {
+		t.Logf("groups: %v, expect: %v", groups, expect)
+		t.Fail()
+	}
+	if groups = KubernetesGroups(nil, nil); len(groups) != 0 {
+		t.Logf("user without group and roles should have no groups: %v", groups)
+		t.Fail()
+	}
+}
+
+func TestGetKubernetesSubject(t *testing.T) {
+	subject, ok := GetKubernetesSubject([]string{"system:authenticated", "imanager:group:g1", "imanager:role:admin"})
+	if !ok || subject.Group != "g1" || !reflect.DeepEqual(subject.Roles, []string{"admin"}) {
+		t.Logf("subject: %+v, ok: %v", subject, ok)
+		t.Fail()
+	}
+	if _, ok = GetKubernetesSubject([]string{"system:masters", "system:authenticated"}); ok {
+		t.Logf("user without imanager groups shouldn't be a subject")
+		t.Fail()
+	}
+}
+
+func TestKubernetesRuleAllowsPrefixedGroup(t *testing.T) {
+	team := KubernetesSubject{Group: "team", Roles: []string{"admin"}}
+	teamB := KubernetesSubject{Group: "team-b", Roles: []string{"admin"}}
+	prefixed := KubernetesSubject{Group: "team-", Roles: []string{"admin"}}
+	separated := KubernetesSubject{Group: "team--b", Roles: []string{"admin"}}
+	cases := []struct {
+		subject   KubernetesSubject
+		namespace string
+		expect    bool
+	}{
+		{team, "team", true},
+		{team, "team--dev", true},
+		{team, "team-b", false},
+		{team, "team-b--dev", false},
+		{team, "team---dev", true},
+		{teamB, "team-b", true},
+		{teamB, "team-b--dev", true},
+		{teamB, "team--dev", false},
+		// the namespaces prefixed by "team---" belong to team
+		{prefixed, "team---dev", false},
+		// the namespaces of the group containing the separator belong to team
+		{separated, "team--b", false},
+		{separated, "team--b--dev", false},
+	}
+	for _, c := range cases {
+		spec := SubjectAccessReviewSpec{ResourceAttributes: &KubernetesResourceAttributes{
+			Namespace: c.namespace, Verb: "get", Resource: "pods"}}
+		if DefaultKubernetesRules[0].Allows(c.subject, spec) != c.expect {
+			t.Logf("group %v in namespace %v: expect %v", c.subject.Group, c.namespace, c.expect)
+			t.Fail()
+		}
+	}
+}
+
+func TestKubernetesRuleAllows(t *testing.T) {
+	admin := KubernetesSubject{Group: "g1", Roles: []string{"admin"}}
+	user := KubernetesSubject{Group: "g1", Roles: []string{"user"}}
+	resource := func(namespace, verb, resource, subresource string) SubjectAccessReviewSpec {
+		return SubjectAccessReviewSpec{ResourceAttributes: &KubernetesResourceAttributes{
+			Namespace: namespace, Verb: verb, Resource: resource, Subresource: subresource}}
+	}
+	readLogs := KubernetesRule{Groups: []string{"g*"}, Namespaces: []string{"shared"}, Verbs: []string{"get"},
+		APIGroups: []string{""}, Resources: []string{"pods/log"}}
+	healthz := KubernetesRule{Verbs: []string{"get"}, NonResourcePaths: []string{"/healthz*"}}
+	cases := []struct {
+		rule    KubernetesRule
+		subject KubernetesSubject
+		spec    SubjectAccessReviewSpec
+		expect  bool
+	}{
+		{DefaultKubernetesRules[0], admin, resource("g1", "delete", "pods", ""), true},
+		{DefaultKubernetesRules[0], admin, resource("g1--dev", "create", "deployments", ""), true},
+		{DefaultKubernetesRules[0], admin, resource("g1-dev", "create", "deployments", ""), false},
+		{DefaultKubernetesRules[0], admin, resource("g2", "get", "pods", ""), false},
+		{DefaultKubernetesRules[0], admin, resource("", "list", "nodes", ""), false},
+		{DefaultKubernetesRules[0], user, resource("g1", "get", "pods", ""), false},
+		{DefaultKubernetesRules[0], KubernetesSubject{Roles: []string{"admin"}}, resource("--dev", "get", "pods", ""), false},
+		{readLogs, user, resource("shared", "get", "pods", "log"), true},
+		{readLogs, user, resource("shared", "get", "pods", ""), false},
+		{readLogs, KubernetesSubject{Group: "h1"}, resource("shared", "get", "pods", "log"), false},
+		{healthz, user, SubjectAccessReviewSpec{NonResourceAttributes: &KubernetesNonResourceAttributes{Path: "/healthz/ready", Verb: "get"}}, true},
+		{healthz, user, resource("g1", "get", "pods", ""), false},
+	}
+	for i, c := range cases {
+		if c.rule.Allows(c.subject, c.spec) != c.expect {
+			t.Logf("case %v: rule: %+v, subject: %+v, expect: %v", i, c.rule, c.subject, c.expect)
+			t.Fail()
+		}
+	}
+}
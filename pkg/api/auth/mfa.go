@@ -0,0 +1,39 @@
+package auth
+
+// the mfa policies of group
+const (
+	// MFAPolicyOptional lets the users decide whether to enable mfa
+	MFAPolicyOptional = "optional"
+	// MFAPolicyPrivileged makes mfa mandatory for the users whose roles have permissions, such as admin and op service
+	MFAPolicyPrivileged = "privileged"
+	// MFAPolicyRequired makes mfa mandatory for all users in the group
+	MFAPolicyRequired = "required"
+)
+
+var MFAPolicies = map[string]bool{
+	"":                  true,
+	MFAPolicyOptional:   true,
+	MFAPolicyPrivileged: true,
+	MFAPolicyRequired:   true,
+}
+
+// MFAEnrollmentScope is the scope of the token issued to the user who must enroll mfa before login
+var MFAEnrollmentScope = TokenScope{Actions: []string{MFAResource + ":" + AnyScope}}
+
+// MFAEnrollment is returned once when the user starts to enroll, the secret and recovery codes can't be read again
+type MFAEnrollment struct {
+	Secret          string   `json:"secret"`
+	ProvisioningURI string   `json:"provisioning_uri"`
+	RecoveryCodes   []string `json:"recovery_codes"`
+}
+
+type MFAStatus struct {
+	Enabled bool `json:"enabled"`
+	// Required is true if the policy of user's group makes mfa mandatory
+	Required          bool `json:"required"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+type ReqMFA struct {
+	OTP string `json:"otp"`
+}
@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+
+	"imanager/pkg/api/util"
+)
+
+const OAuthClientURL = "/v1/auth/clients"
+
+const (
+	ClientCredentialsGrantType = "client_credentials"
+	// TokenType of RespToken issued to the client itself by client credentials grant
+	ClientTokenType = "client"
+	// ClientSubjectPrefix is the prefix of the subject of the client token, so that it never equals a user
+	ClientSubjectPrefix = "client:"
+)
+
+// DefaultClientGrantTypes are allowed if the grant types of the client are empty
+var DefaultClientGrantTypes = []string{AuthorizationCodeGrantType, RefreshTokenGrantType}
+
+// OAuthClient is the application registered to get the tokens of the users by oauth2 and openid connect
+type OAuthClient struct {
+	ClientID string `json:"client_id"`
+	// ClientSecret is only returned when it's created or rotated
+	ClientSecret string `json:"client_secret,omitempty"`
+	Name         string `json:"name"`
+	// RedirectURIs are matched exactly in the authorization request
+	RedirectURIs []string `json:"redirect_uris,omitempty"`
+	// Public client has no secret, such as the single page application, it should use pkce
+	Public bool `json:"public,omitempty"`
+	// Group owns the client, the admins of the group can manage it
+	Group *GroupInUser `json:"group,omitempty"`
+	// GrantTypes are the grants the client can use, DefaultClientGrantTypes are used if it's empty
+	GrantTypes []string `json:"grant_types,omitempty"`
+	// AllowedScopes are the actions of TokenScope the client can request, all actions are allowed if it's empty.
+	// It's required by the client credentials grant, whose token has all of them if no action is requested
+	AllowedScopes []string `json:"allowed_scopes,omitempty"`
+	// Role is the roles of the client token in client credentials grant, the default role is used if it's empty
+	Role           []RoleInUser `json:"role,omitempty"`
+	CreatedBy      string       `json:"created_by,omitempty"`
+	util.BaseModel `json:",inline"`
+}
+
+// Subject is the user id of the client token
+func (c *OAuthClient) Subject() string {
+	return ClientSubjectPrefix + c.ClientID
+}
+
+func (c *OAuthClient) AllowsGrantType(grantType string) bool {
+	grantTypes := c.GrantTypes
+	if len(grantTypes) == 0 {
+		grantTypes = DefaultClientGrantTypes
+	}
+	for _, v := range grantTypes {
+		if v == grantType {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsScope checks the action is covered by the allowed scopes, such as user:read by user:*
+func (c *OAuthClient) AllowsScope(action string) bool {
+	if len(c.AllowedScopes) == 0 {
+		return true
+	}
+	allowed := &TokenScope{Actions: c.AllowedScopes}
+	resource, verb := splitAction(action)
+	return allowed.AllowsAction(resource, verb)
+}
+
+// Valid checks the grant types and the allowed scopes
+func (c *OAuthClient) Valid() error {
+	for _, v := range c.GrantTypes {
+		switch v {
+		case AuthorizationCodeGrantType, RefreshTokenGrantType, DeviceCodeGrantType:
+		case ClientCredentialsGrantType:
+			if c.Public {
+				return fmt.Errorf("public client can't use %v grant", v)
+			}
+			if len(c.AllowedScopes) == 0 {
+				return fmt.Errorf("client of %v grant should have allowed scopes", v)
+			}
+		default:
+			return fmt.Errorf("unsupported grant type %q", v)
+		}
+	}
+	for _, v := range c.AllowedScopes {
+		if err := ValidAction(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type OAuthClientList struct {
+	Count int64         `json:"count"`
+	Item  []OAuthClient `json:"item,omitempty"`
+}
+
+// the error codes in the oauth2 error response, see rfc6749
+const (
+	InvalidRequestError          = "invalid_request"
+	InvalidClientError           = "invalid_client"
+	InvalidGrantError            = "invalid_grant"
+	InvalidScopeError            = "invalid_scope"
+	UnauthorizedClientError      = "unauthorized_client"
+	UnsupportedGrantTypeError    = "unsupported_grant_type"
+	UnsupportedResponseTypeError = "unsupported_response_type"
+	AccessDeniedError            = "access_denied"
+	ServerError                  = "server_error"
+)
+
+type OAuthError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// OAuthErrorStatus is the status code of the error response of the token endpoint
+func OAuthErrorStatus(code string) int {
+	switch code {
+	case InvalidClientError:
+		return http.StatusUnauthorized
+	case ServerError:
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
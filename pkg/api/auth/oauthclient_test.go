@@ -0,0 +1,61 @@
+package auth
+
+import "testing"
+
+func TestOAuthClientAllowsScope(t *testing.T) {
+	cases := []struct {
+		allowed []string
+		action  string
+		expect  bool
+	}{
+		{nil, "user:delete", true},
+		{[]string{"user:read"}, "user:read", true},
+		{[]string{"user:read"}, "user:update", false},
+		{[]string{"user:read"}, "user:*", false},
+		{[]string{"user:*"}, "user:delete", true},
+		{[]string{"*:read", "group:update"}, "role:read", true},
+		{[]string{"*:read", "group:update"}, "group:delete", false},
+	}
+	for _, c := range cases {
+		client := &OAuthClient{AllowedScopes: c.allowed}
+		if client.AllowsScope(c.action) != c.expect {
+			t.Logf("allowed scopes: %v, action: %v, expect: %v", c.allowed, c.action, c.expect)
+			t.Fail()
+		}
+	}
+}
+
+func TestOAuthClientGrantTypes(t *testing.T) {
+	client := &OAuthClient{}
+	if !client.AllowsGrantType(AuthorizationCodeGrantType) || client.AllowsGrantType(ClientCredentialsGrantType) {
+		t.Logf("client without grant types should only allow the default grants")
+		t.Fail()
+	}
+	client.GrantTypes = []string{ClientCredentialsGrantType}
+	if client.AllowsGrantType(AuthorizationCodeGrantType) || !client.AllowsGrantType(ClientCredentialsGrantType) {
+		t.Logf("client should only allow client credentials grant")
+		t.Fail()
+	}
+	client.AllowedScopes = []string{"user:read"}
+	if err := client.Valid(); err != nil {
+		t.Logf("client should be valid, err: %v", err)
+		t.Fail()
+	}
+
+	invalid := []*OAuthClient{
+		{GrantTypes: []string{ClientCredentialsGrantType}, Public: true},
+		{GrantTypes: []string{ClientCredentialsGrantType}},
+		{GrantTypes: []string{"password"}},
+		{AllowedScopes: []string{"user:write"}},
+	}
+	for _, v := range invalid {
+		if err := v.Valid(); err == nil {
+			t.Logf("client %+v should be invalid", v)
+			t.Fail()
+		}
+	}
+	if subject := (&OAuthClient{ClientID: "abc"}).Subject(); subject != "client:abc" {
+		t.Logf("unexpected subject %v", subject)
+		t.Fail()
+	}
+}
@@ -0,0 +1,20 @@
+package auth
+
+import "net/http"
+
+// the login by the upstream openid connect provider, the login redirects the user agent to the provider,
+// which redirects it back to the callback
+const OIDCLoginURL = "/v1/auth/oidc/login"
+const OIDCLoginMethod = http.MethodGet
+const OIDCCallbackURL = "/v1/auth/oidc/callback"
+const OIDCCallbackMethod = http.MethodGet
+
+// OIDCStateCookieName is the cookie which binds the state of the login to the user agent
+const OIDCStateCookieName = "imanager_oidc_state"
+
+// the parameters in the fragment of the return url, where the user agent is redirected after the login
+const (
+	OIDCReturnTokenKey        = "token"
+	OIDCReturnRefreshTokenKey = "refresh_token"
+	OIDCReturnExpiresAtKey    = "expires_at"
+)
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// imanager is an openid connect provider, the issuer is TokenIssuer, which should be the external url of imanager
+const OIDCDiscoveryURL = "/.well-known/openid-configuration"
+const OIDCDiscoveryMethod = http.MethodGet
+const OAuthAuthorizeURL = "/v1/auth/oauth/authorize"
+const OAuthTokenURL = "/v1/auth/oauth/token"
+const OAuthTokenMethod = http.MethodPost
+const OAuthUserInfoURL = "/v1/auth/oauth/userinfo"
+
+const AuthorizationCodeGrantType = "authorization_code"
+
+// the scopes of openid connect, the other scopes of the authorization are the actions of TokenScope
+const (
+	OpenIDScope  = "openid"
+	ProfileScope = "profile"
+	EmailScope   = "email"
+	// GroupsScope asks for the group and the roles of the user
+	GroupsScope = "groups"
+)
+
+// OIDCDiscovery is the provider metadata, see openid connect discovery 1.0
+type OIDCDiscovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+}
+
+// OAuthTokenResponse is the response of the token endpoint, see rfc6749
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// UserInfo is the claims of the user in the id token and the userinfo response
+type UserInfo struct {
+	Subject           string   `json:"sub"`
+	PreferredUsername string   `json:"preferred_username,omitempty"`
+	Name              string   `json:"name,omitempty"`
+	Email             string   `json:"email,omitempty"`
+	Group             string   `json:"group,omitempty"`
+	Roles             []string `json:"roles,omitempty"`
+}
+
+var oidcScopes = map[string]bool{
+	OpenIDScope:  true,
+	ProfileScope: true,
+	EmailScope:   true,
+	GroupsScope:  true,
+}
+
+// ParseOAuthScope splits the space separated scope of the authorization into the openid connect scopes
+// and the actions of TokenScope
+func ParseOAuthScope(scope string) ([]string, []string, error) {
+	scopes := make([]string, 0)
+	actions := make([]string, 0)
+	for _, v := range strings.Fields(scope) {
+		if oidcScopes[v] {
+			scopes = append(scopes, v)
+			continue
+		}
+		if err := ValidAction(v); err != nil {
+			return nil, nil, fmt.Errorf("unknown scope %q", v)
+		}
+		actions = append(actions, v)
+	}
+	return scopes, actions, nil
+}
+
+func HasScope(scopes []string, scope string) bool {
+	for _, v := range scopes {
+		if v == scope {
+			return true
+		}
+	}
+	return false
+}
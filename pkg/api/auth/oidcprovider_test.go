@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseOAuthScope(t *testing.T) {
+	cases := []struct {
+		scope   string
+		scopes  string
+		actions string
+		isValid bool
+	}{
+		{"", "", "", true},
+		{"openid profile email groups", "openid,profile,email,groups", "", true},
+		{" openid  user:read ", "openid", "user:read", true},
+		{"openid user:* userinfo:read", "openid", "user:*,userinfo:read", true},
+		{"openid address", "", "", false},
+		{"openid user:write", "", "", false},
+	}
+	for _, c := range cases {
+		scopes, actions, err := ParseOAuthScope(c.scope)
+		if (err == nil) != c.isValid {
+			t.Logf("scope %q, expect valid: %v, err: %v", c.scope, c.isValid, err)
+			t.Fail()
+			continue
+		}
+		if c.isValid && (strings.Join(scopes, ",") != c.scopes || strings.Join(actions, ",") != c.actions) {
+			t.Logf("scope %q, scopes: %v, actions: %v", c.scope, scopes, actions)
+			t.Fail()
+		}
+	}
+	if !HasScope([]string{OpenIDScope, EmailScope}, EmailScope) || HasScope([]string{OpenIDScope}, GroupsScope) {
+		t.Logf("unexpected result of HasScope")
+		t.Fail()
+	}
+}
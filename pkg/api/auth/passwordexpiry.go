@@ -0,0 +1,11 @@
+package auth
+
+// PasswordChangeScope is the scope of the token issued to the user whose password is expired or reset by
+// the others, the user can only change the password with it
+var PasswordChangeScope = TokenScope{Actions: []string{PasswordResource + ":" + UpdateVerb}}
+
+// ReqChangePassword changes the password of the user himself, the old password is required
+type ReqChangePassword struct {
+	OldPassword string `json:"old_password"`
+	Password    string `json:"password"`
+}
@@ -0,0 +1,29 @@
+package auth
+
+// the rules of the password policy
+const (
+	PasswordMinLengthRule = "min_length"
+	PasswordMaxLengthRule = "max_length"
+	PasswordUpperRule     = "uppercase"
+	PasswordLowerRule     = "lowercase"
+	PasswordDigitRule     = "digit"
+	PasswordSymbolRule    = "symbol"
+	// PasswordCharacterRule rejects the characters other than the letters, digits and allowed symbols
+	PasswordCharacterRule  = "character"
+	PasswordDictionaryRule = "dictionary"
+	PasswordUsernameRule   = "username"
+	PasswordHistoryRule    = "history"
+)
+
+// PasswordViolation is a rule of the password policy which the password fails
+type PasswordViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicyErrorResponse is the error response listing all the failing rules of the password
+type PasswordPolicyErrorResponse struct {
+	ErrorCode    int                 `json:"ErrorCode"`
+	ErrorMessage string              `json:"ErrorMessage"`
+	Violations   []PasswordViolation `json:"violations"`
+}
@@ -0,0 +1,20 @@
+package auth
+
+import "net/http"
+
+// ForgotPasswordURL sends the password reset link to the email of the user
+const ForgotPasswordURL = "/v1/auth/password/forgot"
+const ForgotPasswordMethod = http.MethodPost
+
+// ResetPasswordURL shows the page to set the new password by GET, and resets the password by POST
+const ResetPasswordURL = "/v1/auth/password/reset"
+
+type ReqForgotPassword struct {
+	Name string `json:"name"`
+}
+
+type ReqResetPassword struct {
+	// Token is the token in the password reset link
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
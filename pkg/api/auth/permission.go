@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// builtinRolePermissions are the permissions of the builtin roles which are not set in db, they are the same as
+// the role ladder before the permissions are introduced. The user role has no permission other than on himself
+var builtinRolePermissions = map[RoleType]Role{
+	OpServiceRole: {Permissions: []string{AnyScope + ":" + AnyScope}, AllGroups: true},
+	AdminRole: {Permissions: []string{
+		UserResource + ":" + AnyScope,
+		TokenResource + ":" + AnyScope,
+		SessionResource + ":" + AnyScope,
+		MFAResource + ":" + AnyScope,
+		ClientResource + ":" + AnyScope,
+	}},
+}
+
+// WithBuiltinPermissions returns the role with the default permissions if it's a builtin role without permissions,
+// it's only for the role whose permissions have never been set
+func (r Role) WithBuiltinPermissions() Role {
+	builtin, ok := builtinRolePermissions[RoleType(r.ID)]
+	if !ok || len(r.Permissions) != 0 {
+		return r
+	}
+	r.Permissions = append([]string{}, builtin.Permissions...)
+	r.AllGroups = builtin.AllGroups
+	return r
+}
+
+// the names of the resources and verbs of the services other than imanager
+var permissionPartRegexp = regexp.MustCompile(`^([a-z0-9][a-z0-9_.-]{0,63}|\*)$`)
+
+// ValidPermission checks the format of the permission of roles, the resources and verbs can be defined by other services
+func ValidPermission(permission string) error {
+	resource, verb := splitAction(permission)
+	if !permissionPartRegexp.MatchString(resource) || !permissionPartRegexp.MatchString(verb) {
+		return fmt.Errorf("permission %q should be in the format of resource:verb", permission)
+	}
+	return nil
+}
+
+// MatchPermission returns the permission of the role which allows the action
+func (r Role) MatchPermission(resource, verb string) (string, bool) {
+	action := resource + ":" + verb
+	for _, v := range r.Permissions {
+		if actionCovers(v, action) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// Permissions are the actions allowed by the roles of a user, the actions are in the format of resource:verb
+// as the token scope
+type Permissions struct {
+	// InGroup are allowed on the group of the user and the users, clients in it
+	InGroup []string `json:"in_group,omitempty"`
+	// AllGroups are allowed on all groups
+	AllGroups []string `json:"all_groups,omitempty"`
+}
+
+// Add merges the actions of a role, the actions of a role of all groups apply to all groups
+func (p *Permissions) Add(actions []string, allGroups bool) {
+	if allGroups {
+		p.AllGroups = append(p.AllGroups, actions...)
+	} else {
+		p.InGroup = append(p.InGroup, actions...)
+	}
+}
+
+// actionCovers is true if every action matched by other is matched by action
+func actionCovers(action, other string) bool {
+	r, v := splitAction(action)
+	otherR, otherV := splitAction(other)
+	return (r == AnyScope || r == otherR) && (v == AnyScope || v == otherV)
+}
+
+func actionsCover(actions []string, other string) bool {
+	for _, v := range actions {
+		if actionCovers(v, other) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows checks the action in all groups, or in the group of the user if inGroup is true
+func (p *Permissions) Allows(resource, verb string, inGroup bool) bool {
+	if p == nil {
+		return false
+	}
+	action := resource + ":" + verb
+	return actionsCover(p.AllGroups, action) || (inGroup && actionsCover(p.InGroup, action))
+}
+
+// Covers is true if the permissions allow everything of other in the same or more groups, so that the user
+// can grant the roles of other without getting more authority
+func (p *Permissions) Covers(other *Permissions) bool {
+	if other == nil {
+		return true
+	}
+	if p == nil {
+		return len(other.InGroup) == 0 && len(other.AllGroups) == 0
+	}
+	for _, v := range other.AllGroups {
+		if !actionsCover(p.AllGroups, v) {
+			return false
+		}
+	}
+	for _, v := range other.InGroup {
+		if !actionsCover(p.AllGroups, v) && !actionsCover(p.InGroup, v) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+package auth
+
+import "testing"
+
+func TestPermissionsAllows(t *testing.T) {
+	perms := &Permissions{InGroup: []string{"user:*"}, AllGroups: []string{"group:read"}}
+	cases := []struct {
+		resource string
+		verb     string
+		inGroup  bool
+		expect   bool
+	}{
+		{UserResource, DeleteVerb, true, true},
+		{UserResource, DeleteVerb, false, false},
+		{GroupResource, ReadVerb, false, true},
+		{GroupResource, ReadVerb, true, true},
+		{GroupResource, UpdateVerb, true, false},
+		{RoleResource, ReadVerb, true, false},
+	}
+	for _, c := range cases {
+		if perms.Allows(c.resource, c.verb, c.inGroup) != c.expect {
+			t.Logf("action: %v:%v, in group: %v, expect: %v", c.resource, c.verb, c.inGroup, c.expect)
+			t.Fail()
+		}
+	}
+	var empty *Permissions
+	if empty.Allows(UserResource, ReadVerb, true) {
+		t.Logf("nil permissions should allow nothing")
+		t.Fail()
+	}
+}
+
+func TestPermissionsCovers(t *testing.T) {
+	opService, admin := Permissions{}, Permissions{}
+	role := Role{ID: int(OpServiceRole)}.WithBuiltinPermissions()
+	opService.Add(role.Permissions, role.AllGroups)
+	role = Role{ID: int(AdminRole)}.WithBuiltinPermissions()
+	admin.Add(role.Permissions, role.AllGroups)
+	cases := []struct {
+		perms  Permissions
+		other  Permissions
+		expect bool
+	}{
+		{opService, admin, true},
+		{admin, opService, false},
+		{admin, admin, true},
+		{admin, Permissions{}, true},
+		{admin, Permissions{InGroup: []string{"user:read"}}, true},
+		{admin, Permissions{AllGroups: []string{"user:read"}}, false},
+		{admin, Permissions{InGroup: []string{"*:read"}}, false},
+		{Permissions{AllGroups: []string{"*:read"}}, Permissions{InGroup: []string{"role:read"}}, true},
+		{Permissions{InGroup: []string{"user:read"}}, Permissions{InGroup: []string{"user:*"}}, false},
+	}
+	for _, c := range cases {
+		if c.perms.Covers(&c.other) != c.expect {
+			t.Logf("permissions: %+v, other: %+v, expect: %v", c.perms, c.other, c.expect)
+			t.Fail()
+		}
+	}
+}
+
+func TestRoleWithBuiltinPermissions(t *testing.T) {
+	if role := (Role{ID: int(UserRole)}).WithBuiltinPermissions(); len(role.Permissions) != 0 {
+		t.Logf("user role should have no permission, got %v", role.Permissions)
+		t.Fail()
+	}
+	role := Role{ID: int(AdminRole), Permissions: []string{"user:read"}}.WithBuiltinPermissions()
+	if len(role.Permissions) != 1 || role.AllGroups {
+		t.Logf("permissions set in db should be kept, got %+v", role)
+		t.Fail()
+	}
+	role = Role{ID: 100}.WithBuiltinPermissions()
+	if len(role.Permissions) != 0 {
+		t.Logf("custom role should have no default permission, got %v", role.Permissions)
+		t.Fail()
+	}
+}
+
+func TestValidPermission(t *testing.T) {
+	cases := map[string]bool{
+		"user:read":      true,
+		"*:*":            true,
+		"project:deploy": true,
+		"app.v1:get":     true,
+		"user":           false,
+		"user:":          false,
+		"User:read":      false,
+		"user:read:all":  false,
+	}
+	for permission, expect := range cases {
+		if (ValidPermission(permission) == nil) != expect {
+			t.Logf("permission: %v, expect valid: %v", permission, expect)
+			t.Fail()
+		}
+	}
+}
+
+func TestRoleMatchPermission(t *testing.T) {
+	role := Role{Permissions: []string{"group:read", "user:*"}}
+	if permission, ok := role.MatchPermission(UserResource, DeleteVerb); !ok || permission != "user:*" {
+		t.Logf("user:delete should match user:*, got %v", permission)
+		t.Fail()
+	}
+	if _, ok := role.MatchPermission(GroupResource, UpdateVerb); ok {
+		t.Logf("group:update should not match")
+		t.Fail()
+	}
+}
@@ -3,10 +3,17 @@ package auth
 import "imanager/pkg/api/util"
 
 type Role struct {
-	ID             int    `json:"id"`
-	Name           string `json:"name"`
-	Annotation     string `json:"annotation"`
+	ID             int          `json:"id"`
+	Name           string       `json:"name"`
+	Annotation     string       `json:"annotation"`
+	TokenPolicy    *TokenPolicy `json:"token_policy,omitempty"`
 	util.BaseModel `json:",inline"`
+
+	// Permissions are the actions in the format of resource:verb allowed by the role, see Permissions.
+	// They aren't changed if it's null in the update, and all permissions are removed if it's empty
+	Permissions []string `json:"permissions,omitempty"`
+	// AllGroups makes the permissions apply to all groups, otherwise only to the group of the user
+	AllGroups bool `json:"all_groups,omitempty"`
 }
 
 type RoleList struct {
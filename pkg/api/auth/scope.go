@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// the resources and verbs of the action in token scope, the action is in the format of resource:verb
+const (
+	UserResource    = "user"
+	RoleResource    = "role"
+	GroupResource   = "group"
+	TokenResource   = "token"
+	SecretResource  = "secret"
+	MFAResource     = "mfa"
+	SessionResource = "session"
+	ClientResource  = "client"
+	// PasswordResource is the password of the user himself
+	PasswordResource = "password"
+	// UserInfoResource is the openid connect userinfo, a token scoped to it can only read the userinfo
+	UserInfoResource = "userinfo"
+	// KubernetesResource is the kubernetes api, a token scoped to it can authenticate to kube-apiserver
+	KubernetesResource = "kubernetes"
+	// IngressResource is the routes behind the ingress, a token scoped to it can pass the ingress
+	IngressResource = "ingress"
+
+	ReadVerb   = "read"
+	CreateVerb = "create"
+	UpdateVerb = "update"
+	DeleteVerb = "delete"
+
+	AnyScope = "*"
+)
+
+var (
+	scopeResources = map[string]bool{
+		UserResource:       true,
+		RoleResource:       true,
+		GroupResource:      true,
+		TokenResource:      true,
+		SecretResource:     true,
+		MFAResource:        true,
+		SessionResource:    true,
+		ClientResource:     true,
+		PasswordResource:   true,
+		UserInfoResource:   true,
+		KubernetesResource: true,
+		IngressResource:    true,
+		AnyScope:           true,
+	}
+	scopeVerbs = map[string]bool{
+		ReadVerb:   true,
+		CreateVerb: true,
+		UpdateVerb: true,
+		DeleteVerb: true,
+		AnyScope:   true,
+	}
+)
+
+// TokenScope restricts what the token can do, it can't grant more than the roles of the user
+type TokenScope struct {
+	// Actions are allowed actions, all actions are allowed if it's empty
+	Actions []string `json:"actions,omitempty"`
+	// Group restricts the token to the users and the group of the group name
+	Group string `json:"group,omitempty"`
+}
+
+func splitAction(action string) (string, string) {
+	strs := strings.SplitN(action, ":", 2)
+	if len(strs) != 2 {
+		return strs[0], ""
+	}
+	return strs[0], strs[1]
+}
+
+func ValidAction(action string) error {
+	resource, verb := splitAction(action)
+	if !scopeResources[resource] {
+		return fmt.Errorf("unknown resource %q in action %q", resource, action)
+	}
+	if !scopeVerbs[verb] {
+		return fmt.Errorf("unknown verb %q in action %q", verb, action)
+	}
+	return nil
+}
+
+func (s *TokenScope) IsEmpty() bool {
+	return s == nil || (len(s.Actions) == 0 && len(s.Group) == 0)
+}
+
+func (s *TokenScope) AllowsAction(resource, verb string) bool {
+	if s == nil || len(s.Actions) == 0 {
+		return true
+	}
+	for _, action := range s.Actions {
+		r, v := splitAction(action)
+		if (r == resource || r == AnyScope) && (v == verb || v == AnyScope) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsResource is true if any action on the resource is allowed
+func (s *TokenScope) AllowsResource(resource string) bool {
+	if s == nil || len(s.Actions) == 0 {
+		return true
+	}
+	for _, action := range s.Actions {
+		r, _ := splitAction(action)
+		if r == resource || r == AnyScope {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *TokenScope) AllowsGroup(group string) bool {
+	return s == nil || len(s.Group) == 0 || s.Group == group
+}
+
+// AllowsAllGroups is false if the token is restricted to a group
+func (s *TokenScope) AllowsAllGroups() bool {
+	return s == nil || len(s.Group) == 0
+}
+
+// String returns the space separated scope, the group is in the format of group:<name>
+func (s *TokenScope) String() string {
+	if s.IsEmpty() {
+		return ""
+	}
+	res := make([]string, 0, len(s.Actions)+1)
+	res = append(res, s.Actions...)
+	if len(s.Group) != 0 {
+		res = append(res, "group:"+s.Group)
+	}
+	return strings.Join(res, " ")
+}
@@ -0,0 +1,76 @@
+package auth
+
+import "testing"
+
+func TestTokenScopeAllowsAction(t *testing.T) {
+	cases := []struct {
+		scope    *TokenScope
+		resource string
+		verb     string
+		expect   bool
+	}{
+		{nil, UserResource, DeleteVerb, true},
+		{&TokenScope{Group: "g1"}, UserResource, DeleteVerb, true},
+		{&TokenScope{Actions: []string{"user:read"}}, UserResource, ReadVerb, true},
+		{&TokenScope{Actions: []string{"user:read"}}, UserResource, UpdateVerb, false},
+		{&TokenScope{Actions: []string{"user:read"}}, GroupResource, ReadVerb, false},
+		{&TokenScope{Actions: []string{"user:*"}}, UserResource, DeleteVerb, true},
+		{&TokenScope{Actions: []string{"*:read"}}, RoleResource, ReadVerb, true},
+		{&TokenScope{Actions: []string{"*:read"}}, RoleResource, CreateVerb, false},
+		{&TokenScope{Actions: []string{"group:read", "role:create"}}, RoleResource, CreateVerb, true},
+	}
+	for _, c := range cases {
+		if c.scope.AllowsAction(c.resource, c.verb) != c.expect {
+			t.Logf("scope: %+v, action: %v:%v, expect: %v", c.scope, c.resource, c.verb, c.expect)
+			t.Fail()
+		}
+	}
+}
+
+func TestTokenScopeAllowsGroup(t *testing.T) {
+	var scope *TokenScope
+	if !scope.AllowsGroup("g1") || !scope.AllowsAllGroups() {
+		t.Logf("nil scope should allow all groups")
+		t.Fail()
+	}
+	scope = &TokenScope{Group: "g1"}
+	if !scope.AllowsGroup("g1") || scope.AllowsGroup("g2") || scope.AllowsAllGroups() {
+		t.Logf("scope should only allow group g1")
+		t.Fail()
+	}
+}
+
+func TestTokenScopeAllowsResource(t *testing.T) {
+	cases := []struct {
+		scope    *TokenScope
+		resource string
+		expect   bool
+	}{
+		{nil, KubernetesResource, true},
+		{&TokenScope{Group: "g1"}, KubernetesResource, true},
+		{&TokenScope{Actions: []string{"kubernetes:read"}}, KubernetesResource, true},
+		{&TokenScope{Actions: []string{"*:read"}}, KubernetesResource, true},
+		{&TokenScope{Actions: []string{"password:update"}}, KubernetesResource, false},
+	}
+	for _, c := range cases {
+		if c.scope.AllowsResource(c.resource) != c.expect {
+			t.Logf("scope: %+v, resource: %v, expect: %v", c.scope, c.resource, c.expect)
+			t.Fail()
+		}
+	}
+}
+
+func TestValidAction(t *testing.T) {
+	for _, action := range []string{"user:read", "group:*", "*:*", "token:delete"} {
+		if err := ValidAction(action); err != nil {
+			t.Logf("action %v should be valid, err: %v", action, err)
+			t.Fail()
+		}
+	}
+	for _, action := range []string{"user", "user:", "users:read", "user:write", ":read"} {
+		if err := ValidAction(action); err == nil {
+			t.Logf("action %v should be invalid", action)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package auth
+
+import "time"
+
+// Session is a login of the user, all tokens refreshed from the login belong to it
+type Session struct {
+	ID         string    `json:"id"`
+	ClientIP   string    `json:"client_ip"`
+	UserAgent  string    `json:"user_agent"`
+	IssuedAt   time.Time `json:"issued_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// Current is true if it's the session of the request token
+	Current bool `json:"current"`
+}
+
+type SessionList struct {
+	Count int64     `json:"count"`
+	Item  []Session `json:"item,omitempty"`
+}
@@ -6,17 +6,43 @@ import (
 )
 
 type ReqToken struct {
-	Auth  ReqTokenAuth  `json:"auth,omitempty"`
-	Scope ReqTokenScope `json:"scope,omitempty"`
+	GrantType string        `json:"grant_type,omitempty"`
+	Auth      ReqTokenAuth  `json:"auth,omitempty"`
+	Scope     ReqTokenScope `json:"scope,omitempty"`
 }
 
 type ReqTokenAuth struct {
-	Name     string `json:"name,omitempty"`
-	Password string `json:"password,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Password     string `json:"password,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	// OTP is the totp code or a recovery code of the user who enabled mfa
+	OTP string `json:"otp,omitempty"`
+	// SubjectToken is the name of the user to impersonate in token exchange,
+	// and SubjectTokenType should be UserNameTokenType
+	SubjectToken     string `json:"subject_token,omitempty"`
+	SubjectTokenType string `json:"subject_token_type,omitempty"`
+	// ActorToken is the token of the caller in token exchange, the token in header is used if it's empty
+	ActorToken     string `json:"actor_token,omitempty"`
+	ActorTokenType string `json:"actor_token_type,omitempty"`
 }
 
+const (
+	PasswordGrantType      = "password"
+	RefreshTokenGrantType  = "refresh_token"
+	TokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+)
+
+// the token types in token exchange, see rfc8693
+const (
+	AccessTokenType   = "urn:ietf:params:oauth:token-type:access_token"
+	UserNameTokenType = "urn:imanager:params:oauth:token-type:user_name"
+)
+
 type ReqTokenScope struct {
 	Duration time.Duration `json:"duration,omitempty"`
+	// Actions and Group down scope the token, see TokenScope
+	Actions []string `json:"actions,omitempty"`
+	Group   string   `json:"group,omitempty"`
 }
 
 type RespToken struct {
@@ -27,9 +53,49 @@ type RespToken struct {
 	TrueName  string       `json:"true_name,omitempty"`
 	Group     *GroupInUser `json:"group,omitempty"`
 	Role      []RoleInUser `json:"roles,omitempty"`
+	// TokenID is the jti of the token
+	TokenID string `json:"token_id,omitempty"`
+	// SessionID is the family id of the refresh tokens issued with the token
+	SessionID string   `json:"session_id,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  []string `json:"aud,omitempty"`
+	// TokenType is empty for the jwt of users
+	TokenType string      `json:"token_type,omitempty"`
+	Scope     *TokenScope `json:"scope,omitempty"`
+	// Act is the caller who impersonates the user by token exchange
+	Act *Actor `json:"act,omitempty"`
+	// ClientID is the oauth client of the client token, whose UserID is the subject of the client,
+	// or the oauth client which the token of the user is issued to
+	ClientID string `json:"client_id,omitempty"`
+	// OpenIDScopes are the openid connect scopes granted to the oauth client, they decide the claims of userinfo
+	OpenIDScopes []string `json:"openid_scopes,omitempty"`
+}
+
+// Actor is the party which acts on behalf of the subject of the token, see rfc8693
+type Actor struct {
+	Subject string `json:"sub"`
+	Name    string `json:"name,omitempty"`
+}
+
+// JSONWebKey is the public part of a token signing key, see rfc7517
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
 }
 
 const TokenHeaderKey = "X-Subject-Token"
+const RefreshTokenHeaderKey = "X-Refresh-Token"
 
 const DefaultExpireTime = 30
 const BaseDuration = time.Minute
@@ -39,6 +105,9 @@ const ParseInfo = "X-Subject-Info"
 const GetTokenURL = "/v1/auth/tokens"
 const GetTokenMethod = http.MethodPost
 
+const JWKSURL = "/.well-known/jwks.json"
+const JWKSMethod = http.MethodGet
+
 var (
 	OpServiceRole RoleType = 1
 	AdminRole     RoleType = 2
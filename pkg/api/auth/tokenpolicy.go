@@ -0,0 +1,74 @@
+package auth
+
+import "fmt"
+
+// TokenPolicy limits the lifetime of the tokens, the durations are in minutes and 0 means not limited by the policy
+type TokenPolicy struct {
+	// DefaultDuration is used if the token request doesn't specify a duration
+	DefaultDuration int `json:"default_duration,omitempty"`
+	MaxDuration     int `json:"max_duration,omitempty"`
+	// IdleTimeout ends the session which isn't used for the time
+	IdleTimeout int `json:"idle_timeout,omitempty"`
+}
+
+// EffectiveTokenPolicy is the policy applied to the tokens of a user, merged from the config, the group and the roles
+type EffectiveTokenPolicy struct {
+	TokenPolicy `json:",inline"`
+	// RejectOverMax rejects the request of a duration over MaxDuration instead of clamping it
+	RejectOverMax bool `json:"reject_over_max"`
+}
+
+func (p *TokenPolicy) IsEmpty() bool {
+	return p == nil || (p.DefaultDuration == 0 && p.MaxDuration == 0 && p.IdleTimeout == 0)
+}
+
+func (p *TokenPolicy) Valid() error {
+	if p == nil {
+		return nil
+	}
+	if p.DefaultDuration < 0 || p.MaxDuration < 0 || p.IdleTimeout < 0 {
+		return fmt.Errorf("durations of token policy should not be negative")
+	}
+	if p.MaxDuration != 0 && p.DefaultDuration > p.MaxDuration {
+		return fmt.Errorf("default duration %v of token policy is longer than max duration %v", p.DefaultDuration, p.MaxDuration)
+	}
+	return nil
+}
+
+func stricter(a, b int) int {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// Restrict merges other into p, the stricter value of each field is kept
+func (p *TokenPolicy) Restrict(other *TokenPolicy) {
+	if other == nil {
+		return
+	}
+	p.DefaultDuration = stricter(p.DefaultDuration, other.DefaultDuration)
+	p.MaxDuration = stricter(p.MaxDuration, other.MaxDuration)
+	p.IdleTimeout = stricter(p.IdleTimeout, other.IdleTimeout)
+}
+
+// Duration returns the duration in minutes for the requested one, 0 requests the default duration.
+// The duration over MaxDuration is clamped and exceeded is true
+func (p *TokenPolicy) Duration(requested int) (duration int, exceeded bool) {
+	duration = requested
+	if duration <= 0 {
+		duration = p.DefaultDuration
+		if duration <= 0 {
+			duration = DefaultExpireTime
+		}
+		// the default isn't requested by the user, so it's never exceeded
+		if p.MaxDuration != 0 && duration > p.MaxDuration {
+			duration = p.MaxDuration
+		}
+		return duration, false
+	}
+	if p.MaxDuration != 0 && duration > p.MaxDuration {
+		return p.MaxDuration, true
+	}
+	return duration, false
+}
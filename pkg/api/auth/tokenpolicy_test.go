@@ -0,0 +1,58 @@
+package auth
+
+import "testing"
+
+func TestTokenPolicyRestrict(t *testing.T) {
+	policy := &TokenPolicy{DefaultDuration: 60, MaxDuration: 600}
+	policy.Restrict(&TokenPolicy{MaxDuration: 120, IdleTimeout: 30})
+	policy.Restrict(&TokenPolicy{DefaultDuration: 90, IdleTimeout: 45})
+	policy.Restrict(nil)
+	expect := TokenPolicy{DefaultDuration: 60, MaxDuration: 120, IdleTimeout: 30}
+	if *policy != expect {
+		t.Logf("policy: %+v, expect: %+v", *policy, expect)
+		t.Fail()
+	}
+}
+
+func TestTokenPolicyDuration(t *testing.T) {
+	cases := []struct {
+		policy    TokenPolicy
+		requested int
+		duration  int
+		exceeded  bool
+	}{
+		{TokenPolicy{}, 0, DefaultExpireTime, false},
+		{TokenPolicy{}, 100000, 100000, false},
+		{TokenPolicy{DefaultDuration: 60}, 0, 60, false},
+		{TokenPolicy{DefaultDuration: 60, MaxDuration: 120}, 90, 90, false},
+		{TokenPolicy{DefaultDuration: 60, MaxDuration: 120}, 121, 120, true},
+		{TokenPolicy{MaxDuration: 10}, 0, 10, false},
+	}
+	for _, c := range cases {
+		duration, exceeded := c.policy.Duration(c.requested)
+		if duration != c.duration || exceeded != c.exceeded {
+			t.Logf("policy: %+v, requested: %v, duration: %v/%v, exceeded: %v/%v",
+				c.policy, c.requested, duration, c.duration, exceeded, c.exceeded)
+			t.Fail()
+		}
+	}
+}
+
+func TestTokenPolicyValid(t *testing.T) {
+	cases := []struct {
+		policy *TokenPolicy
+		valid  bool
+	}{
+		{nil, true},
+		{&TokenPolicy{DefaultDuration: 30, MaxDuration: 60, IdleTimeout: 10}, true},
+		{&TokenPolicy{DefaultDuration: 90}, true},
+		{&TokenPolicy{DefaultDuration: 90, MaxDuration: 60}, false},
+		{&TokenPolicy{IdleTimeout: -1}, false},
+	}
+	for _, c := range cases {
+		if err := c.policy.Valid(); (err == nil) != c.valid {
+			t.Logf("policy: %+v, err: %v, expect valid: %v", c.policy, err, c.valid)
+			t.Fail()
+		}
+	}
+}
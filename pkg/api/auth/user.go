@@ -2,6 +2,7 @@ package auth
 
 import (
 	"net/http"
+	"time"
 
 	"imanager/pkg/api/util"
 )
@@ -18,9 +19,23 @@ type User struct {
 	PhoneNum       string       `json:"phone_num"`
 	Group          *GroupInUser `json:"group"`
 	Role           []RoleInUser `json:"role"`
+	Lockout        *UserLockout `json:"lockout,omitempty"`
+	Source         string       `json:"source,omitempty"`
 	util.BaseModel `json:",inline"`
+
+	// PasswordChangedAt is when the password was changed last time, it's zero for the external users
+	PasswordChangedAt time.Time `json:"password_changed_at"`
+	// MustChangePassword makes the user change the password at next login
+	MustChangePassword bool `json:"must_change_password,omitempty"`
 }
 
+// the sources where the users are authenticated
+const (
+	LocalUserSource = ""
+	LDAPUserSource  = "ldap"
+	OIDCUserSource  = "oidc"
+)
+
 type RoleInUser struct {
 	ID         int    `json:"id"`
 	Name       string `json:"name"`
@@ -41,3 +56,11 @@ type UserList struct {
 type UserSecret struct {
 	Password string `json:"password"`
 }
+
+// UserLockout is the state of failed logins of the user
+type UserLockout struct {
+	Locked        bool      `json:"locked"`
+	Failures      int       `json:"failures"`
+	LastFailureAt time.Time `json:"last_failure_at"`
+	LockedUntil   time.Time `json:"locked_until"`
+}
@@ -33,7 +33,6 @@ func GetConfig() Configer {
 
 func init() {
 	Init()
-	flag.Parse()
 	defer glog.Flush()
 	log.SetFlags(log.Llongfile | log.Lmicroseconds | log.Ldate)
 
@@ -52,6 +51,10 @@ var (
 	httpPort    string
 	HttpPortKey string = "httpport"
 
+	// grpcPort serves the envoy external authorization, it isn't served if it's empty
+	grpcPort    string
+	GrpcPortKey string = "grpcport"
+
 	dataSource    string
 	DataSourceKey string = "datasource"
 
@@ -59,10 +62,18 @@ var (
 	EncryptDirKey string = "encryptDir"
 )
 
+// ApplyFlags sets the flags into the config again after they are parsed by main, the env still overrides them
+func ApplyFlags() {
+	setValues()
+}
+
 func setValues() {
 	if httpPort != "" {
 		_ = c.Set(HttpPortKey, httpPort)
 	}
+	if grpcPort != "" {
+		_ = c.Set(GrpcPortKey, grpcPort)
+	}
 	if dataSource != "" {
 		_ = c.Set(DataSourceKey, dataSource)
 	}
@@ -73,7 +84,8 @@ func setValues() {
 	// set all env into config
 	environ := os.Environ()
 	for _, v := range environ {
-		strs := strings.Split(v, "=")
+		// the value may contain "=", such as the dn of ldap
+		strs := strings.SplitN(v, "=", 2)
 		if len(strs) != 2 {
 			continue
 		}
@@ -83,6 +95,7 @@ func setValues() {
 
 func Init() {
 	flag.StringVar(&httpPort, "httpport", "8080", "listen port")
+	flag.StringVar(&grpcPort, "grpcport", "", "listen port of the envoy external authorization")
 	flag.StringVar(&dataSource, "dataSource", "root:Eec0215@tcp(10.5.26.50:10196)/default?charset=utf8", "mysql data source")
 	flag.StringVar(&encryptDir, "encryptDir", "", "the dir save master_key and pub_key")
 }
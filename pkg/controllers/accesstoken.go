@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+
+	authapi "imanager/pkg/api/auth"
+	authsvc "imanager/pkg/services/auth"
+	"imanager/pkg/util"
+)
+
+// isAllowedManageUserTokens allows the user himself, and the callers whose roles allow the action on the user
+func isAllowedManageUserTokens(name string, info *authapi.RespToken, resource, verb string) bool {
+	return name == info.Name || isAllowedOnUser(&authapi.User{Name: name}, info, resource, verb)
+}
+
+func (c AuthController) CreatePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	info, err := util.GetUserInfo(r.Header.Get(authapi.ParseInfo))
+	if err != nil {
+		glog.Errorf("get user info from header failed, err: %v", err)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if info.TokenType == authapi.PersonalAccessTokenType {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "personal access token can't create personal access token")
+		return
+	}
+	if info.Act != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "impersonated token can't create personal access token")
+		return
+	}
+	if info.TokenType == authapi.ClientTokenType {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "client token can't create personal access token")
+		return
+	}
+	// the personal access token isn't restricted by scope, so it can't be created by scoped token
+	if !info.Scope.IsEmpty() {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "scoped token can't create personal access token")
+		return
+	}
+	name := mux.Vars(r)["name"]
+	if !isAllowedManageUserTokens(name, info, authapi.TokenResource, authapi.CreateVerb) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to create personal access token")
+		return
+	}
+
+	requestBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("request body read failed, %v", err))
+		return
+	}
+	pat := &authapi.PersonalAccessToken{}
+	err = json.Unmarshal(requestBody, pat)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("request body unmarshal failed, %v", err))
+		return
+	}
+	isMatch, _ := regexp.MatchString(NameRegexp, pat.Name)
+	if !isMatch {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token name don't match the format")
+		return
+	}
+
+	glog.Infof("create personal access token[%v] for user[%v] by %v/%v", pat.Name, name, info.Name, info.UserID)
+	pat, err = authsvc.CreatePersonalAccessToken(name, info.UserID, pat)
+	if err == orm.ErrNoRows {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "user isn't exist")
+		return
+	}
+	if err == authsvc.ErrNotTokenOwner {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("create personal access token failed, %v", err))
+		return
+	}
+	out, err := json.Marshal(pat)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("marshal failed, %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(out)
+}
+
+func (c AuthController) ListPersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	info, err := util.GetUserInfo(r.Header.Get(authapi.ParseInfo))
+	if err != nil {
+		glog.Errorf("get user info from header failed, err: %v", err)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	name := mux.Vars(r)["name"]
+	if !isAllowedManageUserTokens(name, info, authapi.TokenResource, authapi.ReadVerb) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to list personal access token")
+		return
+	}
+	if !info.Scope.AllowsAction(authapi.TokenResource, authapi.ReadVerb) || !isAllowedUserByScope(name, info) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to list personal access token")
+		return
+	}
+
+	tokens, num, err := authsvc.ListPersonalAccessTokens(name)
+	if err == orm.ErrNoRows {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "user isn't exist")
+		return
+	}
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("list personal access token failed, %v", err))
+		return
+	}
+	respBody, _ := json.Marshal(authapi.PersonalAccessTokenList{
+		Count: num,
+		Item:  tokens,
+	})
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(respBody)
+}
+
+func (c AuthController) DeletePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	info, err := util.GetUserInfo(r.Header.Get(authapi.ParseInfo))
+	if err != nil {
+		glog.Errorf("get user info from header failed, err: %v", err)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	name := mux.Vars(r)["name"]
+	if !isAllowedManageUserTokens(name, info, authapi.TokenResource, authapi.DeleteVerb) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to delete personal access token")
+		return
+	}
+	if !info.Scope.AllowsAction(authapi.TokenResource, authapi.DeleteVerb) || !isAllowedUserByScope(name, info) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to delete personal access token")
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("token id is invalid, %v", err))
+		return
+	}
+
+	glog.Infof("delete personal access token[%v] of user[%v] by %v/%v", id, name, info.Name, info.UserID)
+	err = authsvc.DeletePersonalAccessToken(name, id)
+	if err == orm.ErrNoRows {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "personal access token isn't exist")
+		return
+	}
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("delete personal access token failed, %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/astaxie/beego/orm"
@@ -14,6 +15,7 @@ import (
 
 	authapi "imanager/pkg/api/auth"
 	"imanager/pkg/controllers/parse"
+	"imanager/pkg/passwordpolicy"
 	authsvc "imanager/pkg/services/auth"
 	"imanager/pkg/util"
 )
@@ -34,51 +36,197 @@ func (c AuthController) CreateTokenInHttp(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	glog.Infof("%v request token", reqToken.Auth.Name)
-	isValid, user, err := authsvc.ValidUserPasswordAndGetRoles(reqToken.Auth.Name, reqToken.Auth.Password)
-	if err != nil {
-		glog.Errorf("valid user[%v]'s password failed, err: %v", reqToken.Auth.Name, err)
-		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("valid user's password failed, %v", err))
+	var user *authapi.User
+	var refreshToken *authsvc.IssuedRefreshToken
+	clientIP := getClientIP(r)
+	switch reqToken.GrantType {
+	case "", authapi.PasswordGrantType:
+		glog.Infof("%v request token", reqToken.Auth.Name)
+		// check before the password, so that the locked user doesn't cost the decryption
+		retryAfter, err := authsvc.CheckLoginAllowed(reqToken.Auth.Name, clientIP)
+		if err == authsvc.ErrLoginLocked || err == authsvc.ErrLoginThrottled {
+			glog.Errorf("login of user[%v] from %v is rejected, err: %v", reqToken.Auth.Name, clientIP, err)
+			returnTooManyRequests(w, retryAfter, err)
+			return
+		}
+		if err != nil {
+			util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("check login failures failed, %v", err))
+			return
+		}
+		var isValid bool
+		isValid, user, err = authsvc.ValidUserPasswordAndGetRoles(reqToken.Auth.Name, reqToken.Auth.Password)
+		if err != nil {
+			glog.Errorf("valid user[%v]'s password failed, err: %v", reqToken.Auth.Name, err)
+			util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("valid user's password failed, %v", err))
+			return
+		}
+		if !isValid {
+			glog.Errorf("user name[%v] or password is invalid", reqToken.Auth.Name)
+			authsvc.RecordLoginFailure(reqToken.Auth.Name, clientIP)
+			util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("user name or password is invalid"))
+			return
+		}
+		scope, err := authsvc.ValidTokenScope(user, reqToken.Scope)
+		if err != nil {
+			util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("invalid token scope, %v", err))
+			return
+		}
+		err = authsvc.ValidMFA(user, reqToken.Auth.OTP)
+		mfaEnrollment := false
+		switch err {
+		case nil:
+		case authsvc.ErrMFAEnrollmentRequired:
+			// the user can only enroll mfa with this token
+			glog.Infof("user[%v] should enroll mfa, issue token for mfa enrollment only", user.Name)
+			mfaScope := authapi.MFAEnrollmentScope
+			scope = &mfaScope
+			mfaEnrollment = true
+		case authsvc.ErrOTPRequired, authsvc.ErrInvalidOTP:
+			glog.Errorf("mfa of user[%v] failed, err: %v", user.Name, err)
+			if err == authsvc.ErrInvalidOTP {
+				authsvc.RecordLoginFailure(user.Name, clientIP)
+			}
+			util.ReturnErrorResponseInResponseWriter(w, http.StatusUnauthorized, err.Error())
+			return
+		default:
+			util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("valid user's otp failed, %v", err))
+			return
+		}
+		authsvc.ResetLoginFailures(user.Name)
+		passwordScope, ok := passwordChangeScope(w, user)
+		if !ok {
+			return
+		}
+		if passwordScope != nil {
+			if mfaEnrollment {
+				passwordScope.Actions = append(passwordScope.Actions, authapi.MFAEnrollmentScope.Actions...)
+			}
+			scope = passwordScope
+		}
+		refreshToken, err = authsvc.CreateRefreshToken(user.UUID, scope)
+		if err != nil {
+			util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("create refresh token failed, %v", err))
+			return
+		}
+	case authapi.RefreshTokenGrantType:
+		user, refreshToken, err = authsvc.UseRefreshToken(reqToken.Auth.RefreshToken)
+		if err == authsvc.ErrInvalidRefreshToken || err == authsvc.ErrRefreshTokenReused || err == authsvc.ErrRefreshTokenClient ||
+			err == authsvc.ErrSessionIdle || err == authsvc.ErrTokenRevoked {
+			util.ReturnErrorResponseInResponseWriter(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if err != nil {
+			util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("use refresh token failed, %v", err))
+			return
+		}
+		glog.Infof("%v refresh token", user.Name)
+		// the password may expire during the session
+		passwordScope, ok := passwordChangeScope(w, user)
+		if !ok {
+			return
+		}
+		if passwordScope != nil {
+			refreshToken.Scope = passwordScope
+		}
+		// the access token issued by refresh token is always short
+		reqToken.Scope.Duration = 0
+	case authapi.TokenExchangeGrantType:
+		c.exchangeToken(w, r, reqToken)
+		return
+	default:
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("unsupported grant type %v", reqToken.GrantType))
 		return
 	}
-	if !isValid {
-		glog.Errorf("user name[%v] or password is invalid", reqToken.Auth.Name)
-		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("user name or password is invalid"))
+
+	res, tokenss, ok := c.issueToken(w, r, user, refreshToken, int(reqToken.Scope.Duration),
+		reqToken.GrantType == authapi.RefreshTokenGrantType)
+	if !ok {
 		return
 	}
+	respBody, _ := json.Marshal(res)
+	w.Header().Set(authapi.TokenHeaderKey, tokenss)
+	w.Header().Set(authapi.RefreshTokenHeaderKey, refreshToken.Token)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(respBody)
+}
 
-	if reqToken.Scope.Duration == 0 {
-		reqToken.Scope.Duration = authapi.DefaultExpireTime
+// passwordChangeScope returns the scope which only allows to change the password if the user should change it, or nil.
+// The error response is written if it fails
+func passwordChangeScope(w http.ResponseWriter, user *authapi.User) (*authapi.TokenScope, bool) {
+	required, err := authsvc.IsPasswordChangeRequired(user)
+	if err != nil {
+		glog.Errorf("check password expiry of user[%v] failed, err: %v", user.Name, err)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("check password expiry failed, %v", err))
+		return nil, false
+	}
+	if !required {
+		return nil, true
+	}
+	// the user can only change the password with this token
+	glog.Infof("user[%v] should change the password, issue token for password change only", user.Name)
+	scope := authapi.PasswordChangeScope
+	scope.Actions = append([]string{}, scope.Actions...)
+	return &scope, true
+}
+
+// issueToken creates the access token in the session of the refresh token, the session is renewed
+// if the token is refreshed. The error response is written if it fails, and the refresh token is revoked
+// since it's never returned to the client
+func (c AuthController) issueToken(w http.ResponseWriter, r *http.Request, user *authapi.User,
+	refreshToken *authsvc.IssuedRefreshToken, requestedDuration int, renew bool) (_ *authapi.RespToken, _ string, ok bool) {
+	defer func() {
+		if !ok {
+			authsvc.RevokeRefreshTokenFamily(refreshToken.FamilyID)
+		}
+	}()
+	policy, err := authsvc.GetTokenPolicy(user)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("get token policy failed, %v", err))
+		return nil, "", false
+	}
+	duration, err := authsvc.TokenDuration(policy, requestedDuration)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return nil, "", false
 	}
-	reqToken.Scope.Duration = reqToken.Scope.Duration * authapi.BaseDuration
 
 	issuedAt := time.Now()
 	res := authapi.RespToken{
-		ExpiresAt: issuedAt.Add(reqToken.Scope.Duration),
+		ExpiresAt: issuedAt.Add(duration),
 		IssuedAt:  issuedAt,
-		Name:      reqToken.Auth.Name,
+		Name:      user.Name,
 		UserID:    user.UUID,
 		Role:      user.Role,
 		Group:     user.Group,
 		TrueName:  user.TruthName,
+		SessionID: refreshToken.FamilyID,
+		Scope:     refreshToken.Scope,
+		// the token of the oauth client only has the claims of the scopes granted to it
+		ClientID:     refreshToken.ClientID,
+		OpenIDScopes: refreshToken.OpenIDScopes,
 	}
 
-	tokenss, err := authsvc.CreateToken(res)
+	tokenss, err := authsvc.CreateToken(&res)
 	if err != nil {
-		glog.Errorf("create token failed, user name: %v, err: %v", reqToken.Auth.Name, err)
+		glog.Errorf("create token failed, user name: %v, err: %v", user.Name, err)
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("create token failed, %v", err))
-		return
+		return nil, "", false
 	}
-
-	respBody, _ := json.Marshal(res)
-	w.Header().Set(authapi.TokenHeaderKey, tokenss)
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(respBody)
+	if renew {
+		err = authsvc.RenewSession(&res, policy.IdleTimeout, getClientIP(r), r.UserAgent())
+	} else {
+		err = authsvc.CreateSession(&res, policy.IdleTimeout, getClientIP(r), r.UserAgent())
+	}
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("record session failed, %v", err))
+		return nil, "", false
+	}
+	return &res, tokenss, true
 }
 
 func (c AuthController) CheckTokenInHttp(w http.ResponseWriter, r *http.Request) {
 	tokenStr := r.Header.Get(authapi.TokenHeaderKey)
-	info, err := authsvc.ParseToken(tokenStr)
+	info, err := authsvc.ValidateToken(tokenStr)
 	if err != nil {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("parse token failed, %v", err))
 		return
@@ -88,37 +236,86 @@ func (c AuthController) CheckTokenInHttp(w http.ResponseWriter, r *http.Request)
 	_, _ = w.Write(respBody)
 }
 
-func isAllowedModifyUser(user *authapi.User, info *authapi.RespToken) bool {
-	largestRolePermission := authapi.GetLargestRolePermission(info.Role)
-	if largestRolePermission.IsLargerPermission(authapi.OpServiceRole) {
+func (c AuthController) RevokeTokenInHttp(w http.ResponseWriter, r *http.Request) {
+	info, err := util.GetUserInfo(r.Header.Get(authapi.ParseInfo))
+	if err != nil {
+		glog.Errorf("get user info from header failed, err: %v", err)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if info.TokenType == authapi.PersonalAccessTokenType {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "personal access token should be deleted from the user's tokens")
+		return
+	}
+	glog.Infof("%v/%v logout, token: %v", info.Name, info.UserID, info.TokenID)
+	err = authsvc.RevokeToken(*info)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("revoke token failed, %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c AuthController) GetJSONWebKeySet(w http.ResponseWriter, r *http.Request) {
+	respBody, _ := json.Marshal(authsvc.GetJSONWebKeySet())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(respBody)
+}
+
+// isAllowedOnUser checks the roles of the caller allow the action on the user, the user should be in the group
+// of the caller unless the action is allowed in all groups
+func isAllowedOnUser(user *authapi.User, info *authapi.RespToken, resource, verb string) bool {
+	permissions := getPermissions(info)
+	if permissions.Allows(resource, verb, false) {
 		return true
 	}
-	if !largestRolePermission.IsLargerPermission(authapi.AdminRole) {
+	if !permissions.Allows(resource, verb, true) || info.Group == nil {
 		return false
 	}
 
-	group, err:= authsvc.GetGroupByID(info.Group.ID)
+	group, err := authsvc.GetUserGroup(user.Name, user.UUID)
 	if err != nil {
-		glog.Errorf("get detail group by id failed, user: %v/%v, group id: %v, err: %v", user.Name, user.UUID, info.Group.ID, err)
+		glog.Errorf("get group of user[%v/%v] failed, err: %v", user.Name, user.UUID, err)
 		return false
 	}
-	for _, v := range group.User {
-		if v.Name == user.Name {
-			return true
-		}
-	}
+	return group.ID == info.Group.ID
+}
 
-	return false
+// isAllowedGrantRoles checks the roles don't have more permissions than the caller
+func isAllowedGrantRoles(roles []authapi.RoleInUser, info *authapi.RespToken) bool {
+	permissions, err := authsvc.GetPermissions(roles)
+	if err != nil {
+		glog.Errorf("get permissions of roles %+v failed, err: %v", roles, err)
+		return false
+	}
+	return getPermissions(info).Covers(permissions)
 }
 
 var (
 	UserNameRegexp      = "^[a-zA-Z0-9-]{4,64}$"
-	PasswordRegexp      = "^[a-zA-Z0-9_-]{8,18}$"
 	UserTruthNameRegexp = `^[a-zA-Z\p{Han}]+$`
 	EmailRegexp         = `^[0-9a-z][_.0-9a-z-]{0,31}@([0-9a-z][0-9a-z-]{0,30}[0-9a-z]\.){1,4}[a-z]{2,4}$`
 	PhoneNumRegexp      = "^((13[0-9])|(14[5,7])|(15[0-3,5-9])|(17[0,3,5-8])|(18[0-9])|166|198|199|(147))\\d{8}$"
 )
 
+// returnValidationError returns the failing rules of the password policy, or the message of the other errors
+func returnValidationError(w http.ResponseWriter, err error) {
+	policyErr, ok := err.(*passwordpolicy.Error)
+	if !ok {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	glog.Errorf("return code: %v, error message: %v", http.StatusBadRequest, err)
+	respBody, _ := json.Marshal(authapi.PasswordPolicyErrorResponse{
+		ErrorCode:    http.StatusBadRequest,
+		ErrorMessage: err.Error(),
+		Violations:   policyErr.Violations,
+	})
+	w.WriteHeader(http.StatusBadRequest)
+	_, _ = w.Write(respBody)
+}
+
 func validUserForCreateOrUpdate(user *authapi.User, isCreate bool, info *authapi.RespToken) error {
 	var isMatch bool
 	if isCreate || len(user.Name) != 0 {
@@ -131,15 +328,8 @@ func validUserForCreateOrUpdate(user *authapi.User, isCreate bool, info *authapi
 		return fmt.Errorf("user name and uuid should not be empty at same time")
 	}
 	if isCreate || len(user.Password) != 0 {
-		isMatch, _ = regexp.MatchString(PasswordRegexp, user.Password)
-		if !isMatch {
-			return fmt.Errorf("user password doesn't match the format")
-		}
-		hasLower := regexp.MustCompile(`[a-z]`)
-		hasUpper := regexp.MustCompile(`[A-Z]`)
-		hasNumber := regexp.MustCompile(`[0-9]`)
-		if !(hasLower.MatchString(user.Password) && hasUpper.MatchString(user.Password) && hasNumber.MatchString(user.Password)) {
-			return fmt.Errorf("user password must longer than 8 chars and shorter than 18 chars with at least 1 uppercase letter, 1 lowercase letter and 1 number")
+		if err := authsvc.ValidPassword(user.Password, user); err != nil {
+			return err
 		}
 	}
 	if isCreate || len(user.TruthName) != 0 {
@@ -161,9 +351,13 @@ func validUserForCreateOrUpdate(user *authapi.User, isCreate bool, info *authapi
 		}
 	}
 
-	largestRolePermissionInInfo := authapi.GetLargestRolePermission(info.Role)
+	// the user who can manage the users of all groups can put the user into any group
+	allGroups := isAllowedInAllGroups(info, authapi.UserResource, authapi.CreateVerb)
+	if !isCreate {
+		allGroups = isAllowedInAllGroups(info, authapi.UserResource, authapi.UpdateVerb)
+	}
 	if isCreate && user.Group == nil {
-		if largestRolePermissionInInfo == authapi.OpServiceRole {
+		if allGroups {
 			user.Group = authsvc.DefaultGroup
 		} else {
 			user.Group = info.Group
@@ -177,13 +371,12 @@ func validUserForCreateOrUpdate(user *authapi.User, isCreate bool, info *authapi
 		if largestRolePermissionInUser == authapi.OpServiceRole {
 			user.Group = authsvc.OpServiceGroup
 		}
-		if !largestRolePermissionInInfo.IsLargerPermission(largestRolePermissionInUser) {
+		if !isAllowedGrantRoles(user.Role, info) {
 			return fmt.Errorf("user's permission is not allowed more authority than info")
 		}
 	}
 
-	// op service can create user into any group and any role
-	if largestRolePermissionInInfo == authapi.OpServiceRole {
+	if allGroups {
 		return nil
 	}
 	if user.Group != nil && user.Group.ID != info.Group.ID {
@@ -204,10 +397,10 @@ func modifyUserForSpecialRole(user *authapi.User) error {
 	case authapi.AdminRole, authapi.UserRole:
 		if user.Group == nil {
 			var oldUser *authapi.User
-			if user.Name != "" {
-				oldUser, err = authsvc.GetUserByName(user.Name)
-			} else if user.UUID != "" {
+			if user.UUID != "" {
 				oldUser, err = authsvc.GetUserByUUID(user.UUID)
+			} else if user.Name != "" {
+				oldUser, err = authsvc.GetUserByName(user.Name)
 			} else {
 				return fmt.Errorf("find user by name or uuid failed")
 			}
@@ -231,11 +424,14 @@ func (c AuthController) CreateUser(w http.ResponseWriter, r *http.Request) {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	// only op service can create user
-	if !authapi.GetLargestRolePermission(info.Role).IsLargerPermission(authapi.AdminRole) {
+	if !getPermissions(info).Allows(authapi.UserResource, authapi.CreateVerb, true) {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to create user")
 		return
 	}
+	if !info.Scope.AllowsAction(authapi.UserResource, authapi.CreateVerb) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to create user")
+		return
+	}
 
 	requestBody, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -250,7 +446,7 @@ func (c AuthController) CreateUser(w http.ResponseWriter, r *http.Request) {
 	}
 	err = validUserForCreateOrUpdate(user, true, info)
 	if err != nil {
-		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("%v", err))
+		returnValidationError(w, err)
 		return
 	}
 	err = modifyUserForSpecialRole(user)
@@ -258,6 +454,10 @@ func (c AuthController) CreateUser(w http.ResponseWriter, r *http.Request) {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("%v", err))
 		return
 	}
+	if !isAllowedGroupIDByScope(user.Group.ID, info) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to create user in this group")
+		return
+	}
 	user, err = authsvc.CreateUser(user)
 	if err != nil {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("create user failed, %v", err))
@@ -292,21 +492,58 @@ func (c AuthController) ModifyUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	glog.Infof("username: %v, info name: %v", user.Name, info.Name)
-	if user.Name != info.Name && !isAllowedModifyUser(user, info) {
+	// the caller modifies himself only if the uuid matches, the user of the name may be deleted and created again
+	var oldUser *authapi.User
+	if len(user.UUID) != 0 {
+		oldUser, err = authsvc.GetUserByUUID(user.UUID)
+	} else {
+		oldUser, err = authsvc.GetUserByName(user.Name)
+	}
+	if err == orm.ErrNoRows {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "user isn't exist")
+		return
+	}
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("get user failed, %v", err))
+		return
+	}
+	// the permissions are checked on the user found above, the name can't point to another user
+	if len(user.Name) != 0 && user.Name != oldUser.Name {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "user name doesn't match the uuid")
+		return
+	}
+	user.UUID, user.Name = oldUser.UUID, oldUser.Name
+	isSelf := oldUser.UUID == info.UserID
+	if !isSelf && !isAllowedOnUser(oldUser, info, authapi.UserResource, authapi.UpdateVerb) {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to modify")
 		return
 	}
+	if !info.Scope.AllowsAction(authapi.UserResource, authapi.UpdateVerb) || !isAllowedUserByScope(oldUser.Name, info) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to modify user")
+		return
+	}
 	err = validUserForCreateOrUpdate(user, false, info)
 	if err != nil {
-		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("%v", err))
+		returnValidationError(w, err)
 		return
 	}
+	// the user should change the password reset by the others at next login
+	if isSelf {
+		user.MustChangePassword = false
+	} else if len(user.Password) != 0 {
+		user.MustChangePassword = true
+	}
 	err = modifyUserForSpecialRole(user)
 	if err != nil {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("%v", err))
 		return
 	}
 
+	if user.Group != nil && !isAllowedGroupIDByScope(user.Group.ID, info) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to move user to this group")
+		return
+	}
+
 	err = authsvc.IsAllowUserUpdate(user, info)
 	if err != nil {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("%v", err))
@@ -314,6 +551,10 @@ func (c AuthController) ModifyUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	user, err = authsvc.UpdateUser(user)
+	if err == authsvc.ErrExternalUserPassword {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	if err != nil {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("update user failed, %v", err))
 		return
@@ -336,11 +577,14 @@ func (c AuthController) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	name := mux.Vars(r)["name"]
-	// only op service and admin in user's group can delete user
-	if !isAllowedModifyUser(&authapi.User{Name:name}, info) {
+	if !isAllowedOnUser(&authapi.User{Name: name}, info, authapi.UserResource, authapi.DeleteVerb) {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to delete user")
 		return
 	}
+	if !info.Scope.AllowsAction(authapi.UserResource, authapi.DeleteVerb) || !isAllowedUserByScope(name, info) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to delete user")
+		return
+	}
 	glog.Infof("delete user[%v] by %v/%v", name, info.Name, info.UserID)
 	err = authsvc.DeleteUserByName(name)
 	if err == orm.ErrNoRows {
@@ -364,10 +608,14 @@ func (c AuthController) GetUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	name := mux.Vars(r)["name"]
-	if name != info.Name && !authapi.GetLargestRolePermission(info.Role).IsLargerPermission(authapi.OpServiceRole) {
+	if name != info.Name && !isAllowedOnUser(&authapi.User{Name: name}, info, authapi.UserResource, authapi.ReadVerb) {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to get user detail")
 		return
 	}
+	if !info.Scope.AllowsAction(authapi.UserResource, authapi.ReadVerb) || !isAllowedUserByScope(name, info) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to get user detail")
+		return
+	}
 
 	user, err := authsvc.GetUserByName(name)
 	if err == orm.ErrNoRows {
@@ -378,6 +626,11 @@ func (c AuthController) GetUser(w http.ResponseWriter, r *http.Request) {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("get user from db failed, %v", err))
 		return
 	}
+	user.Lockout, err = authsvc.GetUserLockout(name)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("get user lockout failed, %v", err))
+		return
+	}
 	out, err := json.Marshal(user)
 	if err != nil {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("marshal user failed, %v", err))
@@ -394,9 +647,26 @@ func (c AuthController) ListUser(w http.ResponseWriter, r *http.Request) {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	if !info.Scope.AllowsAction(authapi.UserResource, authapi.ReadVerb) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to list user")
+		return
+	}
 	dataSelect := parse.ParseDataSelectPathParameter(r)
 
-	resp, num, err := authsvc.ListUserByUserID(getManageUserIDs(info), dataSelect)
+	userIDs, err := filterUserIDsByScope(getManageUserIDs(info), info)
+	if err != nil {
+		glog.Errorf("filter users by token scope failed, query user: %v/%v, %v", info.Name, info.UserID, err)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("%v", err))
+		return
+	}
+	if len(userIDs) == 0 && !info.Scope.AllowsAllGroups() {
+		// empty ids means all users, but no user in the scope is managed
+		respBody, _ := json.Marshal(authapi.UserList{Item: []authapi.User{}})
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(respBody)
+		return
+	}
+	resp, num, err := authsvc.ListUserByUserID(userIDs, dataSelect)
 	if err != nil {
 		glog.Errorf("list users failed, query user: %v/%v, %v", info.Name, info.UserID, err)
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("%v", err))
@@ -410,30 +680,67 @@ func (c AuthController) ListUser(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(respBody)
 }
 
-func (c AuthController) GetUserSecret(w http.ResponseWriter, r *http.Request) {
+// UnlockUser clears the failed logins and lockout of the user
+func (c AuthController) UnlockUser(w http.ResponseWriter, r *http.Request) {
 	info, err := util.GetUserInfo(r.Header.Get(authapi.ParseInfo))
 	if err != nil {
 		glog.Errorf("get user info from header failed, err: %v", err)
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	// only op service can get user's password
-	if !authapi.GetLargestRolePermission(info.Role).IsLargerPermission(authapi.OpServiceRole) {
-		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to get user's password")
+
+	name := mux.Vars(r)["name"]
+	if !isAllowedOnUser(&authapi.User{Name: name}, info, authapi.UserResource, authapi.UpdateVerb) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to unlock user")
+		return
+	}
+	if !info.Scope.AllowsAction(authapi.UserResource, authapi.UpdateVerb) || !isAllowedUserByScope(name, info) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to unlock user")
+		return
+	}
+	glog.Infof("unlock user[%v] by %v/%v", name, info.Name, info.UserID)
+	err = authsvc.UnlockUser(name)
+	if err == orm.ErrNoRows {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "user isn't exist")
 		return
 	}
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("unlock user failed, %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
 
+func (c AuthController) GetUserSecret(w http.ResponseWriter, r *http.Request) {
+	info, err := util.GetUserInfo(r.Header.Get(authapi.ParseInfo))
+	if err != nil {
+		glog.Errorf("get user info from header failed, err: %v", err)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	name := mux.Vars(r)["name"]
 	if len(name) == 0 {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "user name is empty")
 		return
 	}
+	if !isAllowedOnUser(&authapi.User{Name: name}, info, authapi.SecretResource, authapi.ReadVerb) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to get user's password")
+		return
+	}
+	if !info.Scope.AllowsAction(authapi.SecretResource, authapi.ReadVerb) || !isAllowedUserByScope(name, info) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to get user's password")
+		return
+	}
 
 	userSecret, err := authsvc.GetUserSecret(name)
 	if err == orm.ErrNoRows {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusNotFound, "user isn't exist")
 		return
 	}
+	if err == authsvc.ErrNoReversiblePassword {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	if err != nil {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("get user[%v]'s password failed, %v", name, err))
 		return
@@ -469,18 +776,25 @@ func (c AuthController) UnInitUser(w http.ResponseWriter, r *http.Request) {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	// only op service can unInit user
-	if !authapi.GetLargestRolePermission(info.Role).IsLargerPermission(authapi.OpServiceRole) {
-		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to unInit user")
-		return
-	}
-
 	name := mux.Vars(r)["name"]
 	if len(name) == 0 {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, "user name is empty")
 		return
 	}
+	// the password of the user is stored as plain text after unInit
+	if !isAllowedOnUser(&authapi.User{Name: name}, info, authapi.SecretResource, authapi.UpdateVerb) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to unInit user")
+		return
+	}
+	if !info.Scope.AllowsAction(authapi.UserResource, authapi.UpdateVerb) || !isAllowedUserByScope(name, info) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to unInit user")
+		return
+	}
 	_, err = authsvc.UnInitUser(name)
+	if err == authsvc.ErrNoReversiblePassword {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	if err != nil {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("encrypt user[%v] failed, %v", name, err))
 		return
@@ -506,9 +820,24 @@ func validRole(role *authapi.Role) error {
 	if !isMatch {
 		return fmt.Errorf("role annotation don't match the format")
 	}
+	if err := role.TokenPolicy.Valid(); err != nil {
+		return err
+	}
+	for _, v := range role.Permissions {
+		if err := authapi.ValidPermission(v); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// isAllowedGrantPermissions checks the role doesn't have more permissions than the caller
+func isAllowedGrantPermissions(role *authapi.Role, info *authapi.RespToken) bool {
+	permissions := &authapi.Permissions{}
+	permissions.Add(role.Permissions, role.AllGroups)
+	return getPermissions(info).Covers(permissions)
+}
+
 func (c AuthController) CreateRole(w http.ResponseWriter, r *http.Request) {
 	info, err := util.GetUserInfo(r.Header.Get(authapi.ParseInfo))
 	if err != nil {
@@ -516,11 +845,14 @@ func (c AuthController) CreateRole(w http.ResponseWriter, r *http.Request) {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	// only op service can create role
-	if !authapi.GetLargestRolePermission(info.Role).IsLargerPermission(authapi.OpServiceRole) {
+	if !isAllowedInAllGroups(info, authapi.RoleResource, authapi.CreateVerb) {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to create role")
 		return
 	}
+	if !info.Scope.AllowsAction(authapi.RoleResource, authapi.CreateVerb) || !info.Scope.AllowsAllGroups() {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to create role")
+		return
+	}
 
 	requestBody, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -539,6 +871,10 @@ func (c AuthController) CreateRole(w http.ResponseWriter, r *http.Request) {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("%v", err))
 		return
 	}
+	if !isAllowedGrantPermissions(role, info) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to grant the permissions to role")
+		return
+	}
 
 	role, err = authsvc.CreateRole(role)
 	if err != nil {
@@ -562,10 +898,14 @@ func (c AuthController) ModifyRole(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !authapi.GetLargestRolePermission(info.Role).IsLargerPermission(authapi.OpServiceRole) {
+	if !isAllowedInAllGroups(info, authapi.RoleResource, authapi.UpdateVerb) {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to modify")
 		return
 	}
+	if !info.Scope.AllowsAction(authapi.RoleResource, authapi.UpdateVerb) || !info.Scope.AllowsAllGroups() {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to modify role")
+		return
+	}
 
 	requestBody, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -583,6 +923,10 @@ func (c AuthController) ModifyRole(w http.ResponseWriter, r *http.Request) {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("%v", err))
 		return
 	}
+	if !isAllowedGrantPermissions(role, info) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to grant the permissions to role")
+		return
+	}
 
 	role, err = authsvc.UpdateRole(role)
 	if err != nil {
@@ -605,11 +949,14 @@ func (c AuthController) DeleteRole(w http.ResponseWriter, r *http.Request) {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	// only op service can delete role
-	if !authapi.GetLargestRolePermission(info.Role).IsLargerPermission(authapi.OpServiceRole) {
+	if !isAllowedInAllGroups(info, authapi.RoleResource, authapi.DeleteVerb) {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to delete role")
 		return
 	}
+	if !info.Scope.AllowsAction(authapi.RoleResource, authapi.DeleteVerb) || !info.Scope.AllowsAllGroups() {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to delete role")
+		return
+	}
 	name := mux.Vars(r)["name"]
 	glog.Infof("delete role[%v] by %v/%v", name, info.Name, info.UserID)
 	err = authsvc.DeleteRoleByName(name)
@@ -626,6 +973,17 @@ func (c AuthController) DeleteRole(w http.ResponseWriter, r *http.Request) {
 }
 
 func (c AuthController) GetRole(w http.ResponseWriter, r *http.Request) {
+	info, err := util.GetUserInfo(r.Header.Get(authapi.ParseInfo))
+	if err != nil {
+		glog.Errorf("get user info from header failed, err: %v", err)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !info.Scope.AllowsAction(authapi.RoleResource, authapi.ReadVerb) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to get role")
+		return
+	}
+
 	name := mux.Vars(r)["name"]
 	role, err := authsvc.GetRoleByName(name)
 	if err == orm.ErrNoRows {
@@ -646,6 +1004,16 @@ func (c AuthController) GetRole(w http.ResponseWriter, r *http.Request) {
 }
 
 func (c AuthController) ListRole(w http.ResponseWriter, r *http.Request) {
+	info, err := util.GetUserInfo(r.Header.Get(authapi.ParseInfo))
+	if err != nil {
+		glog.Errorf("get user info from header failed, err: %v", err)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !info.Scope.AllowsAction(authapi.RoleResource, authapi.ReadVerb) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to list role")
+		return
+	}
 	dataSelect := parse.ParseDataSelectPathParameter(r)
 	roles, num, err := authsvc.ListRole(dataSelect)
 	if err != nil {
@@ -666,6 +1034,19 @@ func validGroup(group *authapi.Group) error {
 	if !isMatch {
 		return fmt.Errorf("group name don't match the format")
 	}
+	// the kubernetes namespaces of the group are named by the group and the separator
+	if strings.Contains(group.Name, authapi.KubernetesNamespaceSeparator) {
+		return fmt.Errorf("group name can't contain %v", authapi.KubernetesNamespaceSeparator)
+	}
+	if !authapi.MFAPolicies[group.MFAPolicy] {
+		return fmt.Errorf("group mfa policy %v is unknown", group.MFAPolicy)
+	}
+	if group.PasswordMaxAge < 0 {
+		return fmt.Errorf("group password max age should not be negative")
+	}
+	if err := group.TokenPolicy.Valid(); err != nil {
+		return err
+	}
 	if len(group.Annotation) != 0 {
 		// allow annotation is empty
 		if len(group.Annotation) > AnnotationMaxLen {
@@ -686,11 +1067,14 @@ func (c AuthController) CreateGroup(w http.ResponseWriter, r *http.Request) {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	// only op service can create
-	if !authapi.GetLargestRolePermission(info.Role).IsLargerPermission(authapi.OpServiceRole) {
+	if !isAllowedInAllGroups(info, authapi.GroupResource, authapi.CreateVerb) {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to create group")
 		return
 	}
+	if !info.Scope.AllowsAction(authapi.GroupResource, authapi.CreateVerb) || !info.Scope.AllowsAllGroups() {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to create group")
+		return
+	}
 
 	requestBody, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -757,7 +1141,7 @@ func (c AuthController) ModifyGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !authapi.GetLargestRolePermission(info.Role).IsLargerPermission(authapi.OpServiceRole) {
+	if !isAllowedInAllGroups(info, authapi.GroupResource, authapi.UpdateVerb) {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to modify")
 		return
 	}
@@ -778,6 +1162,10 @@ func (c AuthController) ModifyGroup(w http.ResponseWriter, r *http.Request) {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("%v", err))
 		return
 	}
+	if !info.Scope.AllowsAction(authapi.GroupResource, authapi.UpdateVerb) || !info.Scope.AllowsGroup(group.Name) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to modify group")
+		return
+	}
 	isAllowed, message, err := isAllowModifyGroup(group)
 	if err != nil {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("check group is allowed modify failed, %v", err))
@@ -809,12 +1197,15 @@ func (c AuthController) DeleteGroup(w http.ResponseWriter, r *http.Request) {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	// only op service can delete group
-	if !authapi.GetLargestRolePermission(info.Role).IsLargerPermission(authapi.OpServiceRole) {
+	if !isAllowedInAllGroups(info, authapi.GroupResource, authapi.DeleteVerb) {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to delete group")
 		return
 	}
 	name := mux.Vars(r)["name"]
+	if !info.Scope.AllowsAction(authapi.GroupResource, authapi.DeleteVerb) || !info.Scope.AllowsGroup(name) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to delete group")
+		return
+	}
 	glog.Infof("delete group[%v] by %v/%v", name, info.Name, info.UserID)
 	err = authsvc.DeleteGroupByName(name)
 	if err == orm.ErrNoRows {
@@ -838,10 +1229,14 @@ func (c AuthController) GetGroup(w http.ResponseWriter, r *http.Request) {
 	}
 
 	name := mux.Vars(r)["name"]
-	if info.Group.Name != name && !authapi.GetLargestRolePermission(info.Role).IsLargerPermission(authapi.OpServiceRole) {
+	if info.Group.Name != name && !isAllowedInAllGroups(info, authapi.GroupResource, authapi.ReadVerb) {
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to get group detail")
 		return
 	}
+	if !info.Scope.AllowsAction(authapi.GroupResource, authapi.ReadVerb) || !info.Scope.AllowsGroup(name) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to get group detail")
+		return
+	}
 
 	group, err := authsvc.GetGroupByName(name)
 	if err == orm.ErrNoRows {
@@ -862,8 +1257,29 @@ func (c AuthController) GetGroup(w http.ResponseWriter, r *http.Request) {
 }
 
 func (c AuthController) ListGroup(w http.ResponseWriter, r *http.Request) {
+	info, err := util.GetUserInfo(r.Header.Get(authapi.ParseInfo))
+	if err != nil {
+		glog.Errorf("get user info from header failed, err: %v", err)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !info.Scope.AllowsAction(authapi.GroupResource, authapi.ReadVerb) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to list group")
+		return
+	}
 	dataSelect := parse.ParseDataSelectPathParameter(r)
-	groups, num, err := authsvc.ListGroup(dataSelect)
+	var groups []authapi.Group
+	var num int64
+	if info.Scope.AllowsAllGroups() {
+		groups, num, err = authsvc.ListGroup(dataSelect)
+	} else {
+		// the token restricted to a group can only see that group
+		var group *authapi.Group
+		group, err = authsvc.GetGroupByName(info.Scope.Group)
+		if err == nil {
+			groups, num = []authapi.Group{*group}, 1
+		}
+	}
 	if err != nil {
 		glog.Errorf("list group failed, %v", err)
 		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("%v", err))
@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+
+	authapi "imanager/pkg/api/auth"
+	authsvc "imanager/pkg/services/auth"
+)
+
+// clientCredentialsToken issues the token of the client itself, the requested actions should be allowed by the client,
+// and all the allowed scopes are granted if no action is requested
+func (c AuthController) clientCredentialsToken(w http.ResponseWriter, client *authapi.OAuthClient, scope string) {
+	// the token without actions isn't restricted, the client saved before the allowed scopes are required has none
+	if len(client.AllowedScopes) == 0 {
+		returnOAuthError(w, authapi.InvalidScopeError, "client has no allowed scopes")
+		return
+	}
+	actions := strings.Fields(scope)
+	for _, v := range actions {
+		if err := authapi.ValidAction(v); err != nil {
+			returnOAuthError(w, authapi.InvalidScopeError, err.Error())
+			return
+		}
+		if !client.AllowsScope(v) {
+			returnOAuthError(w, authapi.InvalidScopeError, fmt.Sprintf("client isn't allowed to request %v", v))
+			return
+		}
+	}
+	if len(actions) == 0 {
+		actions = client.AllowedScopes
+	}
+
+	res, tokenss, err := authsvc.CreateClientToken(client, actions)
+	if err != nil {
+		returnOAuthError(w, authapi.ServerError, "create client token failed")
+		return
+	}
+	glog.Infof("issue token of client[%v/%v] by %v", client.Name, client.ClientID, authapi.ClientCredentialsGrantType)
+
+	respBody, _ := json.Marshal(authapi.OAuthTokenResponse{
+		AccessToken: tokenss,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(res.ExpiresAt.Sub(res.IssuedAt).Seconds()),
+		Scope:       res.Scope.String(),
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(respBody)
+}
@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	authapi "imanager/pkg/api/auth"
+	authsvc "imanager/pkg/services/auth"
+)
+
+func TestClientCredentialsToken(t *testing.T) {
+	o := setupTestDB(t)
+	useTestSigningKeys(t)
+	createTestRoles(t, o)
+	createClient := func(name string, allowedScopes ...string) *authapi.OAuthClient {
+		client, err := authsvc.CreateOAuthClient(&authapi.OAuthClient{
+			Name:          name,
+			Group:         &authapi.GroupInUser{Name: "g1"},
+			GrantTypes:    []string{authapi.ClientCredentialsGrantType},
+			AllowedScopes: allowedScopes,
+		}, "admin1")
+		if err != nil {
+			t.Fatalf("create oauth client %v failed, err: %v", name, err)
+		}
+		return client
+	}
+	createTestUser(t, o, "admin1", "g1")
+	// the client saved before the allowed scopes are required
+	unrestricted := createClient("unrestricted")
+	client := createClient("service", "user:read", "group:read")
+
+	cases := []struct {
+		name   string
+		client *authapi.OAuthClient
+		scope  string
+		err    string
+		expect string
+	}{
+		{"client without allowed scopes", unrestricted, "", authapi.InvalidScopeError, ""},
+		{"client without allowed scopes requests action", unrestricted, "user:read", authapi.InvalidScopeError, ""},
+		{"all allowed scopes", client, "", "", "user:read group:read"},
+		{"requested action", client, "user:read", "", "user:read"},
+		{"action not allowed", client, "user:write", authapi.InvalidScopeError, ""},
+	}
+	for _, c := range cases {
+		form := url.Values{"grant_type": {authapi.ClientCredentialsGrantType}}
+		if len(c.scope) != 0 {
+			form.Set("scope", c.scope)
+		}
+		w := serveTestForm(AuthController{}.OAuthToken, authapi.OAuthTokenURL, form, c.client)
+		res, oauthErr := decodeOAuthResponse(w)
+		if oauthErr.Error != c.err {
+			t.Logf("%v: error should be %q, got %q, body: %v", c.name, c.err, oauthErr.Error, w.Body.String())
+			t.Fail()
+			continue
+		}
+		if len(c.err) == 0 && (w.Code != http.StatusOK || len(res.AccessToken) == 0 || res.Scope != c.expect) {
+			t.Logf("%v: token of scope %q should be issued, status: %v, body: %v", c.name, c.expect, w.Code, w.Body.String())
+			t.Fail()
+		}
+	}
+}
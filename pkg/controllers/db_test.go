@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	_ "github.com/mattn/go-sqlite3"
+	uuid "github.com/satori/go.uuid"
+
+	authapi "imanager/pkg/api/auth"
+	"imanager/pkg/config"
+	authdb "imanager/pkg/db/auth"
+	"imanager/pkg/encrypt"
+	"imanager/pkg/encrypt/verifier"
+	authsvc "imanager/pkg/services/auth"
+)
+
+var registerTestDB sync.Once
+
+// setupTestDB recreates all the tables in an in-memory sqlite, so the controllers can be tested without mysql
+func setupTestDB(t *testing.T) orm.Ormer {
+	registerTestDB.Do(func() {
+		orm.DefaultTimeLoc = time.UTC
+		if err := orm.RegisterDataBase("default", "sqlite3", "file::memory:?cache=shared"); err != nil {
+			t.Fatalf("register test database failed, err: %v", err)
+		}
+		orm.RegisterModel(authdb.Models()...)
+	})
+	if err := orm.RunSyncdb("default", true, false); err != nil {
+		t.Fatalf("create test tables failed, err: %v", err)
+	}
+	return orm.NewOrm()
+}
+
+// createTestRoles creates the builtin roles op_service, admin and user, so they have the builtin permissions
+func createTestRoles(t *testing.T, o orm.Ormer) (opService, admin, user *authdb.Role) {
+	roles := make([]*authdb.Role, 0, 3)
+	for _, v := range []authapi.RoleType{authapi.OpServiceRole, authapi.AdminRole, authapi.UserRole} {
+		role := &authdb.Role{Id: int(v), Name: v.String()}
+		if _, err := o.Insert(role); err != nil {
+			t.Fatalf("create role %v failed, err: %v", v, err)
+		}
+		roles = append(roles, role)
+	}
+	return roles[0], roles[1], roles[2]
+}
+
+// createTestUser creates a local user in the group, the group is created if it doesn't exist
+func createTestUser(t *testing.T, o orm.Ormer, name, groupName string, roles ...*authdb.Role) *authapi.User {
+	group, err := authdb.GetGroupByName(o, groupName)
+	if err == orm.ErrNoRows {
+		group = authdb.Group{Name: groupName}
+		_, err = o.Insert(&group)
+	}
+	if err != nil {
+		t.Fatalf("create group %v failed, err: %v", groupName, err)
+	}
+	_, err = authdb.CreateUser(o, authdb.User{
+		UUID:   uuid.NewV4().String(),
+		Name:   name,
+		Group:  &group,
+		Role:   roles,
+		Source: authapi.LocalUserSource,
+	})
+	if err != nil {
+		t.Fatalf("create user %v failed, err: %v", name, err)
+	}
+	user, err := authsvc.GetUserByName(name)
+	if err != nil {
+		t.Fatalf("get user %v failed, err: %v", name, err)
+	}
+	return user
+}
+
+// setTestPassword sets the password of the user without the reversible one
+func setTestPassword(t *testing.T, o orm.Ormer, user *authapi.User, password string) {
+	hash, err := verifier.Hash(password, verifier.BcryptAlgorithm)
+	if err != nil {
+		t.Fatalf("hash password failed, err: %v", err)
+	}
+	if err = authdb.UpdateUserPassword(o, user.UUID, hash, ""); err != nil {
+		t.Fatalf("set password of user %v failed, err: %v", user.Name, err)
+	}
+}
+
+// testUserInfo is the info which authFilter sets for the token of the user
+func setTestConfig(t *testing.T, key, value string) {
+	old := config.GetConfig().String(key)
+	if err := config.GetConfig().Set(key, value); err != nil {
+		t.Fatalf("set config %v failed, err: %v", key, err)
+	}
+	t.Cleanup(func() { _ = config.GetConfig().Set(key, old) })
+}
+
+func testUserInfo(user *authapi.User) *authapi.RespToken {
+	now := time.Now()
+	return &authapi.RespToken{
+		ExpiresAt: now.Add(time.Hour),
+		IssuedAt:  now,
+		Name:      user.Name,
+		UserID:    user.UUID,
+		Role:      user.Role,
+		Group:     user.Group,
+		TokenID:   uuid.NewV4().String(),
+	}
+}
+
+// useTestSigningKeys signs the tokens by a new rsa key in a temp dir
+func useTestSigningKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signing")
+	if err != nil {
+		t.Fatalf("create temp dir failed, err: %v", err)
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate rsa key failed, err: %v", err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err = ioutil.WriteFile(path.Join(dir, encrypt.SigningKeyFilePrefix+"1"+encrypt.SigningKeyFileSuffix), data, 0600); err != nil {
+		t.Fatalf("write signing key failed, err: %v", err)
+	}
+	oldDir := encrypt.SigningKeyDir
+	encrypt.SigningKeyDir = dir
+	if err = authsvc.ReloadSigningKeys(); err != nil {
+		t.Fatalf("load signing keys failed, err: %v", err)
+	}
+	t.Cleanup(func() {
+		encrypt.SigningKeyDir = oldDir
+		_ = authsvc.ReloadSigningKeys()
+		_ = os.RemoveAll(dir)
+	})
+}
+
+// serveTest calls the handler with the json body, info is set to the header as authFilter does if it isn't nil
+func serveTest(handler http.HandlerFunc, method, url string, body interface{}, info *authapi.RespToken) *httptest.ResponseRecorder {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	r := httptest.NewRequest(method, url, bytes.NewReader(data))
+	if info != nil {
+		infoData, _ := json.Marshal(info)
+		r.Header.Set(authapi.ParseInfo, string(infoData))
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/golang/glog"
+
+	authapi "imanager/pkg/api/auth"
+	authsvc "imanager/pkg/services/auth"
+	"imanager/pkg/util"
+)
+
+// the max items of a batch decision, a page rarely has more buttons
+const maxDecisionItems = 100
+
+// decide returns the decisions of the subject. The caller can decide for himself, the token of others needs the
+// permission to read the tokens of all groups as introspection, and the user needs the permission to read the user.
+// The error response is written if it fails
+func decide(w http.ResponseWriter, info *authapi.RespToken, reqSubject authapi.DecisionSubject,
+	items []authapi.DecisionItem) (string, []authapi.Decision, bool) {
+	subject := info
+	switch {
+	case len(reqSubject.Token) != 0:
+		if !isAllowedInAllGroups(info, authapi.TokenResource, authapi.ReadVerb) {
+			util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to decide for the token")
+			return "", nil, false
+		}
+		tokenInfo, err := authsvc.ValidateToken(reqSubject.Token)
+		if err != nil {
+			// the invalid token is denied for everything
+			res := make([]authapi.Decision, 0, len(items))
+			for range items {
+				res = append(res, authapi.Decision{
+					Reason: fmt.Sprintf("token is invalid, %v", err),
+					Rule:   &authapi.MatchedRule{Kind: authapi.TokenRule},
+				})
+			}
+			return "", res, true
+		}
+		subject = &tokenInfo
+	case len(reqSubject.User) != 0 && reqSubject.User != info.Name:
+		if !isAllowedOnUser(&authapi.User{Name: reqSubject.User}, info, authapi.UserResource, authapi.ReadVerb) {
+			util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to decide for the user")
+			return "", nil, false
+		}
+		if !info.Scope.AllowsAction(authapi.UserResource, authapi.ReadVerb) || !isAllowedUserByScope(reqSubject.User, info) {
+			util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to decide for the user")
+			return "", nil, false
+		}
+		var err error
+		subject, err = authsvc.GetDecisionSubject(reqSubject.User)
+		if err == orm.ErrNoRows {
+			util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "user isn't exist")
+			return "", nil, false
+		}
+		if err == authsvc.ErrLoginLocked || err == authsvc.ErrInactiveExternalUser {
+			// the user who can't login is denied for everything
+			res := make([]authapi.Decision, 0, len(items))
+			for range items {
+				res = append(res, authapi.Decision{
+					Reason: fmt.Sprintf("user can't login, %v", err),
+					Rule:   &authapi.MatchedRule{Kind: authapi.UserRule},
+				})
+			}
+			return reqSubject.User, res, true
+		}
+		if err != nil {
+			util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("get user failed, %v", err))
+			return "", nil, false
+		}
+	}
+
+	res, err := authsvc.Decide(subject, items)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("decide failed, %v", err))
+		return "", nil, false
+	}
+	return subject.Name, res, true
+}
+
+// Decide answers whether the subject can do the action on the resource, so that the other services needn't
+// check the roles themselves
+func (c AuthController) Decide(w http.ResponseWriter, r *http.Request) {
+	info, err := util.GetUserInfo(r.Header.Get(authapi.ParseInfo))
+	if err != nil {
+		glog.Errorf("get user info from header failed, err: %v", err)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	requestBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("request body read failed, %v", err))
+		return
+	}
+	req := authapi.ReqDecision{}
+	err = json.Unmarshal(requestBody, &req)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("request body unmarshal failed, %v", err))
+		return
+	}
+
+	subject, decisions, ok := decide(w, info, req.Subject, []authapi.DecisionItem{req.DecisionItem})
+	if !ok {
+		return
+	}
+	glog.V(4).Infof("decide %v:%v of %v for %v, allowed: %v", req.Resource.Type, req.Action, subject, info.Name, decisions[0].Allowed)
+	respBody, _ := json.Marshal(decisions[0])
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(respBody)
+}
+
+// DecideBatch decides the requests of a subject at once, e.g. all the actions on a page
+func (c AuthController) DecideBatch(w http.ResponseWriter, r *http.Request) {
+	info, err := util.GetUserInfo(r.Header.Get(authapi.ParseInfo))
+	if err != nil {
+		glog.Errorf("get user info from header failed, err: %v", err)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	requestBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("request body read failed, %v", err))
+		return
+	}
+	req := authapi.ReqDecisions{}
+	err = json.Unmarshal(requestBody, &req)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("request body unmarshal failed, %v", err))
+		return
+	}
+	if len(req.Items) > maxDecisionItems {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("at most %v items can be decided at once", maxDecisionItems))
+		return
+	}
+
+	subject, decisions, ok := decide(w, info, req.Subject, req.Items)
+	if !ok {
+		return
+	}
+	respBody, _ := json.Marshal(authapi.DecisionList{
+		Subject: subject,
+		Items:   decisions,
+	})
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(respBody)
+}
@@ -0,0 +1,179 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/golang/glog"
+
+	authapi "imanager/pkg/api/auth"
+	authsvc "imanager/pkg/services/auth"
+	"imanager/pkg/util"
+)
+
+// DeviceAuthorization issues the device code and the user code to the client, such as the command line tools
+func (c AuthController) DeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		returnOAuthError(w, authapi.InvalidRequestError, "request form parse failed")
+		return
+	}
+	client, err := authenticateOAuthClient(r)
+	if err == authsvc.ErrInvalidOAuthClient {
+		returnOAuthError(w, authapi.InvalidClientError, err.Error())
+		return
+	}
+	if err != nil {
+		returnOAuthError(w, authapi.ServerError, "authenticate client failed")
+		return
+	}
+	if !client.AllowsGrantType(authapi.DeviceCodeGrantType) {
+		returnOAuthError(w, authapi.UnauthorizedClientError, "client isn't allowed to use device code grant")
+		return
+	}
+	scope := r.PostForm.Get("scope")
+	_, actions, err := authapi.ParseOAuthScope(scope)
+	if err != nil {
+		returnOAuthError(w, authapi.InvalidScopeError, err.Error())
+		return
+	}
+	for _, v := range actions {
+		if !client.AllowsScope(v) {
+			returnOAuthError(w, authapi.InvalidScopeError, fmt.Sprintf("client isn't allowed to request %v", v))
+			return
+		}
+	}
+
+	resp, err := authsvc.CreateDeviceAuthorization(client.ClientID, scope)
+	if err != nil {
+		returnOAuthError(w, authapi.ServerError, fmt.Sprintf("create device code failed, %v", err))
+		return
+	}
+	glog.Infof("issue device code %v to client %v/%v", resp.UserCode, client.Name, client.ClientID)
+	respBody, _ := json.Marshal(resp)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(respBody)
+}
+
+func renderDevicePage(w http.ResponseWriter, statusCode int, r *http.Request, device *authsvc.DeviceAuthorization, errMsg string) {
+	data := loginPageData{
+		Action:   authapi.OAuthDeviceVerificationURL,
+		Title:    "Device login",
+		Name:     r.PostForm.Get("name"),
+		Error:    errMsg,
+		Device:   true,
+		UserCode: r.Form.Get("user_code"),
+		Consent:  true,
+	}
+	if device != nil {
+		data.Title = device.Client.Name
+		data.Message = fmt.Sprintf("%v requests to sign in as you", device.Client.Name)
+		if len(device.Scope) != 0 {
+			data.Message += fmt.Sprintf(" with scope %q", device.Scope)
+		}
+	}
+	writeLoginPage(w, statusCode, data)
+}
+
+// DeviceVerification is the page where the user enters the user code, logs in and approves or denies the device
+func (c AuthController) DeviceVerification(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "request form parse failed")
+		return
+	}
+	userCode := r.Form.Get("user_code")
+	if r.Method != http.MethodPost {
+		var device *authsvc.DeviceAuthorization
+		if len(userCode) != 0 {
+			device, _ = authsvc.GetDeviceAuthorization(userCode)
+		}
+		renderDevicePage(w, http.StatusOK, r, device, "")
+		return
+	}
+
+	device, err := authsvc.GetDeviceAuthorization(userCode)
+	if err == authsvc.ErrInvalidUserCode {
+		renderDevicePage(w, http.StatusBadRequest, r, nil, err.Error())
+		return
+	}
+	if err != nil {
+		renderDevicePage(w, http.StatusInternalServerError, r, nil, "get device code failed")
+		return
+	}
+	user, statusCode, errMsg := authenticateLoginForm(w, r, device.Client)
+	if user == nil {
+		renderDevicePage(w, statusCode, r, device, errMsg)
+		return
+	}
+	approve := r.PostForm.Get("decision") == "approve"
+	err = authsvc.DecideDeviceAuthorization(device.UserCode, user, approve)
+	if err == authsvc.ErrInvalidUserCode {
+		renderDevicePage(w, http.StatusBadRequest, r, nil, err.Error())
+		return
+	}
+	if err != nil {
+		renderDevicePage(w, http.StatusBadRequest, r, device, err.Error())
+		return
+	}
+	glog.Infof("%v/%v decides device %v of client %v/%v, approve: %v", user.Name, user.UUID, device.UserCode,
+		device.Client.Name, device.Client.ClientID, approve)
+
+	data := loginPageData{Title: device.Client.Name, Done: true, Message: "The device is denied, you can close the page."}
+	if approve {
+		data.Message = "The device is approved, you can return to it and close the page."
+	}
+	writeLoginPage(w, http.StatusOK, data)
+}
+
+// ApproveDevice approves or denies the device by the token of the logged-in user, so that the web console
+// doesn't ask for the password again
+func (c AuthController) ApproveDevice(w http.ResponseWriter, r *http.Request) {
+	info, err := util.GetUserInfo(r.Header.Get(authapi.ParseInfo))
+	if err != nil {
+		glog.Errorf("get user info from header failed, err: %v", err)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// the device gets a new token of the user, so only the token of the user's login can approve it
+	if info.TokenType != "" || info.Act != nil || !info.Scope.IsEmpty() {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to approve device")
+		return
+	}
+	requestBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("request body read failed, %v", err))
+		return
+	}
+	req := authapi.ReqDeviceApproval{}
+	err = json.Unmarshal(requestBody, &req)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("request body unmarshal failed, %v", err))
+		return
+	}
+	user, err := authsvc.GetUserByName(info.Name)
+	if err == orm.ErrNoRows {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "user isn't exist")
+		return
+	}
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("get user failed, %v", err))
+		return
+	}
+	user.Password = ""
+
+	glog.Infof("%v/%v decides device %v, approve: %v", info.Name, info.UserID, req.UserCode, req.Approve)
+	err = authsvc.DecideDeviceAuthorization(req.UserCode, user, req.Approve)
+	if err == authsvc.ErrInvalidUserCode {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("decide device failed, %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
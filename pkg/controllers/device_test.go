@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"net/http"
+	"testing"
+
+	authapi "imanager/pkg/api/auth"
+	authsvc "imanager/pkg/services/auth"
+)
+
+func TestApproveDevice(t *testing.T) {
+	o := setupTestDB(t)
+	setTestConfig(t, "TokenIssuer", "https://imanager.example.com")
+	_, _, userRole := createTestRoles(t, o)
+	user := createTestUser(t, o, "u1", "g1", userRole)
+	actor := createTestUser(t, o, "admin1", "g1", userRole)
+
+	scoped := testUserInfo(user)
+	scoped.Scope = &authapi.TokenScope{Actions: []string{"user:read"}}
+	impersonated := testUserInfo(user)
+	impersonated.Act = &authapi.Actor{Subject: actor.UUID, Name: actor.Name}
+	client := testUserInfo(user)
+	client.TokenType = authapi.ClientTokenType
+
+	cases := []struct {
+		name   string
+		info   *authapi.RespToken
+		status int
+	}{
+		{"scoped token", scoped, http.StatusBadRequest},
+		{"impersonated token", impersonated, http.StatusBadRequest},
+		{"client token", client, http.StatusBadRequest},
+		{"token of login", testUserInfo(user), http.StatusOK},
+	}
+	for _, c := range cases {
+		device, err := authsvc.CreateDeviceAuthorization("app", "openid")
+		if err != nil {
+			t.Fatalf("create device authorization failed, err: %v", err)
+		}
+		w := serveTest(AuthController{}.ApproveDevice, authapi.OAuthDeviceApprovalMethod, authapi.OAuthDeviceApprovalURL,
+			&authapi.ReqDeviceApproval{UserCode: device.UserCode, Approve: true}, c.info)
+		if w.Code != c.status {
+			t.Logf("%v: status should be %v, got %v, body: %v", c.name, c.status, w.Code, w.Body.String())
+			t.Fail()
+		}
+		_, err = authsvc.PollDeviceCode("app", device.DeviceCode)
+		if approved := err == nil; approved != (c.status == http.StatusOK) {
+			t.Logf("%v: device should be approved only by the token of login, err: %v", c.name, err)
+			t.Fail()
+		}
+	}
+}
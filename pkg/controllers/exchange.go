@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/golang/glog"
+
+	authapi "imanager/pkg/api/auth"
+	authsvc "imanager/pkg/services/auth"
+	"imanager/pkg/util"
+)
+
+// isAllowedImpersonateUser allows the actor whose roles allow to create the tokens of the user,
+// the impersonated user can't have more authority than the actor
+func isAllowedImpersonateUser(user *authapi.User, actor *authapi.RespToken) bool {
+	if !isAllowedGrantRoles(user.Role, actor) {
+		return false
+	}
+	return isAllowedOnUser(user, actor, authapi.TokenResource, authapi.CreateVerb)
+}
+
+// exchangeToken issues a token of the subject user to the actor, see rfc8693. There is no refresh token
+// for the impersonation, the actor should exchange again when the token expires
+func (c AuthController) exchangeToken(w http.ResponseWriter, r *http.Request, reqToken authapi.ReqToken) {
+	if reqToken.Auth.SubjectTokenType != authapi.UserNameTokenType {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("unsupported subject token type %v", reqToken.Auth.SubjectTokenType))
+		return
+	}
+	if len(reqToken.Auth.ActorTokenType) != 0 && reqToken.Auth.ActorTokenType != authapi.AccessTokenType {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("unsupported actor token type %v", reqToken.Auth.ActorTokenType))
+		return
+	}
+	actorToken := reqToken.Auth.ActorToken
+	if len(actorToken) == 0 {
+		actorToken = r.Header.Get(authapi.TokenHeaderKey)
+	}
+	actor, err := authsvc.ValidateToken(actorToken)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusUnauthorized, fmt.Sprintf("parse actor token failed, %v", err))
+		return
+	}
+	if actor.Act != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "impersonated token can't be exchanged")
+		return
+	}
+	if actor.TokenType == authapi.ClientTokenType {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "client token can't be exchanged")
+		return
+	}
+	if !actor.Scope.IsEmpty() {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "scoped token can't be exchanged")
+		return
+	}
+
+	name := reqToken.Auth.SubjectToken
+	user, err := authsvc.GetUserByName(name)
+	if err == orm.ErrNoRows {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "user isn't exist")
+		return
+	}
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("get user failed, %v", err))
+		return
+	}
+	user.Password = ""
+	if user.UUID == actor.UserID {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no need to impersonate yourself")
+		return
+	}
+	if !isAllowedImpersonateUser(user, &actor) {
+		glog.Errorf("%v/%v is not allowed to impersonate user[%v]", actor.Name, actor.UserID, name)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to impersonate user")
+		return
+	}
+	scope, err := authsvc.ValidTokenScope(user, reqToken.Scope)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("invalid token scope, %v", err))
+		return
+	}
+
+	policy, err := authsvc.GetTokenPolicy(user)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("get token policy failed, %v", err))
+		return
+	}
+	// the impersonation is always short
+	policy.Restrict(&authapi.TokenPolicy{MaxDuration: authapi.DefaultExpireTime})
+	duration, err := authsvc.TokenDuration(policy, int(reqToken.Scope.Duration))
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	issuedAt := time.Now()
+	res := authapi.RespToken{
+		ExpiresAt: issuedAt.Add(duration),
+		IssuedAt:  issuedAt,
+		Name:      user.Name,
+		UserID:    user.UUID,
+		Role:      user.Role,
+		Group:     user.Group,
+		TrueName:  user.TruthName,
+		Scope:     scope,
+		Act: &authapi.Actor{
+			Subject: actor.UserID,
+			Name:    actor.Name,
+		},
+	}
+	tokenss, err := authsvc.CreateToken(&res)
+	if err != nil {
+		glog.Errorf("create token failed, user name: %v, err: %v", user.Name, err)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("create token failed, %v", err))
+		return
+	}
+	glog.Warningf("%v/%v impersonates user[%v/%v] from %v, token: %v, expires at: %v",
+		actor.Name, actor.UserID, user.Name, user.UUID, getClientIP(r), res.TokenID, res.ExpiresAt.Format(time.RFC3339))
+
+	respBody, _ := json.Marshal(res)
+	w.Header().Set(authapi.TokenHeaderKey, tokenss)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(respBody)
+}
@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	authapi "imanager/pkg/api/auth"
+	authsvc "imanager/pkg/services/auth"
+)
+
+func exchangeTestToken(actorToken, subject string) *authapi.ReqToken {
+	return &authapi.ReqToken{
+		GrantType: authapi.TokenExchangeGrantType,
+		Auth: authapi.ReqTokenAuth{
+			SubjectToken:     subject,
+			SubjectTokenType: authapi.UserNameTokenType,
+			ActorToken:       actorToken,
+			ActorTokenType:   authapi.AccessTokenType,
+		},
+	}
+}
+
+func TestExchangeToken(t *testing.T) {
+	o := setupTestDB(t)
+	useTestSigningKeys(t)
+	opServiceRole, adminRole, userRole := createTestRoles(t, o)
+	admin := createTestUser(t, o, "admin1", "g1", adminRole, userRole)
+	createTestUser(t, o, "u1", "g1", userRole)
+	createTestUser(t, o, "u2", "g2", userRole)
+	createTestUser(t, o, "op1", "g1", opServiceRole)
+	normal := createTestUser(t, o, "u3", "g1", userRole)
+
+	adminToken, err := authsvc.CreateToken(testUserInfo(admin))
+	if err != nil {
+		t.Fatalf("create token failed, err: %v", err)
+	}
+	userToken, err := authsvc.CreateToken(testUserInfo(normal))
+	if err != nil {
+		t.Fatalf("create token failed, err: %v", err)
+	}
+	scopedInfo := testUserInfo(admin)
+	scopedInfo.Scope = &authapi.TokenScope{Actions: []string{"token:create"}}
+	scopedToken, err := authsvc.CreateToken(scopedInfo)
+	if err != nil {
+		t.Fatalf("create token failed, err: %v", err)
+	}
+	// the personal access token is restricted to the roles in it
+	fullPAT, err := authsvc.CreatePersonalAccessToken("admin1", admin.UUID, &authapi.PersonalAccessToken{Name: "full"})
+	if err != nil {
+		t.Fatalf("create personal access token failed, err: %v", err)
+	}
+	userPAT, err := authsvc.CreatePersonalAccessToken("admin1", admin.UUID, &authapi.PersonalAccessToken{
+		Name: "user",
+		Role: []authapi.RoleInUser{{ID: userRole.Id}},
+	})
+	if err != nil {
+		t.Fatalf("create personal access token failed, err: %v", err)
+	}
+
+	cases := []struct {
+		name    string
+		actor   string
+		subject string
+		status  int
+	}{
+		{"admin impersonates user in group", adminToken, "u1", http.StatusOK},
+		{"user in other group", adminToken, "u2", http.StatusBadRequest},
+		{"user with larger role", adminToken, "op1", http.StatusBadRequest},
+		{"user can't impersonate", userToken, "u1", http.StatusBadRequest},
+		{"scoped token", scopedToken, "u1", http.StatusBadRequest},
+		{"impersonate himself", adminToken, "admin1", http.StatusBadRequest},
+		{"unknown user", adminToken, "unknown", http.StatusBadRequest},
+		{"invalid actor token", "invalid", "u1", http.StatusUnauthorized},
+		{"personal access token", fullPAT.Token, "u1", http.StatusOK},
+		{"personal access token of user role", userPAT.Token, "u1", http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		w := serveTest(AuthController{}.CreateTokenInHttp, http.MethodPost, authapi.GetTokenURL, exchangeTestToken(c.actor, c.subject), nil)
+		if w.Code != c.status {
+			t.Logf("%v: status should be %v, got %v, body: %v", c.name, c.status, w.Code, w.Body.String())
+			t.Fail()
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		res := authapi.RespToken{}
+		_ = json.Unmarshal(w.Body.Bytes(), &res)
+		if res.Name != c.subject || res.Act == nil || res.Act.Subject != admin.UUID || len(w.Header().Get(authapi.RefreshTokenHeaderKey)) != 0 {
+			t.Logf("%v: token should be issued to the actor without refresh token: %+v", c.name, res)
+			t.Fail()
+		}
+		info, err := authsvc.ValidateToken(w.Header().Get(authapi.TokenHeaderKey))
+		if err != nil || info.Act == nil || info.Act.Name != "admin1" {
+			t.Logf("%v: exchanged token should be valid, info: %+v, err: %v", c.name, info, err)
+			t.Fail()
+			continue
+		}
+
+		// the impersonated token can't be exchanged again
+		w = serveTest(AuthController{}.CreateTokenInHttp, http.MethodPost, authapi.GetTokenURL,
+			exchangeTestToken(w.Header().Get(authapi.TokenHeaderKey), "u1"), nil)
+		if w.Code != http.StatusBadRequest {
+			t.Logf("%v: impersonated token shouldn't be exchanged, status: %v", c.name, w.Code)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+
+	authapi "imanager/pkg/api/auth"
+	apiutil "imanager/pkg/api/util"
+	authsvc "imanager/pkg/services/auth"
+	"imanager/pkg/util"
+)
+
+// ExtAuthz is the auth_request of nginx. The identity is returned in the ParseInfo header as authFilter sets it,
+// and nginx should pass it to the upstream by auth_request_set, it's empty for the anonymous request of
+// the public route so that the header of the client is overwritten
+func (c AuthController) ExtAuthz(w http.ResponseWriter, r *http.Request) {
+	uri := r.Header.Get(authapi.OriginalURIHeader)
+	if len(uri) == 0 {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "original uri is empty")
+		return
+	}
+	method := r.Header.Get(authapi.OriginalMethodHeader)
+	if len(method) == 0 {
+		method = r.Method
+	}
+	host := r.Header.Get(authapi.OriginalHostHeader)
+	if len(host) == 0 {
+		host = r.Host
+	}
+
+	res := authsvc.CheckRoute(host, method, uri, r.Header.Get(authapi.TokenHeaderKey))
+	glog.V(4).Infof("check route %v %v%v, status: %v, reason: %v", method, host, uri, res.Status, res.Reason)
+	if !res.Allowed() {
+		util.ReturnErrorResponseInResponseWriter(w, res.Status, res.Reason)
+		return
+	}
+	if res.Info != nil {
+		data, _ := json.Marshal(res.Info)
+		w.Header().Set(authapi.ParseInfo, string(data))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// ExtAuthzServer is the envoy external authorization service on the grpc port
+type ExtAuthzServer struct{}
+
+// Check decides the request of envoy in the same way as ExtAuthz. The ParseInfo header of the client is
+// always replaced or removed
+func (s ExtAuthzServer) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	httpReq := req.GetAttributes().GetRequest().GetHttp()
+	// the headers of envoy are in lower case
+	token := httpReq.GetHeaders()[strings.ToLower(authapi.TokenHeaderKey)]
+	res := authsvc.CheckRoute(httpReq.GetHost(), httpReq.GetMethod(), httpReq.GetPath(), token)
+	glog.V(4).Infof("check route %v %v%v, status: %v, reason: %v", httpReq.GetMethod(), httpReq.GetHost(),
+		httpReq.GetPath(), res.Status, res.Reason)
+	if !res.Allowed() {
+		code := codes.PermissionDenied
+		if res.Status == http.StatusUnauthorized {
+			code = codes.Unauthenticated
+		}
+		body, _ := json.Marshal(apiutil.ErrorResponse{ErrorCode: res.Status, ErrorMessage: res.Reason})
+		return &authv3.CheckResponse{
+			Status: &status.Status{Code: int32(code), Message: res.Reason},
+			HttpResponse: &authv3.CheckResponse_DeniedResponse{
+				DeniedResponse: &authv3.DeniedHttpResponse{
+					Status: &typev3.HttpStatus{Code: typev3.StatusCode(res.Status)},
+					Headers: []*corev3.HeaderValueOption{{
+						Header: &corev3.HeaderValue{Key: "Content-Type", Value: "application/json"},
+					}},
+					Body: string(body),
+				},
+			},
+		}, nil
+	}
+
+	okResp := &authv3.OkHttpResponse{}
+	if res.Info != nil {
+		data, _ := json.Marshal(res.Info)
+		okResp.Headers = []*corev3.HeaderValueOption{{
+			Header: &corev3.HeaderValue{Key: authapi.ParseInfo, Value: string(data)},
+			Append: &wrappers.BoolValue{Value: false},
+		}}
+	} else {
+		okResp.HeadersToRemove = []string{authapi.ParseInfo}
+	}
+	return &authv3.CheckResponse{
+		Status:       &status.Status{Code: int32(codes.OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: okResp},
+	}, nil
+}
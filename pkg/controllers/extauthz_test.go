@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"google.golang.org/grpc/codes"
+
+	authapi "imanager/pkg/api/auth"
+	authsvc "imanager/pkg/services/auth"
+)
+
+type testRouteTokens struct {
+	admin  string
+	user   string
+	scoped string
+}
+
+// setupTestRoutes allows anyone on /public/*, and only the admins of g1 on /admin/*
+func setupTestRoutes(t *testing.T) testRouteTokens {
+	o := setupTestDB(t)
+	useTestSigningKeys(t)
+	dir, err := ioutil.TempDir("", "routes")
+	if err != nil {
+		t.Fatalf("create temp dir failed, err: %v", err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	file := path.Join(dir, "routes.json")
+	rules := `{"rules":[{"paths":["/public/*"],"public":true},{"paths":["/admin/*"],"groups":["g1"],"roles":["admin"]}]}`
+	if err = ioutil.WriteFile(file, []byte(rules), 0600); err != nil {
+		t.Fatalf("write route rules failed, err: %v", err)
+	}
+	setTestConfig(t, "RouteRulesFile", file)
+
+	_, adminRole, userRole := createTestRoles(t, o)
+	admin := createTestUser(t, o, "admin1", "g1", adminRole)
+	normal := createTestUser(t, o, "user1", "g1", userRole)
+	res := testRouteTokens{}
+	if res.admin, err = authsvc.CreateToken(testUserInfo(admin)); err != nil {
+		t.Fatalf("create token failed, err: %v", err)
+	}
+	if res.user, err = authsvc.CreateToken(testUserInfo(normal)); err != nil {
+		t.Fatalf("create token failed, err: %v", err)
+	}
+	// the token of the admin which can't pass the ingress
+	scopedInfo := testUserInfo(admin)
+	scopedInfo.Scope = &authapi.TokenScope{Actions: []string{"user:read"}}
+	if res.scoped, err = authsvc.CreateToken(scopedInfo); err != nil {
+		t.Fatalf("create token failed, err: %v", err)
+	}
+	return res
+}
+
+func TestExtAuthzServerCheck(t *testing.T) {
+	tokens := setupTestRoutes(t)
+
+	cases := []struct {
+		name    string
+		path    string
+		token   string
+		code    codes.Code
+		status  int
+		subject string
+	}{
+		{"public route without token", "/public/index.html", "", codes.OK, http.StatusOK, ""},
+		{"public route with token", "/public/index.html", tokens.user, codes.OK, http.StatusOK, "user1"},
+		{"public route with invalid token", "/public/index.html", "invalid", codes.OK, http.StatusOK, ""},
+		{"public route with token of other scope", "/public/index.html", tokens.scoped, codes.OK, http.StatusOK, ""},
+		{"protected route without token", "/admin/users", "", codes.Unauthenticated, http.StatusUnauthorized, ""},
+		{"protected route with invalid token", "/admin/users", "invalid", codes.Unauthenticated, http.StatusUnauthorized, ""},
+		{"protected route with token of other scope", "/admin/users", tokens.scoped, codes.Unauthenticated, http.StatusUnauthorized, ""},
+		{"protected route denied by rule", "/admin/users", tokens.user, codes.PermissionDenied, http.StatusForbidden, ""},
+		{"protected route allowed by rule", "/admin/users", tokens.admin, codes.OK, http.StatusOK, "admin1"},
+		{"escaped path to protected route", "/public/..%2Fadmin/users", tokens.user, codes.PermissionDenied, http.StatusForbidden, ""},
+	}
+	for _, c := range cases {
+		headers := map[string]string{}
+		if len(c.token) != 0 {
+			headers[strings.ToLower(authapi.TokenHeaderKey)] = c.token
+		}
+		req := &authv3.CheckRequest{Attributes: &authv3.AttributeContext{Request: &authv3.AttributeContext_Request{
+			Http: &authv3.AttributeContext_HttpRequest{Host: "app.example.com:443", Method: http.MethodGet, Path: c.path, Headers: headers},
+		}}}
+		resp, err := ExtAuthzServer{}.Check(context.Background(), req)
+		if err != nil {
+			t.Logf("%v: check failed, err: %v", c.name, err)
+			t.Fail()
+			continue
+		}
+		if codes.Code(resp.GetStatus().GetCode()) != c.code {
+			t.Logf("%v: code should be %v, got %v", c.name, c.code, resp.GetStatus())
+			t.Fail()
+			continue
+		}
+		if c.code != codes.OK {
+			if status := int(resp.GetDeniedResponse().GetStatus().GetCode()); status != c.status {
+				t.Logf("%v: status should be %v, got %v", c.name, c.status, status)
+				t.Fail()
+			}
+			continue
+		}
+
+		okResp := resp.GetOkResponse()
+		if len(c.subject) == 0 {
+			// the identity sent by the client is removed
+			removed := okResp.GetHeadersToRemove()
+			if len(removed) != 1 || removed[0] != authapi.ParseInfo || len(okResp.GetHeaders()) != 0 {
+				t.Logf("%v: %v should be removed: %+v", c.name, authapi.ParseInfo, okResp)
+				t.Fail()
+			}
+			continue
+		}
+		info := authapi.RespToken{}
+		added := okResp.GetHeaders()
+		replaced := len(added) == 1 && added[0].GetHeader().GetKey() == authapi.ParseInfo && !added[0].GetAppend().GetValue()
+		if replaced {
+			_ = json.Unmarshal([]byte(added[0].GetHeader().GetValue()), &info)
+		}
+		if !replaced || info.Name != c.subject {
+			t.Logf("%v: %v should be replaced by the identity of %v: %+v", c.name, authapi.ParseInfo, c.subject, okResp)
+			t.Fail()
+		}
+	}
+}
+
+func TestExtAuthz(t *testing.T) {
+	tokens := setupTestRoutes(t)
+
+	cases := []struct {
+		name    string
+		uri     string
+		token   string
+		status  int
+		subject string
+	}{
+		{"no original uri", "", tokens.admin, http.StatusBadRequest, ""},
+		{"public route without token", "/public/index.html?lang=en", "", http.StatusOK, ""},
+		{"public route with token", "/public/index.html", tokens.user, http.StatusOK, "user1"},
+		{"protected route without token", "/admin/users", "", http.StatusUnauthorized, ""},
+		{"protected route with token of other scope", "/admin/users", tokens.scoped, http.StatusUnauthorized, ""},
+		{"protected route denied by rule", "/admin/users", tokens.user, http.StatusForbidden, ""},
+		{"protected route allowed by rule", "/admin/users", tokens.admin, http.StatusOK, "admin1"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/v1/auth/extauthz", nil)
+		if len(c.uri) != 0 {
+			r.Header.Set(authapi.OriginalURIHeader, c.uri)
+		}
+		if len(c.token) != 0 {
+			r.Header.Set(authapi.TokenHeaderKey, c.token)
+		}
+		w := httptest.NewRecorder()
+		AuthController{}.ExtAuthz(w, r)
+		if w.Code != c.status {
+			t.Logf("%v: status should be %v, got %v, body: %v", c.name, c.status, w.Code, w.Body.String())
+			t.Fail()
+			continue
+		}
+		// nginx copies the header to the upstream, so it's empty for the anonymous request
+		info := authapi.RespToken{}
+		if data := w.Header().Get(authapi.ParseInfo); len(data) != 0 {
+			_ = json.Unmarshal([]byte(data), &info)
+		}
+		if info.Name != c.subject {
+			t.Logf("%v: %v should be the identity of %q, got %q", c.name, authapi.ParseInfo, c.subject, w.Header().Get(authapi.ParseInfo))
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package controllers
+
+import (
+	"net/http"
+	"testing"
+
+	authapi "imanager/pkg/api/auth"
+)
+
+func TestCreateGroupName(t *testing.T) {
+	o := setupTestDB(t)
+	opService, _, _ := createTestRoles(t, o)
+	operator := createTestUser(t, o, "operator", "op", opService)
+
+	cases := []struct {
+		name   string
+		group  string
+		status int
+	}{
+		{"name with dash", "team-b", http.StatusCreated},
+		// the namespace team--b would be owned by both team--b and team
+		{"name with namespace separator", "team--b", http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		w := serveTest(AuthController{}.CreateGroup, http.MethodPost, "/v1/auth/group",
+			&authapi.Group{Name: c.group, MFAPolicy: authapi.MFAPolicyOptional}, testUserInfo(operator))
+		if w.Code != c.status {
+			t.Logf("%v: status should be %v, got %v, body: %v", c.name, c.status, w.Code, w.Body.String())
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/golang/glog"
+
+	authsvc "imanager/pkg/services/auth"
+	"imanager/pkg/util"
+)
+
+func (c AuthController) IntrospectToken(w http.ResponseWriter, r *http.Request) {
+	caller, err := authenticateServiceCaller(r)
+	if err != nil {
+		glog.Errorf("authenticate the caller of introspection failed, err: %v", err)
+		w.Header().Set("WWW-Authenticate", `Basic realm="imanager"`)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusUnauthorized, fmt.Sprintf("authenticate caller failed, %v", err))
+		return
+	}
+	if err = r.ParseForm(); err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("request form parse failed, %v", err))
+		return
+	}
+
+	res := authsvc.IntrospectToken(r.PostForm.Get("token"), r.PostForm.Get("token_type_hint"))
+	glog.Infof("%v introspect token, active: %v, user: %v", caller, res.Active, res.Username)
+	respBody, _ := json.Marshal(res)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(respBody)
+}
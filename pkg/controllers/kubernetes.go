@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/golang/glog"
+
+	authapi "imanager/pkg/api/auth"
+	authsvc "imanager/pkg/services/auth"
+	"imanager/pkg/util"
+)
+
+// TokenReview authenticates the bearer tokens of kubectl for kube-apiserver. The kube-apiserver is authenticated
+// as the caller of introspection by the token in the kubeconfig of the webhook
+func (c AuthController) TokenReview(w http.ResponseWriter, r *http.Request) {
+	caller, err := authenticateServiceCaller(r)
+	if err != nil {
+		glog.Errorf("authenticate the caller of token review failed, err: %v", err)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusUnauthorized, fmt.Sprintf("authenticate caller failed, %v", err))
+		return
+	}
+	requestBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("request body read failed, %v", err))
+		return
+	}
+	review := authapi.TokenReview{}
+	err = json.Unmarshal(requestBody, &review)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("request body unmarshal failed, %v", err))
+		return
+	}
+	if review.Kind != authapi.TokenReviewKind ||
+		(review.APIVersion != authapi.AuthenticationV1 && review.APIVersion != authapi.AuthenticationV1beta1) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest,
+			fmt.Sprintf("unsupported review %v of %v", review.Kind, review.APIVersion))
+		return
+	}
+
+	review.Status = authsvc.ReviewToken(review.Spec.Token, review.Spec.Audiences)
+	review.Spec = authapi.TokenReviewSpec{}
+	glog.Infof("%v review token, authenticated: %v, user: %v", caller, review.Status.Authenticated, review.Status.User.Username)
+	respBody, _ := json.Marshal(review)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(respBody)
+}
+
+// SubjectAccessReview authorizes the requests of the imanager users for kube-apiserver, the caller is
+// authenticated in the same way as TokenReview
+func (c AuthController) SubjectAccessReview(w http.ResponseWriter, r *http.Request) {
+	caller, err := authenticateServiceCaller(r)
+	if err != nil {
+		glog.Errorf("authenticate the caller of subject access review failed, err: %v", err)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusUnauthorized, fmt.Sprintf("authenticate caller failed, %v", err))
+		return
+	}
+	requestBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("request body read failed, %v", err))
+		return
+	}
+	review := authapi.SubjectAccessReview{}
+	err = json.Unmarshal(requestBody, &review)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("request body unmarshal failed, %v", err))
+		return
+	}
+	if review.Kind != authapi.SubjectAccessReviewKind ||
+		(review.APIVersion != authapi.AuthorizationV1 && review.APIVersion != authapi.AuthorizationV1beta1) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest,
+			fmt.Sprintf("unsupported review %v of %v", review.Kind, review.APIVersion))
+		return
+	}
+
+	spec := review.Spec
+	if review.APIVersion == authapi.AuthorizationV1beta1 {
+		spec.Groups = spec.Group
+	}
+	review.Status = authsvc.ReviewSubjectAccess(spec)
+	glog.V(4).Infof("%v review access of %v, allowed: %v, reason: %v", caller, review.Spec.User, review.Status.Allowed, review.Status.Reason)
+	respBody, _ := json.Marshal(review)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(respBody)
+}
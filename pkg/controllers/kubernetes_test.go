@@ -0,0 +1,44 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	authapi "imanager/pkg/api/auth"
+)
+
+func TestSubjectAccessReview(t *testing.T) {
+	o := setupTestDB(t)
+	setTestConfig(t, "KubernetesRulesFile", "")
+	opService, adminRole, _ := createTestRoles(t, o)
+	caller := createTestUser(t, o, "kube-apiserver", "op", opService)
+	setTestPassword(t, o, caller, "Passw0rd!")
+	user := createTestUser(t, o, "u1", "g1", adminRole)
+
+	cases := []struct {
+		name string
+		body string
+	}{
+		{"v1", `{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview","spec":{
+			"resourceAttributes":{"namespace":"g1","verb":"get","resource":"pods"},
+			"user":"u1","groups":["imanager:group:g1","imanager:role:admin"],"uid":"` + user.UUID + `"}}`},
+		{"v1beta1", `{"apiVersion":"authorization.k8s.io/v1beta1","kind":"SubjectAccessReview","spec":{
+			"resourceAttributes":{"namespace":"g1","verb":"get","resource":"pods"},
+			"user":"u1","group":["imanager:group:g1","imanager:role:admin"],"uid":"` + user.UUID + `"}}`},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodPost, authapi.SubjectAccessReviewURL, bytes.NewReader([]byte(c.body)))
+		r.SetBasicAuth("kube-apiserver", "Passw0rd!")
+		w := httptest.NewRecorder()
+		AuthController{}.SubjectAccessReview(w, r)
+		review := authapi.SubjectAccessReview{}
+		_ = json.Unmarshal(w.Body.Bytes(), &review)
+		if w.Code != http.StatusOK || !review.Status.Allowed {
+			t.Logf("%v: review should be allowed, status: %v, body: %v", c.name, w.Code, w.Body.String())
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+
+	authapi "imanager/pkg/api/auth"
+	authsvc "imanager/pkg/services/auth"
+	"imanager/pkg/util"
+)
+
+func isMFAClientError(err error) bool {
+	switch err {
+	case authsvc.ErrOTPRequired, authsvc.ErrInvalidOTP, authsvc.ErrMFAAlreadyEnabled, authsvc.ErrMFANotEnrolled:
+		return true
+	}
+	return false
+}
+
+func readReqMFA(r *http.Request) (*authapi.ReqMFA, error) {
+	requestBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("request body read failed, %v", err)
+	}
+	req := &authapi.ReqMFA{}
+	if len(requestBody) == 0 {
+		return req, nil
+	}
+	err = json.Unmarshal(requestBody, req)
+	if err != nil {
+		return nil, fmt.Errorf("request body unmarshal failed, %v", err)
+	}
+	return req, nil
+}
+
+// EnrollMFA starts the enrollment of the user himself, the secret is returned only once
+func (c AuthController) EnrollMFA(w http.ResponseWriter, r *http.Request) {
+	info, err := util.GetUserInfo(r.Header.Get(authapi.ParseInfo))
+	if err != nil {
+		glog.Errorf("get user info from header failed, err: %v", err)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	name := mux.Vars(r)["name"]
+	if name != info.Name || info.TokenType == authapi.PersonalAccessTokenType {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to enroll mfa")
+		return
+	}
+	if !info.Scope.AllowsAction(authapi.MFAResource, authapi.CreateVerb) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to enroll mfa")
+		return
+	}
+
+	glog.Infof("user[%v/%v] enroll mfa", info.Name, info.UserID)
+	enrollment, err := authsvc.StartMFAEnrollment(name)
+	if isMFAClientError(err) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("enroll mfa failed, %v", err))
+		return
+	}
+	out, err := json.Marshal(enrollment)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("marshal failed, %v", err))
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(out)
+}
+
+// VerifyMFA enables mfa with the first code from the authenticator
+func (c AuthController) VerifyMFA(w http.ResponseWriter, r *http.Request) {
+	info, err := util.GetUserInfo(r.Header.Get(authapi.ParseInfo))
+	if err != nil {
+		glog.Errorf("get user info from header failed, err: %v", err)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	name := mux.Vars(r)["name"]
+	if name != info.Name || info.TokenType == authapi.PersonalAccessTokenType {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to verify mfa")
+		return
+	}
+	if !info.Scope.AllowsAction(authapi.MFAResource, authapi.UpdateVerb) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to verify mfa")
+		return
+	}
+	req, err := readReqMFA(r)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = authsvc.VerifyMFAEnrollment(name, req.OTP)
+	if isMFAClientError(err) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("verify mfa failed, %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c AuthController) GetMFA(w http.ResponseWriter, r *http.Request) {
+	info, err := util.GetUserInfo(r.Header.Get(authapi.ParseInfo))
+	if err != nil {
+		glog.Errorf("get user info from header failed, err: %v", err)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	name := mux.Vars(r)["name"]
+	if name != info.Name && !isAllowedOnUser(&authapi.User{Name: name}, info, authapi.MFAResource, authapi.ReadVerb) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to get mfa")
+		return
+	}
+	if !info.Scope.AllowsAction(authapi.MFAResource, authapi.ReadVerb) || !isAllowedUserByScope(name, info) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to get mfa")
+		return
+	}
+
+	status, err := authsvc.GetMFAStatus(name)
+	if err == orm.ErrNoRows {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "user isn't exist")
+		return
+	}
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("get mfa failed, %v", err))
+		return
+	}
+	out, _ := json.Marshal(status)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(out)
+}
+
+// DisableMFA removes the mfa, the user himself should provide an otp, the caller allowed to delete the mfa
+// of the user can reset it if the user lost the authenticator
+func (c AuthController) DisableMFA(w http.ResponseWriter, r *http.Request) {
+	info, err := util.GetUserInfo(r.Header.Get(authapi.ParseInfo))
+	if err != nil {
+		glog.Errorf("get user info from header failed, err: %v", err)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	name := mux.Vars(r)["name"]
+	isReset := name != info.Name
+	if isReset && !isAllowedOnUser(&authapi.User{Name: name}, info, authapi.MFAResource, authapi.DeleteVerb) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to disable mfa")
+		return
+	}
+	if !info.Scope.AllowsAction(authapi.MFAResource, authapi.DeleteVerb) || !isAllowedUserByScope(name, info) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to disable mfa")
+		return
+	}
+	req, err := readReqMFA(r)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	glog.Infof("disable mfa of user[%v] by %v/%v", name, info.Name, info.UserID)
+	err = authsvc.DisableMFA(name, req.OTP, !isReset)
+	if err == orm.ErrNoRows {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "user isn't exist")
+		return
+	}
+	if isMFAClientError(err) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("disable mfa failed, %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
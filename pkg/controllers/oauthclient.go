@@ -0,0 +1,291 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+
+	authapi "imanager/pkg/api/auth"
+	authsvc "imanager/pkg/services/auth"
+	"imanager/pkg/util"
+)
+
+// isAllowedManageOAuthClient checks the roles of the caller allow the verb on the clients of the group
+func isAllowedManageOAuthClient(group *authapi.GroupInUser, info *authapi.RespToken, verb string) bool {
+	groupName := ""
+	if group != nil {
+		groupName = group.Name
+	}
+	return isAllowedInGroup(info, authapi.ClientResource, verb, groupName)
+}
+
+func validOAuthClient(client *authapi.OAuthClient) error {
+	isMatch, _ := regexp.MatchString(NameRegexp, client.Name)
+	if !isMatch {
+		return fmt.Errorf("client name don't match the format")
+	}
+	for _, v := range client.RedirectURIs {
+		u, err := url.Parse(v)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || len(u.Host) == 0 || len(u.Fragment) != 0 {
+			return fmt.Errorf("redirect uri %q should be an absolute http url without fragment", v)
+		}
+	}
+	return client.Valid()
+}
+
+// isAllowedGrantClientRoles checks the caller doesn't grant the client token more permissions than his own
+func isAllowedGrantClientRoles(client *authapi.OAuthClient, info *authapi.RespToken) bool {
+	return isAllowedGrantRoles(client.Role, info)
+}
+
+// getOAuthClientForManage returns the client in path if the token is allowed to do the verb on it,
+// otherwise the error response is written. The caller can't change the client whose roles are larger than his own
+func getOAuthClientForManage(w http.ResponseWriter, r *http.Request, verb string) (*authapi.OAuthClient, *authapi.RespToken, bool) {
+	info, err := util.GetUserInfo(r.Header.Get(authapi.ParseInfo))
+	if err != nil {
+		glog.Errorf("get user info from header failed, err: %v", err)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return nil, nil, false
+	}
+	client, err := authsvc.GetOAuthClient(mux.Vars(r)["id"])
+	if err == orm.ErrNoRows {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "client isn't exist")
+		return nil, nil, false
+	}
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("get client failed, %v", err))
+		return nil, nil, false
+	}
+	if !isAllowedManageOAuthClient(client.Group, info, verb) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("no permission to %v client", verb))
+		return nil, nil, false
+	}
+	if !info.Scope.AllowsAction(authapi.ClientResource, verb) || !info.Scope.AllowsGroup(client.Group.Name) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("token scope doesn't allow to %v client", verb))
+		return nil, nil, false
+	}
+	if verb != authapi.ReadVerb && !isAllowedGrantClientRoles(client, info) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("no permission to %v client with the roles", verb))
+		return nil, nil, false
+	}
+	return client, info, true
+}
+
+func (c AuthController) CreateOAuthClient(w http.ResponseWriter, r *http.Request) {
+	info, err := util.GetUserInfo(r.Header.Get(authapi.ParseInfo))
+	if err != nil {
+		glog.Errorf("get user info from header failed, err: %v", err)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	requestBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("request body read failed, %v", err))
+		return
+	}
+	client := &authapi.OAuthClient{}
+	err = json.Unmarshal(requestBody, client)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("request body unmarshal failed, %v", err))
+		return
+	}
+	// the client is in the group of the creator by default
+	if client.Group == nil || len(client.Group.Name) == 0 {
+		client.Group = info.Group
+	}
+	// the caller without group such as op_service has to choose the group
+	if client.Group == nil || len(client.Group.Name) == 0 {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "group is required")
+		return
+	}
+	if !isAllowedManageOAuthClient(client.Group, info, authapi.CreateVerb) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to create client")
+		return
+	}
+	if !info.Scope.AllowsAction(authapi.ClientResource, authapi.CreateVerb) || !info.Scope.AllowsGroup(client.Group.Name) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to create client")
+		return
+	}
+	if err = validOAuthClient(client); err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !isAllowedGrantClientRoles(client, info) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to grant the roles to client")
+		return
+	}
+
+	glog.Infof("create oauth client[%v] in group %v by %v/%v", client.Name, client.Group.Name, info.Name, info.UserID)
+	client, err = authsvc.CreateOAuthClient(client, info.Name)
+	if err == orm.ErrNoRows {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "group isn't exist")
+		return
+	}
+	if err == authsvc.ErrOAuthClientRole {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("create client failed, %v", err))
+		return
+	}
+	out, err := json.Marshal(client)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("marshal failed, %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(out)
+}
+
+func (c AuthController) ModifyOAuthClient(w http.ResponseWriter, r *http.Request) {
+	oldClient, info, ok := getOAuthClientForManage(w, r, authapi.UpdateVerb)
+	if !ok {
+		return
+	}
+	requestBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("request body read failed, %v", err))
+		return
+	}
+	client := &authapi.OAuthClient{}
+	err = json.Unmarshal(requestBody, client)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("request body unmarshal failed, %v", err))
+		return
+	}
+	client.ClientID = oldClient.ClientID
+	client.Public = oldClient.Public
+	if len(client.Name) == 0 {
+		client.Name = oldClient.Name
+	}
+	if client.GrantTypes == nil {
+		client.GrantTypes = oldClient.GrantTypes
+	}
+	// only the caller allowed to update the clients of all groups can move the client to another group
+	if client.Group != nil && len(client.Group.Name) != 0 && client.Group.Name != oldClient.Group.Name &&
+		!isAllowedInAllGroups(info, authapi.ClientResource, authapi.UpdateVerb) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to change the group of client")
+		return
+	}
+	if client.Group != nil && len(client.Group.Name) != 0 && !info.Scope.AllowsGroup(client.Group.Name) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to change the group of client")
+		return
+	}
+	if err = validOAuthClient(client); err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !isAllowedGrantClientRoles(client, info) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to grant the roles to client")
+		return
+	}
+
+	glog.Infof("modify oauth client[%v/%v] by %v/%v", client.Name, client.ClientID, info.Name, info.UserID)
+	client, err = authsvc.UpdateOAuthClient(client)
+	if err == orm.ErrNoRows {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "group isn't exist")
+		return
+	}
+	if err == authsvc.ErrOAuthClientRole {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("modify client failed, %v", err))
+		return
+	}
+	out, _ := json.Marshal(client)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(out)
+}
+
+func (c AuthController) RotateOAuthClientSecret(w http.ResponseWriter, r *http.Request) {
+	client, info, ok := getOAuthClientForManage(w, r, authapi.UpdateVerb)
+	if !ok {
+		return
+	}
+	glog.Infof("rotate the secret of oauth client[%v/%v] by %v/%v", client.Name, client.ClientID, info.Name, info.UserID)
+	client, err := authsvc.RotateOAuthClientSecret(client.ClientID)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("rotate client secret failed, %v", err))
+		return
+	}
+	out, _ := json.Marshal(client)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(out)
+}
+
+func (c AuthController) DeleteOAuthClient(w http.ResponseWriter, r *http.Request) {
+	client, info, ok := getOAuthClientForManage(w, r, authapi.DeleteVerb)
+	if !ok {
+		return
+	}
+	glog.Infof("delete oauth client[%v/%v] by %v/%v", client.Name, client.ClientID, info.Name, info.UserID)
+	err := authsvc.DeleteOAuthClient(client.ClientID)
+	if err == orm.ErrNoRows {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "client isn't exist")
+		return
+	}
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("delete client failed, %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c AuthController) GetOAuthClient(w http.ResponseWriter, r *http.Request) {
+	client, _, ok := getOAuthClientForManage(w, r, authapi.ReadVerb)
+	if !ok {
+		return
+	}
+	out, _ := json.Marshal(client)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(out)
+}
+
+// ListOAuthClient returns all clients to the caller allowed to read the clients of all groups, otherwise the clients of his group
+func (c AuthController) ListOAuthClient(w http.ResponseWriter, r *http.Request) {
+	info, err := util.GetUserInfo(r.Header.Get(authapi.ParseInfo))
+	if err != nil {
+		glog.Errorf("get user info from header failed, err: %v", err)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	permissions := getPermissions(info)
+	if !permissions.Allows(authapi.ClientResource, authapi.ReadVerb, true) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to list client")
+		return
+	}
+	if !info.Scope.AllowsAction(authapi.ClientResource, authapi.ReadVerb) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to list client")
+		return
+	}
+	groupName := info.Scope.Group
+	if !permissions.Allows(authapi.ClientResource, authapi.ReadVerb, false) {
+		if info.Group == nil {
+			util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to list client")
+			return
+		}
+		groupName = info.Group.Name
+	}
+
+	clients, num, err := authsvc.ListOAuthClients(groupName)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("list client failed, %v", err))
+		return
+	}
+	respBody, _ := json.Marshal(authapi.OAuthClientList{
+		Count: num,
+		Item:  clients,
+	})
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(respBody)
+}
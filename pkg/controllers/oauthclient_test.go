@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	authapi "imanager/pkg/api/auth"
+	authsvc "imanager/pkg/services/auth"
+)
+
+func TestManageOAuthClientWithLargerRole(t *testing.T) {
+	o := setupTestDB(t)
+	opServiceRole, adminRole, userRole := createTestRoles(t, o)
+	admin := createTestUser(t, o, "admin1", "g1", adminRole, userRole)
+	client, err := authsvc.CreateOAuthClient(&authapi.OAuthClient{
+		Name:         "op",
+		RedirectURIs: []string{testRedirectURI},
+		Group:        &authapi.GroupInUser{Name: "g1"},
+		GrantTypes:   []string{authapi.AuthorizationCodeGrantType},
+		Role:         []authapi.RoleInUser{{ID: opServiceRole.Id}},
+	}, "op1")
+	if err != nil {
+		t.Fatalf("create oauth client failed, err: %v", err)
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc(authapi.OAuthClientURL+"/{id}", AuthController{}.GetOAuthClient).Methods(http.MethodGet)
+	router.HandleFunc(authapi.OAuthClientURL+"/{id}", AuthController{}.ModifyOAuthClient).Methods(http.MethodPut)
+	router.HandleFunc(authapi.OAuthClientURL+"/{id}", AuthController{}.DeleteOAuthClient).Methods(http.MethodDelete)
+	router.HandleFunc(authapi.OAuthClientURL+"/{id}/secret", AuthController{}.RotateOAuthClientSecret).Methods(http.MethodPut)
+
+	cases := []struct {
+		name   string
+		method string
+		url    string
+		body   interface{}
+		status int
+	}{
+		{"get", http.MethodGet, authapi.OAuthClientURL + "/" + client.ClientID, nil, http.StatusOK},
+		{"modify without roles", http.MethodPut, authapi.OAuthClientURL + "/" + client.ClientID,
+			&authapi.OAuthClient{RedirectURIs: []string{testRedirectURI}, Role: []authapi.RoleInUser{{ID: userRole.Id}}}, http.StatusBadRequest},
+		{"rotate secret", http.MethodPut, authapi.OAuthClientURL + "/" + client.ClientID + "/secret", nil, http.StatusBadRequest},
+		{"delete", http.MethodDelete, authapi.OAuthClientURL + "/" + client.ClientID, nil, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		w := serveTest(router.ServeHTTP, c.method, c.url, c.body, testUserInfo(admin))
+		if w.Code != c.status {
+			t.Logf("%v: status should be %v, got %v, body: %v", c.name, c.status, w.Code, w.Body.String())
+			t.Fail()
+		}
+	}
+	if _, err = authsvc.GetOAuthClient(client.ClientID); err != nil {
+		t.Logf("client of op service shouldn't be deleted by admin, err: %v", err)
+		t.Fail()
+	}
+}
+
+func TestCreateOAuthClientWithoutGroup(t *testing.T) {
+	o := setupTestDB(t)
+	opServiceRole, _, _ := createTestRoles(t, o)
+	// the op service user may have no group
+	info := testUserInfo(createTestUser(t, o, "op1", "g1", opServiceRole))
+	info.Group = nil
+
+	body := &authapi.OAuthClient{
+		Name:         "app",
+		RedirectURIs: []string{testRedirectURI},
+		GrantTypes:   []string{authapi.AuthorizationCodeGrantType},
+	}
+	w := serveTest(AuthController{}.CreateOAuthClient, http.MethodPost, authapi.OAuthClientURL, body, info)
+	if w.Code != http.StatusBadRequest {
+		t.Logf("client without group should be rejected, status: %v, body: %v", w.Code, w.Body.String())
+		t.Fail()
+	}
+	body.Group = &authapi.GroupInUser{Name: "g1"}
+	w = serveTest(AuthController{}.CreateOAuthClient, http.MethodPost, authapi.OAuthClientURL, body, info)
+	if w.Code != http.StatusCreated {
+		t.Logf("client in the requested group should be created, status: %v, body: %v", w.Code, w.Body.String())
+		t.Fail()
+	}
+}
@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+
+	"github.com/golang/glog"
+
+	authapi "imanager/pkg/api/auth"
+	authsvc "imanager/pkg/services/auth"
+	"imanager/pkg/util"
+)
+
+// OIDCLogin redirects the user agent to the upstream openid connect provider, the state of the login
+// is kept in the cookie, so that the callback can only be finished by the same user agent
+func (c AuthController) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	authURL, state, err := authsvc.StartOIDCLogin()
+	if err == authsvc.ErrOIDCDisabled {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("start oidc login failed, %v", err))
+		return
+	}
+	// lax is required, since the callback is a top level navigation from the provider
+	http.SetCookie(w, &http.Cookie{
+		Name:     authapi.OIDCStateCookieName,
+		Value:    state,
+		Path:     authapi.OIDCCallbackURL,
+		MaxAge:   int(authsvc.OIDCLoginStateDuration.Seconds()),
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback issues the tokens of the user asserted by the id token, the user is created at the first login.
+// The user agent is redirected to the return url with the tokens in the fragment if it's configured
+func (c AuthController) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if errCode := query.Get("error"); len(errCode) != 0 {
+		glog.Errorf("oidc login failed, error: %v, description: %v", errCode, query.Get("error_description"))
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusUnauthorized, fmt.Sprintf("oidc login failed, %v", errCode))
+		return
+	}
+	boundState := ""
+	if cookie, err := r.Cookie(authapi.OIDCStateCookieName); err == nil {
+		boundState = cookie.Value
+	}
+	// the state is used only once
+	http.SetCookie(w, &http.Cookie{Name: authapi.OIDCStateCookieName, Path: authapi.OIDCCallbackURL, MaxAge: -1})
+	identity, err := authsvc.FinishOIDCLogin(query.Get("state"), boundState, query.Get("code"))
+	if err == authsvc.ErrOIDCDisabled || err == authsvc.ErrInvalidOIDCLoginState {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		glog.Errorf("finish oidc login failed, err: %v", err)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusUnauthorized, fmt.Sprintf("oidc login failed, %v", err))
+		return
+	}
+	isMatch, _ := regexp.MatchString(UserNameRegexp, identity.Username)
+	if !isMatch {
+		glog.Errorf("user name %q of oidc subject %v is invalid", identity.Username, identity.Subject)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusUnauthorized, fmt.Sprintf("oidc user name %q is invalid", identity.Username))
+		return
+	}
+	glog.Infof("%v login by oidc, subject: %v", identity.Username, identity.Subject)
+
+	user, err := authsvc.ProvisionOIDCUser(identity)
+	if err == authsvc.ErrOIDCUserConflict || err == authsvc.ErrOIDCUserNotMapped {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("provision oidc user failed, %v", err))
+		return
+	}
+	// the mfa is done by the provider, it should be asserted in the id token if the group requires it
+	err = authsvc.CheckOIDCMFA(user, identity)
+	if err == authsvc.ErrOIDCMFARequired {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("check mfa of oidc user failed, %v", err))
+		return
+	}
+	refreshToken, err := authsvc.CreateRefreshToken(user.UUID, nil)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("create refresh token failed, %v", err))
+		return
+	}
+	res, tokenss, ok := c.issueToken(w, r, user, refreshToken, 0, false)
+	if !ok {
+		return
+	}
+
+	if returnURL := authsvc.OIDCLoginReturnURL(); len(returnURL) != 0 {
+		// the fragment isn't sent to the server of the return url
+		fragment := url.Values{
+			authapi.OIDCReturnTokenKey:        {tokenss},
+			authapi.OIDCReturnRefreshTokenKey: {refreshToken.Token},
+			authapi.OIDCReturnExpiresAtKey:    {strconv.FormatInt(res.ExpiresAt.Unix(), 10)},
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, returnURL+"#"+fragment.Encode(), http.StatusFound)
+		return
+	}
+	respBody, _ := json.Marshal(res)
+	w.Header().Set(authapi.TokenHeaderKey, tokenss)
+	w.Header().Set(authapi.RefreshTokenHeaderKey, refreshToken.Token)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(respBody)
+}
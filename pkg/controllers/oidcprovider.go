@@ -0,0 +1,476 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+
+	authapi "imanager/pkg/api/auth"
+	authsvc "imanager/pkg/services/auth"
+	"imanager/pkg/util"
+)
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>imanager</title>
+<style>
+body { font-family: sans-serif; background: #f5f5f5; }
+form { width: 320px; margin: 10% auto; padding: 24px; background: #fff; border-radius: 4px; }
+input { display: block; width: 100%; box-sizing: border-box; margin: 8px 0 16px; padding: 8px; }
+button { width: 100%; padding: 8px; }
+.error { color: #c00; }
+</style>
+</head>
+<body>
+<form method="post" action="{{.Action}}">
+<h3>{{.Title}}</h3>
+{{if .Message}}<p>{{.Message}}</p>{{end}}
+{{if not .Done}}{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+{{range $k, $v := .Params}}<input type="hidden" name="{{$k}}" value="{{$v}}">
+{{end}}{{if .Device}}<label>Device code<input name="user_code" value="{{.UserCode}}" autocomplete="off" required></label>
+{{end}}<label>User name<input name="name" value="{{.Name}}" autocomplete="username" required></label>
+<label>Password<input name="password" type="password" autocomplete="current-password" required></label>
+<label>One-time code (if mfa is enabled)<input name="otp" autocomplete="one-time-code"></label>
+{{if .Consent}}<button type="submit" name="decision" value="approve">Approve</button>
+<p></p><button type="submit" name="decision" value="deny">Deny</button>
+{{else}}<button type="submit">Sign in</button>
+{{end}}{{end}}</form>
+</body>
+</html>
+`))
+
+// the parameters of the authorization request, which are kept in the login page
+var authorizeParams = []string{"response_type", "client_id", "redirect_uri", "scope", "state", "nonce",
+	"code_challenge", "code_challenge_method"}
+
+type loginPageData struct {
+	Action  string
+	Title   string
+	Message string
+	Name    string
+	Error   string
+	Params  map[string]string
+	// Device asks for the user code
+	Device   bool
+	UserCode string
+	// Consent asks the user to approve or deny the client after the login, instead of only signing in
+	Consent bool
+	// Done only shows the message
+	Done bool
+}
+
+func renderLoginPage(w http.ResponseWriter, statusCode int, client *authapi.OAuthClient, r *http.Request, errMsg string) {
+	data := loginPageData{
+		Action:  authapi.OAuthAuthorizeURL,
+		Title:   client.Name,
+		Message: fmt.Sprintf("%v requests to sign in as you", client.Name),
+		Name:    r.PostForm.Get("name"),
+		Error:   errMsg,
+		Params:  make(map[string]string),
+		Consent: true,
+	}
+	if scope := r.Form.Get("scope"); len(scope) != 0 {
+		data.Message += fmt.Sprintf(" with scope %q", scope)
+	}
+	for _, v := range authorizeParams {
+		if value := r.Form.Get(v); len(value) != 0 {
+			data.Params[v] = value
+		}
+	}
+	writeLoginPage(w, statusCode, data)
+}
+
+func writeLoginPage(w http.ResponseWriter, statusCode int, data loginPageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(statusCode)
+	if err := loginPage.Execute(w, data); err != nil {
+		glog.Errorf("render login page failed, err: %v", err)
+	}
+}
+
+// redirectAuthorizeResult redirects the user agent back to the client with the code or the error
+func redirectAuthorizeResult(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+	if state := r.Form.Get("state"); len(state) != 0 {
+		params.Set("state", state)
+	}
+	sep := "?"
+	if strings.Contains(redirectURI, "?") {
+		sep = "&"
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, redirectURI+sep+params.Encode(), http.StatusFound)
+}
+
+func redirectAuthorizeError(w http.ResponseWriter, r *http.Request, redirectURI, code, desc string) {
+	redirectAuthorizeResult(w, r, redirectURI, url.Values{"error": {code}, "error_description": {desc}})
+}
+
+func hasRedirectURI(client *authapi.OAuthClient, redirectURI string) bool {
+	for _, v := range client.RedirectURIs {
+		if v == redirectURI {
+			return true
+		}
+	}
+	return false
+}
+
+// validAuthorizeRequest returns the client and the redirect uri of the request. The error of the client
+// or the redirect uri is shown to the user, and the other errors are redirected to the client
+func validAuthorizeRequest(w http.ResponseWriter, r *http.Request) (*authapi.OAuthClient, bool) {
+	if err := r.ParseForm(); err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "request form parse failed")
+		return nil, false
+	}
+	client, err := authsvc.GetOAuthClient(r.Form.Get("client_id"))
+	if err != nil {
+		glog.Errorf("get client %q of authorization failed, err: %v", r.Form.Get("client_id"), err)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "client is invalid")
+		return nil, false
+	}
+	redirectURI := r.Form.Get("redirect_uri")
+	if !hasRedirectURI(client, redirectURI) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "redirect uri isn't registered by the client")
+		return nil, false
+	}
+	if r.Form.Get("response_type") != "code" {
+		redirectAuthorizeError(w, r, redirectURI, authapi.UnsupportedResponseTypeError, "only code is supported")
+		return nil, false
+	}
+	_, actions, err := authapi.ParseOAuthScope(r.Form.Get("scope"))
+	if err != nil {
+		redirectAuthorizeError(w, r, redirectURI, authapi.InvalidScopeError, err.Error())
+		return nil, false
+	}
+	for _, v := range actions {
+		if !client.AllowsScope(v) {
+			redirectAuthorizeError(w, r, redirectURI, authapi.InvalidScopeError, fmt.Sprintf("client isn't allowed to request %v", v))
+			return nil, false
+		}
+	}
+	if !client.AllowsGrantType(authapi.AuthorizationCodeGrantType) {
+		redirectAuthorizeError(w, r, redirectURI, authapi.UnauthorizedClientError, "client isn't allowed to use authorization code grant")
+		return nil, false
+	}
+	challenge := r.Form.Get("code_challenge")
+	if len(challenge) != 0 && r.Form.Get("code_challenge_method") != "S256" {
+		redirectAuthorizeError(w, r, redirectURI, authapi.InvalidRequestError, "only S256 code challenge method is supported")
+		return nil, false
+	}
+	if len(challenge) == 0 && client.Public {
+		redirectAuthorizeError(w, r, redirectURI, authapi.InvalidRequestError, "pkce is required for public client")
+		return nil, false
+	}
+	return client, true
+}
+
+func (c AuthController) GetOIDCDiscovery(w http.ResponseWriter, r *http.Request) {
+	discovery, err := authsvc.GetOIDCDiscovery()
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusNotFound, err.Error())
+		return
+	}
+	respBody, _ := json.Marshal(discovery)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(respBody)
+}
+
+// authenticateLoginForm checks the lockout, the password and the otp posted by the login page, the status code
+// and the message to render the page are returned if the login fails
+func authenticateLoginForm(w http.ResponseWriter, r *http.Request, client *authapi.OAuthClient) (*authapi.User, int, string) {
+	name := r.PostForm.Get("name")
+	clientIP := getClientIP(r)
+	glog.Infof("%v login for client %v/%v", name, client.Name, client.ClientID)
+	retryAfter, err := authsvc.CheckLoginAllowed(name, clientIP)
+	if err == authsvc.ErrLoginLocked || err == authsvc.ErrLoginThrottled {
+		glog.Errorf("login of user[%v] from %v is rejected, err: %v", name, clientIP, err)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		return nil, http.StatusTooManyRequests, err.Error()
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, "check login failures failed"
+	}
+	isValid, user, err := authsvc.ValidUserPasswordAndGetRoles(name, r.PostForm.Get("password"))
+	if err != nil {
+		glog.Errorf("valid user[%v]'s password failed, err: %v", name, err)
+		return nil, http.StatusInternalServerError, "valid user's password failed"
+	}
+	if !isValid {
+		glog.Errorf("user name[%v] or password is invalid", name)
+		authsvc.RecordLoginFailure(name, clientIP)
+		return nil, http.StatusUnauthorized, "user name or password is invalid"
+	}
+	err = authsvc.ValidMFA(user, r.PostForm.Get("otp"))
+	switch err {
+	case nil:
+	case authsvc.ErrMFAEnrollmentRequired:
+		return nil, http.StatusUnauthorized, "mfa should be enrolled before the login"
+	case authsvc.ErrOTPRequired, authsvc.ErrInvalidOTP:
+		glog.Errorf("mfa of user[%v] failed, err: %v", user.Name, err)
+		if err == authsvc.ErrInvalidOTP {
+			authsvc.RecordLoginFailure(user.Name, clientIP)
+		}
+		return nil, http.StatusUnauthorized, err.Error()
+	default:
+		return nil, http.StatusInternalServerError, "valid user's otp failed"
+	}
+	authsvc.ResetLoginFailures(user.Name)
+	required, err := authsvc.IsPasswordChangeRequired(user)
+	if err != nil {
+		glog.Errorf("check password expiry of user[%v] failed, err: %v", user.Name, err)
+		return nil, http.StatusInternalServerError, "check password expiry failed"
+	}
+	if required {
+		return nil, http.StatusUnauthorized, "password should be changed before the login"
+	}
+	return user, http.StatusOK, ""
+}
+
+// Authorize shows the login page with the requested scope, and redirects the user agent back to the client
+// with the code after the user logins and approves the client
+func (c AuthController) Authorize(w http.ResponseWriter, r *http.Request) {
+	client, ok := validAuthorizeRequest(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodPost {
+		renderLoginPage(w, http.StatusOK, client, r, "")
+		return
+	}
+
+	user, statusCode, errMsg := authenticateLoginForm(w, r, client)
+	if user == nil {
+		renderLoginPage(w, statusCode, client, r, errMsg)
+		return
+	}
+
+	redirectURI := r.Form.Get("redirect_uri")
+	if r.PostForm.Get("decision") != "approve" {
+		glog.Infof("%v/%v denies client %v/%v", user.Name, user.UUID, client.Name, client.ClientID)
+		redirectAuthorizeError(w, r, redirectURI, authapi.AccessDeniedError, "the user denied the client")
+		return
+	}
+	_, actions, _ := authapi.ParseOAuthScope(r.Form.Get("scope"))
+	if _, err := authsvc.OAuthTokenScope(user, actions); err != nil {
+		redirectAuthorizeError(w, r, redirectURI, authapi.InvalidScopeError, err.Error())
+		return
+	}
+	code, err := authsvc.CreateAuthorizationCode(client.ClientID, user, redirectURI, r.Form.Get("scope"),
+		r.Form.Get("nonce"), r.Form.Get("code_challenge"))
+	if err != nil {
+		redirectAuthorizeError(w, r, redirectURI, authapi.ServerError, "create authorization code failed")
+		return
+	}
+	glog.Infof("%v/%v authorizes client %v/%v", user.Name, user.UUID, client.Name, client.ClientID)
+	redirectAuthorizeResult(w, r, redirectURI, url.Values{"code": {code}})
+}
+
+func returnOAuthError(w http.ResponseWriter, code, desc string) {
+	statusCode := authapi.OAuthErrorStatus(code)
+	if statusCode == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="imanager"`)
+	}
+	respBody, _ := json.Marshal(authapi.OAuthError{Error: code, ErrorDescription: desc})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(respBody)
+}
+
+// authenticateOAuthClient accepts client_secret_basic and client_secret_post, the public client only sends its id
+func authenticateOAuthClient(r *http.Request) (*authapi.OAuthClient, error) {
+	clientID, secret, ok := r.BasicAuth()
+	if ok {
+		// the id and secret are form encoded in basic auth, see rfc6749 section 2.3.1
+		if id, err := url.QueryUnescape(clientID); err == nil {
+			clientID = id
+		}
+		if s, err := url.QueryUnescape(secret); err == nil {
+			secret = s
+		}
+	} else {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	return authsvc.AuthenticateOAuthClient(clientID, secret)
+}
+
+// OAuthToken is the token endpoint of the authorization code, refresh token, client credentials and device code grants
+func (c AuthController) OAuthToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		returnOAuthError(w, authapi.InvalidRequestError, "request form parse failed")
+		return
+	}
+	client, err := authenticateOAuthClient(r)
+	if err == authsvc.ErrInvalidOAuthClient {
+		returnOAuthError(w, authapi.InvalidClientError, err.Error())
+		return
+	}
+	if err != nil {
+		returnOAuthError(w, authapi.ServerError, "authenticate client failed")
+		return
+	}
+
+	grantType := r.PostForm.Get("grant_type")
+	switch grantType {
+	case authapi.AuthorizationCodeGrantType, authapi.RefreshTokenGrantType, authapi.ClientCredentialsGrantType,
+		authapi.DeviceCodeGrantType:
+		if !client.AllowsGrantType(grantType) {
+			returnOAuthError(w, authapi.UnauthorizedClientError, fmt.Sprintf("client isn't allowed to use %v grant", grantType))
+			return
+		}
+	default:
+		returnOAuthError(w, authapi.UnsupportedGrantTypeError,
+			"only authorization_code, refresh_token, client_credentials and device_code are supported")
+		return
+	}
+	if grantType == authapi.ClientCredentialsGrantType {
+		c.clientCredentialsToken(w, client, r.PostForm.Get("scope"))
+		return
+	}
+
+	var user *authapi.User
+	var refreshToken *authsvc.IssuedRefreshToken
+	var grant *authsvc.AuthorizationGrant
+	var scopes []string
+	switch grantType {
+	case authapi.AuthorizationCodeGrantType:
+		grant, err = authsvc.ExchangeAuthorizationCode(client.ClientID, r.PostForm.Get("code"),
+			r.PostForm.Get("redirect_uri"), r.PostForm.Get("code_verifier"))
+		if err == authsvc.ErrInvalidAuthorizationCode {
+			returnOAuthError(w, authapi.InvalidGrantError, err.Error())
+			return
+		}
+		if err != nil {
+			returnOAuthError(w, authapi.ServerError, "exchange authorization code failed")
+			return
+		}
+	case authapi.DeviceCodeGrantType:
+		grant, err = authsvc.PollDeviceCode(client.ClientID, r.PostForm.Get("device_code"))
+		switch err {
+		case nil:
+		case authsvc.ErrAuthorizationPending:
+			returnOAuthError(w, authapi.AuthorizationPendingError, err.Error())
+			return
+		case authsvc.ErrSlowDown:
+			returnOAuthError(w, authapi.SlowDownError, err.Error())
+			return
+		case authsvc.ErrDeviceCodeExpired:
+			returnOAuthError(w, authapi.ExpiredTokenError, err.Error())
+			return
+		case authsvc.ErrDeviceAccessDenied:
+			returnOAuthError(w, authapi.AccessDeniedError, err.Error())
+			return
+		case authsvc.ErrInvalidDeviceCode:
+			returnOAuthError(w, authapi.InvalidGrantError, err.Error())
+			return
+		default:
+			returnOAuthError(w, authapi.ServerError, "poll device code failed")
+			return
+		}
+	case authapi.RefreshTokenGrantType:
+		user, refreshToken, err = authsvc.UseClientRefreshToken(r.PostForm.Get("refresh_token"), client.ClientID)
+		if err == authsvc.ErrInvalidRefreshToken || err == authsvc.ErrRefreshTokenReused || err == authsvc.ErrRefreshTokenClient ||
+			err == authsvc.ErrSessionIdle || err == authsvc.ErrTokenRevoked {
+			returnOAuthError(w, authapi.InvalidGrantError, err.Error())
+			return
+		}
+		if err != nil {
+			returnOAuthError(w, authapi.ServerError, "use refresh token failed")
+			return
+		}
+	}
+	// the user granted the scope to the client by the authorization code or the device code
+	if grant != nil {
+		user = grant.User
+		var actions []string
+		scopes, actions, _ = authapi.ParseOAuthScope(grant.Scope)
+		scope, err := authsvc.OAuthTokenScope(user, actions)
+		if err != nil {
+			returnOAuthError(w, authapi.InvalidScopeError, err.Error())
+			return
+		}
+		refreshToken, err = authsvc.CreateClientRefreshToken(client.ClientID, user.UUID, scope, scopes)
+		if err != nil {
+			returnOAuthError(w, authapi.ServerError, "create refresh token failed")
+			return
+		}
+	}
+
+	if grantType == authapi.RefreshTokenGrantType {
+		scopes = refreshToken.OpenIDScopes
+	}
+
+	res, tokenss, ok := c.issueToken(w, r, user, refreshToken, 0, grantType == authapi.RefreshTokenGrantType)
+	if !ok {
+		return
+	}
+	resp := authapi.OAuthTokenResponse{
+		AccessToken:  tokenss,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(res.ExpiresAt.Sub(res.IssuedAt).Seconds()),
+		RefreshToken: refreshToken.Token,
+		Scope:        strings.TrimSpace(strings.Join(scopes, " ") + " " + refreshToken.Scope.String()),
+	}
+	if grant != nil && authapi.HasScope(scopes, authapi.OpenIDScope) {
+		resp.IDToken, err = authsvc.CreateIDToken(grant, client.ClientID, scopes, res.IssuedAt, res.ExpiresAt)
+		if err != nil {
+			glog.Errorf("create id token of user[%v] for client %v failed, err: %v", user.Name, client.ClientID, err)
+			returnOAuthError(w, authapi.ServerError, "create id token failed")
+			return
+		}
+	}
+	glog.Infof("issue token of user[%v/%v] to client %v/%v by %v", user.Name, user.UUID, client.Name, client.ClientID, grantType)
+
+	respBody, _ := json.Marshal(resp)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(respBody)
+}
+
+// UserInfo returns the claims of the user of the bearer token
+func (c AuthController) UserInfo(w http.ResponseWriter, r *http.Request) {
+	tokenStr := r.Header.Get(authapi.TokenHeaderKey)
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		tokenStr = strings.TrimPrefix(auth, "Bearer ")
+	}
+	info, err := authsvc.ValidateToken(tokenStr)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusUnauthorized, "token is invalid")
+		return
+	}
+	if info.TokenType == authapi.ClientTokenType {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusUnauthorized, "client token has no userinfo")
+		return
+	}
+	if !info.Scope.AllowsAction(authapi.UserInfoResource, authapi.ReadVerb) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusForbidden, "token scope doesn't allow to read userinfo")
+		return
+	}
+	userInfo, err := authsvc.GetUserInfo(&info)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, "get userinfo failed")
+		return
+	}
+	respBody, _ := json.Marshal(userInfo)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(respBody)
+}
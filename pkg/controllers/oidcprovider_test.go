@@ -0,0 +1,319 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/astaxie/beego/orm"
+
+	authapi "imanager/pkg/api/auth"
+	authdb "imanager/pkg/db/auth"
+	"imanager/pkg/oidcclient"
+	authsvc "imanager/pkg/services/auth"
+)
+
+const testRedirectURI = "https://app.example.com/callback"
+
+func createTestOAuthClient(t *testing.T, name string, public bool) *authapi.OAuthClient {
+	client, err := authsvc.CreateOAuthClient(&authapi.OAuthClient{
+		Name:         name,
+		RedirectURIs: []string{testRedirectURI},
+		Public:       public,
+		Group:        &authapi.GroupInUser{Name: "g1"},
+		GrantTypes:   []string{authapi.AuthorizationCodeGrantType, authapi.RefreshTokenGrantType},
+	}, "admin1")
+	if err != nil {
+		t.Fatalf("create oauth client %v failed, err: %v", name, err)
+	}
+	return client
+}
+
+// serveTestForm posts the form to the handler, the client authenticates by client_secret_basic if it has a secret
+func serveTestForm(handler http.HandlerFunc, u string, form url.Values, client *authapi.OAuthClient) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, u, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if client != nil && len(client.ClientSecret) != 0 {
+		r.SetBasicAuth(client.ClientID, client.ClientSecret)
+	} else if client != nil {
+		form.Set("client_id", client.ClientID)
+		r = httptest.NewRequest(http.MethodPost, u, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func decodeOAuthResponse(w *httptest.ResponseRecorder) (authapi.OAuthTokenResponse, authapi.OAuthError) {
+	res, oauthErr := authapi.OAuthTokenResponse{}, authapi.OAuthError{}
+	_ = json.Unmarshal(w.Body.Bytes(), &res)
+	_ = json.Unmarshal(w.Body.Bytes(), &oauthErr)
+	return res, oauthErr
+}
+
+func TestAuthorize(t *testing.T) {
+	o := setupTestDB(t)
+	_, _, userRole := createTestRoles(t, o)
+	user := createTestUser(t, o, "u1", "g1", userRole)
+	setTestPassword(t, o, user, "Passw0rd!")
+	client := createTestOAuthClient(t, "app", false)
+
+	authorizeForm := func(redirectURI string) url.Values {
+		return url.Values{
+			"response_type": {"code"},
+			"client_id":     {client.ClientID},
+			"redirect_uri":  {redirectURI},
+			"scope":         {"openid profile"},
+			"state":         {"xyz"},
+		}
+	}
+	w := serveTest(AuthController{}.Authorize, http.MethodGet,
+		authapi.OAuthAuthorizeURL+"?"+authorizeForm(testRedirectURI+"/other").Encode(), nil, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Logf("redirect uri not registered should be rejected without redirect, status: %v", w.Code)
+		t.Fail()
+	}
+	w = serveTest(AuthController{}.Authorize, http.MethodGet,
+		authapi.OAuthAuthorizeURL+"?"+authorizeForm(testRedirectURI).Encode(), nil, nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "openid profile") || !strings.Contains(w.Body.String(), "approve") {
+		t.Logf("login page should show the scope and ask for approval, status: %v, body: %v", w.Code, w.Body.String())
+		t.Fail()
+	}
+
+	cases := []struct {
+		decision string
+		param    string
+	}{
+		{"deny", "error"},
+		{"", "error"},
+		{"approve", "code"},
+	}
+	for _, c := range cases {
+		form := authorizeForm(testRedirectURI)
+		form.Set("name", "u1")
+		form.Set("password", "Passw0rd!")
+		form.Set("decision", c.decision)
+		w = serveTestForm(AuthController{}.Authorize, authapi.OAuthAuthorizeURL, form, nil)
+		location, err := url.Parse(w.Header().Get("Location"))
+		if w.Code != http.StatusFound || err != nil || !strings.HasPrefix(location.String(), testRedirectURI+"?") {
+			t.Logf("decision %q should redirect to the client, status: %v, location: %v", c.decision, w.Code, location)
+			t.Fail()
+			continue
+		}
+		query := location.Query()
+		if len(query.Get(c.param)) == 0 || query.Get("state") != "xyz" {
+			t.Logf("decision %q should return %v, location: %v", c.decision, c.param, location)
+			t.Fail()
+		}
+		if c.param == "error" && query.Get("error") != authapi.AccessDeniedError {
+			t.Logf("decision %q should deny the client, location: %v", c.decision, location)
+			t.Fail()
+		}
+	}
+}
+
+func TestOAuthTokenAuthorizationCode(t *testing.T) {
+	o := setupTestDB(t)
+	useTestSigningKeys(t)
+	_, _, userRole := createTestRoles(t, o)
+	user := createTestUser(t, o, "u1", "g1", userRole)
+	client := createTestOAuthClient(t, "app", false)
+	other := createTestOAuthClient(t, "other", false)
+	public := createTestOAuthClient(t, "spa", true)
+	verifier, challenge, err := oidcclient.NewPKCE()
+	if err != nil {
+		t.Fatalf("new pkce failed, err: %v", err)
+	}
+
+	cases := []struct {
+		name        string
+		client      *authapi.OAuthClient
+		codeClient  *authapi.OAuthClient
+		challenge   string
+		redirectURI string
+		verifier    string
+		err         string
+	}{
+		{"confidential client", client, client, "", testRedirectURI, "", ""},
+		{"pkce", public, public, challenge, testRedirectURI, verifier, ""},
+		{"wrong verifier", public, public, challenge, testRedirectURI, "wrong", authapi.InvalidGrantError},
+		{"no verifier", public, public, challenge, testRedirectURI, "", authapi.InvalidGrantError},
+		{"verifier without challenge", client, client, "", testRedirectURI, verifier, authapi.InvalidGrantError},
+		{"other redirect uri", client, client, "", testRedirectURI + "/other", "", authapi.InvalidGrantError},
+		{"code of other client", other, client, "", testRedirectURI, "", authapi.InvalidGrantError},
+	}
+	for _, c := range cases {
+		code, err := authsvc.CreateAuthorizationCode(c.codeClient.ClientID, user, testRedirectURI, "openid", "nonce", c.challenge)
+		if err != nil {
+			t.Fatalf("create authorization code failed, err: %v", err)
+		}
+		form := url.Values{
+			"grant_type":   {authapi.AuthorizationCodeGrantType},
+			"code":         {code},
+			"redirect_uri": {c.redirectURI},
+		}
+		if len(c.verifier) != 0 {
+			form.Set("code_verifier", c.verifier)
+		}
+		w := serveTestForm(AuthController{}.OAuthToken, authapi.OAuthTokenURL, form, c.client)
+		res, oauthErr := decodeOAuthResponse(w)
+		if oauthErr.Error != c.err {
+			t.Logf("%v: error should be %q, got %q, body: %v", c.name, c.err, oauthErr.Error, w.Body.String())
+			t.Fail()
+			continue
+		}
+		if len(c.err) != 0 {
+			continue
+		}
+		if len(res.AccessToken) == 0 || len(res.RefreshToken) == 0 || len(res.IDToken) == 0 {
+			t.Logf("%v: tokens should be issued: %+v", c.name, res)
+			t.Fail()
+		}
+		// the code can only be exchanged once
+		w = serveTestForm(AuthController{}.OAuthToken, authapi.OAuthTokenURL, form, c.client)
+		if _, oauthErr = decodeOAuthResponse(w); oauthErr.Error != authapi.InvalidGrantError {
+			t.Logf("%v: used code should be invalid, body: %v", c.name, w.Body.String())
+			t.Fail()
+		}
+	}
+}
+
+func TestOAuthTokenRefreshOfOtherClient(t *testing.T) {
+	o := setupTestDB(t)
+	useTestSigningKeys(t)
+	_, _, userRole := createTestRoles(t, o)
+	user := createTestUser(t, o, "u1", "g1", userRole)
+	client := createTestOAuthClient(t, "app", false)
+	other := createTestOAuthClient(t, "other", false)
+
+	code, err := authsvc.CreateAuthorizationCode(client.ClientID, user, testRedirectURI, "openid", "", "")
+	if err != nil {
+		t.Fatalf("create authorization code failed, err: %v", err)
+	}
+	w := serveTestForm(AuthController{}.OAuthToken, authapi.OAuthTokenURL, url.Values{
+		"grant_type":   {authapi.AuthorizationCodeGrantType},
+		"code":         {code},
+		"redirect_uri": {testRedirectURI},
+	}, client)
+	res, _ := decodeOAuthResponse(w)
+	if w.Code != http.StatusOK || len(res.RefreshToken) == 0 {
+		t.Fatalf("exchange code failed, status: %v, body: %v", w.Code, w.Body.String())
+	}
+
+	refreshForm := url.Values{"grant_type": {authapi.RefreshTokenGrantType}, "refresh_token": {res.RefreshToken}}
+	w = serveTestForm(AuthController{}.OAuthToken, authapi.OAuthTokenURL, refreshForm, other)
+	if _, oauthErr := decodeOAuthResponse(w); oauthErr.Error != authapi.InvalidGrantError {
+		t.Logf("refresh token of the other client should be invalid, body: %v", w.Body.String())
+		t.Fail()
+	}
+	w = serveTest(AuthController{}.CreateTokenInHttp, http.MethodPost, authapi.GetTokenURL, &authapi.ReqToken{
+		GrantType: authapi.RefreshTokenGrantType,
+		Auth:      authapi.ReqTokenAuth{RefreshToken: res.RefreshToken},
+	}, nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Logf("refresh token of the client shouldn't be used by imanager, status: %v", w.Code)
+		t.Fail()
+	}
+	// the refresh token isn't consumed by the other client
+	w = serveTestForm(AuthController{}.OAuthToken, authapi.OAuthTokenURL, refreshForm, client)
+	refreshed, _ := decodeOAuthResponse(w)
+	if w.Code != http.StatusOK || len(refreshed.RefreshToken) == 0 {
+		t.Fatalf("refresh token of the client should be valid, status: %v, body: %v", w.Code, w.Body.String())
+	}
+
+	if err = authsvc.DeleteOAuthClient(client.ClientID); err != nil {
+		t.Fatalf("delete oauth client failed, err: %v", err)
+	}
+	if _, _, err = authsvc.UseClientRefreshToken(refreshed.RefreshToken, client.ClientID); err != authsvc.ErrInvalidRefreshToken {
+		t.Logf("refresh token of the deleted client should be revoked, err: %v", err)
+		t.Fail()
+	}
+}
+
+func TestUserInfoOfGrantedScopes(t *testing.T) {
+	o := setupTestDB(t)
+	useTestSigningKeys(t)
+	_, _, userRole := createTestRoles(t, o)
+	user := createTestUser(t, o, "u1", "g1", userRole)
+	if _, err := o.QueryTable(authdb.User{}).Filter("uuid", user.UUID).Update(orm.Params{"email": "u1@example.com"}); err != nil {
+		t.Fatalf("update email of user failed, err: %v", err)
+	}
+	client := createTestOAuthClient(t, "app", false)
+
+	userInfo := func(token string) (int, map[string]interface{}) {
+		r := httptest.NewRequest(http.MethodGet, authapi.OAuthUserInfoURL, nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		AuthController{}.UserInfo(w, r)
+		claims := map[string]interface{}{}
+		_ = json.Unmarshal(w.Body.Bytes(), &claims)
+		return w.Code, claims
+	}
+	cases := []struct {
+		scope  string
+		claims []string
+		absent []string
+	}{
+		{"openid", []string{"sub"}, []string{"preferred_username", "email", "group", "roles"}},
+		{"openid email", []string{"sub", "email"}, []string{"preferred_username", "group", "roles"}},
+		{"openid profile email groups", []string{"sub", "preferred_username", "email", "group", "roles"}, nil},
+	}
+	for _, c := range cases {
+		code, err := authsvc.CreateAuthorizationCode(client.ClientID, user, testRedirectURI, c.scope, "", "")
+		if err != nil {
+			t.Fatalf("create authorization code failed, err: %v", err)
+		}
+		w := serveTestForm(AuthController{}.OAuthToken, authapi.OAuthTokenURL, url.Values{
+			"grant_type":   {authapi.AuthorizationCodeGrantType},
+			"code":         {code},
+			"redirect_uri": {testRedirectURI},
+		}, client)
+		res, _ := decodeOAuthResponse(w)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%v: exchange code failed, status: %v, body: %v", c.scope, w.Code, w.Body.String())
+		}
+		// the refreshed token keeps the scopes granted to the client
+		w = serveTestForm(AuthController{}.OAuthToken, authapi.OAuthTokenURL, url.Values{
+			"grant_type":    {authapi.RefreshTokenGrantType},
+			"refresh_token": {res.RefreshToken},
+		}, client)
+		refreshed, _ := decodeOAuthResponse(w)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%v: refresh token failed, status: %v, body: %v", c.scope, w.Code, w.Body.String())
+		}
+		for _, token := range []string{res.AccessToken, refreshed.AccessToken} {
+			status, claims := userInfo(token)
+			if status != http.StatusOK {
+				t.Logf("%v: get userinfo failed, status: %v", c.scope, status)
+				t.Fail()
+				continue
+			}
+			for _, v := range c.claims {
+				if _, ok := claims[v]; !ok {
+					t.Logf("%v: claim %v should be returned: %v", c.scope, v, claims)
+					t.Fail()
+				}
+			}
+			for _, v := range c.absent {
+				if _, ok := claims[v]; ok {
+					t.Logf("%v: claim %v isn't granted: %v", c.scope, v, claims)
+					t.Fail()
+				}
+			}
+		}
+	}
+
+	// the token issued by imanager itself has all the claims
+	token, err := authsvc.CreateToken(testUserInfo(user))
+	if err != nil {
+		t.Fatalf("create token failed, err: %v", err)
+	}
+	if status, claims := userInfo(token); status != http.StatusOK || claims["email"] != "u1@example.com" || claims["group"] != "g1" {
+		t.Logf("token of imanager should have all the claims, status: %v, claims: %v", status, claims)
+		t.Fail()
+	}
+}
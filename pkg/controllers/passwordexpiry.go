@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+
+	authapi "imanager/pkg/api/auth"
+	authsvc "imanager/pkg/services/auth"
+	"imanager/pkg/util"
+)
+
+// ChangePassword changes the password of the user himself, it's allowed by the token which is issued when
+// the password is expired or reset by the others
+func (c AuthController) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	info, err := util.GetUserInfo(r.Header.Get(authapi.ParseInfo))
+	if err != nil {
+		glog.Errorf("get user info from header failed, err: %v", err)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	name := mux.Vars(r)["name"]
+	if name != info.Name || info.TokenType != "" || info.Act != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to change password")
+		return
+	}
+	if !info.Scope.AllowsAction(authapi.PasswordResource, authapi.UpdateVerb) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to change password")
+		return
+	}
+
+	requestBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("request body read failed, %v", err))
+		return
+	}
+	req := authapi.ReqChangePassword{}
+	err = json.Unmarshal(requestBody, &req)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("request body unmarshal failed, %v", err))
+		return
+	}
+	// the old password is checked as the login, so it can't be guessed by the token
+	clientIP := getClientIP(r)
+	retryAfter, err := authsvc.CheckLoginAllowed(name, clientIP)
+	if err == authsvc.ErrLoginLocked || err == authsvc.ErrLoginThrottled {
+		glog.Errorf("change password of user[%v] from %v is rejected, err: %v", name, clientIP, err)
+		returnTooManyRequests(w, retryAfter, err)
+		return
+	}
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("check login failures failed, %v", err))
+		return
+	}
+
+	err = authsvc.ValidPassword(req.Password, &authapi.User{UUID: info.UserID, Name: info.Name})
+	if err != nil {
+		returnValidationError(w, err)
+		return
+	}
+
+	glog.Infof("user[%v/%v] change password", info.Name, info.UserID)
+	err = authsvc.ChangePassword(name, req.OldPassword, req.Password)
+	if err == authsvc.ErrInvalidOldPassword {
+		authsvc.RecordLoginFailure(name, clientIP)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err == authsvc.ErrExternalUserPassword {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("change password failed, %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
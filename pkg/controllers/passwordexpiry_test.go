@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/gorilla/mux"
+	uuid "github.com/satori/go.uuid"
+
+	authapi "imanager/pkg/api/auth"
+	authdb "imanager/pkg/db/auth"
+	authsvc "imanager/pkg/services/auth"
+)
+
+func TestLoginWithPasswordChangeAndMFAEnrollment(t *testing.T) {
+	o := setupTestDB(t)
+	useTestSigningKeys(t)
+	_, _, userRole := createTestRoles(t, o)
+	user := createTestUser(t, o, "u1", "g1", userRole)
+	setTestPassword(t, o, user, "Passw0rd!")
+	if _, err := o.QueryTable(authdb.User{}).Filter("uuid", user.UUID).Update(orm.Params{"must_change_password": true}); err != nil {
+		t.Fatalf("update user failed, err: %v", err)
+	}
+	if _, err := o.QueryTable(authdb.Group{}).Filter("name", "g1").Update(orm.Params{"mfa_policy": authapi.MFAPolicyRequired}); err != nil {
+		t.Fatalf("update mfa policy of group failed, err: %v", err)
+	}
+
+	w := serveTest(AuthController{}.CreateTokenInHttp, http.MethodPost, authapi.GetTokenURL, &authapi.ReqToken{
+		Auth: authapi.ReqTokenAuth{Name: "u1", Password: "Passw0rd!"},
+	}, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login should succeed, status: %v, body: %v", w.Code, w.Body.String())
+	}
+	res := authapi.RespToken{}
+	_ = json.Unmarshal(w.Body.Bytes(), &res)
+	// the user changes the password and enrolls mfa with the same token, nothing else
+	if !res.Scope.AllowsAction(authapi.PasswordResource, authapi.UpdateVerb) ||
+		!res.Scope.AllowsAction(authapi.MFAResource, authapi.UpdateVerb) ||
+		res.Scope.AllowsAction(authapi.UserResource, authapi.ReadVerb) {
+		t.Logf("token should only allow to change password and enroll mfa: %+v", res.Scope)
+		t.Fail()
+	}
+}
+
+func TestChangePasswordThrottled(t *testing.T) {
+	o := setupTestDB(t)
+	_, _, userRole := createTestRoles(t, o)
+	user := createTestUser(t, o, "u1", "g1", userRole)
+	setTestPassword(t, o, user, "Passw0rd!")
+	info := testUserInfo(user)
+	scope := authapi.PasswordChangeScope
+	info.Scope = &scope
+
+	router := mux.NewRouter()
+	router.HandleFunc("/v1/auth/user/{name}/password", AuthController{}.ChangePassword).Methods(http.MethodPut)
+	cases := []struct {
+		name        string
+		oldPassword string
+		status      int
+	}{
+		{"wrong old password", "wrong", http.StatusBadRequest},
+		{"retry too fast", "wrong", http.StatusTooManyRequests},
+		{"right old password after failure", "Passw0rd!", http.StatusTooManyRequests},
+	}
+	for _, c := range cases {
+		w := serveTest(router.ServeHTTP, http.MethodPut, "/v1/auth/user/u1/password",
+			&authapi.ReqChangePassword{OldPassword: c.oldPassword, Password: "N3w-Passw0rd!"}, info)
+		if w.Code != c.status {
+			t.Logf("%v: status should be %v, got %v, body: %v", c.name, c.status, w.Code, w.Body.String())
+			t.Fail()
+		}
+	}
+}
+
+func TestModifyUserOfSameName(t *testing.T) {
+	o := setupTestDB(t)
+	_, _, userRole := createTestRoles(t, o)
+	user := createTestUser(t, o, "user1", "g1", userRole)
+	// the token of the user deleted before user1 is created again
+	info := testUserInfo(user)
+	info.UserID = uuid.NewV4().String()
+
+	w := serveTest(AuthController{}.ModifyUser, http.MethodPut, "/v1/auth/user",
+		&authapi.User{Name: "user1", Email: "user1@example.com"}, info)
+	if w.Code != http.StatusBadRequest {
+		t.Logf("user of the same name shouldn't be modified, status: %v, body: %v", w.Code, w.Body.String())
+		t.Fail()
+	}
+}
+
+func TestModifyUserOfOtherGroupByUUID(t *testing.T) {
+	o := setupTestDB(t)
+	_, adminRole, userRole := createTestRoles(t, o)
+	admin := createTestUser(t, o, "admin1", "g1", adminRole, userRole)
+	createTestUser(t, o, "user1", "g1", userRole)
+	other := createTestUser(t, o, "user2", "g2", userRole)
+
+	cases := []struct {
+		name string
+		user *authapi.User
+	}{
+		{"uuid of the other group with name in the group", &authapi.User{UUID: other.UUID, Name: "user1", Group: admin.Group, Email: "user2@example.com"}},
+		{"uuid of the other group", &authapi.User{UUID: other.UUID, Group: admin.Group, Email: "user2@example.com"}},
+	}
+	for _, c := range cases {
+		w := serveTest(AuthController{}.ModifyUser, http.MethodPut, "/v1/auth/user", c.user, testUserInfo(admin))
+		if w.Code != http.StatusBadRequest {
+			t.Logf("%v: admin shouldn't modify the user of the other group, status: %v, body: %v", c.name, w.Code, w.Body.String())
+			t.Fail()
+		}
+	}
+	if user, err := authsvc.GetUserByUUID(other.UUID); err != nil || len(user.Email) != 0 || user.Group.Name != "g2" {
+		t.Logf("user of the other group shouldn't be modified: %+v, err: %v", user, err)
+		t.Fail()
+	}
+}
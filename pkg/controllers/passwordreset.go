@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/golang/glog"
+
+	authapi "imanager/pkg/api/auth"
+	authsvc "imanager/pkg/services/auth"
+	"imanager/pkg/util"
+)
+
+var resetPasswordPage = template.Must(template.New("reset").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>imanager</title>
+<style>
+body { font-family: sans-serif; background: #f5f5f5; }
+form { width: 320px; margin: 10% auto; padding: 24px; background: #fff; border-radius: 4px; }
+input { display: block; width: 100%; box-sizing: border-box; margin: 8px 0 16px; padding: 8px; }
+button { width: 100%; padding: 8px; }
+.error { color: #c00; }
+</style>
+</head>
+<body>
+<form method="post" action="{{.Action}}">
+<h3>Reset password{{if .Name}} of {{.Name}}{{end}}</h3>
+{{if .Message}}<p>{{.Message}}</p>{{end}}
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+{{if not .Done}}<input type="hidden" name="token" value="{{.Token}}">
+<label>New password<input name="password" type="password" autocomplete="new-password" required></label>
+<label>Confirm password<input name="confirm_password" type="password" autocomplete="new-password" required></label>
+<button type="submit">Reset</button>
+{{end}}</form>
+</body>
+</html>
+`))
+
+type resetPasswordPageData struct {
+	Action  string
+	Name    string
+	Token   string
+	Message string
+	Error   string
+	Done    bool
+}
+
+func renderResetPasswordPage(w http.ResponseWriter, statusCode int, data resetPasswordPageData) {
+	data.Action = authapi.ResetPasswordURL
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	// the token in the url isn't leaked to the other sites
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(statusCode)
+	if err := resetPasswordPage.Execute(w, data); err != nil {
+		glog.Errorf("render reset password page failed, err: %v", err)
+	}
+}
+
+// ForgotPassword mails the password reset link to the user in background, it always returns 202 if the mail sender
+// is configured, so that it can't be used to find the users
+func (c AuthController) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	requestBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("request body read failed, %v", err))
+		return
+	}
+	req := authapi.ReqForgotPassword{}
+	err = json.Unmarshal(requestBody, &req)
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("request body unmarshal failed, %v", err))
+		return
+	}
+	isMatch, _ := regexp.MatchString(UserNameRegexp, req.Name)
+	if !isMatch {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "user name doesn't match the format")
+		return
+	}
+
+	glog.Infof("user[%v] forgets the password, from %v", req.Name, getClientIP(r))
+	err = authsvc.SendPasswordResetLink(req.Name)
+	if err == authsvc.ErrMailDisabled {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "password reset isn't enabled")
+		return
+	}
+	if err != nil {
+		glog.Errorf("send password reset link failed, err: %v", err)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ResetPassword shows the page of the reset link by GET, and resets the password by POST from the page or in json
+func (c AuthController) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	isJSON := strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+	req := authapi.ReqResetPassword{}
+	if isJSON {
+		requestBody, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("request body read failed, %v", err))
+			return
+		}
+		if err = json.Unmarshal(requestBody, &req); err != nil {
+			util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("request body unmarshal failed, %v", err))
+			return
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "request form parse failed")
+			return
+		}
+		req.Token, req.Password = r.Form.Get("token"), r.PostForm.Get("password")
+	}
+	data := resetPasswordPageData{Token: req.Token}
+	returnError := func(statusCode int, msg string) {
+		if isJSON {
+			util.ReturnErrorResponseInResponseWriter(w, statusCode, msg)
+			return
+		}
+		data.Error = msg
+		renderResetPasswordPage(w, statusCode, data)
+	}
+
+	user, err := authsvc.CheckPasswordResetToken(req.Token)
+	if err == authsvc.ErrInvalidPasswordResetToken {
+		data.Done = true
+		returnError(http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		returnError(http.StatusInternalServerError, "check password reset link failed")
+		return
+	}
+	data.Name = user.Name
+	if r.Method != http.MethodPost {
+		renderResetPasswordPage(w, http.StatusOK, data)
+		return
+	}
+	if !isJSON && req.Password != r.PostForm.Get("confirm_password") {
+		returnError(http.StatusBadRequest, "the passwords are different")
+		return
+	}
+	if err = authsvc.ValidPassword(req.Password, user); err != nil {
+		if isJSON {
+			returnValidationError(w, err)
+			return
+		}
+		returnError(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	_, err = authsvc.ResetPassword(req.Token, req.Password)
+	if err == authsvc.ErrInvalidPasswordResetToken || err == authsvc.ErrExternalUserPassword {
+		data.Done = true
+		returnError(http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		returnError(http.StatusInternalServerError, fmt.Sprintf("reset password failed, %v", err))
+		return
+	}
+	if isJSON {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	data.Done = true
+	data.Message = "The password is reset, please login with the new password."
+	renderResetPasswordPage(w, http.StatusOK, data)
+}
@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+
+	authapi "imanager/pkg/api/auth"
+	authsvc "imanager/pkg/services/auth"
+	"imanager/pkg/util"
+)
+
+func (c AuthController) ListSession(w http.ResponseWriter, r *http.Request) {
+	info, err := util.GetUserInfo(r.Header.Get(authapi.ParseInfo))
+	if err != nil {
+		glog.Errorf("get user info from header failed, err: %v", err)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	name := mux.Vars(r)["name"]
+	if !isAllowedManageUserTokens(name, info, authapi.SessionResource, authapi.ReadVerb) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to list session")
+		return
+	}
+	if !info.Scope.AllowsAction(authapi.SessionResource, authapi.ReadVerb) || !isAllowedUserByScope(name, info) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to list session")
+		return
+	}
+
+	currentSessionID := ""
+	if name == info.Name {
+		currentSessionID = info.SessionID
+	}
+	sessions, num, err := authsvc.ListSessions(name, currentSessionID)
+	if err == orm.ErrNoRows {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "user isn't exist")
+		return
+	}
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("list session failed, %v", err))
+		return
+	}
+	respBody, _ := json.Marshal(authapi.SessionList{
+		Count: num,
+		Item:  sessions,
+	})
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(respBody)
+}
+
+func (c AuthController) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	info, err := util.GetUserInfo(r.Header.Get(authapi.ParseInfo))
+	if err != nil {
+		glog.Errorf("get user info from header failed, err: %v", err)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	name := mux.Vars(r)["name"]
+	if !isAllowedManageUserTokens(name, info, authapi.SessionResource, authapi.DeleteVerb) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to delete session")
+		return
+	}
+	if !info.Scope.AllowsAction(authapi.SessionResource, authapi.DeleteVerb) || !isAllowedUserByScope(name, info) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to delete session")
+		return
+	}
+	id := mux.Vars(r)["id"]
+
+	glog.Infof("delete session[%v] of user[%v] by %v/%v", id, name, info.Name, info.UserID)
+	err = authsvc.RevokeSession(name, id)
+	if err == orm.ErrNoRows {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "session isn't exist")
+		return
+	}
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("delete session failed, %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c AuthController) DeleteAllSessions(w http.ResponseWriter, r *http.Request) {
+	info, err := util.GetUserInfo(r.Header.Get(authapi.ParseInfo))
+	if err != nil {
+		glog.Errorf("get user info from header failed, err: %v", err)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	name := mux.Vars(r)["name"]
+	if !isAllowedManageUserTokens(name, info, authapi.SessionResource, authapi.DeleteVerb) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to delete session")
+		return
+	}
+	if !info.Scope.AllowsAction(authapi.SessionResource, authapi.DeleteVerb) || !isAllowedUserByScope(name, info) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to delete session")
+		return
+	}
+
+	glog.Infof("delete all sessions of user[%v] by %v/%v", name, info.Name, info.UserID)
+	err = authsvc.RevokeAllSessions(name)
+	if err == orm.ErrNoRows {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "user isn't exist")
+		return
+	}
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("delete session failed, %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+
+	authapi "imanager/pkg/api/auth"
+	authsvc "imanager/pkg/services/auth"
+	"imanager/pkg/util"
+)
+
+// GetUserTokenPolicy returns the token policy applied to the user, merged from the config, the group and the roles
+func (c AuthController) GetUserTokenPolicy(w http.ResponseWriter, r *http.Request) {
+	info, err := util.GetUserInfo(r.Header.Get(authapi.ParseInfo))
+	if err != nil {
+		glog.Errorf("get user info from header failed, err: %v", err)
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	name := mux.Vars(r)["name"]
+	if name != info.Name && !isAllowedOnUser(&authapi.User{Name: name}, info, authapi.UserResource, authapi.ReadVerb) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "no permission to get token policy")
+		return
+	}
+	if !info.Scope.AllowsAction(authapi.UserResource, authapi.ReadVerb) || !isAllowedUserByScope(name, info) {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "token scope doesn't allow to get token policy")
+		return
+	}
+
+	policy, err := authsvc.GetUserTokenPolicy(name)
+	if err == orm.ErrNoRows {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, "user isn't exist")
+		return
+	}
+	if err != nil {
+		util.ReturnErrorResponseInResponseWriter(w, http.StatusInternalServerError, fmt.Sprintf("get token policy failed, %v", err))
+		return
+	}
+	respBody, _ := json.Marshal(policy)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(respBody)
+}
@@ -1,12 +1,47 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/golang/glog"
 
 	authapi "imanager/pkg/api/auth"
+	"imanager/pkg/config"
 	authsvc "imanager/pkg/services/auth"
+	"imanager/pkg/util"
 )
 
+// set it to true only if imanager is behind a proxy which sets X-Forwarded-For
+const trustForwardedForKey = "TrustForwardedFor"
+
+// getPermissions returns the permissions of the roles of the caller, nothing is allowed if they can't be read
+func getPermissions(info *authapi.RespToken) *authapi.Permissions {
+	res, err := authsvc.GetPermissions(info.Role)
+	if err != nil {
+		glog.Errorf("get permissions of user[%v/%v] failed, err: %v", info.Name, info.UserID, err)
+		return &authapi.Permissions{}
+	}
+	return res
+}
+
+// isAllowedInGroup checks the roles of the caller allow the action in the group
+func isAllowedInGroup(info *authapi.RespToken, resource, verb string, groupName string) bool {
+	inGroup := info.Group != nil && len(groupName) != 0 && info.Group.Name == groupName
+	return getPermissions(info).Allows(resource, verb, inGroup)
+}
+
+// isAllowedInAllGroups checks the roles of the caller allow the action in all groups
+func isAllowedInAllGroups(info *authapi.RespToken, resource, verb string) bool {
+	return getPermissions(info).Allows(resource, verb, false)
+}
+
 func getManageUserIDs(info *authapi.RespToken) []string {
 	user, err := authsvc.GetUserByUUID(info.UserID)
 	if err != nil {
@@ -14,19 +49,12 @@ func getManageUserIDs(info *authapi.RespToken) []string {
 		return []string{info.UserID}
 	}
 
-	baseRole := authapi.UserRole
-	for _, v := range user.Role {
-		role := authapi.RoleType(v.ID)
-		if role == authapi.OpServiceRole {
-			// return all batch work
-			return []string{}
-		}
-		if role.IsLargerPermission(baseRole) {
-			baseRole = role
-		}
-
+	permissions := getPermissions(info)
+	if permissions.Allows(authapi.UserResource, authapi.ReadVerb, false) {
+		// return all batch work
+		return []string{}
 	}
-	if baseRole == authapi.UserRole {
+	if !permissions.Allows(authapi.UserResource, authapi.ReadVerb, true) {
 		return []string{info.UserID}
 	}
 
@@ -42,4 +70,131 @@ func getManageUserIDs(info *authapi.RespToken) []string {
 	}
 
 	return res
-}
\ No newline at end of file
+}
+
+// authenticateServiceCaller checks the credentials of other services, which should be a user allowed to read the tokens
+// of all groups in basic auth, or his token in bearer auth or token header. Basic auth goes through the lockout,
+// and is rejected if the user has mfa or should change the password. It returns the name of the caller
+func authenticateServiceCaller(r *http.Request) (string, error) {
+	var roles []authapi.RoleInUser
+	var name string
+	if username, password, ok := r.BasicAuth(); ok {
+		clientIP := getClientIP(r)
+		if _, err := authsvc.CheckLoginAllowed(username, clientIP); err != nil {
+			return "", err
+		}
+		isValid, user, err := authsvc.ValidUserPasswordAndGetRoles(username, password)
+		if err != nil {
+			return "", err
+		}
+		if !isValid {
+			authsvc.RecordLoginFailure(username, clientIP)
+			return "", errors.New("user name or password is invalid")
+		}
+		// basic auth can't carry the otp, the user with mfa should use the token
+		if err = authsvc.ValidMFA(user, ""); err != nil {
+			return "", fmt.Errorf("basic auth of user[%v] is rejected, %v", user.Name, err)
+		}
+		required, err := authsvc.IsPasswordChangeRequired(user)
+		if err != nil {
+			return "", err
+		}
+		if required {
+			return "", fmt.Errorf("the password of user[%v] should be changed", user.Name)
+		}
+		authsvc.ResetLoginFailures(user.Name)
+		name, roles = user.Name, user.Role
+	} else {
+		tokenStr := r.Header.Get(authapi.TokenHeaderKey)
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			tokenStr = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if len(tokenStr) == 0 {
+			return "", errors.New("no credentials")
+		}
+		info, err := authsvc.ValidateToken(tokenStr)
+		if err != nil {
+			return "", fmt.Errorf("parse token failed, %v", err)
+		}
+		if !info.Scope.AllowsAction(authapi.TokenResource, authapi.ReadVerb) || !info.Scope.AllowsAllGroups() {
+			return "", fmt.Errorf("scope of the token of %v doesn't allow to read the tokens", info.Name)
+		}
+		name, roles = info.Name, info.Role
+	}
+	permissions, err := authsvc.GetPermissions(roles)
+	if err != nil {
+		return "", err
+	}
+	if !permissions.Allows(authapi.TokenResource, authapi.ReadVerb, false) {
+		return "", fmt.Errorf("%v is not allowed to read the tokens", name)
+	}
+	return name, nil
+}
+
+// isAllowedUserByScope checks the user is in the group which the token is restricted to
+func isAllowedUserByScope(name string, info *authapi.RespToken) bool {
+	if info.Scope.AllowsAllGroups() {
+		return true
+	}
+	group, err := authsvc.GetUserGroup(name, "")
+	if err != nil {
+		glog.Errorf("get group of user[%v] failed, err: %v", name, err)
+		return false
+	}
+	return info.Scope.AllowsGroup(group.Name)
+}
+
+// isAllowedGroupIDByScope checks the group is the one which the token is restricted to
+func isAllowedGroupIDByScope(id int, info *authapi.RespToken) bool {
+	if info.Scope.AllowsAllGroups() {
+		return true
+	}
+	group, err := authsvc.GetGroupByID(id)
+	if err != nil {
+		glog.Errorf("get group by id %v failed, err: %v", id, err)
+		return false
+	}
+	return info.Scope.AllowsGroup(group.Name)
+}
+
+// filterUserIDsByScope keeps the users in the group which the token is restricted to,
+// empty ids means all users in getManageUserIDs
+func filterUserIDsByScope(ids []string, info *authapi.RespToken) ([]string, error) {
+	if info.Scope.AllowsAllGroups() {
+		return ids, nil
+	}
+	group, err := authsvc.GetGroupByName(info.Scope.Group)
+	if err != nil {
+		return nil, err
+	}
+	allowed := make(map[string]bool, len(ids))
+	for _, v := range ids {
+		allowed[v] = true
+	}
+	res := make([]string, 0, len(group.User))
+	for _, v := range group.User {
+		if len(ids) == 0 || allowed[v.UUID] {
+			res = append(res, v.UUID)
+		}
+	}
+	return res, nil
+}
+
+// getClientIP returns the source ip of the request, X-Forwarded-For is used only if the proxy is trusted
+func getClientIP(r *http.Request) string {
+	if trust, _ := config.GetConfig().Bool(trustForwardedForKey); trust {
+		if forwarded := r.Header.Get("X-Forwarded-For"); len(forwarded) != 0 {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func returnTooManyRequests(w http.ResponseWriter, retryAfter time.Duration, err error) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	util.ReturnErrorResponseInResponseWriter(w, http.StatusTooManyRequests, err.Error())
+}
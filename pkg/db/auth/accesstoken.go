@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/astaxie/beego/orm"
+
+	"imanager/pkg/db/util"
+)
+
+type PersonalAccessToken struct {
+	Id          int    `json:"id" orm:"unique"`
+	Name        string `json:"name"`
+	TokenHash   string `json:"token_hash" orm:"unique"`
+	TokenPrefix string `json:"token_prefix"`
+	UserUUID    string `json:"user_uuid" orm:"column(user_uuid);index"`
+	// comma separated role ids, empty means all roles of the user
+	Roles     string    `json:"roles"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// LastUsedAt is nil if the token is never used
+	LastUsedAt     *time.Time `json:"last_used_at" orm:"null"`
+	util.BaseModel `json:",inline"`
+}
+
+func (t *PersonalAccessToken) TableUnique() [][]string {
+	return [][]string{
+		{"UserUUID", "Name"},
+	}
+}
+
+func CreatePersonalAccessToken(o orm.Ormer, token PersonalAccessToken) (PersonalAccessToken, error) {
+	_, err := o.Insert(&token)
+	return token, err
+}
+
+func GetPersonalAccessTokenByHash(o orm.Ormer, hash string) (PersonalAccessToken, error) {
+	token := PersonalAccessToken{}
+	err := o.QueryTable(PersonalAccessToken{}).Filter("token_hash", hash).One(&token)
+	return token, err
+}
+
+func ListPersonalAccessTokensByUser(o orm.Ormer, userUUID string) ([]PersonalAccessToken, int64, error) {
+	tokens := []PersonalAccessToken{}
+	num, err := o.QueryTable(PersonalAccessToken{}).Filter("user_uuid", userUUID).OrderBy("id").All(&tokens)
+	return tokens, num, err
+}
+
+func DeletePersonalAccessToken(o orm.Ormer, userUUID string, id int) error {
+	num, err := o.QueryTable(PersonalAccessToken{}).Filter("user_uuid", userUUID).Filter("id", id).Delete()
+	if err != nil {
+		return err
+	}
+	if num == 0 {
+		return orm.ErrNoRows
+	}
+	return nil
+}
+
+func DeletePersonalAccessTokensByUser(o orm.Ormer, userUUID string) error {
+	_, err := o.QueryTable(PersonalAccessToken{}).Filter("user_uuid", userUUID).Delete()
+	return err
+}
+
+// TouchPersonalAccessToken updates the last used time at most once per interval
+func TouchPersonalAccessToken(o orm.Ormer, id int, now time.Time, interval time.Duration) error {
+	cond := orm.NewCondition().Or("last_used_at__isnull", true).Or("last_used_at__lt", now.Add(-interval))
+	_, err := o.QueryTable(PersonalAccessToken{}).SetCond(orm.NewCondition().And("id", id).AndCond(cond)).Update(orm.Params{
+		"last_used_at": now,
+	})
+	return err
+}
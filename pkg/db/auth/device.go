@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/astaxie/beego/orm"
+
+	"imanager/pkg/db/util"
+)
+
+// the status of the device authorization
+const (
+	DevicePending  = "pending"
+	DeviceApproved = "approved"
+	DeviceDenied   = "denied"
+)
+
+// OAuthDeviceCode is the pending device authorization, the device polls it by the device code,
+// and the user approves it by the user code
+type OAuthDeviceCode struct {
+	Id             int       `json:"id" orm:"unique"`
+	DeviceCodeHash string    `json:"device_code_hash" orm:"unique"`
+	UserCode       string    `json:"user_code" orm:"unique"`
+	ClientID       string    `json:"client_id" orm:"column(client_id)"`
+	Scope          string    `json:"scope" orm:"type(text)"`
+	Status         string    `json:"status"`
+	UserUUID       string    `json:"user_uuid" orm:"column(user_uuid)"`
+	AuthTime       time.Time `json:"auth_time" orm:"null"`
+	// Interval is the seconds the device should wait between the polls, it's increased by slow_down
+	Interval       int       `json:"interval"`
+	PolledAt       time.Time `json:"polled_at" orm:"null"`
+	ExpiresAt      time.Time `json:"expires_at" orm:"index"`
+	util.BaseModel `json:",inline"`
+}
+
+func (m *OAuthDeviceCode) TableName() string {
+	return "oauth_device_code"
+}
+
+func CreateOAuthDeviceCode(o orm.Ormer, code OAuthDeviceCode) (OAuthDeviceCode, error) {
+	_, err := o.Insert(&code)
+	return code, err
+}
+
+func GetOAuthDeviceCodeByUserCode(o orm.Ormer, userCode string) (OAuthDeviceCode, error) {
+	res := OAuthDeviceCode{}
+	err := o.QueryTable(OAuthDeviceCode{}).Filter("user_code", userCode).One(&res)
+	return res, err
+}
+
+func GetOAuthDeviceCodeByHash(o orm.Ormer, deviceCodeHash string) (OAuthDeviceCode, error) {
+	res := OAuthDeviceCode{}
+	err := o.QueryTable(OAuthDeviceCode{}).Filter("device_code_hash", deviceCodeHash).One(&res)
+	return res, err
+}
+
+// DecideOAuthDeviceCode approves or denies the pending device authorization, orm.ErrNoRows is returned
+// if it isn't pending any more
+func DecideOAuthDeviceCode(o orm.Ormer, id int, status, userUUID string, authTime time.Time) error {
+	num, err := o.QueryTable(OAuthDeviceCode{}).Filter("id", id).Filter("status", DevicePending).Update(orm.Params{
+		"status":    status,
+		"user_uuid": userUUID,
+		"auth_time": authTime,
+	})
+	if err != nil {
+		return err
+	}
+	if num != 1 {
+		return orm.ErrNoRows
+	}
+	return nil
+}
+
+func UpdateOAuthDeviceCodePoll(o orm.Ormer, id, interval int, polledAt time.Time) error {
+	_, err := o.QueryTable(OAuthDeviceCode{}).Filter("id", id).Update(orm.Params{
+		"interval":  interval,
+		"polled_at": polledAt,
+	})
+	return err
+}
+
+// DeleteOAuthDeviceCode deletes the device code after the token is issued, orm.ErrNoRows is returned
+// if it was already deleted by a concurrent poll
+func DeleteOAuthDeviceCode(o orm.Ormer, id int) error {
+	num, err := o.QueryTable(OAuthDeviceCode{}).Filter("id", id).Delete()
+	if err != nil {
+		return err
+	}
+	if num != 1 {
+		return orm.ErrNoRows
+	}
+	return nil
+}
+
+func DeleteExpiredOAuthDeviceCodes(o orm.Ormer, now time.Time) (int64, error) {
+	return o.QueryTable(OAuthDeviceCode{}).Filter("expires_at__lt", now).Delete()
+}
@@ -9,13 +9,21 @@ import (
 )
 
 type Group struct {
-	Id             int     `json:"id" orm:"unique"`
-	Name           string  `json:"name" orm:"unique"`
-	Annotation     string  `json:"annotation"`
-	Builtin        bool    `json:"builtin"`
-	Role           []*Role `json:"role" orm:"rel(m2m)"`
-	User           []*User `orm:"reverse(many)"`
-	util.BaseModel `json:",inline"`
+	Id         int    `json:"id" orm:"unique"`
+	Name       string `json:"name" orm:"unique"`
+	Annotation string `json:"annotation"`
+	Builtin    bool   `json:"builtin"`
+	MFAPolicy  string `json:"mfa_policy" orm:"column(mfa_policy)"`
+	// the token policy in minutes, see authapi.TokenPolicy
+	TokenDefaultDuration int     `json:"token_default_duration"`
+	TokenMaxDuration     int     `json:"token_max_duration"`
+	TokenIdleTimeout     int     `json:"token_idle_timeout"`
+	Role                 []*Role `json:"role" orm:"rel(m2m)"`
+	User                 []*User `orm:"reverse(many)"`
+	util.BaseModel       `json:",inline"`
+
+	// PasswordMaxAge is in days
+	PasswordMaxAge int `json:"password_max_age"`
 }
 
 var (
@@ -81,6 +89,7 @@ func UpdateGroup(o orm.Ormer, group Group) (Group, error) {
 	if err != nil {
 		return group, err
 	}
+	requested := group
 	err = patch(&oldGroup, &group)
 	if err != nil {
 		return group, err
@@ -90,6 +99,16 @@ func UpdateGroup(o orm.Ormer, group Group) (Group, error) {
 	if err != nil {
 		return group, err
 	}
+	// patch keeps the old values of the zero fields, but 0 of the policies means unlimited or the default
+	_, err = o.QueryTable(Group{}).Filter("id", group.Id).Update(orm.Params{
+		"token_default_duration": requested.TokenDefaultDuration,
+		"token_max_duration":     requested.TokenMaxDuration,
+		"token_idle_timeout":     requested.TokenIdleTimeout,
+		"password_max_age":       requested.PasswordMaxAge,
+	})
+	if err != nil {
+		return group, err
+	}
 	return GetGroupByName(o, group.Name)
 }
 
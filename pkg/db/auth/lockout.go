@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/astaxie/beego/orm"
+
+	"imanager/pkg/db/util"
+)
+
+// LoginFailure counts the failed logins of a subject, which is the user name or source ip with prefix
+type LoginFailure struct {
+	Id             int       `json:"id" orm:"unique"`
+	Subject        string    `json:"subject" orm:"unique"`
+	Failures       int       `json:"failures"`
+	LastFailureAt  time.Time `json:"last_failure_at" orm:"null"`
+	LockedUntil    time.Time `json:"locked_until" orm:"null;index"`
+	util.BaseModel `json:",inline"`
+}
+
+func GetLoginFailure(o orm.Ormer, subject string) (LoginFailure, error) {
+	failure := LoginFailure{}
+	err := o.QueryTable(LoginFailure{}).Filter("subject", subject).One(&failure)
+	return failure, err
+}
+
+// RecordLoginFailure increases the failures of subject, the failures before the window are forgotten
+func RecordLoginFailure(o orm.Ormer, subject string, now time.Time, window time.Duration) (LoginFailure, error) {
+	_, err := o.QueryTable(LoginFailure{}).Filter("subject", subject).Filter("last_failure_at__lt", now.Add(-window)).Update(orm.Params{
+		"failures": 0,
+	})
+	if err != nil {
+		return LoginFailure{}, err
+	}
+	for i := 0; i < 2; i++ {
+		// the counter is increased in db, so that the failures from all replicas are counted
+		var num int64
+		num, err = o.QueryTable(LoginFailure{}).Filter("subject", subject).Update(orm.Params{
+			"failures":        orm.ColValue(orm.ColAdd, 1),
+			"last_failure_at": now,
+		})
+		if err != nil {
+			return LoginFailure{}, err
+		}
+		if num != 0 {
+			break
+		}
+		// insert fails if the subject is inserted by others at the same time, then update again
+		_, err = o.Insert(&LoginFailure{Subject: subject, Failures: 1, LastFailureAt: now})
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return LoginFailure{}, err
+	}
+	return GetLoginFailure(o, subject)
+}
+
+// LockLoginSubject locks the subject until the time and clears the failures
+func LockLoginSubject(o orm.Ormer, subject string, until time.Time) error {
+	_, err := o.QueryTable(LoginFailure{}).Filter("subject", subject).Update(orm.Params{
+		"failures":     0,
+		"locked_until": until,
+	})
+	return err
+}
+
+func DeleteLoginFailure(o orm.Ormer, subject string) error {
+	_, err := o.QueryTable(LoginFailure{}).Filter("subject", subject).Delete()
+	return err
+}
+
+// DeleteStaleLoginFailures deletes the subjects which failed before the time and aren't locked now
+func DeleteStaleLoginFailures(o orm.Ormer, failedBefore, now time.Time) (int64, error) {
+	cond := orm.NewCondition().And("last_failure_at__lt", failedBefore).AndCond(
+		orm.NewCondition().Or("locked_until__isnull", true).Or("locked_until__lt", now))
+	return o.QueryTable(LoginFailure{}).SetCond(cond).Delete()
+}
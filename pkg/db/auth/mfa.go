@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"github.com/astaxie/beego/orm"
+
+	"imanager/pkg/db/util"
+)
+
+type UserMFA struct {
+	Id       int    `json:"id" orm:"unique"`
+	UserUUID string `json:"user_uuid" orm:"column(user_uuid);unique"`
+	// the encrypted totp secret
+	Secret string `json:"secret" orm:"type(text)"`
+	// it's enabled after the first code is verified
+	Enabled bool `json:"enabled"`
+	// comma separated hashes of the unused recovery codes
+	RecoveryCodes string `json:"recovery_codes" orm:"type(text)"`
+	// the time step of the last accepted code, the codes until it can't be used again
+	LastUsedStep   int64 `json:"last_used_step"`
+	util.BaseModel `json:",inline"`
+}
+
+func (m *UserMFA) TableName() string {
+	return "user_mfa"
+}
+
+func GetUserMFA(o orm.Ormer, userUUID string) (UserMFA, error) {
+	mfa := UserMFA{}
+	err := o.QueryTable(UserMFA{}).Filter("user_uuid", userUUID).One(&mfa)
+	return mfa, err
+}
+
+// ResetUserMFA replaces the mfa of the user with the new enrollment which isn't enabled
+func ResetUserMFA(o orm.Ormer, mfa UserMFA) (UserMFA, error) {
+	if err := DeleteUserMFA(o, mfa.UserUUID); err != nil {
+		return mfa, err
+	}
+	mfa.Enabled = false
+	_, err := o.Insert(&mfa)
+	return mfa, err
+}
+
+// UseUserMFAStep saves the accepted step, it returns false if a code of the step or later was already used
+func UseUserMFAStep(o orm.Ormer, id int, step int64, enable bool) (bool, error) {
+	params := orm.Params{"last_used_step": step}
+	if enable {
+		params["enabled"] = true
+	}
+	num, err := o.QueryTable(UserMFA{}).Filter("id", id).Filter("last_used_step__lt", step).Update(params)
+	return num == 1, err
+}
+
+// UseUserMFARecoveryCodes replaces the recovery codes if they aren't changed by others
+func UseUserMFARecoveryCodes(o orm.Ormer, id int, oldCodes, newCodes string) (bool, error) {
+	num, err := o.QueryTable(UserMFA{}).Filter("id", id).Filter("recovery_codes", oldCodes).Update(orm.Params{
+		"recovery_codes": newCodes,
+	})
+	return num == 1, err
+}
+
+// UpdateUserMFASecret replaces the encrypted secret if it isn't changed by others
+func UpdateUserMFASecret(o orm.Ormer, id int, oldSecret, newSecret string) error {
+	_, err := o.QueryTable(UserMFA{}).Filter("id", id).Filter("secret", oldSecret).Update(orm.Params{
+		"secret": newSecret,
+	})
+	return err
+}
+
+func DeleteUserMFA(o orm.Ormer, userUUID string) error {
+	_, err := o.QueryTable(UserMFA{}).Filter("user_uuid", userUUID).Delete()
+	return err
+}
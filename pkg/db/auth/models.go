@@ -0,0 +1,10 @@
+package auth
+
+// Models returns all the models of auth which should be registered to orm
+func Models() []interface{} {
+	return []interface{}{new(User), new(Role), new(Group), new(RefreshToken), new(RevokedToken),
+		new(PersonalAccessToken), new(UserMFA), new(LoginFailure),
+		new(Session), new(OIDCLoginState),
+		new(OAuthClient), new(OAuthAuthorizationCode), new(OAuthDeviceCode),
+		new(PasswordResetToken), new(PasswordHistory)}
+}
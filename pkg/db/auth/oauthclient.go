@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/astaxie/beego/orm"
+
+	"imanager/pkg/db/util"
+)
+
+type OAuthClient struct {
+	Id         int    `json:"id" orm:"unique"`
+	ClientID   string `json:"client_id" orm:"column(client_id);unique"`
+	SecretHash string `json:"secret_hash"`
+	Name       string `json:"name"`
+	// newline separated
+	RedirectURIs string `json:"redirect_uris" orm:"column(redirect_uris);type(text)"`
+	Public       bool   `json:"public"`
+	GroupID      int    `json:"group_id" orm:"column(group_id);index"`
+	// space separated, empty means the default grant types
+	GrantTypes string `json:"grant_types"`
+	// space separated actions, empty means all actions
+	AllowedScopes string `json:"allowed_scopes" orm:"type(text)"`
+	// comma separated role ids of the client token, empty means the default role
+	Roles          string `json:"roles"`
+	CreatedBy      string `json:"created_by"`
+	util.BaseModel `json:",inline"`
+}
+
+func (m *OAuthClient) TableName() string {
+	return "oauth_client"
+}
+
+func CreateOAuthClient(o orm.Ormer, client OAuthClient) (OAuthClient, error) {
+	_, err := o.Insert(&client)
+	return client, err
+}
+
+func GetOAuthClient(o orm.Ormer, clientID string) (OAuthClient, error) {
+	client := OAuthClient{}
+	err := o.QueryTable(OAuthClient{}).Filter("client_id", clientID).One(&client)
+	return client, err
+}
+
+// ListOAuthClients returns the clients of the group, or all clients if groupID is 0
+func ListOAuthClients(o orm.Ormer, groupID int) ([]OAuthClient, int64, error) {
+	clients := []OAuthClient{}
+	qs := o.QueryTable(OAuthClient{})
+	if groupID != 0 {
+		qs = qs.Filter("group_id", groupID)
+	}
+	num, err := qs.OrderBy("id").All(&clients)
+	return clients, num, err
+}
+
+func CountOAuthClientsByGroup(o orm.Ormer, groupID int) (int64, error) {
+	return o.QueryTable(OAuthClient{}).Filter("group_id", groupID).Count()
+}
+
+// UpdateOAuthClient updates the attributes except the client id and the secret
+func UpdateOAuthClient(o orm.Ormer, client OAuthClient) error {
+	_, err := o.QueryTable(OAuthClient{}).Filter("client_id", client.ClientID).Update(orm.Params{
+		"name":             client.Name,
+		"redirect_uris":    client.RedirectURIs,
+		"public":           client.Public,
+		"group_id":         client.GroupID,
+		"grant_types":      client.GrantTypes,
+		"allowed_scopes":   client.AllowedScopes,
+		"roles":            client.Roles,
+		"update_timestamp": time.Now(),
+	})
+	return err
+}
+
+func UpdateOAuthClientSecret(o orm.Ormer, clientID, secretHash string) error {
+	_, err := o.QueryTable(OAuthClient{}).Filter("client_id", clientID).Update(orm.Params{
+		"secret_hash":      secretHash,
+		"update_timestamp": time.Now(),
+	})
+	return err
+}
+
+func DeleteOAuthClient(o orm.Ormer, clientID string) error {
+	num, err := o.QueryTable(OAuthClient{}).Filter("client_id", clientID).Delete()
+	if err != nil {
+		return err
+	}
+	if num == 0 {
+		return orm.ErrNoRows
+	}
+	return nil
+}
+
+// OAuthAuthorizationCode is issued to the client after the user logins at the authorization endpoint,
+// it can only be exchanged once
+type OAuthAuthorizationCode struct {
+	Id             int       `json:"id" orm:"unique"`
+	CodeHash       string    `json:"code_hash" orm:"unique"`
+	ClientID       string    `json:"client_id" orm:"column(client_id)"`
+	UserUUID       string    `json:"user_uuid" orm:"column(user_uuid)"`
+	RedirectURI    string    `json:"redirect_uri" orm:"column(redirect_uri);type(text)"`
+	Scope          string    `json:"scope" orm:"type(text)"`
+	Nonce          string    `json:"nonce" orm:"type(text)"`
+	CodeChallenge  string    `json:"code_challenge"`
+	AuthTime       time.Time `json:"auth_time"`
+	ExpiresAt      time.Time `json:"expires_at" orm:"index"`
+	util.BaseModel `json:",inline"`
+}
+
+func (m *OAuthAuthorizationCode) TableName() string {
+	return "oauth_authorization_code"
+}
+
+func CreateOAuthAuthorizationCode(o orm.Ormer, code OAuthAuthorizationCode) (OAuthAuthorizationCode, error) {
+	_, err := o.Insert(&code)
+	return code, err
+}
+
+// UseOAuthAuthorizationCode deletes the code and returns it, orm.ErrNoRows is returned if it isn't exist
+// or was already used by a concurrent request
+func UseOAuthAuthorizationCode(o orm.Ormer, codeHash string) (OAuthAuthorizationCode, error) {
+	res := OAuthAuthorizationCode{}
+	err := o.QueryTable(OAuthAuthorizationCode{}).Filter("code_hash", codeHash).One(&res)
+	if err != nil {
+		return res, err
+	}
+	num, err := o.QueryTable(OAuthAuthorizationCode{}).Filter("id", res.Id).Delete()
+	if err != nil {
+		return res, err
+	}
+	if num != 1 {
+		return res, orm.ErrNoRows
+	}
+	return res, nil
+}
+
+func DeleteExpiredOAuthAuthorizationCodes(o orm.Ormer, now time.Time) (int64, error) {
+	return o.QueryTable(OAuthAuthorizationCode{}).Filter("expires_at__lt", now).Delete()
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/astaxie/beego/orm"
+
+	"imanager/pkg/db/util"
+)
+
+// OIDCLoginState is the pending login redirected to the upstream openid connect provider,
+// it's looked up by the state in the callback and can only be used once
+type OIDCLoginState struct {
+	Id             int       `json:"id" orm:"unique"`
+	State          string    `json:"state" orm:"unique"`
+	Nonce          string    `json:"nonce"`
+	CodeVerifier   string    `json:"code_verifier"`
+	ExpiresAt      time.Time `json:"expires_at" orm:"index"`
+	util.BaseModel `json:",inline"`
+}
+
+func (m *OIDCLoginState) TableName() string {
+	return "oidc_login_state"
+}
+
+func CreateOIDCLoginState(o orm.Ormer, state OIDCLoginState) (OIDCLoginState, error) {
+	_, err := o.Insert(&state)
+	return state, err
+}
+
+// UseOIDCLoginState deletes the state and returns it, orm.ErrNoRows is returned if it isn't exist
+// or was already used by a concurrent callback
+func UseOIDCLoginState(o orm.Ormer, state string) (OIDCLoginState, error) {
+	res := OIDCLoginState{}
+	err := o.QueryTable(OIDCLoginState{}).Filter("state", state).One(&res)
+	if err != nil {
+		return res, err
+	}
+	num, err := o.QueryTable(OIDCLoginState{}).Filter("id", res.Id).Delete()
+	if err != nil {
+		return res, err
+	}
+	if num != 1 {
+		return res, orm.ErrNoRows
+	}
+	return res, nil
+}
+
+func DeleteExpiredOIDCLoginStates(o orm.Ormer, now time.Time) (int64, error) {
+	return o.QueryTable(OIDCLoginState{}).Filter("expires_at__lt", now).Delete()
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"github.com/astaxie/beego/orm"
+
+	"imanager/pkg/db/util"
+)
+
+// PasswordHistory keeps the hashes of the previous passwords of the user, so that they aren't reused
+type PasswordHistory struct {
+	Id             int    `json:"id" orm:"unique"`
+	UserUUID       string `json:"user_uuid" orm:"column(user_uuid);index"`
+	PasswordHash   string `json:"password_hash"`
+	util.BaseModel `json:",inline"`
+}
+
+func CreatePasswordHistory(o orm.Ormer, history PasswordHistory) (PasswordHistory, error) {
+	_, err := o.Insert(&history)
+	return history, err
+}
+
+// ListPasswordHistory returns the latest previous passwords of the user
+func ListPasswordHistory(o orm.Ormer, userUUID string, limit int) ([]PasswordHistory, error) {
+	res := []PasswordHistory{}
+	_, err := o.QueryTable(PasswordHistory{}).Filter("user_uuid", userUUID).OrderBy("-id").Limit(limit).All(&res)
+	return res, err
+}
+
+// TrimPasswordHistory keeps the latest size previous passwords of the user
+func TrimPasswordHistory(o orm.Ormer, userUUID string, size int) error {
+	res := []PasswordHistory{}
+	_, err := o.QueryTable(PasswordHistory{}).Filter("user_uuid", userUUID).OrderBy("-id").Offset(size).All(&res, "Id")
+	if err != nil || len(res) == 0 {
+		return err
+	}
+	ids := make([]int, 0, len(res))
+	for _, v := range res {
+		ids = append(ids, v.Id)
+	}
+	_, err = o.QueryTable(PasswordHistory{}).Filter("id__in", ids).Delete()
+	return err
+}
+
+func DeletePasswordHistoryByUser(o orm.Ormer, userUUID string) error {
+	_, err := o.QueryTable(PasswordHistory{}).Filter("user_uuid", userUUID).Delete()
+	return err
+}
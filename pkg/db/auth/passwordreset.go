@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/astaxie/beego/orm"
+
+	"imanager/pkg/db/util"
+)
+
+// PasswordResetToken records the password reset link sent to the user, so that it can only be used once
+type PasswordResetToken struct {
+	Id             int       `json:"id" orm:"unique"`
+	TokenID        string    `json:"token_id" orm:"column(token_id);unique"`
+	UserUUID       string    `json:"user_uuid" orm:"column(user_uuid);index"`
+	Used           bool      `json:"used"`
+	ExpiresAt      time.Time `json:"expires_at" orm:"index"`
+	util.BaseModel `json:",inline"`
+}
+
+func CreatePasswordResetToken(o orm.Ormer, token PasswordResetToken) (PasswordResetToken, error) {
+	_, err := o.Insert(&token)
+	return token, err
+}
+
+// CountPasswordResetTokensSince counts the links sent to the user since the time
+func CountPasswordResetTokensSince(o orm.Ormer, userUUID string, since time.Time) (int64, error) {
+	return o.QueryTable(PasswordResetToken{}).Filter("user_uuid", userUUID).Filter("create_timestamp__gte", since).Count()
+}
+
+// GetValidPasswordResetToken returns the token if it isn't expired or used, otherwise orm.ErrNoRows is returned
+func GetValidPasswordResetToken(o orm.Ormer, tokenID, userUUID string, now time.Time) (PasswordResetToken, error) {
+	token := PasswordResetToken{}
+	err := o.QueryTable(PasswordResetToken{}).Filter("token_id", tokenID).Filter("user_uuid", userUUID).
+		Filter("used", false).Filter("expires_at__gt", now).One(&token)
+	return token, err
+}
+
+// UsePasswordResetToken marks the token used, orm.ErrNoRows is returned if it isn't exist, expired or already used
+func UsePasswordResetToken(o orm.Ormer, tokenID, userUUID string, now time.Time) error {
+	num, err := o.QueryTable(PasswordResetToken{}).Filter("token_id", tokenID).Filter("user_uuid", userUUID).
+		Filter("used", false).Filter("expires_at__gt", now).Update(orm.Params{"used": true})
+	if err != nil {
+		return err
+	}
+	if num != 1 {
+		return orm.ErrNoRows
+	}
+	return nil
+}
+
+// DeletePasswordResetTokensByUser invalidates the links of the user after the password is changed
+func DeletePasswordResetTokensByUser(o orm.Ormer, userUUID string) error {
+	_, err := o.QueryTable(PasswordResetToken{}).Filter("user_uuid", userUUID).Delete()
+	return err
+}
+
+func DeleteExpiredPasswordResetTokens(o orm.Ormer, now time.Time) (int64, error) {
+	return o.QueryTable(PasswordResetToken{}).Filter("expires_at__lt", now).Delete()
+}
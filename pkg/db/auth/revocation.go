@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/astaxie/beego/orm"
+
+	"imanager/pkg/db/util"
+)
+
+// RevokedToken records a revoked token by its token id, the row whose token id is empty
+// revokes all the tokens of the user issued in or before the second of RevokedAt
+type RevokedToken struct {
+	Id        int       `json:"id" orm:"unique"`
+	TokenID   string    `json:"token_id" orm:"column(token_id);index"`
+	UserUUID  string    `json:"user_uuid" orm:"column(user_uuid);index"`
+	RevokedAt time.Time `json:"revoked_at"`
+	ExpiresAt time.Time `json:"expires_at" orm:"null;index"`
+	// Exempt records the token issued after the revocation of the user in the same second, it isn't revoked
+	Exempt         bool `json:"exempt"`
+	util.BaseModel `json:",inline"`
+}
+
+func CreateRevokedToken(o orm.Ormer, token RevokedToken) (RevokedToken, error) {
+	_, err := o.Insert(&token)
+	return token, err
+}
+
+// IsTokenIDRevoked checks the token ids, the session id of the token can be revoked as a token id too
+func IsTokenIDRevoked(o orm.Ormer, tokenIDs ...string) bool {
+	return o.QueryTable(RevokedToken{}).Filter("token_id__in", tokenIDs).Filter("exempt", false).Exist()
+}
+
+// IsTokenExempt checks if the token is exempted from the current revocation of the user
+func IsTokenExempt(o orm.Ormer, tokenID, userUUID string) bool {
+	return o.QueryTable(RevokedToken{}).Filter("token_id", tokenID).Filter("user_uuid", userUUID).Filter("exempt", true).Exist()
+}
+
+// GetUserRevokedAt returns zero time if tokens of the user were never revoked
+func GetUserRevokedAt(o orm.Ormer, userUUID string) (time.Time, error) {
+	token := RevokedToken{}
+	err := o.QueryTable(RevokedToken{}).Filter("user_uuid", userUUID).Filter("token_id", "").One(&token)
+	if err == orm.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return token.RevokedAt, err
+}
+
+// RevokeUserTokens also removes the tokens exempted from the previous revocation of the user
+func RevokeUserTokens(o orm.Ormer, userUUID string, revokedAt time.Time) error {
+	_, err := o.QueryTable(RevokedToken{}).Filter("user_uuid", userUUID).Filter("exempt", true).Delete()
+	if err != nil {
+		return err
+	}
+	num, err := o.QueryTable(RevokedToken{}).Filter("user_uuid", userUUID).Filter("token_id", "").Update(orm.Params{
+		"revoked_at":       revokedAt,
+		"update_timestamp": time.Now(),
+	})
+	if err != nil || num != 0 {
+		return err
+	}
+	_, err = CreateRevokedToken(o, RevokedToken{
+		UserUUID:  userUUID,
+		RevokedAt: revokedAt,
+	})
+	return err
+}
+
+// DeleteExpiredRevokedTokens keeps the rows which revoke all tokens of users
+func DeleteExpiredRevokedTokens(o orm.Ormer, before time.Time) (int64, error) {
+	return o.QueryTable(RevokedToken{}).Filter("token_id__gt", "").Filter("expires_at__lt", before).Delete()
+}
@@ -10,12 +10,23 @@ import (
 )
 
 type Role struct {
-	Id             int      `json:"id" orm:"unique"`
-	Name           string   `json:"name" orm:"unique"`
-	Annotation     string   `json:"annotation"`
-	User           []*User  `json:"-" orm:"reverse(many)"`
-	Group          []*Group `json:"-" orm:"reverse(many)"`
-	util.BaseModel `json:",inline"`
+	Id         int    `json:"id" orm:"unique"`
+	Name       string `json:"name" orm:"unique"`
+	Annotation string `json:"annotation"`
+	// the token policy in minutes, see authapi.TokenPolicy
+	TokenDefaultDuration int      `json:"token_default_duration"`
+	TokenMaxDuration     int      `json:"token_max_duration"`
+	TokenIdleTimeout     int      `json:"token_idle_timeout"`
+	User                 []*User  `json:"-" orm:"reverse(many)"`
+	Group                []*Group `json:"-" orm:"reverse(many)"`
+	util.BaseModel       `json:",inline"`
+
+	// the space separated actions, see authapi.Role
+	Permissions string `json:"permissions" orm:"type(text)"`
+	AllGroups   bool   `json:"all_groups"`
+	// PermissionsSet is true once the permissions are set by api, then the builtin role has no default permissions
+	// even if they are empty
+	PermissionsSet bool `json:"permissions_set"`
 }
 
 var (
@@ -78,6 +89,7 @@ func UpdateRole(o orm.Ormer, role Role) (Role, error) {
 	if err != nil {
 		return role, err
 	}
+	requested := role
 	err = patch(&oldRole, &role)
 	if err != nil {
 		return role, err
@@ -87,6 +99,26 @@ func UpdateRole(o orm.Ormer, role Role) (Role, error) {
 	if err != nil {
 		return role, err
 	}
+	// patch keeps the old values of the zero fields, but 0 of the token policy means unlimited or the default
+	_, err = o.QueryTable(Role{}).Filter("id", role.Id).Update(orm.Params{
+		"token_default_duration": requested.TokenDefaultDuration,
+		"token_max_duration":     requested.TokenMaxDuration,
+		"token_idle_timeout":     requested.TokenIdleTimeout,
+	})
+	if err != nil {
+		return role, err
+	}
+	// the permissions can be removed, and all_groups can be false
+	if requested.PermissionsSet {
+		_, err = o.QueryTable(Role{}).Filter("id", role.Id).Update(orm.Params{
+			"permissions":     requested.Permissions,
+			"all_groups":      requested.AllGroups,
+			"permissions_set": true,
+		})
+		if err != nil {
+			return role, err
+		}
+	}
 	return GetRoleByName(o, role.Name)
 }
 
@@ -107,6 +139,16 @@ func CreateRole(o orm.Ormer, role Role) (Role, error) {
 	return GetRoleByName(o, role.Name)
 }
 
+// ListRoleByIDs returns the roles without the related users and groups
+func ListRoleByIDs(o orm.Ormer, ids []int) ([]Role, error) {
+	roles := []Role{}
+	if len(ids) == 0 {
+		return roles, nil
+	}
+	_, err := o.QueryTable(Role{}).Filter("id__in", ids).All(&roles)
+	return roles, err
+}
+
 func ListRole(o orm.Ormer, query *dataselect.DataSelectQuery) ([]Role, int64, error) {
 	roles := []Role{}
 	origin := o.QueryTable(Role{})
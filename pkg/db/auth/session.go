@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/astaxie/beego/orm"
+
+	"imanager/pkg/db/util"
+)
+
+// Session is a login of the user, the session id is the family id of its refresh tokens
+type Session struct {
+	Id         int       `json:"id" orm:"unique"`
+	SessionID  string    `json:"session_id" orm:"column(session_id);unique"`
+	UserUUID   string    `json:"user_uuid" orm:"column(user_uuid);index"`
+	ClientIP   string    `json:"client_ip" orm:"column(client_ip)"`
+	UserAgent  string    `json:"user_agent" orm:"size(512)"`
+	IssuedAt   time.Time `json:"issued_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	// the expiry of the refresh token
+	ExpiresAt time.Time `json:"expires_at" orm:"index"`
+	// the latest expiry of the access tokens issued in the session
+	AccessExpiresAt time.Time `json:"access_expires_at"`
+	// minutes, the session ends if it isn't used for the time, 0 means never
+	IdleTimeout    int  `json:"idle_timeout"`
+	Revoked        bool `json:"revoked"`
+	util.BaseModel `json:",inline"`
+}
+
+func CreateSession(o orm.Ormer, session Session) (Session, error) {
+	_, err := o.Insert(&session)
+	return session, err
+}
+
+func GetSession(o orm.Ormer, sessionID string) (Session, error) {
+	session := Session{}
+	err := o.QueryTable(Session{}).Filter("session_id", sessionID).One(&session)
+	return session, err
+}
+
+// ListActiveSessionsByUser returns the sessions which aren't revoked or expired
+func ListActiveSessionsByUser(o orm.Ormer, userUUID string, now time.Time) ([]Session, int64, error) {
+	sessions := []Session{}
+	num, err := o.QueryTable(Session{}).Filter("user_uuid", userUUID).Filter("revoked", false).
+		Filter("expires_at__gt", now).OrderBy("-last_seen_at").All(&sessions)
+	return sessions, num, err
+}
+
+// RenewSession is called when the tokens of the session are refreshed
+func RenewSession(o orm.Ormer, sessionID, clientIP, userAgent string, now, expiresAt, accessExpiresAt time.Time, idleTimeout int) error {
+	_, err := o.QueryTable(Session{}).Filter("session_id", sessionID).Update(orm.Params{
+		"client_ip":         clientIP,
+		"user_agent":        userAgent,
+		"last_seen_at":      now,
+		"expires_at":        expiresAt,
+		"access_expires_at": accessExpiresAt,
+		"idle_timeout":      idleTimeout,
+	})
+	return err
+}
+
+// TouchSession updates the last seen time at most once per interval
+func TouchSession(o orm.Ormer, sessionID string, now time.Time, interval time.Duration) error {
+	_, err := o.QueryTable(Session{}).Filter("session_id", sessionID).Filter("last_seen_at__lt", now.Add(-interval)).Update(orm.Params{
+		"last_seen_at": now,
+	})
+	return err
+}
+
+func RevokeSession(o orm.Ormer, sessionID string) error {
+	_, err := o.QueryTable(Session{}).Filter("session_id", sessionID).Update(orm.Params{
+		"revoked": true,
+	})
+	return err
+}
+
+func RevokeSessionsByUser(o orm.Ormer, userUUID string) error {
+	_, err := o.QueryTable(Session{}).Filter("user_uuid", userUUID).Filter("revoked", false).Update(orm.Params{
+		"revoked": true,
+	})
+	return err
+}
+
+func DeleteSessionsByUser(o orm.Ormer, userUUID string) error {
+	_, err := o.QueryTable(Session{}).Filter("user_uuid", userUUID).Delete()
+	return err
+}
+
+// DeleteExpiredSessions deletes the sessions whose refresh and access tokens are all expired
+func DeleteExpiredSessions(o orm.Ormer, before time.Time) (int64, error) {
+	return o.QueryTable(Session{}).Filter("expires_at__lt", before).Filter("access_expires_at__lt", before).Delete()
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/astaxie/beego/orm"
+
+	"imanager/pkg/db/util"
+)
+
+// RefreshToken only saves the hash of the token, all tokens rotated from the same login share a family
+type RefreshToken struct {
+	Id        int    `json:"id" orm:"unique"`
+	TokenHash string `json:"token_hash" orm:"unique"`
+	FamilyID  string `json:"family_id" orm:"column(family_id);index"`
+	UserUUID  string `json:"user_uuid" orm:"column(user_uuid);index"`
+	// ClientID is the oauth client which the family is issued to, it's empty if it's issued by imanager itself
+	ClientID string `json:"client_id" orm:"column(client_id);null;index"`
+	// the json of the token scope which is kept by the family
+	Scope string `json:"scope" orm:"type(text);null"`
+	// OpenIDScope is the openid connect scopes granted to the oauth client, separated by space
+	OpenIDScope    string    `json:"openid_scope" orm:"column(openid_scope);null"`
+	Used           bool      `json:"used"`
+	Revoked        bool      `json:"revoked"`
+	ExpiresAt      time.Time `json:"expires_at" orm:"index"`
+	util.BaseModel `json:",inline"`
+}
+
+func CreateRefreshToken(o orm.Ormer, token RefreshToken) (RefreshToken, error) {
+	_, err := o.Insert(&token)
+	return token, err
+}
+
+func GetRefreshTokenByHash(o orm.Ormer, hash string) (RefreshToken, error) {
+	token := RefreshToken{}
+	err := o.QueryTable(RefreshToken{}).Filter("token_hash", hash).One(&token)
+	return token, err
+}
+
+// MarkRefreshTokenUsed returns false if the token was already used by others
+func MarkRefreshTokenUsed(o orm.Ormer, id int) (bool, error) {
+	num, err := o.QueryTable(RefreshToken{}).Filter("id", id).Filter("used", false).Update(orm.Params{
+		"used":             true,
+		"update_timestamp": time.Now(),
+	})
+	return num == 1, err
+}
+
+func RevokeRefreshTokenFamily(o orm.Ormer, familyID string) error {
+	_, err := o.QueryTable(RefreshToken{}).Filter("family_id", familyID).Update(orm.Params{
+		"revoked":          true,
+		"update_timestamp": time.Now(),
+	})
+	return err
+}
+
+func DeleteExpiredRefreshTokens(o orm.Ormer, before time.Time) (int64, error) {
+	return o.QueryTable(RefreshToken{}).Filter("expires_at__lt", before).Delete()
+}
+
+// RevokeRefreshTokensByClient revokes the refresh tokens issued to the client, the families are returned
+func RevokeRefreshTokensByClient(o orm.Ormer, clientID string) ([]string, error) {
+	var families orm.ParamsList
+	_, err := o.QueryTable(RefreshToken{}).Filter("client_id", clientID).Filter("revoked", false).
+		Distinct().ValuesFlat(&families, "family_id")
+	if err != nil {
+		return nil, err
+	}
+	_, err = o.QueryTable(RefreshToken{}).Filter("client_id", clientID).Filter("revoked", false).Update(orm.Params{
+		"revoked":          true,
+		"update_timestamp": time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, 0, len(families))
+	for _, v := range families {
+		if s, ok := v.(string); ok {
+			res = append(res, s)
+		}
+	}
+	return res, nil
+}
+
+func RevokeRefreshTokensByUser(o orm.Ormer, userUUID string) error {
+	_, err := o.QueryTable(RefreshToken{}).Filter("user_uuid", userUUID).Filter("revoked", false).Update(orm.Params{
+		"revoked":          true,
+		"update_timestamp": time.Now(),
+	})
+	return err
+}
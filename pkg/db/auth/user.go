@@ -2,6 +2,7 @@ package auth
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/astaxie/beego/orm"
 
@@ -14,12 +15,22 @@ type User struct {
 	UUID           string  `json:"uuid" orm:"column(uuid);unique"`
 	Name           string  `json:"name" orm:"unique"`
 	Password       string  `json:"password" orm:"type(text)"`
+	PasswordHash   string  `json:"password_hash"`
 	Role           []*Role `json:"role" orm:"rel(m2m)"`
 	TruthName      string  `json:"truthname"`
 	Email          string  `json:"email"`
 	PhoneNum       string  `json:"phonenum"`
 	Group          *Group  `json:"group" orm:"rel(fk)"`
+	Source         string  `json:"source"`
 	util.BaseModel `json:",inline"`
+
+	// PasswordChangedAt is null for the users created before it's recorded
+	PasswordChangedAt  time.Time `json:"password_changed_at" orm:"null"`
+	MustChangePassword bool      `json:"must_change_password"`
+
+	// ExternalIssuer and ExternalSubject identify the oidc user, the user name may be changed in the provider
+	ExternalIssuer  string `json:"external_issuer" orm:"null"`
+	ExternalSubject string `json:"external_subject" orm:"null;index"`
 }
 
 var (
@@ -57,6 +68,24 @@ func GetUserByName(o orm.Ormer, name string) (User, error) {
 	return user, nil
 }
 
+// GetUserByExternalID finds the oidc user by the issuer and the subject of its id token
+func GetUserByExternalID(o orm.Ormer, issuer, subject string) (User, error) {
+	user := User{}
+	err := o.QueryTable(User{}).Filter("external_issuer", issuer).Filter("external_subject", subject).One(&user)
+	if err != nil {
+		return User{}, err
+	}
+	_, err = o.LoadRelated(&user, "role")
+	if err != nil {
+		return user, err
+	}
+	_, err = o.LoadRelated(&user, "group")
+	if err != nil {
+		return user, err
+	}
+	return user, nil
+}
+
 func GetUserByUUID(o orm.Ormer, uuid string) (User, error) {
 	user := User{}
 	err := o.QueryTable(User{}).Filter("uuid", uuid).One(&user)
@@ -77,10 +106,11 @@ func GetUserByUUID(o orm.Ormer, uuid string) (User, error) {
 func UpdateUser(o orm.Ormer, user User) (User, error) {
 	var err error
 	var oldUser User
-	if user.Name != "" {
-		oldUser, err = GetUserByName(o, user.Name)
-	} else if user.UUID != "" {
+	// the uuid is preferred since the user of the name may be deleted and created again
+	if user.UUID != "" {
 		oldUser, err = GetUserByUUID(o, user.UUID)
+	} else if user.Name != "" {
+		oldUser, err = GetUserByName(o, user.Name)
 	} else {
 		return user, fmt.Errorf("find user by name or uuid failed")
 	}
@@ -201,3 +231,30 @@ func ListUsersByUserIDs(o orm.Ormer, userIDs []string, query *dataselect.DataSel
 
 	return users, num, err
 }
+
+// UpdateUserPasswordChanged records when the password is changed, and whether it should be changed at next login
+func UpdateUserPasswordChanged(o orm.Ormer, uuid string, changedAt time.Time, mustChange bool) error {
+	_, err := o.QueryTable(User{}).Filter("uuid", uuid).Update(orm.Params{
+		"password_changed_at":  changedAt,
+		"must_change_password": mustChange,
+	})
+	return err
+}
+
+// UpdateUserExternalID links the user created before the external id is recorded to its oidc subject
+func UpdateUserExternalID(o orm.Ormer, uuid, issuer, subject string) error {
+	_, err := o.QueryTable(User{}).Filter("uuid", uuid).Update(orm.Params{
+		"external_issuer":  issuer,
+		"external_subject": subject,
+	})
+	return err
+}
+
+// UpdateUserPassword sets the password columns without patching, so that the reversible password can be cleared
+func UpdateUserPassword(o orm.Ormer, uuid, passwordHash, password string) error {
+	_, err := o.QueryTable(User{}).Filter("uuid", uuid).Update(orm.Params{
+		"password_hash": passwordHash,
+		"password":      password,
+	})
+	return err
+}
@@ -12,7 +12,8 @@ import (
 	"imanager/pkg/db/auth"
 )
 
-func init() {
+// Init registers the database of the config, it's called by main after the flags are applied
+func Init() {
 	err := orm.RegisterDriver("mysql", orm.DRMySQL)
 	if err != nil {
 		panic(fmt.Sprintf("register database driver failed, err: %v", err))
@@ -29,7 +30,7 @@ func init() {
 	orm.SetMaxIdleConns("default", 30)
 	orm.DefaultTimeLoc = time.UTC
 
-	orm.RegisterModel(new(auth.User), new(auth.Role), new(auth.Group))
+	orm.RegisterModel(auth.Models()...)
 
 	err = orm.RunSyncdb("default", false, false)
 	if err != nil {
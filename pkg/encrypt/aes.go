@@ -6,6 +6,7 @@ import (
 	"encoding/hex"
 )
 
+// key is hardcoded, it's only kept to decrypt the secrets encrypted before EncryptSecret
 var key = []byte{
 	0xBA, 0x37, 0x2F, 0x02, 0xC3, 0x92, 0x1F, 0x7D,
 	0x7A, 0x3D, 0x5F, 0x06, 0x41, 0x9B, 0x3F, 0x2D,
@@ -31,11 +31,15 @@ var (
 	PubKeyFileName    = "pub_key"
 )
 
-func init() {
+// Init sets the key files in the encrypt dir of the config, it's called by main after the flags are applied
+func Init() {
 	encryptDir := config.GetConfig().String(config.EncryptDirKey)
 	MasterKeyFileName = path.Join(encryptDir, MasterKeyFileName)
 	PubKeyFileName = path.Join(encryptDir, PubKeyFileName)
-	glog.Infof("MasterKeyFile: %v, PubKeyFile: %v", MasterKeyFileName, PubKeyFileName)
+	if encryptDir != "" {
+		SigningKeyDir = encryptDir
+	}
+	glog.Infof("MasterKeyFile: %v, PubKeyFile: %v, SigningKeyDir: %v", MasterKeyFileName, PubKeyFileName, SigningKeyDir)
 }
 
 func encryptWithAttributeBased(text string, role string) (string, error) {
@@ -0,0 +1,144 @@
+package encrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// the secrets kept in db, such as the totp secrets, are encrypted by aes-256-gcm with the keys in the encrypt dir,
+// one file per key named secret_<kid>.key which contains 32 random bytes in base64. The key of the largest kid
+// encrypts new secrets, the others only decrypt. If there is no key file, the key derived from master_key encrypts
+const (
+	SecretKeyFilePrefix = "secret_"
+	SecretKeyFileSuffix = ".key"
+
+	// the kid of the key derived from master_key
+	masterSecretKeyID = "master_key"
+	// the prefix of the encrypted secret, the secret without it is encrypted by the legacy aes key
+	secretVersion = "v1"
+	secretKeySize = 32
+)
+
+var ErrNoSecretKey = errors.New("no secret key is available")
+
+type secretKey struct {
+	id  string
+	key []byte
+}
+
+// loadSecretKeys reads the secret keys every time, so the rotated keys take effect without restart,
+// the last one of the result is the active key
+func loadSecretKeys() ([]secretKey, error) {
+	files, err := ioutil.ReadDir(SigningKeyDir)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]secretKey, 0)
+	for _, f := range files {
+		if f.IsDir() || !strings.HasPrefix(f.Name(), SecretKeyFilePrefix) || !strings.HasSuffix(f.Name(), SecretKeyFileSuffix) {
+			continue
+		}
+		id := strings.TrimSuffix(strings.TrimPrefix(f.Name(), SecretKeyFilePrefix), SecretKeyFileSuffix)
+		if len(id) == 0 || strings.Contains(id, ":") {
+			continue
+		}
+		data, err := ioutil.ReadFile(path.Join(SigningKeyDir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) != secretKeySize {
+			glog.Errorf("secret key file[%v] should be %v bytes in base64, ignore it, err: %v", f.Name(), secretKeySize, err)
+			continue
+		}
+		res = append(res, secretKey{id: id, key: key})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].id < res[j].id
+	})
+	// the key derived from master_key is always the first, so the secrets encrypted by it
+	// can still be decrypted after the key files are added
+	if data, err := ioutil.ReadFile(MasterKeyFileName); err == nil && len(data) != 0 {
+		sum := sha256.Sum256(append([]byte("imanager secret key:"), data...))
+		res = append([]secretKey{{id: masterSecretKeyID, key: sum[:]}}, res...)
+	}
+	if len(res) == 0 {
+		return nil, ErrNoSecretKey
+	}
+	return res, nil
+}
+
+// EncryptSecret encrypts the text by the active secret key, the result is in the format of v1:<kid>:<base64>
+func EncryptSecret(text string) (string, error) {
+	keys, err := loadSecretKeys()
+	if err != nil {
+		return "", err
+	}
+	active := keys[len(keys)-1]
+	aead, err := newSecretAEAD(active.key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(text), []byte(active.id))
+	return strings.Join([]string{secretVersion, active.id, base64.RawURLEncoding.EncodeToString(sealed)}, ":"), nil
+}
+
+// DecryptSecret decrypts the secret encrypted by EncryptSecret or the legacy aes key, outdated is true
+// if it isn't encrypted by the active key, the caller should encrypt it again
+func DecryptSecret(encrypted string) (text string, outdated bool, err error) {
+	strs := strings.SplitN(encrypted, ":", 3)
+	if len(strs) != 3 || strs[0] != secretVersion {
+		text, err = aesDecrypt(encrypted, key)
+		return text, true, err
+	}
+	keys, err := loadSecretKeys()
+	if err != nil {
+		return "", false, err
+	}
+	id, data := strs[1], strs[2]
+	for _, v := range keys {
+		if v.id != id {
+			continue
+		}
+		sealed, err := base64.RawURLEncoding.DecodeString(data)
+		if err != nil {
+			return "", false, err
+		}
+		aead, err := newSecretAEAD(v.key)
+		if err != nil {
+			return "", false, err
+		}
+		if len(sealed) < aead.NonceSize() {
+			return "", false, errors.New("encrypted secret is too short")
+		}
+		plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(id))
+		if err != nil {
+			return "", false, err
+		}
+		return string(plain), id != keys[len(keys)-1].id, nil
+	}
+	return "", false, fmt.Errorf("secret key %q isn't found", id)
+}
+
+func newSecretAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
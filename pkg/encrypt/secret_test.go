@@ -0,0 +1,118 @@
+package encrypt
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func writeSecretKey(t *testing.T, dir, id string) {
+	b := make([]byte, secretKeySize)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatalf("generate secret key failed, err: %v", err)
+	}
+	name := path.Join(dir, SecretKeyFilePrefix+id+SecretKeyFileSuffix)
+	if err := ioutil.WriteFile(name, []byte(base64.StdEncoding.EncodeToString(b)+"\n"), 0600); err != nil {
+		t.Fatalf("write secret key failed, err: %v", err)
+	}
+}
+
+func useTestEncryptDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "secret")
+	if err != nil {
+		t.Fatalf("create temp dir failed, err: %v", err)
+	}
+	oldDir, oldMasterKey := SigningKeyDir, MasterKeyFileName
+	SigningKeyDir, MasterKeyFileName = dir, path.Join(dir, "master_key")
+	t.Cleanup(func() {
+		SigningKeyDir, MasterKeyFileName = oldDir, oldMasterKey
+		_ = os.RemoveAll(dir)
+	})
+	return dir
+}
+
+func TestEncryptSecretWithRotatedKeys(t *testing.T) {
+	dir := useTestEncryptDir(t)
+	if _, err := EncryptSecret("s1"); err != ErrNoSecretKey {
+		t.Logf("secret shouldn't be encrypted without key, err: %v", err)
+		t.Fail()
+	}
+
+	writeSecretKey(t, dir, "1")
+	oldSecret, err := EncryptSecret("s1")
+	if err != nil || !strings.HasPrefix(oldSecret, "v1:1:") {
+		t.Fatalf("secret should be encrypted by key 1: %v, err: %v", oldSecret, err)
+	}
+	if other, _ := EncryptSecret("s1"); other == oldSecret {
+		t.Logf("the nonce should be random")
+		t.Fail()
+	}
+	text, outdated, err := DecryptSecret(oldSecret)
+	if err != nil || text != "s1" || outdated {
+		t.Logf("decrypt secret failed, text: %v, outdated: %v, err: %v", text, outdated, err)
+		t.Fail()
+	}
+
+	// the new key encrypts, the old one only decrypts
+	writeSecretKey(t, dir, "2")
+	newSecret, err := EncryptSecret("s1")
+	if err != nil || !strings.HasPrefix(newSecret, "v1:2:") {
+		t.Logf("secret should be encrypted by key 2: %v, err: %v", newSecret, err)
+		t.Fail()
+	}
+	text, outdated, err = DecryptSecret(oldSecret)
+	if err != nil || text != "s1" || !outdated {
+		t.Logf("secret of the old key should be outdated, text: %v, outdated: %v, err: %v", text, outdated, err)
+		t.Fail()
+	}
+
+	_ = os.Remove(path.Join(dir, SecretKeyFilePrefix+"1"+SecretKeyFileSuffix))
+	if _, _, err = DecryptSecret(oldSecret); err == nil {
+		t.Logf("secret of the removed key shouldn't be decrypted")
+		t.Fail()
+	}
+	tampered := newSecret[:len(newSecret)-2] + "AA"
+	if tampered != newSecret {
+		if _, _, err = DecryptSecret(tampered); err == nil {
+			t.Logf("tampered secret shouldn't be decrypted")
+			t.Fail()
+		}
+	}
+}
+
+func TestDecryptLegacySecret(t *testing.T) {
+	dir := useTestEncryptDir(t)
+	if err := ioutil.WriteFile(path.Join(dir, "master_key"), []byte("master"), 0600); err != nil {
+		t.Fatalf("write master key failed, err: %v", err)
+	}
+	legacy, err := aesEncrypt("s1", key)
+	if err != nil {
+		t.Fatalf("encrypt legacy secret failed, err: %v", err)
+	}
+	text, outdated, err := DecryptSecret(legacy)
+	if err != nil || text != "s1" || !outdated {
+		t.Logf("legacy secret should be outdated, text: %v, outdated: %v, err: %v", text, outdated, err)
+		t.Fail()
+	}
+
+	// the key is derived from master_key without the key files
+	secret, err := EncryptSecret("s1")
+	if err != nil || !strings.HasPrefix(secret, "v1:"+masterSecretKeyID+":") {
+		t.Fatalf("secret should be encrypted by master_key: %v, err: %v", secret, err)
+	}
+	if text, outdated, err = DecryptSecret(secret); err != nil || text != "s1" || outdated {
+		t.Logf("decrypt secret failed, text: %v, outdated: %v, err: %v", text, outdated, err)
+		t.Fail()
+	}
+
+	// the key of master_key only decrypts after the key file is added
+	writeSecretKey(t, dir, "1")
+	if text, outdated, err = DecryptSecret(secret); err != nil || text != "s1" || !outdated {
+		t.Logf("secret of master_key should be outdated, text: %v, outdated: %v, err: %v", text, outdated, err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,135 @@
+package encrypt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/golang/glog"
+)
+
+// token signing keys are saved in the encrypt dir beside master_key and pub_key,
+// one pem file per key named jwt_<kid>.pem, so they can be mounted from the same secret
+const (
+	SigningKeyFilePrefix = "jwt_"
+	SigningKeyFileSuffix = ".pem"
+)
+
+const minRSAKeyBits = 2048
+
+var SigningKeyDir = "."
+
+// SigningKey is a key which is used to sign or verify the token,
+// PrivateKey is nil when only the public key is left for verification
+type SigningKey struct {
+	ID         string
+	Method     jwt.SigningMethod
+	PrivateKey crypto.PrivateKey
+	PublicKey  crypto.PublicKey
+}
+
+func (k *SigningKey) CanSign() bool {
+	return k.PrivateKey != nil
+}
+
+// LoadSigningKeys reads all signing keys in SigningKeyDir, the keys are sorted by id
+func LoadSigningKeys() ([]SigningKey, error) {
+	files, err := ioutil.ReadDir(SigningKeyDir)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]SigningKey, 0)
+	for _, f := range files {
+		if f.IsDir() || !strings.HasPrefix(f.Name(), SigningKeyFilePrefix) || !strings.HasSuffix(f.Name(), SigningKeyFileSuffix) {
+			continue
+		}
+		id := strings.TrimSuffix(strings.TrimPrefix(f.Name(), SigningKeyFilePrefix), SigningKeyFileSuffix)
+		if len(id) == 0 {
+			continue
+		}
+		data, err := ioutil.ReadFile(path.Join(SigningKeyDir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		key, err := parseSigningKey(id, data)
+		if err != nil {
+			glog.Errorf("parse signing key file[%v] failed, ignore it, err: %v", f.Name(), err)
+			continue
+		}
+		res = append(res, key)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID < res[j].ID
+	})
+	return res, nil
+}
+
+func parseSigningKey(id string, data []byte) (SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return SigningKey{}, fmt.Errorf("no pem data is found")
+	}
+
+	var privateKey, publicKey interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		privateKey, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		publicKey, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		publicKey, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return SigningKey{}, fmt.Errorf("unsupported pem type %v", block.Type)
+	}
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	key := SigningKey{ID: id}
+	switch k := privateKey.(type) {
+	case *rsa.PrivateKey:
+		key.PrivateKey, key.PublicKey = k, &k.PublicKey
+	case *ecdsa.PrivateKey:
+		key.PrivateKey, key.PublicKey = k, &k.PublicKey
+	case nil:
+		key.PublicKey = publicKey
+	default:
+		return SigningKey{}, fmt.Errorf("unsupported private key type %T", privateKey)
+	}
+
+	switch k := key.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < minRSAKeyBits {
+			return SigningKey{}, fmt.Errorf("rsa key should be at least %v bits", minRSAKeyBits)
+		}
+		key.Method = jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			key.Method = jwt.SigningMethodES256
+		case elliptic.P384():
+			key.Method = jwt.SigningMethodES384
+		case elliptic.P521():
+			key.Method = jwt.SigningMethodES512
+		default:
+			return SigningKey{}, fmt.Errorf("unsupported ecdsa curve %v", k.Curve.Params().Name)
+		}
+	default:
+		return SigningKey{}, fmt.Errorf("unsupported public key type %T", key.PublicKey)
+	}
+	return key, nil
+}
@@ -0,0 +1,103 @@
+package encrypt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func rsaKeyPem(t *testing.T, bits int) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatalf("generate rsa key failed, err: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+func ecKeyPem(t *testing.T, curve elliptic.Curve) []byte {
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatalf("generate ecdsa key failed, err: %v", err)
+	}
+	data, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal ecdsa key failed, err: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: data})
+}
+
+func TestParseSigningKey(t *testing.T) {
+	cases := []struct {
+		data   []byte
+		method jwt.SigningMethod
+	}{
+		{rsaKeyPem(t, 2048), jwt.SigningMethodRS256},
+		{ecKeyPem(t, elliptic.P256()), jwt.SigningMethodES256},
+		{ecKeyPem(t, elliptic.P384()), jwt.SigningMethodES384},
+	}
+	for _, c := range cases {
+		key, err := parseSigningKey("k1", c.data)
+		if err != nil || key.Method != c.method || !key.CanSign() {
+			t.Logf("parse signing key failed, method: %v, expect: %v, err: %v", key.Method, c.method, err)
+			t.Fail()
+		}
+	}
+
+	// the public key only verifies the tokens
+	rsaKey, _ := parseSigningKey("k1", cases[0].data)
+	publicData, _ := x509.MarshalPKIXPublicKey(rsaKey.PublicKey)
+	key, err := parseSigningKey("k1", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicData}))
+	if err != nil || key.CanSign() || key.Method != jwt.SigningMethodRS256 {
+		t.Logf("public key should only verify, err: %v", err)
+		t.Fail()
+	}
+
+	if _, err = parseSigningKey("k1", rsaKeyPem(t, 1024)); err == nil {
+		t.Logf("short rsa key should be rejected")
+		t.Fail()
+	}
+	if _, err = parseSigningKey("k1", []byte("not a pem")); err == nil {
+		t.Logf("invalid pem should be rejected")
+		t.Fail()
+	}
+}
+
+func TestLoadSigningKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signing")
+	if err != nil {
+		t.Fatalf("create temp dir failed, err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string][]byte{
+		"jwt_2.pem":  ecKeyPem(t, elliptic.P256()),
+		"jwt_1.pem":  rsaKeyPem(t, 2048),
+		"jwt_3.pem":  []byte("broken"),
+		"master_key": []byte("not a signing key"),
+	}
+	for name, data := range files {
+		if err = ioutil.WriteFile(path.Join(dir, name), data, 0600); err != nil {
+			t.Fatalf("write %v failed, err: %v", name, err)
+		}
+	}
+
+	oldDir := SigningKeyDir
+	SigningKeyDir = dir
+	defer func() { SigningKeyDir = oldDir }()
+	keys, err := LoadSigningKeys()
+	if err != nil {
+		t.Fatalf("load signing keys failed, err: %v", err)
+	}
+	if len(keys) != 2 || keys[0].ID != "1" || keys[1].ID != "2" {
+		t.Logf("keys should be the valid ones sorted by id: %+v", keys)
+		t.Fail()
+	}
+}
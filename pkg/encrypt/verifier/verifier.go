@@ -0,0 +1,139 @@
+// Package verifier hashes the passwords with one-way algorithms, the hashes are encoded in the
+// modular crypt format so that the algorithm and parameters are kept with the hash
+package verifier
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	Argon2idAlgorithm = "argon2id"
+	BcryptAlgorithm   = "bcrypt"
+
+	DefaultAlgorithm = Argon2idAlgorithm
+)
+
+// the argon2id parameters recommended by owasp, memory is in KiB
+const (
+	argon2Memory  = 19 * 1024
+	argon2Time    = 2
+	argon2Threads = 1
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+
+	bcryptCost = 12
+)
+
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+var b64 = base64.RawStdEncoding
+
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+// Hash returns the encoded hash of the password
+func Hash(password, algorithm string) (string, error) {
+	switch algorithm {
+	case Argon2idAlgorithm:
+		salt := make([]byte, argon2SaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argon2Memory, argon2Time,
+			argon2Threads, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+	case BcryptAlgorithm:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	}
+	return "", fmt.Errorf("unknown password hash algorithm %q", algorithm)
+}
+
+// Verify checks the password against the encoded hash
+func Verify(password, encoded string) (bool, error) {
+	switch algorithmOf(encoded) {
+	case Argon2idAlgorithm:
+		params, salt, key, err := decodeArgon2id(encoded)
+		if err != nil {
+			return false, err
+		}
+		other := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, other) == 1, nil
+	case BcryptAlgorithm:
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	return false, ErrUnknownHash
+}
+
+// NeedsRehash is true if the hash isn't made by the algorithm with the current parameters
+func NeedsRehash(encoded, algorithm string) bool {
+	if algorithmOf(encoded) != algorithm {
+		return true
+	}
+	switch algorithm {
+	case Argon2idAlgorithm:
+		params, _, key, err := decodeArgon2id(encoded)
+		return err != nil || params.memory != argon2Memory || params.time != argon2Time ||
+			params.threads != argon2Threads || len(key) != argon2KeyLen
+	case BcryptAlgorithm:
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return err != nil || cost != bcryptCost
+	}
+	return true
+}
+
+func algorithmOf(encoded string) string {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return Argon2idAlgorithm
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		return BcryptAlgorithm
+	}
+	return ""
+}
+
+func decodeArgon2id(encoded string) (argon2Params, []byte, []byte, error) {
+	params := argon2Params{}
+	// $argon2id$v=19$m=19456,t=2,p=1$salt$key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, ErrUnknownHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2 parameters %q", parts[3])
+	}
+	salt, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	key, err := b64.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	if len(key) == 0 {
+		return params, nil, nil, ErrUnknownHash
+	}
+	return params, salt, key, nil
+}
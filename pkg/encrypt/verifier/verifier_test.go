@@ -0,0 +1,51 @@
+package verifier
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHashAndVerify(t *testing.T) {
+	for _, algorithm := range []string{Argon2idAlgorithm, BcryptAlgorithm} {
+		hash, err := Hash("Passw0rd", algorithm)
+		if err != nil {
+			t.Logf("hash with %v failed, %v", algorithm, err)
+			t.Fail()
+			continue
+		}
+		if ok, err := Verify("Passw0rd", hash); !ok || err != nil {
+			t.Logf("verify the right password with %v failed, ok: %v, err: %v", algorithm, ok, err)
+			t.Fail()
+		}
+		if ok, err := Verify("passw0rd", hash); ok || err != nil {
+			t.Logf("verify the wrong password with %v should be false, ok: %v, err: %v", algorithm, ok, err)
+			t.Fail()
+		}
+		if NeedsRehash(hash, algorithm) {
+			t.Logf("hash %v should not need rehash with %v", hash, algorithm)
+			t.Fail()
+		}
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	hash, _ := Hash("Passw0rd", BcryptAlgorithm)
+	if !NeedsRehash(hash, Argon2idAlgorithm) {
+		t.Logf("bcrypt hash should be rehashed with argon2id")
+		t.Fail()
+	}
+	weak := "$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHRzYWx0$" + strings.Repeat("A", 43)
+	if !NeedsRehash(weak, Argon2idAlgorithm) {
+		t.Logf("argon2id hash with other parameters should be rehashed")
+		t.Fail()
+	}
+}
+
+func TestVerifyUnknownHash(t *testing.T) {
+	for _, encoded := range []string{"", "plaintext", "$argon2id$v=19$m=1,t=1$x$y", "$argon2i$v=19$m=1,t=1,p=1$x$y"} {
+		if ok, err := Verify("plaintext", encoded); ok || err == nil {
+			t.Logf("verify %q should fail, ok: %v, err: %v", encoded, ok, err)
+			t.Fail()
+		}
+	}
+}
@@ -24,29 +24,72 @@ func logFilter(h http.Handler) http.Handler {
 	})
 }
 
-func authFilter(h http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.RequestURI == authapi.GetTokenURL && r.Method == authapi.GetTokenMethod {
-			glog.Infof("it should not verify the request which is for create token")
-			h.ServeHTTP(w, r)
-			return
+// publicRequests are the requests which should not verify the token, url is a regexp of the request path
+var publicRequests = []struct {
+	url    string
+	method string
+	desc   string
+}{
+	{url: "^" + authapi.GetTokenURL + "$", method: authapi.GetTokenMethod, desc: "create token"},
+	{url: authapi.InitUserURL, method: authapi.InitUserMethod, desc: "init user"},
+	{url: "^" + authapi.JWKSURL + "$", method: authapi.JWKSMethod, desc: "get jwks"},
+	{url: "^" + authapi.OIDCLoginURL + "$", method: authapi.OIDCLoginMethod, desc: "oidc login"},
+	{url: "^" + authapi.OIDCCallbackURL + "$", method: authapi.OIDCCallbackMethod, desc: "oidc callback"},
+	{url: "^" + authapi.OIDCDiscoveryURL + "$", method: authapi.OIDCDiscoveryMethod, desc: "get oidc discovery"},
+	{url: "^" + authapi.OAuthAuthorizeURL + "$", method: http.MethodGet, desc: "oauth authorize"},
+	{url: "^" + authapi.OAuthAuthorizeURL + "$", method: http.MethodPost, desc: "oauth login"},
+	// the client and the bearer token are authenticated by the controller
+	{url: "^" + authapi.OAuthTokenURL + "$", method: authapi.OAuthTokenMethod, desc: "oauth token"},
+	{url: "^" + authapi.OAuthUserInfoURL + "$", method: http.MethodGet, desc: "oidc userinfo"},
+	{url: "^" + authapi.OAuthUserInfoURL + "$", method: http.MethodPost, desc: "oidc userinfo"},
+	{url: "^" + authapi.OAuthDeviceAuthorizationURL + "$", method: authapi.OAuthDeviceAuthorizationMethod, desc: "device authorization"},
+	{url: "^" + authapi.OAuthDeviceVerificationURL + "$", method: http.MethodGet, desc: "device verification"},
+	{url: "^" + authapi.OAuthDeviceVerificationURL + "$", method: http.MethodPost, desc: "device login"},
+	// the user who forgets the password is authenticated by the emailed link
+	{url: "^" + authapi.ForgotPasswordURL + "$", method: authapi.ForgotPasswordMethod, desc: "forgot password"},
+	{url: "^" + authapi.ResetPasswordURL + "$", method: http.MethodGet, desc: "reset password page"},
+	{url: "^" + authapi.ResetPasswordURL + "$", method: http.MethodPost, desc: "reset password"},
+	// the caller is authenticated by the controller
+	{url: "^" + authapi.IntrospectTokenURL + "$", method: authapi.IntrospectTokenMethod, desc: "introspect token"},
+	{url: "^" + authapi.TokenReviewURL + "$", method: authapi.TokenReviewMethod, desc: "kubernetes token review"},
+	{url: "^" + authapi.SubjectAccessReviewURL + "$", method: authapi.SubjectAccessReviewMethod, desc: "kubernetes subject access review"},
+	// the token of the original request is checked by the controller
+	{url: "^" + authapi.ExtAuthzURL + "$", method: authapi.ExtAuthzMethod, desc: "external authorization"},
+}
+
+func isPublicRequest(r *http.Request) bool {
+	for _, v := range publicRequests {
+		if r.Method != v.method {
+			continue
 		}
+		match, _ := regexp.MatchString(v.url, r.URL.Path)
+		if match {
+			glog.Infof("it should not verify the request which is for %v", v.desc)
+			return true
+		}
+	}
+	return false
+}
 
-		match, _ := regexp.MatchString(authapi.InitUserURL, r.RequestURI)
-		if match && r.Method == authapi.InitUserMethod {
-			glog.Infof("it should not verify the request which is for init user")
+func authFilter(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isPublicRequest(r) {
 			h.ServeHTTP(w, r)
 			return
 		}
 
 		tokenStr := r.Header.Get(authapi.TokenHeaderKey)
 
-		info, err := authsvc.ParseToken(tokenStr)
+		info, err := authsvc.ValidateToken(tokenStr)
 		if err != nil {
 			glog.Errorf("parse token failed, err: %v", err)
 			util.ReturnErrorResponseInResponseWriter(w, http.StatusBadRequest, fmt.Sprintf("parse token failed, %v", err))
 			return
 		}
+		if info.Act != nil {
+			glog.Warningf("impersonated request: %v/%v acts as %v/%v, token: %v, uri: %v, method: %v",
+				info.Act.Name, info.Act.Subject, info.Name, info.UserID, info.TokenID, r.RequestURI, r.Method)
+		}
 		data, _ := json.Marshal(info)
 		r.Header.Set(authapi.ParseInfo, string(data))
 		h.ServeHTTP(w, r)
@@ -0,0 +1,259 @@
+// Package ldapauth authenticates users by binding to an ldap or active directory server,
+// and maps their ldap groups to the groups and roles of imanager
+package ldapauth
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	DefaultUserFilter         = "(uid=%s)"
+	DefaultGroupAttribute     = "memberOf"
+	DefaultTruthNameAttribute = "cn"
+	DefaultEmailAttribute     = "mail"
+	DefaultPhoneAttribute     = "telephoneNumber"
+	DefaultGroupFilter        = "(member=%s)"
+	DefaultTimeout            = 10 * time.Second
+)
+
+var (
+	ErrInvalidCredentials = errors.New("ldap user name or password is invalid")
+	ErrNoGroupMapping     = errors.New("none of the ldap groups of the user is mapped")
+)
+
+// GroupMapping maps the members of the ldap group to the imanager group and roles
+type GroupMapping struct {
+	// LDAPGroup is the dn of the ldap group
+	LDAPGroup string   `json:"ldap_group"`
+	Group     string   `json:"group,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+}
+
+type Config struct {
+	// URL is in the format of ldap://host:389 or ldaps://host:636
+	URL                string
+	StartTLS           bool
+	InsecureSkipVerify bool
+	Timeout            time.Duration
+
+	// BindDN and BindPassword are the service account which searches the users,
+	// the search is anonymous if BindDN is empty
+	BindDN       string
+	BindPassword string
+
+	UserSearchBase string
+	// UserFilter has a %s which is replaced by the escaped user name
+	UserFilter string
+
+	// the groups of the user are searched in GroupSearchBase by GroupFilter if it's set,
+	// otherwise they are read from GroupAttribute of the user entry
+	GroupAttribute  string
+	GroupSearchBase string
+	// GroupFilter has a %s which is replaced by the escaped dn of the user
+	GroupFilter string
+
+	TruthNameAttribute string
+	EmailAttribute     string
+	PhoneAttribute     string
+
+	GroupMapping []GroupMapping
+}
+
+// Entry is the user found in ldap
+type Entry struct {
+	DN        string
+	Name      string
+	TruthName string
+	Email     string
+	PhoneNum  string
+	// Groups are the dns of the ldap groups of the user
+	Groups []string
+}
+
+func orDefault(value, defaultValue string) string {
+	if len(value) == 0 {
+		return defaultValue
+	}
+	return value
+}
+
+func (c *Config) dial() (*ldap.Conn, error) {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+	conn, err := ldap.DialURL(c.URL, ldap.DialWithDialer(&net.Dialer{Timeout: timeout}), ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(timeout)
+	if c.StartTLS {
+		if u, err := url.Parse(c.URL); err == nil {
+			tlsConfig.ServerName = u.Hostname()
+		}
+		if err = conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// Authenticate finds the user by the service account, and binds as the user to check the password
+func (c *Config) Authenticate(name, password string) (*Entry, error) {
+	// an empty password is an unauthenticated bind, which succeeds on most servers
+	if len(name) == 0 || len(password) == 0 {
+		return nil, ErrInvalidCredentials
+	}
+	conn, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("connect to ldap failed, %v", err)
+	}
+	defer conn.Close()
+
+	user, err := c.searchUser(conn, name)
+	if err != nil {
+		return nil, err
+	}
+	err = conn.Bind(user.DN, password)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bind ldap user failed, %v", err)
+	}
+	// rebind so that the groups are searched with the permission of the service account
+	return c.newEntry(conn, name, user, true)
+}
+
+// Lookup finds the user and its groups by the service account without the password, so that the user
+// logged in before can be checked again. ErrInvalidCredentials is returned if the user isn't found
+func (c *Config) Lookup(name string) (*Entry, error) {
+	if len(name) == 0 {
+		return nil, ErrInvalidCredentials
+	}
+	conn, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("connect to ldap failed, %v", err)
+	}
+	defer conn.Close()
+
+	user, err := c.searchUser(conn, name)
+	if err != nil {
+		return nil, err
+	}
+	return c.newEntry(conn, name, user, false)
+}
+
+func (c *Config) bindServiceAccount(conn *ldap.Conn) error {
+	if len(c.BindDN) == 0 {
+		return nil
+	}
+	if err := conn.Bind(c.BindDN, c.BindPassword); err != nil {
+		return fmt.Errorf("bind ldap service account failed, %v", err)
+	}
+	return nil
+}
+
+func (c *Config) searchUser(conn *ldap.Conn, name string) (*ldap.Entry, error) {
+	if err := c.bindServiceAccount(conn); err != nil {
+		return nil, err
+	}
+	attributes := []string{
+		orDefault(c.GroupAttribute, DefaultGroupAttribute),
+		orDefault(c.TruthNameAttribute, DefaultTruthNameAttribute),
+		orDefault(c.EmailAttribute, DefaultEmailAttribute),
+		orDefault(c.PhoneAttribute, DefaultPhoneAttribute),
+	}
+	filter := fmt.Sprintf(orDefault(c.UserFilter, DefaultUserFilter), ldap.EscapeFilter(name))
+	res, err := conn.Search(ldap.NewSearchRequest(c.UserSearchBase, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, 0, false, filter, attributes, nil))
+	if err != nil {
+		return nil, fmt.Errorf("search ldap user failed, %v", err)
+	}
+	if len(res.Entries) != 1 {
+		// the user isn't found or isn't unique
+		return nil, ErrInvalidCredentials
+	}
+	return res.Entries[0], nil
+}
+
+// newEntry reads the attributes and the groups of the user, rebind is true if the connection is bound as the user
+func (c *Config) newEntry(conn *ldap.Conn, name string, user *ldap.Entry, rebind bool) (*Entry, error) {
+	entry := &Entry{
+		DN:        user.DN,
+		Name:      name,
+		TruthName: user.GetEqualFoldAttributeValue(orDefault(c.TruthNameAttribute, DefaultTruthNameAttribute)),
+		Email:     user.GetEqualFoldAttributeValue(orDefault(c.EmailAttribute, DefaultEmailAttribute)),
+		PhoneNum:  user.GetEqualFoldAttributeValue(orDefault(c.PhoneAttribute, DefaultPhoneAttribute)),
+	}
+	if len(c.GroupSearchBase) == 0 {
+		entry.Groups = user.GetEqualFoldAttributeValues(orDefault(c.GroupAttribute, DefaultGroupAttribute))
+		return entry, nil
+	}
+
+	if rebind {
+		if err := c.bindServiceAccount(conn); err != nil {
+			return nil, err
+		}
+	}
+	filter := fmt.Sprintf(orDefault(c.GroupFilter, DefaultGroupFilter), ldap.EscapeFilter(user.DN))
+	res, err := conn.Search(ldap.NewSearchRequest(c.GroupSearchBase, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		0, 0, false, filter, []string{"cn"}, nil))
+	if err != nil {
+		return nil, fmt.Errorf("search ldap groups failed, %v", err)
+	}
+	for _, v := range res.Entries {
+		entry.Groups = append(entry.Groups, v.DN)
+	}
+	return entry, nil
+}
+
+func equalDN(a, b string) bool {
+	dnA, errA := ldap.ParseDN(a)
+	dnB, errB := ldap.ParseDN(b)
+	if errA != nil || errB != nil {
+		return strings.EqualFold(a, b)
+	}
+	return dnA.EqualFold(dnB)
+}
+
+// MapGroups returns the group of the first matched mapping in config order,
+// and the roles of all the matched mappings. ErrNoGroupMapping is returned if nothing is matched
+func (c *Config) MapGroups(groups []string) (string, []string, error) {
+	group := ""
+	roles := make([]string, 0)
+	seen := make(map[string]bool)
+	matched := false
+	for _, mapping := range c.GroupMapping {
+		for _, v := range groups {
+			if !equalDN(mapping.LDAPGroup, v) {
+				continue
+			}
+			matched = true
+			if len(group) == 0 {
+				group = mapping.Group
+			}
+			for _, role := range mapping.Roles {
+				if !seen[role] {
+					seen[role] = true
+					roles = append(roles, role)
+				}
+			}
+			break
+		}
+	}
+	if !matched {
+		return "", nil, ErrNoGroupMapping
+	}
+	return group, roles, nil
+}
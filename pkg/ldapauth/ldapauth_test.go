@@ -0,0 +1,263 @@
+package ldapauth
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	testBindDN       = "cn=admin,dc=example,dc=com"
+	testBindPassword = "admin-secret"
+	testDevGroup     = "cn=dev,ou=groups,dc=example,dc=com"
+	testOpsGroup     = "cn=ops,ou=groups,dc=example,dc=com"
+)
+
+type testEntry struct {
+	dn       string
+	password string
+	attrs    map[string][]string
+}
+
+var testEntries = []testEntry{
+	{dn: testBindDN, password: testBindPassword},
+	{
+		dn:       "uid=alice,ou=people,dc=example,dc=com",
+		password: "alice-secret",
+		attrs: map[string][]string{
+			"uid":             {"alice"},
+			"cn":              {"Alice"},
+			"mail":            {"alice@example.com"},
+			"telephoneNumber": {"13800000000"},
+			"memberOf":        {testDevGroup, testOpsGroup},
+		},
+	},
+	{
+		dn:       "uid=bob,ou=people,dc=example,dc=com",
+		password: "bob-secret",
+		attrs: map[string][]string{
+			"uid": {"bob"},
+			"cn":  {"Bob"},
+		},
+	},
+	{dn: testDevGroup, attrs: map[string][]string{"cn": {"dev"}, "member": {"uid=bob,ou=people,dc=example,dc=com"}}},
+	{dn: testOpsGroup, attrs: map[string][]string{"cn": {"ops"}, "member": {"uid=alice,ou=people,dc=example,dc=com"}}},
+}
+
+// startTestServer serves the bind and the equality search of testEntries, it returns the url of the server
+func startTestServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed, %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestConn(conn)
+		}
+	}()
+	return "ldap://" + listener.Addr().String()
+}
+
+func serveTestConn(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := op.Children[1].Value.(string)
+			password := op.Children[2].Data.String()
+			code := uint16(ldap.LDAPResultInvalidCredentials)
+			for _, v := range testEntries {
+				if v.dn == dn && len(v.password) != 0 && v.password == password {
+					code = ldap.LDAPResultSuccess
+				}
+			}
+			writeTestResult(conn, id, ldap.ApplicationBindResponse, code)
+		case ldap.ApplicationSearchRequest:
+			base := strings.ToLower(op.Children[0].Value.(string))
+			filter, err := ldap.DecompileFilter(op.Children[6])
+			if err != nil {
+				writeTestResult(conn, id, ldap.ApplicationSearchResultDone, ldap.LDAPResultProtocolError)
+				continue
+			}
+			attr, value := splitTestFilter(filter)
+			for _, v := range testEntries {
+				if !strings.HasSuffix(strings.ToLower(v.dn), base) || !hasTestValue(v.attrs[attr], value) {
+					continue
+				}
+				writeTestEntry(conn, id, v)
+			}
+			writeTestResult(conn, id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess)
+		case ldap.ApplicationUnbindRequest:
+			return
+		default:
+			return
+		}
+	}
+}
+
+// splitTestFilter supports the equality filter only, such as (uid=alice)
+func splitTestFilter(filter string) (string, string) {
+	strs := strings.SplitN(strings.TrimSuffix(strings.TrimPrefix(filter, "("), ")"), "=", 2)
+	if len(strs) != 2 {
+		return "", ""
+	}
+	return strs[0], strs[1]
+}
+
+func hasTestValue(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeTestResponse wraps the op in the envelope, the op should be complete since the length is encoded on append
+func writeTestResponse(conn net.Conn, id int64, op *ber.Packet) {
+	envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "Message ID"))
+	envelope.AppendChild(op)
+	_, _ = conn.Write(envelope.Bytes())
+}
+
+func writeTestResult(conn net.Conn, id int64, tag ber.Tag, code uint16) {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	writeTestResponse(conn, id, op)
+}
+
+func writeTestEntry(conn net.Conn, id int64, entry testEntry) {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, "Object Name"))
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, values := range entry.attrs {
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, v := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "Value"))
+		}
+		attr.AppendChild(set)
+		attrs.AppendChild(attr)
+	}
+	op.AppendChild(attrs)
+	writeTestResponse(conn, id, op)
+}
+
+func newTestConfig(url string) *Config {
+	return &Config{
+		URL:            url,
+		BindDN:         testBindDN,
+		BindPassword:   testBindPassword,
+		UserSearchBase: "ou=people,dc=example,dc=com",
+		GroupMapping: []GroupMapping{
+			{LDAPGroup: testDevGroup, Group: "dev", Roles: []string{"user"}},
+			{LDAPGroup: testOpsGroup, Group: "ops", Roles: []string{"admin", "user"}},
+		},
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	config := newTestConfig(startTestServer(t))
+	entry, err := config.Authenticate("alice", "alice-secret")
+	if err != nil {
+		t.Fatalf("authenticate alice failed, %v", err)
+	}
+	if entry.DN != "uid=alice,ou=people,dc=example,dc=com" || entry.TruthName != "Alice" ||
+		entry.Email != "alice@example.com" || entry.PhoneNum != "13800000000" || len(entry.Groups) != 2 {
+		t.Logf("unexpected entry of alice: %+v", entry)
+		t.Fail()
+	}
+
+	cases := []struct {
+		name     string
+		password string
+	}{
+		{"alice", "wrong"},
+		{"alice", ""},
+		{"nobody", "alice-secret"},
+		{"*", "alice-secret"},
+	}
+	for _, c := range cases {
+		if _, err = config.Authenticate(c.name, c.password); err != ErrInvalidCredentials {
+			t.Logf("authenticate %q with %q should be invalid, err: %v", c.name, c.password, err)
+			t.Fail()
+		}
+	}
+
+	config.BindPassword = "wrong"
+	if _, err = config.Authenticate("alice", "alice-secret"); err == nil || err == ErrInvalidCredentials {
+		t.Logf("the failure of service account should not be invalid credentials, err: %v", err)
+		t.Fail()
+	}
+}
+
+func TestAuthenticateWithGroupSearch(t *testing.T) {
+	config := newTestConfig(startTestServer(t))
+	config.GroupSearchBase = "ou=groups,dc=example,dc=com"
+	entry, err := config.Authenticate("bob", "bob-secret")
+	if err != nil {
+		t.Fatalf("authenticate bob failed, %v", err)
+	}
+	if len(entry.Groups) != 1 || entry.Groups[0] != testDevGroup {
+		t.Logf("unexpected groups of bob: %v", entry.Groups)
+		t.Fail()
+	}
+	group, roles, err := config.MapGroups(entry.Groups)
+	if err != nil || group != "dev" || len(roles) != 1 || roles[0] != "user" {
+		t.Logf("unexpected mapping of bob, group: %v, roles: %v, err: %v", group, roles, err)
+		t.Fail()
+	}
+}
+
+func TestMapGroups(t *testing.T) {
+	config := newTestConfig("")
+	group, roles, err := config.MapGroups([]string{"CN=ops, OU=groups, DC=example, DC=com", testDevGroup})
+	if err != nil || group != "dev" || strings.Join(roles, ",") != "user,admin" {
+		t.Logf("unexpected mapping, group: %v, roles: %v, err: %v", group, roles, err)
+		t.Fail()
+	}
+	if _, _, err = config.MapGroups([]string{"cn=qa,ou=groups,dc=example,dc=com"}); err != ErrNoGroupMapping {
+		t.Logf("unmapped groups should return ErrNoGroupMapping, err: %v", err)
+		t.Fail()
+	}
+}
+
+func TestLookup(t *testing.T) {
+	config := newTestConfig(startTestServer(t))
+	entry, err := config.Lookup("alice")
+	if err != nil {
+		t.Fatalf("lookup alice failed, %v", err)
+	}
+	if entry.DN != "uid=alice,ou=people,dc=example,dc=com" || len(entry.Groups) != 2 {
+		t.Logf("unexpected entry of alice: %+v", entry)
+		t.Fail()
+	}
+
+	config.GroupSearchBase = "ou=groups,dc=example,dc=com"
+	if entry, err = config.Lookup("bob"); err != nil || len(entry.Groups) != 1 || entry.Groups[0] != testDevGroup {
+		t.Logf("unexpected entry of bob: %+v, err: %v", entry, err)
+		t.Fail()
+	}
+	if _, err = config.Lookup("nobody"); err != ErrInvalidCredentials {
+		t.Logf("lookup of unknown user should be invalid, err: %v", err)
+		t.Fail()
+	}
+}
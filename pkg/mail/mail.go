@@ -0,0 +1,130 @@
+// Package mail sends the notification mails of imanager, such as the password reset links,
+// by smtp or into local files for development and tests
+package mail
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var ErrNoRecipient = errors.New("mail has no recipient")
+
+type Message struct {
+	To      []string
+	Subject string
+	// Body is plain text
+	Body string
+}
+
+// Bytes returns the message in rfc5322 format
+func (m *Message) Bytes(from string, date time.Time) []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "From: %s\r\n", from)
+	fmt.Fprintf(buf, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(strings.ReplaceAll(m.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return buf.Bytes()
+}
+
+// Sender delivers the message, the implementations are safe for concurrent use
+type Sender interface {
+	Send(msg *Message) error
+}
+
+// SMTPSender sends by the smtp server, STARTTLS is used if the server supports it,
+// and the server is connected by tls directly if the port is 465
+type SMTPSender struct {
+	// Address is host:port of the smtp server
+	Address  string
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTPSender) Send(msg *Message) error {
+	if len(msg.To) == 0 {
+		return ErrNoRecipient
+	}
+	host, port, err := net.SplitHostPort(s.Address)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if len(s.Username) != 0 {
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	data := msg.Bytes(s.From, time.Now())
+	if port != "465" {
+		return smtp.SendMail(s.Address, auth, s.From, msg.To, data)
+	}
+
+	conn, err := tls.Dial("tcp", s.Address, &tls.Config{ServerName: host})
+	if err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer c.Close()
+	if auth != nil {
+		if err = c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err = c.Mail(s.From); err != nil {
+		return err
+	}
+	for _, v := range msg.To {
+		if err = c.Rcpt(v); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(data); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// FileSender writes each message into a new file of the directory instead of sending it
+type FileSender struct {
+	Dir  string
+	From string
+	seq  uint64
+}
+
+func (s *FileSender) Send(msg *Message) error {
+	if len(msg.To) == 0 {
+		return ErrNoRecipient
+	}
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return err
+	}
+	now := time.Now()
+	name := fmt.Sprintf("%d-%d.eml", now.UnixNano(), atomic.AddUint64(&s.seq, 1))
+	return ioutil.WriteFile(filepath.Join(s.Dir, name), msg.Bytes(s.From, now), 0600)
+}
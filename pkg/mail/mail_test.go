@@ -0,0 +1,63 @@
+package mail
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessageBytes(t *testing.T) {
+	msg := &Message{
+		To:      []string{"a@example.com", "b@example.com"},
+		Subject: "重置密码",
+		Body:    "line1\nline2",
+	}
+	data := string(msg.Bytes("imanager@example.com", time.Unix(0, 0).UTC()))
+	for _, v := range []string{
+		"From: imanager@example.com\r\n",
+		"To: a@example.com, b@example.com\r\n",
+		"Subject: =?utf-8?q?",
+		"Date: Thu, 01 Jan 1970 00:00:00 +0000\r\n",
+		"\r\n\r\nline1\r\nline2",
+	} {
+		if !strings.Contains(data, v) {
+			t.Logf("message doesn't contain %q: %q", v, data)
+			t.Fail()
+		}
+	}
+}
+
+func TestFileSender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sender := &FileSender{Dir: filepath.Join(dir, "out"), From: "imanager@example.com"}
+	if err = sender.Send(&Message{Subject: "no recipient"}); err != ErrNoRecipient {
+		t.Logf("message without recipient should be rejected, err: %v", err)
+		t.Fail()
+	}
+	for i := 0; i < 2; i++ {
+		if err = sender.Send(&Message{To: []string{"a@example.com"}, Subject: "hello", Body: "world"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := ioutil.ReadDir(sender.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Logf("expect 2 mails, got %v", len(files))
+		t.Fail()
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(sender.Dir, files[0].Name()))
+	if !strings.Contains(string(data), "Subject: hello\r\n") || !strings.HasSuffix(string(data), "\r\n\r\nworld") {
+		t.Logf("unexpected mail: %q", data)
+		t.Fail()
+	}
+}
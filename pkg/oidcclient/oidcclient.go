@@ -0,0 +1,437 @@
+// Package oidcclient is the relying party of the openid connect authorization code flow with pkce,
+// it verifies the id token by the jwks of the provider
+package oidcclient
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	DefaultUsernameClaim = "preferred_username"
+	DefaultEmailClaim    = "email"
+	DefaultNameClaim     = "name"
+	DefaultGroupsClaim   = "groups"
+
+	defaultTimeout = 10 * time.Second
+	// the jwks isn't fetched again in the interval when a token of an unknown kid comes
+	jwksRefreshInterval = time.Minute
+)
+
+var (
+	ErrInvalidIDToken = errors.New("id token is invalid")
+	ErrNoGroupMapping = errors.New("none of the groups of the user is mapped")
+
+	supportedAlgs = map[string]bool{
+		"RS256": true, "RS384": true, "RS512": true,
+		"ES256": true, "ES384": true, "ES512": true,
+		"PS256": true, "PS384": true, "PS512": true,
+	}
+)
+
+// GroupMapping maps the members of the group in the groups claim to the imanager group and roles
+type GroupMapping struct {
+	ClaimGroup string   `json:"claim_group"`
+	Group      string   `json:"group,omitempty"`
+	Roles      []string `json:"roles,omitempty"`
+}
+
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback of imanager registered in the provider
+	RedirectURL string
+	// Scopes are requested in the authorization, openid is always requested
+	Scopes []string
+
+	UsernameClaim string
+	EmailClaim    string
+	NameClaim     string
+	GroupsClaim   string
+	GroupMapping  []GroupMapping
+
+	HTTPClient *http.Client
+}
+
+// Discovery is the part of the provider metadata which is used, see openid connect discovery 1.0
+type Discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Identity is the user asserted by the verified id token
+type Identity struct {
+	Issuer   string
+	Subject  string
+	Username string
+	Email    string
+	Name     string
+	Groups   []string
+	// ACR and AMR are how the user was authenticated by the provider, see rfc 8176 for the amr values
+	ACR string
+	AMR []string
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type Provider struct {
+	config    Config
+	discovery Discovery
+	client    *http.Client
+
+	sync.Mutex
+	keys        map[string]interface{}
+	keysFetched time.Time
+}
+
+// NewProvider fetches the discovery document of the issuer
+func NewProvider(config Config) (*Provider, error) {
+	p := &Provider{config: config, client: config.HTTPClient}
+	if p.client == nil {
+		p.client = &http.Client{Timeout: defaultTimeout}
+	}
+	wellKnown := strings.TrimSuffix(config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(wellKnown, &p.discovery); err != nil {
+		return nil, fmt.Errorf("get discovery of %v failed, %v", config.Issuer, err)
+	}
+	if p.discovery.Issuer != config.Issuer {
+		return nil, fmt.Errorf("issuer %v in discovery doesn't match %v", p.discovery.Issuer, config.Issuer)
+	}
+	if len(p.discovery.AuthorizationEndpoint) == 0 || len(p.discovery.TokenEndpoint) == 0 || len(p.discovery.JWKSURI) == 0 {
+		return nil, fmt.Errorf("discovery of %v misses the endpoints", config.Issuer)
+	}
+	return p, nil
+}
+
+func (p *Provider) getJSON(u string, out interface{}) error {
+	resp, err := p.client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %v, body: %s", resp.StatusCode, body)
+	}
+	return json.Unmarshal(body, out)
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// NewRandom returns a random string for state and nonce
+func NewRandom() (string, error) {
+	return randomString(32)
+}
+
+// NewPKCE returns the code verifier and its S256 code challenge, see rfc7636
+func NewPKCE() (string, string, error) {
+	verifier, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	return verifier, CodeChallenge(verifier), nil
+}
+
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL is where the user agent is redirected to login
+func (p *Provider) AuthCodeURL(state, nonce, codeChallenge string) string {
+	scopes := []string{"openid"}
+	for _, v := range p.config.Scopes {
+		if v != "openid" {
+			scopes = append(scopes, v)
+		}
+	}
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.discovery.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.discovery.AuthorizationEndpoint + sep + query.Encode()
+}
+
+// Exchange redeems the code at the token endpoint, it returns the raw id token
+func (p *Provider) Exchange(code, codeVerifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequest(http.MethodPost, p.discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("exchange code failed, status: %v, body: %s", resp.StatusCode, body)
+	}
+	token := struct {
+		IDToken string `json:"id_token"`
+	}{}
+	if err = json.Unmarshal(body, &token); err != nil {
+		return "", err
+	}
+	if len(token.IDToken) == 0 {
+		return "", errors.New("no id token in the token response")
+	}
+	return token.IDToken, nil
+}
+
+func (p *Provider) key(kid string) (interface{}, error) {
+	p.Lock()
+	defer p.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	// the keys may be rotated, but don't let the unknown kids flood the provider
+	if time.Since(p.keysFetched) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	p.keysFetched = time.Now()
+	jwks := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := p.getJSON(p.discovery.JWKSURI, &jwks); err != nil {
+		return nil, fmt.Errorf("get jwks failed, %v", err)
+	}
+	keys := make(map[string]interface{}, len(jwks.Keys))
+	for _, v := range jwks.Keys {
+		key, err := v.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[v.Kid] = key
+	}
+	p.keys = keys
+	key, ok := p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return key, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %v", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %v", k.Kty)
+}
+
+func (p *Provider) keyFunc(token *jwt.Token) (interface{}, error) {
+	if !supportedAlgs[token.Method.Alg()] {
+		return nil, fmt.Errorf("unsupported signing method %v", token.Method.Alg())
+	}
+	kid, _ := token.Header["kid"].(string)
+	return p.key(kid)
+}
+
+func stringClaim(claims jwt.MapClaims, name string) string {
+	v, _ := claims[name].(string)
+	return v
+}
+
+// stringsClaim returns the claim of a string array, a single string is an array of one
+func stringsClaim(claims jwt.MapClaims, name string) []string {
+	var res []string
+	switch values := claims[name].(type) {
+	case []interface{}:
+		for _, v := range values {
+			if s, ok := v.(string); ok {
+				res = append(res, s)
+			}
+		}
+	case string:
+		res = []string{values}
+	}
+	return res
+}
+
+// VerifyIDToken checks the signature, issuer, audience, expiry and nonce of the id token
+func (p *Provider) VerifyIDToken(rawIDToken, nonce string) (*Identity, error) {
+	token, err := jwt.Parse(rawIDToken, p.keyFunc)
+	if err != nil {
+		return nil, fmt.Errorf("%v, %v", ErrInvalidIDToken, err)
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, ErrInvalidIDToken
+	}
+	if !claims.VerifyIssuer(p.config.Issuer, true) {
+		return nil, fmt.Errorf("%v, unexpected issuer %v", ErrInvalidIDToken, claims["iss"])
+	}
+	// jwt-go before v4 doesn't verify the audience in array
+	if !hasAudience(claims["aud"], p.config.ClientID) {
+		return nil, fmt.Errorf("%v, unexpected audience %v", ErrInvalidIDToken, claims["aud"])
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, fmt.Errorf("%v, no expiry", ErrInvalidIDToken)
+	}
+	if stringClaim(claims, "nonce") != nonce {
+		return nil, fmt.Errorf("%v, nonce doesn't match", ErrInvalidIDToken)
+	}
+
+	res := &Identity{
+		Issuer:   stringClaim(claims, "iss"),
+		Subject:  stringClaim(claims, "sub"),
+		Username: stringClaim(claims, orDefault(p.config.UsernameClaim, DefaultUsernameClaim)),
+		Email:    stringClaim(claims, orDefault(p.config.EmailClaim, DefaultEmailClaim)),
+		Name:     stringClaim(claims, orDefault(p.config.NameClaim, DefaultNameClaim)),
+		Groups:   stringsClaim(claims, orDefault(p.config.GroupsClaim, DefaultGroupsClaim)),
+		ACR:      stringClaim(claims, "acr"),
+		AMR:      stringsClaim(claims, "amr"),
+	}
+	if len(res.Subject) == 0 {
+		return nil, fmt.Errorf("%v, no subject", ErrInvalidIDToken)
+	}
+	return res, nil
+}
+
+func hasAudience(aud interface{}, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func orDefault(value, defaultValue string) string {
+	if len(value) == 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// MapGroups returns the group of the first matched mapping in config order,
+// and the roles of all the matched mappings. ErrNoGroupMapping is returned if nothing is matched
+func (c *Config) MapGroups(groups []string) (string, []string, error) {
+	group := ""
+	roles := make([]string, 0)
+	seen := make(map[string]bool)
+	matched := false
+	for _, mapping := range c.GroupMapping {
+		for _, v := range groups {
+			if mapping.ClaimGroup != v {
+				continue
+			}
+			matched = true
+			if len(group) == 0 {
+				group = mapping.Group
+			}
+			for _, role := range mapping.Roles {
+				if !seen[role] {
+					seen[role] = true
+					roles = append(roles, role)
+				}
+			}
+			break
+		}
+	}
+	if !matched {
+		return "", nil, ErrNoGroupMapping
+	}
+	return group, roles, nil
+}
@@ -0,0 +1,223 @@
+package oidcclient
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	testClientID     = "imanager"
+	testClientSecret = "client-secret"
+	testRedirectURL  = "https://imanager.example.com/v1/auth/oidc/callback"
+	testKeyID        = "test-key"
+	testCode         = "test-code"
+)
+
+type testProvider struct {
+	server        *httptest.Server
+	key           *rsa.PrivateKey
+	codeChallenge string
+	idToken       string
+}
+
+func startTestProvider(t *testing.T) *testProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key failed, %v", err)
+	}
+	p := &testProvider{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(Discovery{
+			Issuer:                p.server.URL,
+			AuthorizationEndpoint: p.server.URL + "/authorize",
+			TokenEndpoint:         p.server.URL + "/token",
+			JWKSURI:               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []jsonWebKey{{
+			Kty: "RSA",
+			Kid: testKeyID,
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != testClientID || secret != testClientSecret || r.FormValue("grant_type") != "authorization_code" ||
+			r.FormValue("code") != testCode || r.FormValue("redirect_uri") != testRedirectURL ||
+			CodeChallenge(r.FormValue("code_verifier")) != p.codeChallenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "token_type": "Bearer", "id_token": p.idToken})
+	})
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *testProvider) sign(t *testing.T, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = testKeyID
+	res, err := token.SignedString(p.key)
+	if err != nil {
+		t.Fatalf("sign id token failed, %v", err)
+	}
+	return res
+}
+
+func (p *testProvider) claims(nonce string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":                p.server.URL,
+		"sub":                "248289761001",
+		"aud":                []string{testClientID, "other"},
+		"exp":                time.Now().Add(time.Minute).Unix(),
+		"iat":                time.Now().Unix(),
+		"nonce":              nonce,
+		"preferred_username": "alice",
+		"email":              "alice@example.com",
+		"name":               "Alice",
+		"groups":             []string{"dev", "ops"},
+		"amr":                []string{"pwd", "otp", "mfa"},
+	}
+}
+
+func newTestProvider(t *testing.T, p *testProvider) *Provider {
+	provider, err := NewProvider(Config{
+		Issuer:       p.server.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+		Scopes:       []string{"profile", "email"},
+	})
+	if err != nil {
+		t.Fatalf("new provider failed, %v", err)
+	}
+	return provider
+}
+
+func TestLogin(t *testing.T) {
+	p := startTestProvider(t)
+	provider := newTestProvider(t, p)
+
+	verifier, challenge, err := NewPKCE()
+	if err != nil {
+		t.Fatalf("new pkce failed, %v", err)
+	}
+	p.codeChallenge = challenge
+	authURL, err := url.Parse(provider.AuthCodeURL("state", "nonce", challenge))
+	if err != nil {
+		t.Fatalf("parse auth url failed, %v", err)
+	}
+	query := authURL.Query()
+	if authURL.Path != "/authorize" || query.Get("scope") != "openid profile email" || query.Get("state") != "state" ||
+		query.Get("nonce") != "nonce" || query.Get("code_challenge") != challenge ||
+		query.Get("code_challenge_method") != "S256" || query.Get("redirect_uri") != testRedirectURL {
+		t.Logf("unexpected auth url %v", authURL)
+		t.Fail()
+	}
+
+	p.idToken = p.sign(t, p.claims("nonce"))
+	if _, err = provider.Exchange(testCode, "wrong-verifier"); err == nil {
+		t.Logf("exchange with the wrong verifier should fail")
+		t.Fail()
+	}
+	rawIDToken, err := provider.Exchange(testCode, verifier)
+	if err != nil {
+		t.Fatalf("exchange code failed, %v", err)
+	}
+	identity, err := provider.VerifyIDToken(rawIDToken, "nonce")
+	if err != nil {
+		t.Fatalf("verify id token failed, %v", err)
+	}
+	if identity.Issuer != p.server.URL || identity.Subject != "248289761001" || identity.Username != "alice" ||
+		identity.Email != "alice@example.com" || identity.Name != "Alice" || strings.Join(identity.Groups, ",") != "dev,ops" ||
+		strings.Join(identity.AMR, ",") != "pwd,otp,mfa" {
+		t.Logf("unexpected identity %+v", identity)
+		t.Fail()
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	p := startTestProvider(t)
+	provider := newTestProvider(t, p)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key failed, %v", err)
+	}
+	cases := []struct {
+		desc   string
+		modify func(jwt.MapClaims)
+		token  func(jwt.MapClaims) string
+	}{
+		{desc: "wrong nonce", modify: func(c jwt.MapClaims) { c["nonce"] = "other" }},
+		{desc: "wrong issuer", modify: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{desc: "wrong audience", modify: func(c jwt.MapClaims) { c["aud"] = "other" }},
+		{desc: "expired", modify: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }},
+		{desc: "no expiry", modify: func(c jwt.MapClaims) { delete(c, "exp") }},
+		{desc: "no subject", modify: func(c jwt.MapClaims) { delete(c, "sub") }},
+		{desc: "unsigned", token: func(c jwt.MapClaims) string {
+			res, _ := jwt.NewWithClaims(jwt.SigningMethodNone, c).SignedString(jwt.UnsafeAllowNoneSignatureType)
+			return res
+		}},
+		{desc: "hmac with the public key", token: func(c jwt.MapClaims) string {
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, c)
+			token.Header["kid"] = testKeyID
+			res, _ := token.SignedString(p.key.N.Bytes())
+			return res
+		}},
+		{desc: "other key", token: func(c jwt.MapClaims) string {
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, c)
+			token.Header["kid"] = testKeyID
+			res, _ := token.SignedString(otherKey)
+			return res
+		}},
+	}
+	for _, c := range cases {
+		claims := p.claims("nonce")
+		if c.modify != nil {
+			c.modify(claims)
+		}
+		rawIDToken := ""
+		if c.token != nil {
+			rawIDToken = c.token(claims)
+		} else {
+			rawIDToken = p.sign(t, claims)
+		}
+		if _, err := provider.VerifyIDToken(rawIDToken, "nonce"); err == nil {
+			t.Logf("id token of %v should be invalid", c.desc)
+			t.Fail()
+		}
+	}
+}
+
+func TestMapGroups(t *testing.T) {
+	config := &Config{GroupMapping: []GroupMapping{
+		{ClaimGroup: "dev", Group: "dev", Roles: []string{"user"}},
+		{ClaimGroup: "ops", Group: "ops", Roles: []string{"admin", "user"}},
+	}}
+	group, roles, err := config.MapGroups([]string{"ops", "dev"})
+	if err != nil || group != "dev" || strings.Join(roles, ",") != "user,admin" {
+		t.Logf("unexpected mapping, group: %v, roles: %v, err: %v", group, roles, err)
+		t.Fail()
+	}
+	if _, _, err = config.MapGroups([]string{"Dev"}); err != ErrNoGroupMapping {
+		t.Logf("unmapped groups should return ErrNoGroupMapping, err: %v", err)
+		t.Fail()
+	}
+}
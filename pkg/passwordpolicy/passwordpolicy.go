@@ -0,0 +1,179 @@
+// Package passwordpolicy checks the passwords by the configurable rules, and reports all the failing rules at once
+package passwordpolicy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	authapi "imanager/pkg/api/auth"
+)
+
+// DefaultSymbols are all the printable ascii symbols and the space, so that the passphrases are allowed
+const DefaultSymbols = " !\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+
+// the minimum length of the user name which is checked in the password
+const minUsernameLength = 3
+
+// commonPasswords are always in the dictionary
+var commonPasswords = []string{
+	"password", "passw0rd", "p@ssw0rd", "p@ssword", "qwerty", "qwertyuiop", "asdfghjkl", "zxcvbnm", "abc123",
+	"abcdef", "abcd1234", "iloveyou", "letmein", "welcome", "admin", "administrator", "root", "changeme",
+	"monkey", "dragon", "master", "sunshine", "princess", "football", "baseball", "superman", "trustno1",
+	"harbor", "imanager",
+}
+
+type Policy struct {
+	MinLength     int  `json:"min_length"`
+	MaxLength     int  `json:"max_length"`
+	RequireUpper  bool `json:"require_upper"`
+	RequireLower  bool `json:"require_lower"`
+	RequireDigit  bool `json:"require_digit"`
+	RequireSymbol bool `json:"require_symbol"`
+	// Symbols are the allowed characters other than the ascii letters and digits
+	Symbols string `json:"symbols"`
+	// Dictionary are the forbidden words in lower case, the common passwords are always forbidden
+	Dictionary map[string]bool `json:"-"`
+	// RejectUsername rejects the password containing the user name or its reverse
+	RejectUsername bool `json:"reject_username"`
+	// HistorySize is the number of the previous passwords which can't be reused, it's checked by the caller
+	HistorySize int `json:"history_size"`
+}
+
+// Default is compatible with the password rules of harbor
+func Default() *Policy {
+	return &Policy{
+		MinLength:      8,
+		MaxLength:      128,
+		RequireUpper:   true,
+		RequireLower:   true,
+		RequireDigit:   true,
+		Symbols:        DefaultSymbols,
+		RejectUsername: true,
+		HistorySize:    5,
+	}
+}
+
+// Error lists all the failing rules of the password
+type Error struct {
+	Violations []authapi.PasswordViolation
+}
+
+func (e *Error) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		messages = append(messages, v.Message)
+	}
+	return "password violates the policy: " + strings.Join(messages, "; ")
+}
+
+func (e *Error) Add(rule, message string) {
+	e.Violations = append(e.Violations, authapi.PasswordViolation{Rule: rule, Message: message})
+}
+
+// LoadDictionary reads the words line by line, the empty lines and the lines starting with # are skipped
+func LoadDictionary(r io.Reader, dictionary map[string]bool) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		word := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if len(word) != 0 && !strings.HasPrefix(word, "#") {
+			dictionary[word] = true
+		}
+	}
+	return scanner.Err()
+}
+
+func (p *Policy) inDictionary(password string) bool {
+	lower := strings.ToLower(password)
+	// the words decorated by the digits and symbols, such as Password123!
+	trimmed := strings.TrimFunc(lower, func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	for _, v := range commonPasswords {
+		if lower == v || trimmed == v {
+			return true
+		}
+	}
+	return p.Dictionary[lower] || p.Dictionary[trimmed]
+}
+
+func reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+// Check returns *Error listing all the failing rules except the history, or nil if the password is allowed
+func (p *Policy) Check(password, username string) error {
+	res := &Error{}
+	length := len([]rune(password))
+	if p.MinLength > 0 && length < p.MinLength {
+		res.Add(authapi.PasswordMinLengthRule, fmt.Sprintf("password should have at least %v characters", p.MinLength))
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		res.Add(authapi.PasswordMaxLengthRule, fmt.Sprintf("password should have at most %v characters", p.MaxLength))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	invalid := make([]string, 0)
+	seen := make(map[rune]bool)
+	for _, r := range password {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			hasUpper = true
+		case r >= 'a' && r <= 'z':
+			hasLower = true
+		case r >= '0' && r <= '9':
+			hasDigit = true
+		case strings.ContainsRune(p.Symbols, r):
+			hasSymbol = true
+		default:
+			if !seen[r] {
+				seen[r] = true
+				invalid = append(invalid, fmt.Sprintf("%q", r))
+			}
+		}
+	}
+	if p.RequireUpper && !hasUpper {
+		res.Add(authapi.PasswordUpperRule, "password should have an uppercase letter")
+	}
+	if p.RequireLower && !hasLower {
+		res.Add(authapi.PasswordLowerRule, "password should have a lowercase letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		res.Add(authapi.PasswordDigitRule, "password should have a digit")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		res.Add(authapi.PasswordSymbolRule, fmt.Sprintf("password should have a symbol of %q", p.Symbols))
+	}
+	if len(invalid) != 0 {
+		res.Add(authapi.PasswordCharacterRule, fmt.Sprintf("password has characters which aren't allowed: %v",
+			strings.Join(invalid, " ")))
+	}
+
+	if p.inDictionary(password) {
+		res.Add(authapi.PasswordDictionaryRule, "password is a common word or password")
+	}
+	if p.RejectUsername && len(username) >= minUsernameLength {
+		lower, name := strings.ToLower(password), strings.ToLower(username)
+		if strings.Contains(lower, name) || strings.Contains(lower, reverse(name)) {
+			res.Add(authapi.PasswordUsernameRule, "password shouldn't contain the user name")
+		}
+	}
+
+	if len(res.Violations) == 0 {
+		return nil
+	}
+	return res
+}
+
+// HistoryError is the error of the password which is one of the previous passwords
+func (p *Policy) HistoryError() error {
+	res := &Error{}
+	res.Add(authapi.PasswordHistoryRule, fmt.Sprintf("password should be different from the last %v passwords", p.HistorySize))
+	return res
+}
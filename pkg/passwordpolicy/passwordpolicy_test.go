@@ -0,0 +1,85 @@
+package passwordpolicy
+
+import (
+	"strings"
+	"testing"
+
+	authapi "imanager/pkg/api/auth"
+)
+
+func rules(err error) []string {
+	if err == nil {
+		return nil
+	}
+	res := make([]string, 0)
+	for _, v := range err.(*Error).Violations {
+		res = append(res, v.Rule)
+	}
+	return res
+}
+
+func TestCheck(t *testing.T) {
+	policy := Default()
+	policy.Dictionary = map[string]bool{"zjlab": true}
+	cases := []struct {
+		password string
+		username string
+		expect   []string
+	}{
+		{"Abcdefg1", "alice", nil},
+		{"correct Horse battery staple 9", "alice", nil},
+		{"Tr0ub4dor&3", "alice", nil},
+		{"Ab1", "alice", []string{authapi.PasswordMinLengthRule}},
+		{strings.Repeat("Ab1", 50), "alice", []string{authapi.PasswordMaxLengthRule}},
+		{"abcdefgh", "alice", []string{authapi.PasswordUpperRule, authapi.PasswordDigitRule}},
+		{"ABCDEFG1", "alice", []string{authapi.PasswordLowerRule}},
+		{"Abcdefg1中中", "alice", []string{authapi.PasswordCharacterRule}},
+		{"Password123!", "alice", []string{authapi.PasswordDictionaryRule}},
+		{"Zjlab2020", "alice", []string{authapi.PasswordDictionaryRule}},
+		{"xAlice2020", "alice", []string{authapi.PasswordUsernameRule}},
+		{"xEcila2020", "alice", []string{authapi.PasswordUsernameRule}},
+		{"p", "alice", []string{authapi.PasswordMinLengthRule, authapi.PasswordUpperRule, authapi.PasswordDigitRule}},
+	}
+	for _, c := range cases {
+		got := rules(policy.Check(c.password, c.username))
+		if strings.Join(got, ",") != strings.Join(c.expect, ",") {
+			t.Logf("password: %q, expect violations: %v, got: %v", c.password, c.expect, got)
+			t.Fail()
+		}
+	}
+
+	policy = &Policy{MinLength: 4, RequireSymbol: true, Symbols: "_-"}
+	if got := rules(policy.Check("abcd!", "")); strings.Join(got, ",") !=
+		authapi.PasswordSymbolRule+","+authapi.PasswordCharacterRule {
+		t.Logf("unexpected violations of the custom symbols: %v", got)
+		t.Fail()
+	}
+	if err := policy.Check("ab_d", ""); err != nil {
+		t.Logf("password with the allowed symbol should pass, err: %v", err)
+		t.Fail()
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	err := Default().Check("abc", "")
+	if err == nil || !strings.Contains(err.Error(), "at least 8 characters") || !strings.Contains(err.Error(), "; ") {
+		t.Logf("unexpected error: %v", err)
+		t.Fail()
+	}
+	if rules(Default().HistoryError())[0] != authapi.PasswordHistoryRule {
+		t.Logf("unexpected history error: %v", Default().HistoryError())
+		t.Fail()
+	}
+}
+
+func TestLoadDictionary(t *testing.T) {
+	dictionary := make(map[string]bool)
+	err := LoadDictionary(strings.NewReader("# comment\n\nSpring\n  summer  \n"), dictionary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dictionary) != 2 || !dictionary["spring"] || !dictionary["summer"] {
+		t.Logf("unexpected dictionary: %v", dictionary)
+		t.Fail()
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/gorilla/mux"
 
+	authapi "imanager/pkg/api/auth"
 	"imanager/pkg/controllers"
 )
 
@@ -12,6 +13,26 @@ func RegisterRouter() http.Handler {
 	r := mux.NewRouter()
 	r.HandleFunc("/v1/auth/tokens", controllers.AuthController{}.CreateTokenInHttp).Methods(http.MethodPost)
 	r.HandleFunc("/v1/auth/tokens", controllers.AuthController{}.CheckTokenInHttp).Methods(http.MethodGet)
+	r.HandleFunc("/v1/auth/tokens", controllers.AuthController{}.RevokeTokenInHttp).Methods(http.MethodDelete)
+	r.HandleFunc(authapi.IntrospectTokenURL, controllers.AuthController{}.IntrospectToken).Methods(authapi.IntrospectTokenMethod)
+	r.HandleFunc(authapi.JWKSURL, controllers.AuthController{}.GetJSONWebKeySet).Methods(authapi.JWKSMethod)
+	r.HandleFunc(authapi.OIDCLoginURL, controllers.AuthController{}.OIDCLogin).Methods(authapi.OIDCLoginMethod)
+	r.HandleFunc(authapi.OIDCCallbackURL, controllers.AuthController{}.OIDCCallback).Methods(authapi.OIDCCallbackMethod)
+	r.HandleFunc(authapi.OIDCDiscoveryURL, controllers.AuthController{}.GetOIDCDiscovery).Methods(authapi.OIDCDiscoveryMethod)
+	r.HandleFunc(authapi.OAuthAuthorizeURL, controllers.AuthController{}.Authorize).Methods(http.MethodGet, http.MethodPost)
+	r.HandleFunc(authapi.OAuthTokenURL, controllers.AuthController{}.OAuthToken).Methods(authapi.OAuthTokenMethod)
+	r.HandleFunc(authapi.OAuthUserInfoURL, controllers.AuthController{}.UserInfo).Methods(http.MethodGet, http.MethodPost)
+	r.HandleFunc(authapi.OAuthDeviceAuthorizationURL, controllers.AuthController{}.DeviceAuthorization).Methods(authapi.OAuthDeviceAuthorizationMethod)
+	r.HandleFunc(authapi.OAuthDeviceVerificationURL, controllers.AuthController{}.DeviceVerification).Methods(http.MethodGet, http.MethodPost)
+	r.HandleFunc(authapi.OAuthDeviceApprovalURL, controllers.AuthController{}.ApproveDevice).Methods(authapi.OAuthDeviceApprovalMethod)
+	r.HandleFunc(authapi.ForgotPasswordURL, controllers.AuthController{}.ForgotPassword).Methods(authapi.ForgotPasswordMethod)
+	r.HandleFunc(authapi.ResetPasswordURL, controllers.AuthController{}.ResetPassword).Methods(http.MethodGet, http.MethodPost)
+
+	r.HandleFunc(authapi.TokenReviewURL, controllers.AuthController{}.TokenReview).Methods(authapi.TokenReviewMethod)
+	r.HandleFunc(authapi.SubjectAccessReviewURL, controllers.AuthController{}.SubjectAccessReview).Methods(authapi.SubjectAccessReviewMethod)
+	r.HandleFunc(authapi.ExtAuthzURL, controllers.AuthController{}.ExtAuthz).Methods(authapi.ExtAuthzMethod)
+	r.HandleFunc(authapi.DecisionURL, controllers.AuthController{}.Decide).Methods(http.MethodPost)
+	r.HandleFunc(authapi.DecisionsURL, controllers.AuthController{}.DecideBatch).Methods(http.MethodPost)
 
 	r.HandleFunc("/v1/auth/user", controllers.AuthController{}.CreateUser).Methods(http.MethodPost)
 	r.HandleFunc("/v1/auth/user", controllers.AuthController{}.ModifyUser).Methods(http.MethodPut)
@@ -19,6 +40,18 @@ func RegisterRouter() http.Handler {
 	r.HandleFunc("/v1/auth/user", controllers.AuthController{}.ListUser).Methods(http.MethodGet)
 	r.HandleFunc("/v1/auth/user/{name}", controllers.AuthController{}.GetUser).Methods(http.MethodGet)
 	r.HandleFunc("/v1/auth/user/{name}/secret", controllers.AuthController{}.GetUserSecret).Methods(http.MethodGet)
+	r.HandleFunc("/v1/auth/user/{name}/tokens", controllers.AuthController{}.CreatePersonalAccessToken).Methods(http.MethodPost)
+	r.HandleFunc("/v1/auth/user/{name}/tokens", controllers.AuthController{}.ListPersonalAccessToken).Methods(http.MethodGet)
+	r.HandleFunc("/v1/auth/user/{name}/tokens/{id}", controllers.AuthController{}.DeletePersonalAccessToken).Methods(http.MethodDelete)
+	r.HandleFunc("/v1/auth/user/{name}/mfa", controllers.AuthController{}.EnrollMFA).Methods(http.MethodPost)
+	r.HandleFunc("/v1/auth/user/{name}/mfa", controllers.AuthController{}.VerifyMFA).Methods(http.MethodPut)
+	r.HandleFunc("/v1/auth/user/{name}/mfa", controllers.AuthController{}.GetMFA).Methods(http.MethodGet)
+	r.HandleFunc("/v1/auth/user/{name}/mfa", controllers.AuthController{}.DisableMFA).Methods(http.MethodDelete)
+	r.HandleFunc("/v1/auth/user/{name}/password", controllers.AuthController{}.ChangePassword).Methods(http.MethodPut)
+	r.HandleFunc("/v1/auth/user/{name}/sessions", controllers.AuthController{}.ListSession).Methods(http.MethodGet)
+	r.HandleFunc("/v1/auth/user/{name}/sessions", controllers.AuthController{}.DeleteAllSessions).Methods(http.MethodDelete)
+	r.HandleFunc("/v1/auth/user/{name}/sessions/{id}", controllers.AuthController{}.DeleteSession).Methods(http.MethodDelete)
+	r.HandleFunc("/v1/auth/user/{name}/token-policy", controllers.AuthController{}.GetUserTokenPolicy).Methods(http.MethodGet)
 
 	r.HandleFunc("/v1/auth/role", controllers.AuthController{}.CreateRole).Methods(http.MethodPost)
 	r.HandleFunc("/v1/auth/role", controllers.AuthController{}.ModifyRole).Methods(http.MethodPut)
@@ -26,6 +59,13 @@ func RegisterRouter() http.Handler {
 	r.HandleFunc("/v1/auth/role", controllers.AuthController{}.ListRole).Methods(http.MethodGet)
 	r.HandleFunc("/v1/auth/role/{name}", controllers.AuthController{}.GetRole).Methods(http.MethodGet)
 
+	r.HandleFunc(authapi.OAuthClientURL, controllers.AuthController{}.CreateOAuthClient).Methods(http.MethodPost)
+	r.HandleFunc(authapi.OAuthClientURL, controllers.AuthController{}.ListOAuthClient).Methods(http.MethodGet)
+	r.HandleFunc(authapi.OAuthClientURL+"/{id}", controllers.AuthController{}.GetOAuthClient).Methods(http.MethodGet)
+	r.HandleFunc(authapi.OAuthClientURL+"/{id}", controllers.AuthController{}.ModifyOAuthClient).Methods(http.MethodPut)
+	r.HandleFunc(authapi.OAuthClientURL+"/{id}", controllers.AuthController{}.DeleteOAuthClient).Methods(http.MethodDelete)
+	r.HandleFunc(authapi.OAuthClientURL+"/{id}/secret", controllers.AuthController{}.RotateOAuthClientSecret).Methods(http.MethodPut)
+
 	r.HandleFunc("/v1/auth/group", controllers.AuthController{}.CreateGroup).Methods(http.MethodPost)
 	r.HandleFunc("/v1/auth/group", controllers.AuthController{}.ModifyGroup).Methods(http.MethodPut)
 	r.HandleFunc("/v1/auth/group/{name}", controllers.AuthController{}.DeleteGroup).Methods(http.MethodDelete)
@@ -34,5 +74,6 @@ func RegisterRouter() http.Handler {
 
 	r.HandleFunc("/v1/auth/user/{name}/init", controllers.AuthController{}.InitUser).Methods(http.MethodPut)
 	r.HandleFunc("/v1/auth/user/{name}/uninit", controllers.AuthController{}.UnInitUser).Methods(http.MethodPut)
+	r.HandleFunc("/v1/auth/user/{name}/unlock", controllers.AuthController{}.UnlockUser).Methods(http.MethodPut)
 	return r
 }
\ No newline at end of file
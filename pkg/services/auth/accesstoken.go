@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/golang/glog"
+
+	authapi "imanager/pkg/api/auth"
+	apiutil "imanager/pkg/api/util"
+	"imanager/pkg/config"
+	authdb "imanager/pkg/db/auth"
+)
+
+const (
+	// days
+	personalAccessTokenDurationKey        = "PersonalAccessTokenDuration"
+	personalAccessTokenMaxDurationKey     = "PersonalAccessTokenMaxDuration"
+	defaultPersonalAccessTokenDuration    = 90
+	defaultPersonalAccessTokenMaxDuration = 366
+
+	personalAccessTokenTouchInterval = time.Minute
+	// the length of the token saved in plain text to identify it
+	personalAccessTokenShownLen = 8
+)
+
+var (
+	ErrInvalidPersonalAccessToken = errors.New("personal access token is invalid or expired")
+	ErrNotTokenOwner              = errors.New("personal access token can only be created by the user himself")
+)
+
+func getDurationInDays(key string, defaultValue int) time.Duration {
+	days, err := config.GetConfig().Int(key)
+	if err != nil || days <= 0 {
+		days = defaultValue
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, authapi.PersonalAccessTokenPrefix)
+}
+
+// CreatePersonalAccessToken creates the token for the user, callerUUID should be the user himself,
+// the others can't mint the token which lasts for months to act as the user
+func CreatePersonalAccessToken(userName, callerUUID string, pat *authapi.PersonalAccessToken) (*authapi.PersonalAccessToken, error) {
+	o := orm.NewOrm()
+	user, err := authdb.GetUserByName(o, userName)
+	if err != nil {
+		return nil, err
+	}
+	if user.UUID != callerUUID {
+		return nil, ErrNotTokenOwner
+	}
+
+	now := time.Now()
+	if pat.ExpiresAt.IsZero() {
+		pat.ExpiresAt = now.Add(getDurationInDays(personalAccessTokenDurationKey, defaultPersonalAccessTokenDuration))
+	}
+	if !pat.ExpiresAt.After(now) {
+		return nil, fmt.Errorf("expires at should be in the future")
+	}
+	if maxExpiresAt := now.Add(getDurationInDays(personalAccessTokenMaxDurationKey, defaultPersonalAccessTokenMaxDuration)); pat.ExpiresAt.After(maxExpiresAt) {
+		return nil, fmt.Errorf("expires at should be before %v", maxExpiresAt.Format(time.RFC3339))
+	}
+
+	userRoles := make(map[int]bool, len(user.Role))
+	for _, v := range user.Role {
+		userRoles[v.Id] = true
+	}
+	roleIDs := make([]string, 0, len(pat.Role))
+	for _, v := range pat.Role {
+		if !userRoles[v.ID] {
+			return nil, fmt.Errorf("role %v isn't one of the user's roles", v.ID)
+		}
+		roleIDs = append(roleIDs, strconv.Itoa(v.ID))
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	token = authapi.PersonalAccessTokenPrefix + token
+	patDB, err := authdb.CreatePersonalAccessToken(o, authdb.PersonalAccessToken{
+		Name:        pat.Name,
+		TokenHash:   hashToken(token),
+		TokenPrefix: token[:len(authapi.PersonalAccessTokenPrefix)+personalAccessTokenShownLen],
+		UserUUID:    user.UUID,
+		Roles:       strings.Join(roleIDs, ","),
+		ExpiresAt:   pat.ExpiresAt,
+	})
+	if err != nil {
+		glog.Errorf("create personal access token[%v] for user[%v/%v] failed, err: %v", pat.Name, user.Name, user.UUID, err)
+		return nil, err
+	}
+	res := transformPersonalAccessTokenDB2API(patDB, user)
+	res.Token = token
+	return &res, nil
+}
+
+func ListPersonalAccessTokens(userName string) ([]authapi.PersonalAccessToken, int64, error) {
+	o := orm.NewOrm()
+	user, err := authdb.GetUserByName(o, userName)
+	if err != nil {
+		return nil, 0, err
+	}
+	tokens, num, err := authdb.ListPersonalAccessTokensByUser(o, user.UUID)
+	if err != nil {
+		return nil, 0, err
+	}
+	res := make([]authapi.PersonalAccessToken, 0, len(tokens))
+	for _, v := range tokens {
+		res = append(res, transformPersonalAccessTokenDB2API(v, user))
+	}
+	return res, num, nil
+}
+
+func DeletePersonalAccessToken(userName string, id int) error {
+	o := orm.NewOrm()
+	user, err := authdb.GetUserByName(o, userName)
+	if err != nil {
+		return err
+	}
+	return authdb.DeletePersonalAccessToken(o, user.UUID, id)
+}
+
+// validatePersonalAccessToken returns the info of the token owner, the roles are restricted by the token
+func validatePersonalAccessToken(token string) (authapi.RespToken, error) {
+	o := orm.NewOrm()
+	pat, err := authdb.GetPersonalAccessTokenByHash(o, hashToken(token))
+	if err == orm.ErrNoRows {
+		return authapi.RespToken{}, ErrInvalidPersonalAccessToken
+	}
+	if err != nil {
+		return authapi.RespToken{}, err
+	}
+	now := time.Now()
+	if pat.ExpiresAt.Before(now) {
+		return authapi.RespToken{}, ErrInvalidPersonalAccessToken
+	}
+	user, err := authdb.GetUserByUUID(o, pat.UserUUID)
+	if err == orm.ErrNoRows {
+		return authapi.RespToken{}, ErrInvalidPersonalAccessToken
+	}
+	if err != nil {
+		return authapi.RespToken{}, err
+	}
+	if err = authdb.TouchPersonalAccessToken(o, pat.Id, now, personalAccessTokenTouchInterval); err != nil {
+		glog.Errorf("update last used time of personal access token %v failed, err: %v", pat.Id, err)
+	}
+
+	user.Password = ""
+	userAPI := transformUserDB2API(user)
+	return authapi.RespToken{
+		ExpiresAt: pat.ExpiresAt,
+		IssuedAt:  pat.CreateTimestamp,
+		UserID:    userAPI.UUID,
+		Name:      userAPI.Name,
+		TrueName:  userAPI.TruthName,
+		Group:     userAPI.Group,
+		Role:      restrictRoles(userAPI.Role, pat.Roles),
+		TokenID:   strconv.Itoa(pat.Id),
+		Issuer:    TokenIssuer(),
+		TokenType: authapi.PersonalAccessTokenType,
+	}, nil
+}
+
+// restrictRoles returns the roles in the comma separated role ids,
+// the current roles of the user are checked so that the removed roles don't work any more
+func restrictRoles(roles []authapi.RoleInUser, roleIDs string) []authapi.RoleInUser {
+	if len(roleIDs) == 0 {
+		return roles
+	}
+	allowed := make(map[string]bool)
+	for _, v := range strings.Split(roleIDs, ",") {
+		allowed[v] = true
+	}
+	res := make([]authapi.RoleInUser, 0, len(roles))
+	for _, v := range roles {
+		if allowed[strconv.Itoa(v.ID)] {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+func transformPersonalAccessTokenDB2API(in authdb.PersonalAccessToken, user authdb.User) authapi.PersonalAccessToken {
+	res := authapi.PersonalAccessToken{
+		ID:          in.Id,
+		Name:        in.Name,
+		TokenPrefix: in.TokenPrefix,
+		ExpiresAt:   in.ExpiresAt,
+		LastUsedAt:  in.LastUsedAt,
+		BaseModel: apiutil.BaseModel{
+			CreateTimestamp: in.CreateTimestamp,
+			UpdateTimestamp: in.UpdateTimestamp,
+		},
+	}
+	if len(in.Roles) != 0 {
+		userAPI := transformUserDB2API(user)
+		res.Role = restrictRoles(userAPI.Role, in.Roles)
+	}
+	return res
+}
+
+// revokePersonalAccessTokens deletes all the personal access tokens of the user, which carry the old credentials
+func revokePersonalAccessTokens(o orm.Ormer, userUUID string) error {
+	err := authdb.DeletePersonalAccessTokensByUser(o, userUUID)
+	if err != nil {
+		glog.Errorf("delete personal access tokens of user[%v] failed, err: %v", userUUID, err)
+	}
+	return err
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	authapi "imanager/pkg/api/auth"
+	authdb "imanager/pkg/db/auth"
+)
+
+func TestCreatePersonalAccessToken(t *testing.T) {
+	o := setupTestDB(t)
+	admin, user := createTestRole(t, o, "admin"), createTestRole(t, o, "user")
+	u1 := createTestUser(t, o, "u1", "g1", admin, user)
+	u2 := createTestUser(t, o, "u2", "g1", admin)
+
+	if _, err := CreatePersonalAccessToken("u1", u2.UUID, &authapi.PersonalAccessToken{Name: "t1"}); err != ErrNotTokenOwner {
+		t.Logf("the others shouldn't create personal access token for the user, err: %v", err)
+		t.Fail()
+	}
+	pat, err := CreatePersonalAccessToken("u1", u1.UUID, &authapi.PersonalAccessToken{
+		Name: "t1",
+		Role: []authapi.RoleInUser{{ID: user.Id}},
+	})
+	if err != nil {
+		t.Fatalf("create personal access token failed, err: %v", err)
+	}
+	if !IsPersonalAccessToken(pat.Token) || pat.LastUsedAt != nil {
+		t.Logf("invalid personal access token: %+v", pat)
+		t.Fail()
+	}
+
+	info, err := ValidateToken(pat.Token)
+	if err != nil {
+		t.Fatalf("validate personal access token failed, err: %v", err)
+	}
+	if info.Name != "u1" || info.TokenType != authapi.PersonalAccessTokenType || len(info.Role) != 1 || info.Role[0].ID != user.Id {
+		t.Logf("token should be restricted to the role of user: %+v", info)
+		t.Fail()
+	}
+	tokens, _, err := ListPersonalAccessTokens("u1")
+	if err != nil || len(tokens) != 1 || tokens[0].LastUsedAt == nil || len(tokens[0].Token) != 0 {
+		t.Logf("last used time should be recorded without the token: %+v, err: %v", tokens, err)
+		t.Fail()
+	}
+
+	if _, err = CreatePersonalAccessToken("u1", u1.UUID, &authapi.PersonalAccessToken{
+		Name: "t2", ExpiresAt: time.Now().Add(-time.Minute),
+	}); err == nil {
+		t.Logf("expired personal access token shouldn't be created")
+		t.Fail()
+	}
+	if _, err = CreatePersonalAccessToken("u2", u2.UUID, &authapi.PersonalAccessToken{
+		Name: "t1", Role: []authapi.RoleInUser{{ID: user.Id}},
+	}); err == nil {
+		t.Logf("personal access token shouldn't have the role which the user doesn't have")
+		t.Fail()
+	}
+}
+
+func TestRevokePersonalAccessTokensOnRoleChange(t *testing.T) {
+	o := setupTestDB(t)
+	admin, user := createTestRole(t, o, "admin"), createTestRole(t, o, "user")
+	u1 := createTestUser(t, o, "u1", "g1", admin)
+	pat, err := CreatePersonalAccessToken("u1", u1.UUID, &authapi.PersonalAccessToken{Name: "t1"})
+	if err != nil {
+		t.Fatalf("create personal access token failed, err: %v", err)
+	}
+
+	oldUser, err := authdb.GetUserByUUID(o, u1.UUID)
+	if err != nil {
+		t.Fatalf("get user failed, err: %v", err)
+	}
+	newUser := oldUser
+	newUser.Role = []*authdb.Role{user}
+	if _, err = syncExternalUser(o, oldUser, newUser); err != nil {
+		t.Fatalf("sync user failed, err: %v", err)
+	}
+	if _, err = ValidateToken(pat.Token); err != ErrInvalidPersonalAccessToken {
+		t.Logf("personal access token should be revoked when the roles are changed, err: %v", err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	_ "github.com/mattn/go-sqlite3"
+	uuid "github.com/satori/go.uuid"
+
+	authapi "imanager/pkg/api/auth"
+	authdb "imanager/pkg/db/auth"
+)
+
+var registerTestDB sync.Once
+
+// setupTestDB recreates all the tables in an in-memory sqlite, so the services can be tested without mysql
+func setupTestDB(t *testing.T) orm.Ormer {
+	registerTestDB.Do(func() {
+		orm.DefaultTimeLoc = time.UTC
+		if err := orm.RegisterDataBase("default", "sqlite3", "file::memory:?cache=shared"); err != nil {
+			t.Fatalf("register test database failed, err: %v", err)
+		}
+		orm.RegisterModel(authdb.Models()...)
+	})
+	if err := orm.RunSyncdb("default", true, false); err != nil {
+		t.Fatalf("create test tables failed, err: %v", err)
+	}
+	return orm.NewOrm()
+}
+
+// createTestUser creates a local user in the group, the group is created if it doesn't exist
+func createTestUser(t *testing.T, o orm.Ormer, name, groupName string, roles ...*authdb.Role) authdb.User {
+	group, err := authdb.GetGroupByName(o, groupName)
+	if err == orm.ErrNoRows {
+		group = authdb.Group{Name: groupName}
+		_, err = o.Insert(&group)
+	}
+	if err != nil {
+		t.Fatalf("create group %v failed, err: %v", groupName, err)
+	}
+	user, err := authdb.CreateUser(o, authdb.User{
+		UUID:   uuid.NewV4().String(),
+		Name:   name,
+		Group:  &group,
+		Role:   roles,
+		Source: authapi.LocalUserSource,
+	})
+	if err != nil {
+		t.Fatalf("create user %v failed, err: %v", name, err)
+	}
+	return user
+}
+
+func createTestRole(t *testing.T, o orm.Ormer, name string) *authdb.Role {
+	role := &authdb.Role{Name: name}
+	if _, err := o.Insert(role); err != nil {
+		t.Fatalf("create role %v failed, err: %v", name, err)
+	}
+	return role
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/astaxie/beego/orm"
+
+	authapi "imanager/pkg/api/auth"
+	authdb "imanager/pkg/db/auth"
+)
+
+// ErrInactiveExternalUser is returned if the external user is removed from the identity provider,
+// he can't login so all his requests are denied
+var ErrInactiveExternalUser = errors.New("user is removed from the identity provider or its mapped groups")
+
+// GetDecisionSubject returns the identity of the user as the token which he would get at login, so that it's
+// decided in the same way as the token of the user. ErrLoginLocked or ErrInactiveExternalUser is returned
+// if the user can't login
+func GetDecisionSubject(name string) (*authapi.RespToken, error) {
+	o := orm.NewOrm()
+	user, err := authdb.GetUserByName(o, name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = CheckLoginAllowed(user.Name, ""); err == ErrLoginLocked {
+		return nil, err
+	} else if err != nil && err != ErrLoginThrottled {
+		return nil, err
+	}
+	// the group and roles in ldap may be changed after the user is synchronized
+	if user.Source == authapi.LDAPUserSource {
+		synced, found, err := lookupLDAPUser(o, user)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, ErrInactiveExternalUser
+		}
+		user = synced
+	}
+	user.Password = ""
+	userAPI := transformUserDB2API(user)
+	res := &authapi.RespToken{
+		UserID: userAPI.UUID,
+		Name:   userAPI.Name,
+		Group:  userAPI.Group,
+		Role:   userAPI.Role,
+	}
+	// the user can only change the password if he logins now
+	required, err := IsPasswordChangeRequired(&userAPI)
+	if err != nil {
+		return nil, err
+	}
+	if required {
+		scope := authapi.PasswordChangeScope
+		scope.Actions = append([]string{}, scope.Actions...)
+		res.Scope = &scope
+	}
+	return res, nil
+}
+
+// Decide decides the requests of the subject by the rules in order: the scope of the token, the owner of the
+// resource, and the permissions of the roles. The roles are read once for all the requests
+func Decide(subject *authapi.RespToken, items []authapi.DecisionItem) ([]authapi.Decision, error) {
+	ids := make([]int, 0, len(subject.Role))
+	for _, v := range subject.Role {
+		ids = append(ids, v.ID)
+	}
+	rolesDB, err := authdb.ListRoleByIDs(orm.NewOrm(), ids)
+	if err != nil {
+		return nil, err
+	}
+	roles := transformRoleDBs2APIs(rolesDB)
+	res := make([]authapi.Decision, 0, len(items))
+	for _, v := range items {
+		res = append(res, decide(subject, roles, v))
+	}
+	return res, nil
+}
+
+func decide(subject *authapi.RespToken, roles []authapi.Role, item authapi.DecisionItem) authapi.Decision {
+	resource := item.Resource
+	action := resource.Type + ":" + item.Action
+	if err := authapi.ValidPermission(action); err != nil {
+		return authapi.Decision{Reason: err.Error()}
+	}
+	if !subject.Scope.AllowsAction(resource.Type, item.Action) {
+		return authapi.Decision{
+			Reason: fmt.Sprintf("token scope doesn't allow %v", action),
+			Rule:   &authapi.MatchedRule{Kind: authapi.TokenRule},
+		}
+	}
+	if !subject.Scope.AllowsAllGroups() && !subject.Scope.AllowsGroup(resource.Group) {
+		return authapi.Decision{
+			Reason: fmt.Sprintf("token scope is restricted to group %v", subject.Scope.Group),
+			Rule:   &authapi.MatchedRule{Kind: authapi.TokenRule},
+		}
+	}
+	if len(resource.OwnerID) != 0 && resource.OwnerID == subject.UserID {
+		return authapi.Decision{
+			Allowed: true,
+			Reason:  fmt.Sprintf("%v owns the resource", subject.Name),
+			Rule:    &authapi.MatchedRule{Kind: authapi.OwnerRule},
+		}
+	}
+	inGroup := len(resource.Group) != 0 && subject.Group != nil && subject.Group.Name == resource.Group
+	for _, role := range roles {
+		if !role.AllGroups && !inGroup {
+			continue
+		}
+		permission, ok := role.MatchPermission(resource.Type, item.Action)
+		if !ok {
+			continue
+		}
+		reason := fmt.Sprintf("role %v allows %v in all groups", role.Name, permission)
+		if !role.AllGroups {
+			reason = fmt.Sprintf("role %v allows %v in group %v", role.Name, permission, resource.Group)
+		}
+		return authapi.Decision{
+			Allowed: true,
+			Reason:  reason,
+			Rule: &authapi.MatchedRule{
+				Kind:       authapi.RoleRule,
+				Role:       role.Name,
+				Permission: permission,
+				AllGroups:  role.AllGroups,
+			},
+		}
+	}
+	return authapi.Decision{Reason: fmt.Sprintf("no role of %v allows %v on the resource", subject.Name, action)}
+}
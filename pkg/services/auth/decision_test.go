@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/astaxie/beego/orm"
+
+	authapi "imanager/pkg/api/auth"
+	authdb "imanager/pkg/db/auth"
+)
+
+func TestDecide(t *testing.T) {
+	subject := &authapi.RespToken{
+		Name:   "u1",
+		UserID: "uuid1",
+		Group:  &authapi.GroupInUser{ID: 1, Name: "g1"},
+	}
+	scoped := *subject
+	scoped.Scope = &authapi.TokenScope{Actions: []string{"user:read"}}
+	groupScoped := *subject
+	groupScoped.Scope = &authapi.TokenScope{Group: "g1"}
+	inGroup := authapi.Role{Name: "admin", Permissions: []string{"user:*"}}
+	allGroups := authapi.Role{Name: "auditor", Permissions: []string{"user:read"}, AllGroups: true}
+
+	cases := []struct {
+		name    string
+		subject *authapi.RespToken
+		roles   []authapi.Role
+		item    authapi.DecisionItem
+		allowed bool
+		rule    string
+	}{
+		{"invalid action", subject, nil, authapi.DecisionItem{Action: "update", Resource: authapi.DecisionResource{Type: "User"}}, false, ""},
+		{"scope denies action", &scoped, []authapi.Role{inGroup}, authapi.DecisionItem{Action: "update",
+			Resource: authapi.DecisionResource{Type: "user", Group: "g1"}}, false, authapi.TokenRule},
+		{"scope allows action", &scoped, []authapi.Role{inGroup}, authapi.DecisionItem{Action: "read",
+			Resource: authapi.DecisionResource{Type: "user", Group: "g1"}}, true, authapi.RoleRule},
+		{"scope restricted to other group", &groupScoped, []authapi.Role{allGroups}, authapi.DecisionItem{Action: "read",
+			Resource: authapi.DecisionResource{Type: "user", Group: "g2"}}, false, authapi.TokenRule},
+		{"scope denies owner", &scoped, nil, authapi.DecisionItem{Action: "delete",
+			Resource: authapi.DecisionResource{Type: "user", OwnerID: "uuid1"}}, false, authapi.TokenRule},
+		{"owner", subject, nil, authapi.DecisionItem{Action: "delete",
+			Resource: authapi.DecisionResource{Type: "user", Group: "g2", OwnerID: "uuid1"}}, true, authapi.OwnerRule},
+		{"owned by the user of the same name", subject, nil, authapi.DecisionItem{Action: "delete",
+			Resource: authapi.DecisionResource{Type: "user", Group: "g2", OwnerID: "u1"}}, false, ""},
+		{"owned by other", subject, nil, authapi.DecisionItem{Action: "delete",
+			Resource: authapi.DecisionResource{Type: "user", Group: "g1", OwnerID: "uuid2"}}, false, ""},
+		{"role in group", subject, []authapi.Role{inGroup}, authapi.DecisionItem{Action: "update",
+			Resource: authapi.DecisionResource{Type: "user", Group: "g1"}}, true, authapi.RoleRule},
+		{"role in other group", subject, []authapi.Role{inGroup}, authapi.DecisionItem{Action: "update",
+			Resource: authapi.DecisionResource{Type: "user", Group: "g2"}}, false, ""},
+		{"role in group without group of resource", subject, []authapi.Role{inGroup}, authapi.DecisionItem{Action: "update",
+			Resource: authapi.DecisionResource{Type: "user"}}, false, ""},
+		{"role of all groups", subject, []authapi.Role{allGroups}, authapi.DecisionItem{Action: "read",
+			Resource: authapi.DecisionResource{Type: "user", Group: "g2"}}, true, authapi.RoleRule},
+		{"role of all groups without group of resource", subject, []authapi.Role{allGroups}, authapi.DecisionItem{Action: "read",
+			Resource: authapi.DecisionResource{Type: "user"}}, true, authapi.RoleRule},
+		{"role of all groups denies other action", subject, []authapi.Role{allGroups}, authapi.DecisionItem{Action: "update",
+			Resource: authapi.DecisionResource{Type: "user", Group: "g2"}}, false, ""},
+	}
+	for _, c := range cases {
+		res := decide(c.subject, c.roles, c.item)
+		rule := ""
+		if res.Rule != nil {
+			rule = res.Rule.Kind
+		}
+		if res.Allowed != c.allowed || rule != c.rule || len(res.Reason) == 0 {
+			t.Logf("%v: allowed should be %v by rule %q, got %+v", c.name, c.allowed, c.rule, res)
+			t.Fail()
+		}
+		if c.rule == authapi.RoleRule && res.Rule.AllGroups != c.roles[0].AllGroups {
+			t.Logf("%v: rule should be of the role %+v, got %+v", c.name, c.roles[0], res.Rule)
+			t.Fail()
+		}
+	}
+}
+
+func TestGetDecisionSubject(t *testing.T) {
+	o := setupTestDB(t)
+	setTestConfig(t, ldapURLKey, "")
+	createTestUser(t, o, "u1", "g1")
+	createTestUser(t, o, "u2", "g1")
+	mustChange := createTestUser(t, o, "u3", "g1")
+	ldapUser := createTestUser(t, o, "u4", "g1")
+	if _, err := o.QueryTable(authdb.User{}).Filter("uuid", mustChange.UUID).Update(orm.Params{"must_change_password": true}); err != nil {
+		t.Fatalf("update user failed, err: %v", err)
+	}
+	if _, err := o.QueryTable(authdb.User{}).Filter("uuid", ldapUser.UUID).Update(orm.Params{"source": authapi.LDAPUserSource}); err != nil {
+		t.Fatalf("update user failed, err: %v", err)
+	}
+	for i := 0; i < defaultLoginMaxUserFailures; i++ {
+		RecordLoginFailure("u2", "")
+	}
+
+	subject, err := GetDecisionSubject("u1")
+	if err != nil || subject.Name != "u1" || subject.Group == nil || subject.Group.Name != "g1" || !subject.Scope.IsEmpty() {
+		t.Logf("subject should be the user without scope: %+v, err: %v", subject, err)
+		t.Fail()
+	}
+	if _, err = GetDecisionSubject("u2"); err != ErrLoginLocked {
+		t.Logf("locked user should be rejected, err: %v", err)
+		t.Fail()
+	}
+	subject, err = GetDecisionSubject("u3")
+	if err != nil || subject.Scope.AllowsAction(authapi.UserResource, authapi.ReadVerb) ||
+		!subject.Scope.AllowsAction(authapi.PasswordResource, authapi.UpdateVerb) {
+		t.Logf("user who must change the password should only change it: %+v, err: %v", subject, err)
+		t.Fail()
+	}
+	// ldap is disabled, the ldap user can't login
+	if _, err = GetDecisionSubject("u4"); err != ErrInactiveExternalUser {
+		t.Logf("ldap user should be rejected if ldap is disabled, err: %v", err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/golang/glog"
+
+	authapi "imanager/pkg/api/auth"
+	authdb "imanager/pkg/db/auth"
+)
+
+const (
+	deviceCodeDuration      = 10 * time.Minute
+	deviceCodeCleanInterval = time.Hour
+	// the seconds between the polls of the device, and the increment of slow_down
+	devicePollInterval = 5
+)
+
+var (
+	ErrInvalidDeviceCode    = errors.New("device code is invalid")
+	ErrInvalidUserCode      = errors.New("user code is invalid or expired")
+	ErrAuthorizationPending = errors.New("the user hasn't approved the device yet")
+	ErrSlowDown             = errors.New("the device polls too frequently")
+	ErrDeviceCodeExpired    = errors.New("device code is expired")
+	ErrDeviceAccessDenied   = errors.New("the user denied the device")
+)
+
+func init() {
+	go func() {
+		for range time.Tick(deviceCodeCleanInterval) {
+			num, err := authdb.DeleteExpiredOAuthDeviceCodes(orm.NewOrm(), time.Now())
+			if err != nil {
+				glog.Errorf("delete expired device codes failed, err: %v", err)
+				continue
+			}
+			glog.Infof("delete %v expired device codes", num)
+		}
+	}()
+}
+
+func randomUserCode() (string, error) {
+	b := make([]byte, authapi.UserCodeLength)
+	max := big.NewInt(int64(len(authapi.UserCodeCharset)))
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = authapi.UserCodeCharset[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// CreateDeviceAuthorization issues the device code and the user code to the client, the user approves it
+// on the verification page of imanager
+func CreateDeviceAuthorization(clientID, scope string) (*authapi.DeviceAuthorizationResponse, error) {
+	discovery, err := GetOIDCDiscovery()
+	if err != nil {
+		return nil, err
+	}
+	deviceCode, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	userCode, err := randomUserCode()
+	if err != nil {
+		return nil, err
+	}
+	_, err = authdb.CreateOAuthDeviceCode(orm.NewOrm(), authdb.OAuthDeviceCode{
+		DeviceCodeHash: hashToken(deviceCode),
+		UserCode:       userCode,
+		ClientID:       clientID,
+		Scope:          scope,
+		Status:         authdb.DevicePending,
+		Interval:       devicePollInterval,
+		ExpiresAt:      time.Now().Add(deviceCodeDuration),
+	})
+	if err != nil {
+		glog.Errorf("create device code for client %v failed, err: %v", clientID, err)
+		return nil, err
+	}
+	verificationURI := strings.TrimSuffix(discovery.Issuer, "/") + authapi.OAuthDeviceVerificationURL
+	return &authapi.DeviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                authapi.FormatUserCode(userCode),
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?user_code=" + authapi.FormatUserCode(userCode),
+		ExpiresIn:               int64(deviceCodeDuration.Seconds()),
+		Interval:                devicePollInterval,
+	}, nil
+}
+
+// DeviceAuthorization is the pending device authorization shown to the user
+type DeviceAuthorization struct {
+	UserCode string
+	Client   *authapi.OAuthClient
+	Scope    string
+}
+
+// GetDeviceAuthorization returns the pending device authorization of the user code
+func GetDeviceAuthorization(userCode string) (*DeviceAuthorization, error) {
+	userCode = authapi.NormalizeUserCode(userCode)
+	if len(userCode) == 0 {
+		return nil, ErrInvalidUserCode
+	}
+	o := orm.NewOrm()
+	deviceCode, err := authdb.GetOAuthDeviceCodeByUserCode(o, userCode)
+	if err == orm.ErrNoRows {
+		return nil, ErrInvalidUserCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	if deviceCode.Status != authdb.DevicePending || deviceCode.ExpiresAt.Before(time.Now()) {
+		return nil, ErrInvalidUserCode
+	}
+	client, _, err := getOAuthClient(o, deviceCode.ClientID)
+	if err == orm.ErrNoRows {
+		return nil, ErrInvalidUserCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &DeviceAuthorization{
+		UserCode: userCode,
+		Client:   client,
+		Scope:    deviceCode.Scope,
+	}, nil
+}
+
+// DecideDeviceAuthorization approves or denies the device of the user code by the user, the scope
+// of the device authorization should be allowed for the user
+func DecideDeviceAuthorization(userCode string, user *authapi.User, approve bool) error {
+	o := orm.NewOrm()
+	deviceCode, err := authdb.GetOAuthDeviceCodeByUserCode(o, authapi.NormalizeUserCode(userCode))
+	if err == orm.ErrNoRows {
+		return ErrInvalidUserCode
+	}
+	if err != nil {
+		return err
+	}
+	if deviceCode.ExpiresAt.Before(time.Now()) {
+		return ErrInvalidUserCode
+	}
+	status := authdb.DeviceDenied
+	if approve {
+		_, actions, _ := authapi.ParseOAuthScope(deviceCode.Scope)
+		if _, err = OAuthTokenScope(user, actions); err != nil {
+			return err
+		}
+		status = authdb.DeviceApproved
+	}
+	err = authdb.DecideOAuthDeviceCode(o, deviceCode.Id, status, user.UUID, time.Now())
+	if err == orm.ErrNoRows {
+		return ErrInvalidUserCode
+	}
+	return err
+}
+
+// PollDeviceCode returns the grant of the user after the device is approved, the device code is consumed then.
+// ErrAuthorizationPending is returned before the user decides, and ErrSlowDown if the device polls too frequently
+func PollDeviceCode(clientID, code string) (*AuthorizationGrant, error) {
+	if len(code) == 0 {
+		return nil, ErrInvalidDeviceCode
+	}
+	o := orm.NewOrm()
+	deviceCode, err := authdb.GetOAuthDeviceCodeByHash(o, hashToken(code))
+	if err == orm.ErrNoRows {
+		return nil, ErrInvalidDeviceCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	if deviceCode.ClientID != clientID {
+		return nil, ErrInvalidDeviceCode
+	}
+	now := time.Now()
+	if deviceCode.ExpiresAt.Before(now) {
+		return nil, ErrDeviceCodeExpired
+	}
+
+	switch deviceCode.Status {
+	case authdb.DevicePending:
+		interval := deviceCode.Interval
+		if !deviceCode.PolledAt.IsZero() && now.Sub(deviceCode.PolledAt) < time.Duration(interval)*time.Second {
+			interval += devicePollInterval
+			err = ErrSlowDown
+		} else {
+			err = ErrAuthorizationPending
+		}
+		if updateErr := authdb.UpdateOAuthDeviceCodePoll(o, deviceCode.Id, interval, now); updateErr != nil {
+			return nil, updateErr
+		}
+		return nil, err
+	case authdb.DeviceDenied:
+		_ = authdb.DeleteOAuthDeviceCode(o, deviceCode.Id)
+		return nil, ErrDeviceAccessDenied
+	}
+
+	if err = authdb.DeleteOAuthDeviceCode(o, deviceCode.Id); err == orm.ErrNoRows {
+		return nil, ErrInvalidDeviceCode
+	} else if err != nil {
+		return nil, err
+	}
+	user, err := authdb.GetUserByUUID(o, deviceCode.UserUUID)
+	if err == orm.ErrNoRows {
+		return nil, ErrInvalidDeviceCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	user.Password = ""
+	userAPI := transformUserDB2API(user)
+	return &AuthorizationGrant{
+		User:     &userAPI,
+		Scope:    deviceCode.Scope,
+		AuthTime: deviceCode.AuthTime,
+	}, nil
+}
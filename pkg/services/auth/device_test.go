@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+
+	authapi "imanager/pkg/api/auth"
+	authdb "imanager/pkg/db/auth"
+)
+
+// createTestDevice issues the device code and the user code to the client
+func createTestDevice(t *testing.T, clientID string) *authapi.DeviceAuthorizationResponse {
+	setTestConfig(t, tokenIssuerKey, "https://imanager.example.com")
+	resp, err := CreateDeviceAuthorization(clientID, "openid user:read")
+	if err != nil {
+		t.Fatalf("create device authorization failed, err: %v", err)
+	}
+	return resp
+}
+
+// updateTestDevice updates the device code of the user code, such as moving the poll time back
+func updateTestDevice(t *testing.T, o orm.Ormer, userCode string, params orm.Params) {
+	_, err := o.QueryTable(authdb.OAuthDeviceCode{}).Filter("user_code", authapi.NormalizeUserCode(userCode)).Update(params)
+	if err != nil {
+		t.Fatalf("update device code failed, err: %v", err)
+	}
+}
+
+func TestPollDeviceCode(t *testing.T) {
+	o := setupTestDB(t)
+	userDB := createTestUser(t, o, "u1", "g1")
+	user := transformUserDB2API(userDB)
+	device := createTestDevice(t, "app")
+
+	if _, err := PollDeviceCode("other", device.DeviceCode); err != ErrInvalidDeviceCode {
+		t.Logf("device code of the other client should be invalid, err: %v", err)
+		t.Fail()
+	}
+	if _, err := PollDeviceCode("app", "unknown"); err != ErrInvalidDeviceCode {
+		t.Logf("unknown device code should be invalid, err: %v", err)
+		t.Fail()
+	}
+	if _, err := PollDeviceCode("app", device.DeviceCode); err != ErrAuthorizationPending {
+		t.Fatalf("device code should be pending before the user decides, err: %v", err)
+	}
+	// the device should wait for the interval between the polls
+	if _, err := PollDeviceCode("app", device.DeviceCode); err != ErrSlowDown {
+		t.Logf("device polling too frequently should slow down, err: %v", err)
+		t.Fail()
+	}
+	deviceCode, err := authdb.GetOAuthDeviceCodeByUserCode(o, authapi.NormalizeUserCode(device.UserCode))
+	if err != nil || deviceCode.Interval != 2*devicePollInterval {
+		t.Fatalf("interval should be increased by slow_down: %+v, err: %v", deviceCode, err)
+	}
+	updateTestDevice(t, o, device.UserCode, orm.Params{"polled_at": time.Now().Add(-time.Duration(deviceCode.Interval) * time.Second)})
+	if _, err = PollDeviceCode("app", device.DeviceCode); err != ErrAuthorizationPending {
+		t.Logf("device polling after the interval should be pending, err: %v", err)
+		t.Fail()
+	}
+
+	if err = DecideDeviceAuthorization(device.UserCode, &user, true); err != nil {
+		t.Fatalf("approve device failed, err: %v", err)
+	}
+	// the code isn't pending any more
+	if err = DecideDeviceAuthorization(device.UserCode, &user, false); err != ErrInvalidUserCode {
+		t.Logf("decided device shouldn't be decided again, err: %v", err)
+		t.Fail()
+	}
+	if _, err = GetDeviceAuthorization(device.UserCode); err != ErrInvalidUserCode {
+		t.Logf("decided device shouldn't be shown to the user, err: %v", err)
+		t.Fail()
+	}
+	grant, err := PollDeviceCode("app", device.DeviceCode)
+	if err != nil || grant.User.UUID != user.UUID || grant.Scope != "openid user:read" {
+		t.Fatalf("approved device should get the grant of the user: %+v, err: %v", grant, err)
+	}
+	if _, err = PollDeviceCode("app", device.DeviceCode); err != ErrInvalidDeviceCode {
+		t.Logf("device code should only be redeemed once, err: %v", err)
+		t.Fail()
+	}
+}
+
+func TestPollDeviceCodeDenied(t *testing.T) {
+	o := setupTestDB(t)
+	userDB := createTestUser(t, o, "u1", "g1")
+	user := transformUserDB2API(userDB)
+	device := createTestDevice(t, "app")
+
+	if err := DecideDeviceAuthorization(device.UserCode, &user, false); err != nil {
+		t.Fatalf("deny device failed, err: %v", err)
+	}
+	if err := DecideDeviceAuthorization(device.UserCode, &user, true); err != ErrInvalidUserCode {
+		t.Logf("denied device shouldn't be approved again, err: %v", err)
+		t.Fail()
+	}
+	if _, err := PollDeviceCode("app", device.DeviceCode); err != ErrDeviceAccessDenied {
+		t.Logf("denied device should get access_denied, err: %v", err)
+		t.Fail()
+	}
+	if _, err := PollDeviceCode("app", device.DeviceCode); err != ErrInvalidDeviceCode {
+		t.Logf("denied device code should be removed, err: %v", err)
+		t.Fail()
+	}
+}
+
+func TestPollDeviceCodeExpired(t *testing.T) {
+	o := setupTestDB(t)
+	userDB := createTestUser(t, o, "u1", "g1")
+	user := transformUserDB2API(userDB)
+	device := createTestDevice(t, "app")
+
+	updateTestDevice(t, o, device.UserCode, orm.Params{"expires_at": time.Now().Add(-time.Second)})
+	if err := DecideDeviceAuthorization(device.UserCode, &user, true); err != ErrInvalidUserCode {
+		t.Logf("expired device shouldn't be approved, err: %v", err)
+		t.Fail()
+	}
+	if _, err := PollDeviceCode("app", device.DeviceCode); err != ErrDeviceCodeExpired {
+		t.Logf("expired device code should get expired_token, err: %v", err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	authapi "imanager/pkg/api/auth"
+	"imanager/pkg/config"
+)
+
+// the json file of authapi.RouteRules, authapi.DefaultRouteRules are used if it's not set
+const routeRulesFileKey = "RouteRulesFile"
+
+// routeRules caches the rules file until it's modified
+var routeRules = struct {
+	sync.Mutex
+	path    string
+	modTime time.Time
+	rules   []authapi.RouteRule
+}{}
+
+// loadRouteRules returns the rules of the file, the previous rules are kept if the modified file is invalid
+func loadRouteRules(path string) []authapi.RouteRule {
+	if len(path) == 0 {
+		return authapi.DefaultRouteRules
+	}
+	routeRules.Lock()
+	defer routeRules.Unlock()
+	stat, err := os.Stat(path)
+	if err != nil {
+		glog.Errorf("stat route rules %v failed, err: %v", path, err)
+		return routeRules.rules
+	}
+	if path == routeRules.path && stat.ModTime().Equal(routeRules.modTime) {
+		return routeRules.rules
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		glog.Errorf("read route rules %v failed, err: %v", path, err)
+		return routeRules.rules
+	}
+	rules := authapi.RouteRules{}
+	if err = json.Unmarshal(data, &rules); err != nil {
+		glog.Errorf("unmarshal route rules %v failed, err: %v", path, err)
+		return routeRules.rules
+	}
+	glog.Infof("load %v rules from route rules %v", len(rules.Rules), path)
+	routeRules.path, routeRules.modTime, routeRules.rules = path, stat.ModTime(), rules.Rules
+	return rules.Rules
+}
+
+// CheckRoute authenticates the request of the ingress by the token and the rule of the route. The token is
+// validated in the same way as authFilter, and its scope should allow the ingress, so that e.g. the token to
+// change the expired password can't access the backends
+func CheckRoute(host, method, uri, token string) authapi.ExtAuthzResult {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	path, err := authapi.NormalizeRoutePath(uri)
+	if err != nil {
+		return authapi.ExtAuthzResult{Status: http.StatusBadRequest, Reason: fmt.Sprintf("invalid path, %v", err)}
+	}
+	rules := loadRouteRules(config.GetConfig().String(routeRulesFileKey))
+	i := authapi.MatchRoute(rules, host, method, path)
+	if i < 0 {
+		return authapi.ExtAuthzResult{Status: http.StatusForbidden, Reason: "no rule allows the route"}
+	}
+	rule := rules[i]
+
+	var info *authapi.RespToken
+	if len(token) != 0 {
+		tokenInfo, err := ValidateToken(token)
+		switch {
+		case err != nil:
+			glog.Infof("validate token of route %v %v%v failed, err: %v", method, host, path, err)
+		case !tokenInfo.Scope.AllowsResource(authapi.IngressResource):
+			glog.Infof("token scope of %v doesn't allow the ingress", tokenInfo.Name)
+		default:
+			info = &tokenInfo
+		}
+	}
+	if rule.Public {
+		return authapi.ExtAuthzResult{Status: http.StatusOK, Reason: fmt.Sprintf("public by rule %v", i), Info: info}
+	}
+	if info == nil {
+		return authapi.ExtAuthzResult{Status: http.StatusUnauthorized, Reason: "token is invalid"}
+	}
+	if !rule.AllowsSubject(info) {
+		return authapi.ExtAuthzResult{Status: http.StatusForbidden, Reason: fmt.Sprintf("rule %v doesn't allow %v", i, info.Name)}
+	}
+	return authapi.ExtAuthzResult{Status: http.StatusOK, Reason: fmt.Sprintf("allowed by rule %v", i), Info: info}
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"fmt"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/golang/glog"
+	uuid "github.com/satori/go.uuid"
+
+	authapi "imanager/pkg/api/auth"
+	authdb "imanager/pkg/db/auth"
+)
+
+// resolveMappedGroup returns the group and roles of the names mapped from the external groups,
+// the default group and role are used if nothing is mapped
+func resolveMappedGroup(o orm.Ormer, groupName string, roleNames []string) (*authdb.Group, []*authdb.Role, error) {
+	roles := make([]*authdb.Role, 0, len(roleNames))
+	for _, v := range roleNames {
+		role, err := authdb.GetRoleByName(o, v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("get role %v of group mapping failed, %v", v, err)
+		}
+		roles = append(roles, &authdb.Role{Id: role.Id})
+	}
+	if len(roles) == 0 {
+		for _, v := range DefaultRole {
+			roles = append(roles, &authdb.Role{Id: v.ID})
+		}
+	}
+
+	group := &authdb.Group{Id: DefaultGroup.ID}
+	if len(groupName) != 0 {
+		groupDB, err := authdb.GetGroupByName(o, groupName)
+		if err != nil {
+			return nil, nil, fmt.Errorf("get group %v of group mapping failed, %v", groupName, err)
+		}
+		group.Id = groupDB.Id
+	}
+	// the same as the users created by api, op service is always in its own group
+	for _, v := range roles {
+		if authapi.RoleType(v.Id) == authapi.OpServiceRole {
+			group.Id = OpServiceGroup.ID
+		}
+	}
+	return group, roles, nil
+}
+
+// provisionExternalUser creates the local user of the external identity at the first login,
+// and synchronizes its group, roles and attributes at every login
+func provisionExternalUser(o orm.Ormer, user authdb.User, localUser *authdb.User) (*authapi.User, error) {
+	var err error
+	if localUser == nil {
+		user, err = createExternalUser(o, user)
+	} else {
+		user, err = syncExternalUser(o, *localUser, user)
+	}
+	if err != nil {
+		return nil, err
+	}
+	user.Password = ""
+	out := transformUserDB2API(user)
+	return &out, nil
+}
+
+// createExternalUser creates the local user without password, it isn't created in harbor,
+// which should authenticate the user by the same source too
+func createExternalUser(o orm.Ormer, user authdb.User) (authdb.User, error) {
+	user.UUID = uuid.NewV4().String()
+	glog.Infof("create %v user[%v/%v] in group %v", user.Source, user.Name, user.UUID, user.Group.Id)
+	res, err := authdb.CreateUser(o, user)
+	if err != nil {
+		glog.Errorf("create %v user[%v] failed, err: %v", user.Source, user.Name, err)
+	}
+	return res, err
+}
+
+func syncExternalUser(o orm.Ormer, oldUser, user authdb.User) (authdb.User, error) {
+	user.UUID = oldUser.UUID
+	if !isRoleOrGroupChanged(oldUser, user) && oldUser.TruthName == user.TruthName &&
+		oldUser.Email == user.Email && oldUser.PhoneNum == user.PhoneNum {
+		return oldUser, nil
+	}
+
+	glog.Infof("synchronize user[%v/%v] from %v", user.Name, user.UUID, user.Source)
+	err := o.Begin()
+	if err != nil {
+		return user, err
+	}
+	res, err := authdb.UpdateUser(o, user)
+	if err != nil {
+		_ = o.Rollback()
+		glog.Errorf("update %v user[%v/%v] failed, err: %v", user.Source, user.Name, user.UUID, err)
+		return res, err
+	}
+	// the tokens carry the old roles and group, the token of this login is issued after it
+	if isRoleOrGroupChanged(oldUser, res) {
+		if err = revokeUserTokens(o, res.UUID); err != nil {
+			_ = o.Rollback()
+			return res, err
+		}
+		if err = revokePersonalAccessTokens(o, res.UUID); err != nil {
+			_ = o.Rollback()
+			return res, err
+		}
+	}
+	_ = o.Commit()
+	return res, nil
+}
@@ -81,5 +81,12 @@ func DeleteGroupByName(name string) error {
 	if group.Builtin {
 		return fmt.Errorf("the buildin group can't delete")
 	}
+	clients, err := authdb.CountOAuthClientsByGroup(o, group.Id)
+	if err != nil {
+		return err
+	}
+	if clients != 0 {
+		return fmt.Errorf("group owns oauth clients, can't delete")
+	}
 	return authdb.DeleteGroupByName(o, name)
 }
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"testing"
+
+	authapi "imanager/pkg/api/auth"
+	authdb "imanager/pkg/db/auth"
+)
+
+func TestUpdateGroupResetsPolicy(t *testing.T) {
+	o := setupTestDB(t)
+	user := createTestUser(t, o, "u1", "g1")
+
+	group, err := UpdateGroup(&authapi.Group{
+		ID:             user.Group.Id,
+		Name:           "g1",
+		TokenPolicy:    &authapi.TokenPolicy{DefaultDuration: 60, MaxDuration: 600, IdleTimeout: 30},
+		PasswordMaxAge: 90,
+	})
+	if err != nil || group.TokenPolicy == nil || group.TokenPolicy.MaxDuration != 600 || group.PasswordMaxAge != 90 {
+		t.Fatalf("policies of group should be set: %+v, err: %v", group, err)
+	}
+
+	// 0 means unlimited, it can't be kept as the old value
+	group, err = UpdateGroup(&authapi.Group{ID: user.Group.Id, Name: "g1"})
+	if err != nil {
+		t.Fatalf("update group failed, err: %v", err)
+	}
+	if (group.TokenPolicy != nil && !group.TokenPolicy.IsEmpty()) || group.PasswordMaxAge != 0 {
+		t.Logf("policies of group should be reset: %+v, %+v", group, group.TokenPolicy)
+		t.Fail()
+	}
+}
+
+func TestUpdateRoleResetsPolicy(t *testing.T) {
+	o := setupTestDB(t)
+	role := createTestRole(t, o, "r1")
+
+	updated, err := UpdateRole(&authapi.Role{
+		ID:          role.Id,
+		Name:        "r1",
+		TokenPolicy: &authapi.TokenPolicy{MaxDuration: 600, IdleTimeout: 30},
+	})
+	if err != nil || updated.TokenPolicy == nil || updated.TokenPolicy.MaxDuration != 600 {
+		t.Fatalf("token policy of role should be set: %+v, err: %v", updated, err)
+	}
+
+	updated, err = UpdateRole(&authapi.Role{ID: role.Id, Name: "r1"})
+	if err != nil {
+		t.Fatalf("update role failed, err: %v", err)
+	}
+	if updated.TokenPolicy != nil && !updated.TokenPolicy.IsEmpty() {
+		t.Logf("token policy of role should be reset: %+v", updated.TokenPolicy)
+		t.Fail()
+	}
+}
+
+func TestUpdateRolePermissions(t *testing.T) {
+	o := setupTestDB(t)
+	role := createTestRole(t, o, "r1")
+
+	updated, err := UpdateRole(&authapi.Role{
+		ID:          role.Id,
+		Name:        "r1",
+		Permissions: []string{"user:read"},
+		AllGroups:   true,
+	})
+	if err != nil || len(updated.Permissions) != 1 || !updated.AllGroups {
+		t.Fatalf("permissions of role should be set: %+v, err: %v", updated, err)
+	}
+	// the permissions aren't changed if they are null
+	updated, err = UpdateRole(&authapi.Role{ID: role.Id, Name: "r1", Annotation: "reader"})
+	if err != nil || len(updated.Permissions) != 1 || !updated.AllGroups {
+		t.Fatalf("permissions of role should be kept: %+v, err: %v", updated, err)
+	}
+	updated, err = UpdateRole(&authapi.Role{ID: role.Id, Name: "r1", Permissions: []string{}})
+	if err != nil || len(updated.Permissions) != 0 || updated.AllGroups {
+		t.Logf("permissions of role should be removed: %+v, err: %v", updated, err)
+		t.Fail()
+	}
+}
+
+func TestUpdateBuiltinRolePermissions(t *testing.T) {
+	o := setupTestDB(t)
+	admin := &authdb.Role{Id: int(authapi.AdminRole), Name: authapi.AdminRole.String()}
+	if _, err := o.Insert(admin); err != nil {
+		t.Fatalf("create role failed, err: %v", err)
+	}
+	roles := []authapi.RoleInUser{{ID: admin.Id}}
+	permissions, err := GetPermissions(roles)
+	if err != nil || len(permissions.InGroup) == 0 {
+		t.Fatalf("builtin role should have the default permissions: %+v, err: %v", permissions, err)
+	}
+
+	if _, err = UpdateRole(&authapi.Role{ID: admin.Id, Name: admin.Name, Permissions: []string{}}); err != nil {
+		t.Fatalf("update role failed, err: %v", err)
+	}
+	// the role keeps no permissions after the other fields are updated
+	if _, err = UpdateRole(&authapi.Role{ID: admin.Id, Name: admin.Name, Annotation: "no permission"}); err != nil {
+		t.Fatalf("update role failed, err: %v", err)
+	}
+	permissions, err = GetPermissions(roles)
+	if err != nil || len(permissions.InGroup) != 0 || len(permissions.AllGroups) != 0 {
+		t.Logf("all permissions of builtin role should be removed: %+v, err: %v", permissions, err)
+		t.Fail()
+	}
+}
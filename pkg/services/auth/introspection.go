@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/golang/glog"
+
+	authapi "imanager/pkg/api/auth"
+	authdb "imanager/pkg/db/auth"
+)
+
+// IntrospectToken returns the state of the token, the hint decides which type of token is tried first
+func IntrospectToken(token, hint string) authapi.IntrospectionResponse {
+	if len(token) == 0 {
+		return authapi.IntrospectionResponse{Active: false}
+	}
+	introspects := []func(string) (authapi.IntrospectionResponse, bool){introspectAccessToken, introspectRefreshToken}
+	if hint == authapi.RefreshTokenTypeHint {
+		introspects[0], introspects[1] = introspects[1], introspects[0]
+	}
+	for _, introspect := range introspects {
+		if res, ok := introspect(token); ok {
+			return res
+		}
+	}
+	return authapi.IntrospectionResponse{Active: false}
+}
+
+func introspectAccessToken(token string) (authapi.IntrospectionResponse, bool) {
+	// refresh tokens are neither jwt nor personal access token
+	if strings.Count(token, ".") != 2 && !IsPersonalAccessToken(token) {
+		return authapi.IntrospectionResponse{}, false
+	}
+	info, err := ValidateToken(token)
+	if err != nil {
+		glog.Infof("introspect access token failed, err: %v", err)
+		return authapi.IntrospectionResponse{Active: false}, true
+	}
+	tokenType := "Bearer"
+	if info.TokenType == authapi.PersonalAccessTokenType {
+		tokenType = authapi.PersonalAccessTokenType
+	}
+	return authapi.IntrospectionResponse{
+		Active:    true,
+		Scope:     info.Scope.String(),
+		ClientID:  info.ClientID,
+		Username:  info.Name,
+		TokenType: tokenType,
+		Exp:       info.ExpiresAt.Unix(),
+		Iat:       info.IssuedAt.Unix(),
+		Nbf:       info.IssuedAt.Unix(),
+		Sub:       info.UserID,
+		Aud:       info.Audience,
+		Iss:       info.Issuer,
+		Jti:       info.TokenID,
+		Group:     info.Group,
+		Roles:     info.Role,
+		Act:       info.Act,
+	}, true
+}
+
+func introspectRefreshToken(token string) (authapi.IntrospectionResponse, bool) {
+	o := orm.NewOrm()
+	refreshToken, err := authdb.GetRefreshTokenByHash(o, hashToken(token))
+	if err != nil {
+		if err != orm.ErrNoRows {
+			glog.Errorf("get refresh token failed, err: %v", err)
+		}
+		return authapi.IntrospectionResponse{}, false
+	}
+	if refreshToken.Used || refreshToken.Revoked || refreshToken.ExpiresAt.Before(time.Now()) {
+		return authapi.IntrospectionResponse{Active: false}, true
+	}
+	user, err := authdb.GetUserByUUID(o, refreshToken.UserUUID)
+	if err != nil {
+		glog.Errorf("get user[%v] of refresh token failed, err: %v", refreshToken.UserUUID, err)
+		return authapi.IntrospectionResponse{Active: false}, true
+	}
+	scope := &authapi.TokenScope{}
+	if len(refreshToken.Scope) != 0 {
+		if err = json.Unmarshal([]byte(refreshToken.Scope), scope); err != nil {
+			glog.Errorf("unmarshal scope of refresh token %v failed, err: %v", refreshToken.Id, err)
+		}
+	}
+	user.Password = ""
+	userAPI := transformUserDB2API(user)
+	return authapi.IntrospectionResponse{
+		Active:    true,
+		Scope:     scope.String(),
+		Username:  user.Name,
+		TokenType: authapi.RefreshTokenTypeHint,
+		Exp:       refreshToken.ExpiresAt.Unix(),
+		Iat:       refreshToken.CreateTimestamp.Unix(),
+		Sub:       user.UUID,
+		Iss:       TokenIssuer(),
+		Group:     userAPI.Group,
+		Roles:     userAPI.Role,
+	}, true
+}
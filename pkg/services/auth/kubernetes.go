@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/golang/glog"
+
+	authapi "imanager/pkg/api/auth"
+	"imanager/pkg/config"
+	authdb "imanager/pkg/db/auth"
+)
+
+// the audiences of kube-apiserver which accept the imanager tokens besides the audiences of the token,
+// separated by comma. The review requesting none of them isn't authenticated
+const kubernetesAudiencesKey = "KubernetesAudiences"
+
+// reviewAudiences returns the requested audiences which the token is valid for
+func reviewAudiences(info authapi.RespToken, audiences []string) []string {
+	valid := map[string]bool{}
+	for _, v := range append(splitFields(config.GetConfig().String(kubernetesAudiencesKey), ","), info.Audience...) {
+		valid[strings.TrimSpace(v)] = true
+	}
+	res := make([]string, 0, len(audiences))
+	for _, v := range audiences {
+		if valid[v] {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// ReviewToken authenticates the bearer token for kube-apiserver. The token whose scope doesn't allow the
+// kubernetes api, e.g. the token to change the expired password, isn't authenticated. If kube-apiserver
+// requests audiences, the token should be valid for at least one of them, and they are returned
+func ReviewToken(token string, audiences []string) authapi.TokenReviewStatus {
+	info, err := ValidateToken(token)
+	if err != nil {
+		glog.Infof("review token failed, err: %v", err)
+		return authapi.TokenReviewStatus{Error: "token is invalid"}
+	}
+	if !info.Scope.AllowsResource(authapi.KubernetesResource) {
+		return authapi.TokenReviewStatus{Error: "token scope doesn't allow to access kubernetes"}
+	}
+	var reviewed []string
+	if len(audiences) != 0 {
+		reviewed = reviewAudiences(info, audiences)
+		if len(reviewed) == 0 {
+			glog.Infof("token of %v isn't valid for the audiences %v", info.Name, audiences)
+			return authapi.TokenReviewStatus{Error: "token isn't valid for the requested audiences"}
+		}
+	}
+	extra := map[string][]string{}
+	if len(info.TokenID) != 0 {
+		extra[authapi.KubernetesTokenIDExtra] = []string{info.TokenID}
+	}
+	if len(info.ClientID) != 0 {
+		extra[authapi.KubernetesClientIDExtra] = []string{info.ClientID}
+	}
+	return authapi.TokenReviewStatus{
+		Authenticated: true,
+		User: authapi.KubernetesUser{
+			Username: info.Name,
+			UID:      info.UserID,
+			Groups:   authapi.KubernetesGroups(info.Group, info.Role),
+			Extra:    extra,
+		},
+		Audiences: reviewed,
+	}
+}
+
+// the json file of authapi.KubernetesRules, authapi.DefaultKubernetesRules are used if it's not set
+const kubernetesRulesFileKey = "KubernetesRulesFile"
+
+// kubernetesRules caches the rules file until it's modified
+var kubernetesRules = struct {
+	sync.Mutex
+	path    string
+	modTime time.Time
+	rules   []authapi.KubernetesRule
+}{}
+
+// loadKubernetesRules returns the rules of the file, the previous rules are kept if the modified file is invalid
+func loadKubernetesRules(path string) []authapi.KubernetesRule {
+	if len(path) == 0 {
+		return authapi.DefaultKubernetesRules
+	}
+	kubernetesRules.Lock()
+	defer kubernetesRules.Unlock()
+	stat, err := os.Stat(path)
+	if err != nil {
+		glog.Errorf("stat kubernetes rules %v failed, err: %v", path, err)
+		return kubernetesRules.rules
+	}
+	if path == kubernetesRules.path && stat.ModTime().Equal(kubernetesRules.modTime) {
+		return kubernetesRules.rules
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		glog.Errorf("read kubernetes rules %v failed, err: %v", path, err)
+		return kubernetesRules.rules
+	}
+	rules := authapi.KubernetesRules{}
+	if err = json.Unmarshal(data, &rules); err != nil {
+		glog.Errorf("unmarshal kubernetes rules %v failed, err: %v", path, err)
+		return kubernetesRules.rules
+	}
+	glog.Infof("load %v rules from kubernetes rules %v", len(rules.Rules), path)
+	kubernetesRules.path, kubernetesRules.modTime, kubernetesRules.rules = path, stat.ModTime(), rules.Rules
+	return rules.Rules
+}
+
+// ReviewSubjectAccess authorizes the request of kube-apiserver by the current group and roles of the user found
+// by the uid returned by ReviewToken, the groups in the request are only used to check that the user is
+// authenticated by imanager since they are kept by kube-apiserver until the token expires. It has no opinion
+// on the requests which aren't allowed, and the other authorizers of kube-apiserver decide them
+func ReviewSubjectAccess(spec authapi.SubjectAccessReviewSpec) authapi.SubjectAccessReviewStatus {
+	if _, ok := authapi.GetKubernetesSubject(spec.Groups); !ok || len(spec.UID) == 0 {
+		return authapi.SubjectAccessReviewStatus{Reason: fmt.Sprintf("%v isn't authenticated by imanager", spec.User)}
+	}
+	user, err := authdb.GetUserByUUID(orm.NewOrm(), spec.UID)
+	if err == orm.ErrNoRows {
+		return authapi.SubjectAccessReviewStatus{Reason: fmt.Sprintf("%v isn't found in imanager", spec.User)}
+	}
+	if err != nil {
+		glog.Errorf("get user %v of subject access review failed, err: %v", spec.UID, err)
+		return authapi.SubjectAccessReviewStatus{Reason: fmt.Sprintf("get %v from imanager failed", spec.User)}
+	}
+	subject := authapi.KubernetesSubject{}
+	if user.Group != nil {
+		subject.Group = user.Group.Name
+	}
+	for _, v := range user.Role {
+		subject.Roles = append(subject.Roles, v.Name)
+	}
+	for i, rule := range loadKubernetesRules(config.GetConfig().String(kubernetesRulesFileKey)) {
+		if rule.Allows(subject, spec) {
+			return authapi.SubjectAccessReviewStatus{
+				Allowed: true,
+				Reason:  fmt.Sprintf("allowed by imanager rule %v", i),
+			}
+		}
+	}
+	return authapi.SubjectAccessReviewStatus{Reason: fmt.Sprintf("no imanager rule allows %v", spec.User)}
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	authapi "imanager/pkg/api/auth"
+)
+
+func TestReviewToken(t *testing.T) {
+	o := setupTestDB(t)
+	useTestSigningKeys(t, testRSAKey(t, "1"))
+	setTestConfig(t, tokenAudienceKey, "")
+	setTestConfig(t, kubernetesAudiencesKey, "https://kubernetes.default.svc, k8s")
+	user := createTestUser(t, o, "u1", "g1")
+
+	createToken := func(scope *authapi.TokenScope) string {
+		info := testIssuedToken(user, time.Now())
+		info.Scope = scope
+		token, err := CreateToken(&info)
+		if err != nil {
+			t.Fatalf("create token failed, err: %v", err)
+		}
+		return token
+	}
+	token := createToken(nil)
+	passwordScope := authapi.PasswordChangeScope
+	cases := []struct {
+		name          string
+		token         string
+		audiences     []string
+		authenticated bool
+		reviewed      []string
+	}{
+		{"token", token, nil, true, nil},
+		{"invalid token", "invalid", nil, false, nil},
+		{"scope without kubernetes", createToken(&passwordScope), nil, false, nil},
+		{"scope of kubernetes", createToken(&authapi.TokenScope{Actions: []string{"kubernetes:*"}}), nil, true, nil},
+		{"scope of other resource", createToken(&authapi.TokenScope{Actions: []string{"user:read"}}), nil, false, nil},
+		{"configured audience", token, []string{"https://kubernetes.default.svc", "other"}, true, []string{"https://kubernetes.default.svc"}},
+		{"audience of token", token, []string{defaultTokenAudience}, true, []string{defaultTokenAudience}},
+		{"other audience", token, []string{"other"}, false, nil},
+	}
+	for _, c := range cases {
+		status := ReviewToken(c.token, c.audiences)
+		if status.Authenticated != c.authenticated || !reflect.DeepEqual(status.Audiences, c.reviewed) {
+			t.Logf("%v: authenticated should be %v with audiences %v, got %+v", c.name, c.authenticated, c.reviewed, status)
+			t.Fail()
+			continue
+		}
+		if status.Authenticated && (status.User.Username != "u1" || status.User.UID != user.UUID) {
+			t.Logf("%v: token should be authenticated as the user: %+v", c.name, status.User)
+			t.Fail()
+		}
+		if !status.Authenticated && len(status.Error) == 0 {
+			t.Logf("%v: error should be returned", c.name)
+			t.Fail()
+		}
+	}
+}
+
+func TestReviewSubjectAccess(t *testing.T) {
+	o := setupTestDB(t)
+	setTestConfig(t, kubernetesRulesFileKey, "")
+	admin := createTestRole(t, o, authapi.AdminRole.String())
+	user := createTestUser(t, o, "u1", "g1", admin)
+	createTestUser(t, o, "u2", "g2")
+
+	review := func(uid, namespace string, groups ...string) authapi.SubjectAccessReviewStatus {
+		return ReviewSubjectAccess(authapi.SubjectAccessReviewSpec{
+			ResourceAttributes: &authapi.KubernetesResourceAttributes{Namespace: namespace, Verb: "get", Resource: "pods"},
+			User:               "u1",
+			Groups:             groups,
+			UID:                uid,
+		})
+	}
+	// the groups in the request may be issued before the group and roles of the user are changed
+	claimed := []string{"imanager:group:g2", "imanager:role:admin"}
+	cases := []struct {
+		name      string
+		uid       string
+		namespace string
+		groups    []string
+		allowed   bool
+	}{
+		{"namespace of the group", user.UUID, "g1", claimed, true},
+		{"namespace of the claimed group", user.UUID, "g2", claimed, false},
+		{"unknown user", "unknown", "g1", claimed, false},
+		{"not authenticated by imanager", user.UUID, "g1", []string{"system:authenticated"}, false},
+	}
+	for _, c := range cases {
+		if status := review(c.uid, c.namespace, c.groups...); status.Allowed != c.allowed || status.Denied {
+			t.Logf("%v: allowed should be %v, got %+v", c.name, c.allowed, status)
+			t.Fail()
+		}
+	}
+
+	if _, err := o.QueryM2M(&user, "Role").Remove(admin); err != nil {
+		t.Fatalf("remove role of user failed, err: %v", err)
+	}
+	if status := review(user.UUID, "g1", claimed...); status.Allowed {
+		t.Logf("user whose admin role is removed shouldn't be allowed: %+v", status)
+		t.Fail()
+	}
+}
@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/golang/glog"
+
+	authapi "imanager/pkg/api/auth"
+	"imanager/pkg/config"
+	authdb "imanager/pkg/db/auth"
+	"imanager/pkg/ldapauth"
+)
+
+const (
+	// ldap authentication is enabled if the url is set, see ldapauth.Config for the other keys
+	ldapURLKey                = "LDAPURL"
+	ldapStartTLSKey           = "LDAPStartTLS"
+	ldapInsecureSkipVerifyKey = "LDAPInsecureSkipVerify"
+	ldapBindDNKey             = "LDAPBindDN"
+	ldapBindPasswordKey       = "LDAPBindPassword"
+	ldapUserSearchBaseKey     = "LDAPUserSearchBase"
+	ldapUserFilterKey         = "LDAPUserFilter"
+	ldapGroupAttributeKey     = "LDAPGroupAttribute"
+	ldapGroupSearchBaseKey    = "LDAPGroupSearchBase"
+	ldapGroupFilterKey        = "LDAPGroupFilter"
+	ldapTruthNameAttributeKey = "LDAPTruthNameAttribute"
+	ldapEmailAttributeKey     = "LDAPEmailAttribute"
+	ldapPhoneAttributeKey     = "LDAPPhoneAttribute"
+	// json array of ldapauth.GroupMapping
+	ldapGroupMappingKey = "LDAPGroupMapping"
+	// the users in none of the mapped groups are rejected, unless it's true,
+	// then they are created in the default group with the default role
+	ldapAllowUnmappedUsersKey = "LDAPAllowUnmappedUsers"
+)
+
+func isLDAPEnabled() bool {
+	return len(config.GetConfig().String(ldapURLKey)) != 0
+}
+
+func ldapConfig() (*ldapauth.Config, error) {
+	c := config.GetConfig()
+	res := &ldapauth.Config{
+		URL:                c.String(ldapURLKey),
+		BindDN:             c.String(ldapBindDNKey),
+		BindPassword:       c.String(ldapBindPasswordKey),
+		UserSearchBase:     c.String(ldapUserSearchBaseKey),
+		UserFilter:         c.String(ldapUserFilterKey),
+		GroupAttribute:     c.String(ldapGroupAttributeKey),
+		GroupSearchBase:    c.String(ldapGroupSearchBaseKey),
+		GroupFilter:        c.String(ldapGroupFilterKey),
+		TruthNameAttribute: c.String(ldapTruthNameAttributeKey),
+		EmailAttribute:     c.String(ldapEmailAttributeKey),
+		PhoneAttribute:     c.String(ldapPhoneAttributeKey),
+	}
+	res.StartTLS, _ = c.Bool(ldapStartTLSKey)
+	res.InsecureSkipVerify, _ = c.Bool(ldapInsecureSkipVerifyKey)
+	if mapping := c.String(ldapGroupMappingKey); len(mapping) != 0 {
+		if err := json.Unmarshal([]byte(mapping), &res.GroupMapping); err != nil {
+			return nil, fmt.Errorf("unmarshal %v failed, %v", ldapGroupMappingKey, err)
+		}
+	}
+	return res, nil
+}
+
+// validLDAPUser binds the user to ldap, the local user is created at the first login, and its group,
+// roles and attributes are synchronized from ldap at every login
+func validLDAPUser(o orm.Ormer, name, password string, localUser *authdb.User) (bool, *authapi.User, error) {
+	ldapConf, err := ldapConfig()
+	if err != nil {
+		return false, nil, err
+	}
+	entry, err := ldapConf.Authenticate(name, password)
+	if err == ldapauth.ErrInvalidCredentials {
+		return false, nil, nil
+	}
+	if err != nil {
+		return false, nil, err
+	}
+	group, roles, err := mapLDAPGroups(o, ldapConf, entry)
+	if err == ldapauth.ErrNoGroupMapping {
+		glog.Errorf("ldap user[%v] isn't in any mapped group, groups: %v", name, entry.Groups)
+		return false, nil, nil
+	}
+	if err != nil {
+		return false, nil, err
+	}
+
+	user, err := provisionExternalUser(o, authdb.User{
+		Name:      name,
+		TruthName: entry.TruthName,
+		Email:     entry.Email,
+		PhoneNum:  entry.PhoneNum,
+		Group:     group,
+		Role:      roles,
+		Source:    authapi.LDAPUserSource,
+	}, localUser)
+	if err != nil {
+		return false, nil, err
+	}
+	return true, user, nil
+}
+
+func mapLDAPGroups(o orm.Ormer, ldapConf *ldapauth.Config, entry *ldapauth.Entry) (*authdb.Group, []*authdb.Role, error) {
+	groupName, roleNames, err := ldapConf.MapGroups(entry.Groups)
+	if err == ldapauth.ErrNoGroupMapping {
+		if allow, _ := config.GetConfig().Bool(ldapAllowUnmappedUsersKey); !allow {
+			return nil, nil, err
+		}
+	} else if err != nil {
+		return nil, nil, err
+	}
+	return resolveMappedGroup(o, groupName, roleNames)
+}
+
+// checkLDAPUser looks up the ldap user again when its refresh token is used, valid is false if the user
+// is removed from ldap or from the mapped groups. The login ends if the group or roles are changed,
+// since syncExternalUser revokes the tokens carrying the old ones
+func checkLDAPUser(o orm.Ormer, user authdb.User) (valid bool, err error) {
+	synced, found, err := lookupLDAPUser(o, user)
+	if err != nil || !found {
+		return false, err
+	}
+	return !isRoleOrGroupChanged(user, synced), nil
+}
+
+// lookupLDAPUser looks up the ldap user without the password, and synchronizes the group and roles as at login.
+// found is false if ldap is disabled, or the user is removed from ldap or from the mapped groups
+func lookupLDAPUser(o orm.Ormer, user authdb.User) (synced authdb.User, found bool, err error) {
+	if !isLDAPEnabled() {
+		return user, false, nil
+	}
+	ldapConf, err := ldapConfig()
+	if err != nil {
+		return user, false, err
+	}
+	entry, err := ldapConf.Lookup(user.Name)
+	if err == ldapauth.ErrInvalidCredentials {
+		glog.Errorf("ldap user[%v] isn't found", user.Name)
+		return user, false, nil
+	}
+	if err != nil {
+		return user, false, err
+	}
+	group, roles, err := mapLDAPGroups(o, ldapConf, entry)
+	if err == ldapauth.ErrNoGroupMapping {
+		glog.Errorf("ldap user[%v] isn't in any mapped group, groups: %v", user.Name, entry.Groups)
+		return user, false, nil
+	}
+	if err != nil {
+		return user, false, err
+	}
+
+	synced, err = syncExternalUser(o, user, authdb.User{
+		Name:      user.Name,
+		TruthName: entry.TruthName,
+		Email:     entry.Email,
+		PhoneNum:  entry.PhoneNum,
+		Group:     group,
+		Role:      roles,
+		Source:    authapi.LDAPUserSource,
+	})
+	if err != nil {
+		return user, false, err
+	}
+	return synced, true, nil
+}
@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/golang/glog"
+
+	authapi "imanager/pkg/api/auth"
+	"imanager/pkg/config"
+	authdb "imanager/pkg/db/auth"
+)
+
+const (
+	// the failures of a user before it's locked
+	loginMaxUserFailuresKey     = "LoginMaxUserFailures"
+	defaultLoginMaxUserFailures = 5
+	// the failures of a source ip before it's locked
+	loginMaxIPFailuresKey     = "LoginMaxIPFailures"
+	defaultLoginMaxIPFailures = 20
+	// minutes
+	loginLockoutDurationKey     = "LoginLockoutDuration"
+	defaultLoginLockoutDuration = 15
+	// minutes, the failures before the window are forgotten
+	loginFailureWindowKey     = "LoginFailureWindow"
+	defaultLoginFailureWindow = 15
+
+	// the delay after the first failure of a user, it's doubled after every failure
+	loginBaseDelay = time.Second
+	loginMaxDelay  = 30 * time.Second
+
+	loginFailureCleanInterval = time.Hour
+
+	userLoginSubjectPrefix = "user:"
+	ipLoginSubjectPrefix   = "ip:"
+)
+
+var (
+	ErrLoginLocked    = errors.New("too many failed logins, try again later")
+	ErrLoginThrottled = errors.New("login too fast after failures, try again later")
+)
+
+func init() {
+	go func() {
+		for range time.Tick(loginFailureCleanInterval) {
+			now := time.Now()
+			num, err := authdb.DeleteStaleLoginFailures(orm.NewOrm(), now.Add(-getMinutes(loginFailureWindowKey, defaultLoginFailureWindow)), now)
+			if err != nil {
+				glog.Errorf("delete stale login failures failed, err: %v", err)
+				continue
+			}
+			glog.Infof("delete %v stale login failures", num)
+		}
+	}()
+}
+
+func getMinutes(key string, defaultValue int) time.Duration {
+	minutes, err := config.GetConfig().Int(key)
+	if err != nil || minutes <= 0 {
+		minutes = defaultValue
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+func getPositiveInt(key string, defaultValue int) int {
+	value, err := config.GetConfig().Int(key)
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// loginDelay is the time the user should wait after the failures
+func loginDelay(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+	delay := loginBaseDelay
+	for i := 1; i < failures && delay < loginMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > loginMaxDelay {
+		delay = loginMaxDelay
+	}
+	return delay
+}
+
+// CheckLoginAllowed should be called before the password is checked. If the user or ip is locked or
+// throttled, the error and the duration to wait are returned
+func CheckLoginAllowed(name, ip string) (time.Duration, error) {
+	o := orm.NewOrm()
+	now := time.Now()
+	subjects := []string{userLoginSubjectPrefix + name}
+	if len(ip) != 0 {
+		subjects = append(subjects, ipLoginSubjectPrefix+ip)
+	}
+	for _, subject := range subjects {
+		failure, err := authdb.GetLoginFailure(o, subject)
+		if err == orm.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if failure.LockedUntil.After(now) {
+			return failure.LockedUntil.Sub(now), ErrLoginLocked
+		}
+		// only the user is throttled, the users behind the same ip should not wait for each other
+		if subject != subjects[0] {
+			continue
+		}
+		window := getMinutes(loginFailureWindowKey, defaultLoginFailureWindow)
+		if failure.LastFailureAt.Before(now.Add(-window)) {
+			continue
+		}
+		if next := failure.LastFailureAt.Add(loginDelay(failure.Failures)); next.After(now) {
+			return next.Sub(now), ErrLoginThrottled
+		}
+	}
+	return 0, nil
+}
+
+// RecordLoginFailure counts the failure of the user and ip, they are locked if reach the threshold
+func RecordLoginFailure(name, ip string) {
+	recordLoginFailure(userLoginSubjectPrefix+name, getPositiveInt(loginMaxUserFailuresKey, defaultLoginMaxUserFailures))
+	if len(ip) != 0 {
+		recordLoginFailure(ipLoginSubjectPrefix+ip, getPositiveInt(loginMaxIPFailuresKey, defaultLoginMaxIPFailures))
+	}
+}
+
+func recordLoginFailure(subject string, maxFailures int) {
+	o := orm.NewOrm()
+	now := time.Now()
+	failure, err := authdb.RecordLoginFailure(o, subject, now, getMinutes(loginFailureWindowKey, defaultLoginFailureWindow))
+	if err != nil {
+		glog.Errorf("record login failure of %v failed, err: %v", subject, err)
+		return
+	}
+	if failure.Failures < maxFailures {
+		return
+	}
+	until := now.Add(getMinutes(loginLockoutDurationKey, defaultLoginLockoutDuration))
+	glog.Warningf("%v failed to login %v times, lock it until %v", subject, failure.Failures, until)
+	if err = authdb.LockLoginSubject(o, subject, until); err != nil {
+		glog.Errorf("lock login of %v failed, err: %v", subject, err)
+	}
+}
+
+// ResetLoginFailures clears the failures of the user after a successful login, the failures of the ip are kept
+func ResetLoginFailures(name string) {
+	if err := authdb.DeleteLoginFailure(orm.NewOrm(), userLoginSubjectPrefix+name); err != nil {
+		glog.Errorf("reset login failures of user[%v] failed, err: %v", name, err)
+	}
+}
+
+// UnlockUser clears the failures and lockout of the user
+func UnlockUser(name string) error {
+	o := orm.NewOrm()
+	if _, err := authdb.GetUserByName(o, name); err != nil {
+		return err
+	}
+	glog.Infof("unlock user[%v]", name)
+	return authdb.DeleteLoginFailure(o, userLoginSubjectPrefix+name)
+}
+
+func GetUserLockout(name string) (*authapi.UserLockout, error) {
+	failure, err := authdb.GetLoginFailure(orm.NewOrm(), userLoginSubjectPrefix+name)
+	if err == orm.ErrNoRows {
+		return &authapi.UserLockout{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := &authapi.UserLockout{
+		Failures:      failure.Failures,
+		LastFailureAt: failure.LastFailureAt,
+	}
+	if failure.LockedUntil.After(time.Now()) {
+		res.Locked = true
+		res.LockedUntil = failure.LockedUntil
+	}
+	return res, nil
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+
+	"imanager/pkg/config"
+	"imanager/pkg/mail"
+)
+
+const (
+	// smtp or file, the mails are disabled if it's empty
+	mailSenderKey = "MailSender"
+	// host:port of the smtp server
+	smtpAddressKey  = "SMTPAddress"
+	smtpUsernameKey = "SMTPUsername"
+	smtpPasswordKey = "SMTPPassword"
+	mailFromKey     = "MailFrom"
+	// the directory where the file sender writes the mails
+	mailFileDirKey     = "MailFileDir"
+	defaultMailFileDir = "/tmp/imanager-mail"
+)
+
+var ErrMailDisabled = errors.New("mail sender isn't configured")
+
+// getMailSender returns the sender of the config, it's created at each time so that the config can be changed
+func getMailSender() (mail.Sender, error) {
+	c := config.GetConfig()
+	switch kind := c.String(mailSenderKey); kind {
+	case "":
+		return nil, ErrMailDisabled
+	case "smtp":
+		return &mail.SMTPSender{
+			Address:  c.String(smtpAddressKey),
+			Username: c.String(smtpUsernameKey),
+			Password: c.String(smtpPasswordKey),
+			From:     c.String(mailFromKey),
+		}, nil
+	case "file":
+		dir := c.String(mailFileDirKey)
+		if len(dir) == 0 {
+			dir = defaultMailFileDir
+		}
+		return &mail.FileSender{Dir: dir, From: c.String(mailFromKey)}, nil
+	default:
+		return nil, fmt.Errorf("unknown %v %q", mailSenderKey, kind)
+	}
+}
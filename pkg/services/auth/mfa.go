@@ -0,0 +1,286 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/golang/glog"
+
+	authapi "imanager/pkg/api/auth"
+	authdb "imanager/pkg/db/auth"
+	"imanager/pkg/encrypt"
+	"imanager/pkg/totp"
+)
+
+const (
+	recoveryCodeNum = 10
+	// bytes of a recovery code, it's hex encoded
+	recoveryCodeSize = 5
+)
+
+var (
+	ErrOTPRequired           = errors.New("otp is required")
+	ErrInvalidOTP            = errors.New("otp is invalid")
+	ErrMFAEnrollmentRequired = errors.New("mfa is required by the group, it should be enrolled")
+	ErrMFAAlreadyEnabled     = errors.New("mfa is already enabled, disable it first")
+	ErrMFANotEnrolled        = errors.New("mfa isn't enrolled")
+)
+
+// IsMFARequired checks the mfa policy of the user's group
+func IsMFARequired(user *authapi.User) (bool, error) {
+	if user.Group == nil {
+		return false, nil
+	}
+	group, err := authdb.GetGroupByID(orm.NewOrm(), user.Group.ID)
+	if err != nil {
+		return false, err
+	}
+	switch group.MFAPolicy {
+	case authapi.MFAPolicyRequired:
+		return true, nil
+	case authapi.MFAPolicyPrivileged:
+		permissions, err := GetPermissions(user.Role)
+		if err != nil {
+			return false, err
+		}
+		return len(permissions.InGroup) != 0 || len(permissions.AllGroups) != 0, nil
+	}
+	return false, nil
+}
+
+// ValidMFA checks the otp of the user at login. ErrMFAEnrollmentRequired is returned if mfa is mandatory
+// but the user hasn't enabled it, the caller should only allow the user to enroll mfa
+func ValidMFA(user *authapi.User, otp string) error {
+	o := orm.NewOrm()
+	mfa, err := authdb.GetUserMFA(o, user.UUID)
+	if err != nil && err != orm.ErrNoRows {
+		return err
+	}
+	if err == orm.ErrNoRows || !mfa.Enabled {
+		required, err := IsMFARequired(user)
+		if err != nil {
+			return err
+		}
+		if required {
+			return ErrMFAEnrollmentRequired
+		}
+		return nil
+	}
+	if len(otp) == 0 {
+		return ErrOTPRequired
+	}
+	if len(otp) == totp.Digits {
+		return useTOTP(o, mfa, otp, false)
+	}
+	return useRecoveryCode(o, mfa, otp)
+}
+
+func useTOTP(o orm.Ormer, mfa authdb.UserMFA, otp string, enable bool) error {
+	secret, outdated, err := encrypt.DecryptSecret(mfa.Secret)
+	if err != nil {
+		return fmt.Errorf("decrypt mfa secret failed, %v", err)
+	}
+	step, ok := totp.Validate(secret, otp, time.Now(), mfa.LastUsedStep)
+	if !ok {
+		return ErrInvalidOTP
+	}
+	// the step is saved conditionally, so the code can't be used twice even by different replicas
+	ok, err = authdb.UseUserMFAStep(o, mfa.Id, step, enable)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidOTP
+	}
+	if outdated {
+		reencryptMFASecret(o, mfa, secret)
+	}
+	return nil
+}
+
+// reencryptMFASecret encrypts the secret by the active key after the secret key is rotated,
+// it only logs the error since the otp is already accepted
+func reencryptMFASecret(o orm.Ormer, mfa authdb.UserMFA, secret string) {
+	encryptedSecret, err := encrypt.EncryptSecret(secret)
+	if err != nil {
+		glog.Errorf("encrypt mfa secret of user[%v] failed, err: %v", mfa.UserUUID, err)
+		return
+	}
+	if err = authdb.UpdateUserMFASecret(o, mfa.Id, mfa.Secret, encryptedSecret); err != nil {
+		glog.Errorf("update mfa secret of user[%v] failed, err: %v", mfa.UserUUID, err)
+		return
+	}
+	glog.Infof("mfa secret of user[%v] is encrypted by the active key", mfa.UserUUID)
+}
+
+func useRecoveryCode(o orm.Ormer, mfa authdb.UserMFA, code string) error {
+	hash := hashToken(strings.ToLower(strings.TrimSpace(code)))
+	codes := strings.Split(mfa.RecoveryCodes, ",")
+	left := make([]string, 0, len(codes))
+	found := false
+	for _, v := range codes {
+		if v == hash && !found {
+			found = true
+			continue
+		}
+		if len(v) != 0 {
+			left = append(left, v)
+		}
+	}
+	if !found {
+		return ErrInvalidOTP
+	}
+	ok, err := authdb.UseUserMFARecoveryCodes(o, mfa.Id, mfa.RecoveryCodes, strings.Join(left, ","))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidOTP
+	}
+	glog.Infof("recovery code of user[%v] is used, %v codes left", mfa.UserUUID, len(left))
+	return nil
+}
+
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeNum)
+	hashes := make([]string, 0, recoveryCodeNum)
+	for i := 0; i < recoveryCodeNum; i++ {
+		b := make([]byte, recoveryCodeSize)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := hex.EncodeToString(b)
+		codes = append(codes, code)
+		hashes = append(hashes, hashToken(code))
+	}
+	return codes, hashes, nil
+}
+
+// StartMFAEnrollment generates the secret and recovery codes, mfa is enabled after the first code is verified
+func StartMFAEnrollment(userName string) (*authapi.MFAEnrollment, error) {
+	o := orm.NewOrm()
+	user, err := authdb.GetUserByName(o, userName)
+	if err != nil {
+		return nil, err
+	}
+	mfa, err := authdb.GetUserMFA(o, user.UUID)
+	if err != nil && err != orm.ErrNoRows {
+		return nil, err
+	}
+	if err == nil && mfa.Enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	encryptedSecret, err := encrypt.EncryptSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	_, err = authdb.ResetUserMFA(o, authdb.UserMFA{
+		UserUUID:      user.UUID,
+		Secret:        encryptedSecret,
+		RecoveryCodes: strings.Join(hashes, ","),
+	})
+	if err != nil {
+		glog.Errorf("save mfa enrollment of user[%v/%v] failed, err: %v", user.Name, user.UUID, err)
+		return nil, err
+	}
+	return &authapi.MFAEnrollment{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(TokenIssuer(), user.Name, secret),
+		RecoveryCodes:   codes,
+	}, nil
+}
+
+// VerifyMFAEnrollment enables mfa if the otp is valid
+func VerifyMFAEnrollment(userName, otp string) error {
+	o := orm.NewOrm()
+	user, err := authdb.GetUserByName(o, userName)
+	if err != nil {
+		return err
+	}
+	mfa, err := authdb.GetUserMFA(o, user.UUID)
+	if err == orm.ErrNoRows {
+		return ErrMFANotEnrolled
+	}
+	if err != nil {
+		return err
+	}
+	if mfa.Enabled {
+		return ErrMFAAlreadyEnabled
+	}
+	if err = useTOTP(o, mfa, otp, true); err != nil {
+		return err
+	}
+	glog.Infof("mfa of user[%v/%v] is enabled", user.Name, user.UUID)
+	return nil
+}
+
+func GetMFAStatus(userName string) (*authapi.MFAStatus, error) {
+	o := orm.NewOrm()
+	user, err := authdb.GetUserByName(o, userName)
+	if err != nil {
+		return nil, err
+	}
+	res := &authapi.MFAStatus{}
+	userAPI := transformUserDB2API(user)
+	res.Required, err = IsMFARequired(&userAPI)
+	if err != nil {
+		return nil, err
+	}
+	mfa, err := authdb.GetUserMFA(o, user.UUID)
+	if err == orm.ErrNoRows {
+		return res, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	res.Enabled = mfa.Enabled
+	if mfa.Enabled && len(mfa.RecoveryCodes) != 0 {
+		res.RecoveryCodesLeft = len(strings.Split(mfa.RecoveryCodes, ","))
+	}
+	return res, nil
+}
+
+// DisableMFA removes the mfa of the user, the otp is checked unless the user is reset by admin
+func DisableMFA(userName, otp string, checkOTP bool) error {
+	o := orm.NewOrm()
+	user, err := authdb.GetUserByName(o, userName)
+	if err != nil {
+		return err
+	}
+	mfa, err := authdb.GetUserMFA(o, user.UUID)
+	if err == orm.ErrNoRows {
+		return ErrMFANotEnrolled
+	}
+	if err != nil {
+		return err
+	}
+	if checkOTP && mfa.Enabled {
+		if len(otp) == 0 {
+			return ErrOTPRequired
+		}
+		if len(otp) == totp.Digits {
+			err = useTOTP(o, mfa, otp, false)
+		} else {
+			err = useRecoveryCode(o, mfa, otp)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	glog.Infof("mfa of user[%v/%v] is disabled", user.Name, user.UUID)
+	return authdb.DeleteUserMFA(o, user.UUID)
+}
@@ -0,0 +1,364 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/golang/glog"
+	uuid "github.com/satori/go.uuid"
+
+	authapi "imanager/pkg/api/auth"
+	apiutil "imanager/pkg/api/util"
+	authdb "imanager/pkg/db/auth"
+)
+
+var (
+	ErrInvalidOAuthClient = errors.New("client is unknown or its secret is invalid")
+	ErrOAuthClientRole    = errors.New("some roles of the client aren't exist")
+)
+
+// CreateOAuthClient registers the client in the group, the secret is only returned here
+func CreateOAuthClient(client *authapi.OAuthClient, creator string) (*authapi.OAuthClient, error) {
+	o := orm.NewOrm()
+	group, err := authdb.GetGroupByName(o, client.Group.Name)
+	if err != nil {
+		return nil, err
+	}
+	secret := ""
+	secretHash := ""
+	if !client.Public {
+		if secret, err = randomToken(32); err != nil {
+			return nil, err
+		}
+		secretHash = hashToken(secret)
+	}
+	if len(client.Role) == 0 {
+		client.Role = DefaultRole
+	}
+	roleIDs, roles, err := getOAuthClientRoles(o, client.Role)
+	if err != nil {
+		return nil, err
+	}
+	clientDB, err := authdb.CreateOAuthClient(o, authdb.OAuthClient{
+		ClientID:      uuid.NewV4().String(),
+		SecretHash:    secretHash,
+		Name:          client.Name,
+		RedirectURIs:  strings.Join(client.RedirectURIs, "\n"),
+		Public:        client.Public,
+		GroupID:       group.Id,
+		GrantTypes:    strings.Join(client.GrantTypes, " "),
+		AllowedScopes: strings.Join(client.AllowedScopes, " "),
+		Roles:         roleIDs,
+		CreatedBy:     creator,
+	})
+	if err != nil {
+		glog.Errorf("create oauth client[%v] failed, err: %v", client.Name, err)
+		return nil, err
+	}
+	res := transformOAuthClientDB2API(clientDB, group, roles)
+	res.ClientSecret = secret
+	return &res, nil
+}
+
+// getOAuthClientRoles checks the roles exist, it returns the comma separated role ids and the roles
+func getOAuthClientRoles(o orm.Ormer, roles []authapi.RoleInUser) (string, []authdb.Role, error) {
+	ids := make([]int, 0, len(roles))
+	strs := make([]string, 0, len(roles))
+	for _, v := range roles {
+		ids = append(ids, v.ID)
+		strs = append(strs, strconv.Itoa(v.ID))
+	}
+	res, err := authdb.ListRoleByIDs(o, ids)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(res) != len(ids) {
+		return "", nil, ErrOAuthClientRole
+	}
+	return strings.Join(strs, ","), res, nil
+}
+
+func getOAuthClient(o orm.Ormer, clientID string) (*authapi.OAuthClient, authdb.OAuthClient, error) {
+	client, err := authdb.GetOAuthClient(o, clientID)
+	if err != nil {
+		return nil, client, err
+	}
+	group, err := authdb.GetGroupByID(o, client.GroupID)
+	if err != nil {
+		return nil, client, err
+	}
+	ids := make([]int, 0)
+	for _, v := range strings.Split(client.Roles, ",") {
+		if id, err := strconv.Atoi(v); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	roles, err := authdb.ListRoleByIDs(o, ids)
+	if err != nil {
+		return nil, client, err
+	}
+	res := transformOAuthClientDB2API(client, group, roles)
+	return &res, client, nil
+}
+
+func GetOAuthClient(clientID string) (*authapi.OAuthClient, error) {
+	res, _, err := getOAuthClient(orm.NewOrm(), clientID)
+	return res, err
+}
+
+// ListOAuthClients returns the clients of the group, or all clients if the group name is empty
+func ListOAuthClients(groupName string) ([]authapi.OAuthClient, int64, error) {
+	o := orm.NewOrm()
+	groupID := 0
+	if len(groupName) != 0 {
+		group, err := authdb.GetGroupByName(o, groupName)
+		if err != nil {
+			return nil, 0, err
+		}
+		groupID = group.Id
+	}
+	clients, num, err := authdb.ListOAuthClients(o, groupID)
+	if err != nil {
+		return nil, 0, err
+	}
+	res := make([]authapi.OAuthClient, 0, len(clients))
+	for _, v := range clients {
+		client, _, err := getOAuthClient(o, v.ClientID)
+		if err != nil {
+			return nil, 0, err
+		}
+		res = append(res, *client)
+	}
+	return res, num, nil
+}
+
+// UpdateOAuthClient updates the client except its id, secret and whether it's public. The client tokens
+// are revoked if the roles, group or scopes are changed
+func UpdateOAuthClient(client *authapi.OAuthClient) (*authapi.OAuthClient, error) {
+	o := orm.NewOrm()
+	oldClient, clientDB, err := getOAuthClient(o, client.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	oldDB := clientDB
+	if len(client.Name) != 0 {
+		clientDB.Name = client.Name
+	}
+	if client.RedirectURIs != nil {
+		clientDB.RedirectURIs = strings.Join(client.RedirectURIs, "\n")
+	}
+	if client.Group != nil && len(client.Group.Name) != 0 && client.Group.Name != oldClient.Group.Name {
+		group, err := authdb.GetGroupByName(o, client.Group.Name)
+		if err != nil {
+			return nil, err
+		}
+		clientDB.GroupID = group.Id
+	}
+	if client.GrantTypes != nil {
+		clientDB.GrantTypes = strings.Join(client.GrantTypes, " ")
+	}
+	if client.AllowedScopes != nil {
+		clientDB.AllowedScopes = strings.Join(client.AllowedScopes, " ")
+	}
+	if client.Role != nil {
+		if clientDB.Roles, _, err = getOAuthClientRoles(o, client.Role); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = o.Begin(); err != nil {
+		return nil, err
+	}
+	if err = authdb.UpdateOAuthClient(o, clientDB); err != nil {
+		_ = o.Rollback()
+		return nil, err
+	}
+	if oldDB.GroupID != clientDB.GroupID || oldDB.Roles != clientDB.Roles ||
+		oldDB.AllowedScopes != clientDB.AllowedScopes || oldDB.GrantTypes != clientDB.GrantTypes {
+		if err = revokeUserTokens(o, oldClient.Subject()); err != nil {
+			_ = o.Rollback()
+			return nil, err
+		}
+	}
+	_ = o.Commit()
+	res, _, err := getOAuthClient(o, client.ClientID)
+	return res, err
+}
+
+// RotateOAuthClientSecret replaces the secret of the confidential client, the old secret and the client tokens
+// stop working at once
+func RotateOAuthClientSecret(clientID string) (*authapi.OAuthClient, error) {
+	o := orm.NewOrm()
+	client, clientDB, err := getOAuthClient(o, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if clientDB.Public {
+		return nil, errors.New("public client has no secret")
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	if err = o.Begin(); err != nil {
+		return nil, err
+	}
+	if err = authdb.UpdateOAuthClientSecret(o, clientID, hashToken(secret)); err != nil {
+		_ = o.Rollback()
+		return nil, err
+	}
+	if err = revokeUserTokens(o, client.Subject()); err != nil {
+		_ = o.Rollback()
+		return nil, err
+	}
+	_ = o.Commit()
+	client.ClientSecret = secret
+	return client, nil
+}
+
+// DeleteOAuthClient deletes the client and revokes its client tokens, and the refresh tokens and sessions
+// of the users issued to it
+func DeleteOAuthClient(clientID string) error {
+	o := orm.NewOrm()
+	if err := o.Begin(); err != nil {
+		return err
+	}
+	if err := authdb.DeleteOAuthClient(o, clientID); err != nil {
+		_ = o.Rollback()
+		return err
+	}
+	if err := revokeUserTokens(o, authapi.ClientSubjectPrefix+clientID); err != nil {
+		_ = o.Rollback()
+		return err
+	}
+	families, err := authdb.RevokeRefreshTokensByClient(o, clientID)
+	if err != nil {
+		glog.Errorf("revoke refresh tokens of client %v failed, err: %v", clientID, err)
+		_ = o.Rollback()
+		return err
+	}
+	for _, v := range families {
+		session, err := authdb.GetSession(o, v)
+		if err == orm.ErrNoRows {
+			continue
+		}
+		if err == nil {
+			err = revokeSession(o, session)
+		}
+		if err != nil {
+			_ = o.Rollback()
+			return err
+		}
+	}
+	return o.Commit()
+}
+
+// AuthenticateOAuthClient checks the secret of the confidential client, the public client has no secret
+func AuthenticateOAuthClient(clientID, secret string) (*authapi.OAuthClient, error) {
+	if len(clientID) == 0 {
+		return nil, ErrInvalidOAuthClient
+	}
+	client, clientDB, err := getOAuthClient(orm.NewOrm(), clientID)
+	if err == orm.ErrNoRows {
+		return nil, ErrInvalidOAuthClient
+	}
+	if err != nil {
+		return nil, err
+	}
+	if clientDB.Public {
+		if len(secret) != 0 {
+			return nil, ErrInvalidOAuthClient
+		}
+	} else if subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(clientDB.SecretHash)) != 1 {
+		return nil, ErrInvalidOAuthClient
+	}
+	return client, nil
+}
+
+// CreateClientToken issues the token of the client itself by client credentials grant. The subject of the token
+// is the client, and it has the group and roles of the client, so that it's checked by the controllers as a user.
+// There is no refresh token or session, the client authenticates again when the token expires
+func CreateClientToken(client *authapi.OAuthClient, actions []string) (*authapi.RespToken, string, error) {
+	user := &authapi.User{
+		UUID:      client.Subject(),
+		Name:      client.Subject(),
+		TruthName: client.Name,
+		Group:     client.Group,
+		Role:      client.Role,
+	}
+	scope, err := ValidTokenScope(user, authapi.ReqTokenScope{Actions: actions})
+	if err != nil {
+		return nil, "", err
+	}
+	policy, err := GetTokenPolicy(user)
+	if err != nil {
+		return nil, "", err
+	}
+	duration, err := TokenDuration(policy, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	issuedAt := time.Now()
+	res := &authapi.RespToken{
+		ExpiresAt: issuedAt.Add(duration),
+		IssuedAt:  issuedAt,
+		UserID:    user.UUID,
+		Name:      user.Name,
+		TrueName:  user.TruthName,
+		Group:     user.Group,
+		Role:      user.Role,
+		TokenType: authapi.ClientTokenType,
+		Scope:     scope,
+		ClientID:  client.ClientID,
+	}
+	tokenss, err := CreateToken(res)
+	if err != nil {
+		glog.Errorf("create token of client[%v/%v] failed, err: %v", client.Name, client.ClientID, err)
+		return nil, "", err
+	}
+	return res, tokenss, nil
+}
+
+func splitFields(s, sep string) []string {
+	res := make([]string, 0)
+	for _, v := range strings.Split(s, sep) {
+		if len(v) != 0 {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+func transformOAuthClientDB2API(in authdb.OAuthClient, group authdb.Group, roles []authdb.Role) authapi.OAuthClient {
+	res := authapi.OAuthClient{
+		ClientID:     in.ClientID,
+		Name:         in.Name,
+		RedirectURIs: splitFields(in.RedirectURIs, "\n"),
+		Public:       in.Public,
+		Group: &authapi.GroupInUser{
+			ID:         group.Id,
+			Name:       group.Name,
+			Annotation: group.Annotation,
+		},
+		GrantTypes:    splitFields(in.GrantTypes, " "),
+		AllowedScopes: splitFields(in.AllowedScopes, " "),
+		Role:          make([]authapi.RoleInUser, 0, len(roles)),
+		CreatedBy:     in.CreatedBy,
+		BaseModel: apiutil.BaseModel{
+			CreateTimestamp: in.CreateTimestamp,
+			UpdateTimestamp: in.UpdateTimestamp,
+		},
+	}
+	for _, v := range roles {
+		res.Role = append(res.Role, authapi.RoleInUser{
+			ID:         v.Id,
+			Name:       v.Name,
+			Annotation: v.Annotation,
+		})
+	}
+	return res
+}
@@ -0,0 +1,295 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/golang/glog"
+
+	authapi "imanager/pkg/api/auth"
+	"imanager/pkg/config"
+	authdb "imanager/pkg/db/auth"
+	"imanager/pkg/oidcclient"
+)
+
+const (
+	// the login by the upstream openid connect provider is enabled if the issuer is set
+	oidcIssuerKey       = "OIDCIssuer"
+	oidcClientIDKey     = "OIDCClientID"
+	oidcClientSecretKey = "OIDCClientSecret"
+	// the callback of imanager registered in the provider, such as https://imanager/v1/auth/oidc/callback
+	oidcRedirectURLKey = "OIDCRedirectURL"
+	// space separated, openid is always requested
+	oidcScopesKey        = "OIDCScopes"
+	defaultOIDCScopes    = "openid profile email"
+	oidcUsernameClaimKey = "OIDCUsernameClaim"
+	oidcEmailClaimKey    = "OIDCEmailClaim"
+	oidcNameClaimKey     = "OIDCNameClaim"
+	oidcGroupsClaimKey   = "OIDCGroupsClaim"
+	// json array of oidcclient.GroupMapping
+	oidcGroupMappingKey = "OIDCGroupMapping"
+	// the users in none of the mapped groups are rejected, unless it's true,
+	// then they are created in the default group with the default role
+	oidcAllowUnmappedUsersKey = "OIDCAllowUnmappedUsers"
+	// the frontend where the user agent is redirected with the tokens after the login,
+	// the tokens are returned in the response body if it isn't set
+	oidcLoginReturnURLKey = "OIDCLoginReturnURL"
+	// space separated acr values which mean the user is authenticated with mfa by the provider,
+	// the amr of mfa in rfc 8176 is always accepted
+	oidcMFAACRValuesKey = "OIDCMFAACRValues"
+	oidcMFAAMRValue     = "mfa"
+
+	// OIDCLoginStateDuration is how long the login can be finished by the callback
+	OIDCLoginStateDuration      = 10 * time.Minute
+	oidcLoginStateCleanInterval = time.Hour
+)
+
+var (
+	ErrOIDCDisabled          = errors.New("oidc login isn't enabled")
+	ErrInvalidOIDCLoginState = errors.New("oidc login state is invalid or expired")
+	ErrOIDCUserConflict      = errors.New("a user of the same name isn't from oidc")
+	ErrOIDCUserNotMapped     = errors.New("oidc user isn't in any mapped group")
+	ErrOIDCMFARequired       = errors.New("mfa is required by the group, but the oidc provider didn't authenticate the user with mfa")
+
+	oidcProvider = struct {
+		sync.Mutex
+		provider *oidcclient.Provider
+	}{}
+)
+
+func init() {
+	go func() {
+		for range time.Tick(oidcLoginStateCleanInterval) {
+			num, err := authdb.DeleteExpiredOIDCLoginStates(orm.NewOrm(), time.Now())
+			if err != nil {
+				glog.Errorf("delete expired oidc login states failed, err: %v", err)
+				continue
+			}
+			glog.Infof("delete %v expired oidc login states", num)
+		}
+	}()
+}
+
+func IsOIDCEnabled() bool {
+	return len(config.GetConfig().String(oidcIssuerKey)) != 0
+}
+
+func oidcConfig() (*oidcclient.Config, error) {
+	c := config.GetConfig()
+	scopes := c.String(oidcScopesKey)
+	if len(scopes) == 0 {
+		scopes = defaultOIDCScopes
+	}
+	res := &oidcclient.Config{
+		Issuer:        c.String(oidcIssuerKey),
+		ClientID:      c.String(oidcClientIDKey),
+		ClientSecret:  c.String(oidcClientSecretKey),
+		RedirectURL:   c.String(oidcRedirectURLKey),
+		Scopes:        strings.Fields(scopes),
+		UsernameClaim: c.String(oidcUsernameClaimKey),
+		EmailClaim:    c.String(oidcEmailClaimKey),
+		NameClaim:     c.String(oidcNameClaimKey),
+		GroupsClaim:   c.String(oidcGroupsClaimKey),
+	}
+	if mapping := c.String(oidcGroupMappingKey); len(mapping) != 0 {
+		if err := json.Unmarshal([]byte(mapping), &res.GroupMapping); err != nil {
+			return nil, fmt.Errorf("unmarshal %v failed, %v", oidcGroupMappingKey, err)
+		}
+	}
+	return res, nil
+}
+
+// getOIDCProvider discovers the provider at the first login, it's retried at the next login if it fails
+func getOIDCProvider() (*oidcclient.Provider, error) {
+	if !IsOIDCEnabled() {
+		return nil, ErrOIDCDisabled
+	}
+	oidcProvider.Lock()
+	defer oidcProvider.Unlock()
+	if oidcProvider.provider != nil {
+		return oidcProvider.provider, nil
+	}
+	oidcConf, err := oidcConfig()
+	if err != nil {
+		return nil, err
+	}
+	provider, err := oidcclient.NewProvider(*oidcConf)
+	if err != nil {
+		glog.Errorf("discover oidc provider %v failed, err: %v", oidcConf.Issuer, err)
+		return nil, err
+	}
+	oidcProvider.provider = provider
+	return provider, nil
+}
+
+func OIDCLoginReturnURL() string {
+	return config.GetConfig().String(oidcLoginReturnURLKey)
+}
+
+// StartOIDCLogin saves the state, nonce and pkce verifier of the login, and returns the url of the provider
+// where the user agent is redirected. The state should be bound to the user agent, see FinishOIDCLogin
+func StartOIDCLogin() (authURL, state string, err error) {
+	provider, err := getOIDCProvider()
+	if err != nil {
+		return "", "", err
+	}
+	state, err = oidcclient.NewRandom()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := oidcclient.NewRandom()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, challenge, err := oidcclient.NewPKCE()
+	if err != nil {
+		return "", "", err
+	}
+	_, err = authdb.CreateOIDCLoginState(orm.NewOrm(), authdb.OIDCLoginState{
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(OIDCLoginStateDuration),
+	})
+	if err != nil {
+		glog.Errorf("create oidc login state failed, err: %v", err)
+		return "", "", err
+	}
+	return provider.AuthCodeURL(state, nonce, challenge), state, nil
+}
+
+// FinishOIDCLogin consumes the state of the callback, redeems the code and verifies the id token.
+// boundState is the state kept by the user agent which started the login, so that the callback
+// of the login started by the others is rejected
+func FinishOIDCLogin(state, boundState, code string) (*oidcclient.Identity, error) {
+	provider, err := getOIDCProvider()
+	if err != nil {
+		return nil, err
+	}
+	if len(state) == 0 || subtle.ConstantTimeCompare([]byte(state), []byte(boundState)) != 1 {
+		return nil, ErrInvalidOIDCLoginState
+	}
+	loginState, err := authdb.UseOIDCLoginState(orm.NewOrm(), state)
+	if err == orm.ErrNoRows {
+		return nil, ErrInvalidOIDCLoginState
+	}
+	if err != nil {
+		return nil, err
+	}
+	if loginState.ExpiresAt.Before(time.Now()) {
+		return nil, ErrInvalidOIDCLoginState
+	}
+	rawIDToken, err := provider.Exchange(code, loginState.CodeVerifier)
+	if err != nil {
+		return nil, err
+	}
+	return provider.VerifyIDToken(rawIDToken, loginState.Nonce)
+}
+
+// ProvisionOIDCUser creates the local user of the identity at the first login, and synchronizes
+// its group, roles and attributes at every login. The user is matched by the issuer and subject,
+// the user name of the identity is only used when the user is created. The local and ldap users
+// are never taken over
+func ProvisionOIDCUser(identity *oidcclient.Identity) (*authapi.User, error) {
+	oidcConf, err := oidcConfig()
+	if err != nil {
+		return nil, err
+	}
+	o := orm.NewOrm()
+	localUser, err := getOIDCUser(o, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	groupName, roleNames, err := oidcConf.MapGroups(identity.Groups)
+	if err == oidcclient.ErrNoGroupMapping {
+		if allow, _ := config.GetConfig().Bool(oidcAllowUnmappedUsersKey); !allow {
+			glog.Errorf("oidc user[%v] isn't in any mapped group, groups: %v", identity.Username, identity.Groups)
+			return nil, ErrOIDCUserNotMapped
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	group, roles, err := resolveMappedGroup(o, groupName, roleNames)
+	if err != nil {
+		return nil, err
+	}
+
+	user := authdb.User{
+		Name:            identity.Username,
+		TruthName:       identity.Name,
+		Email:           identity.Email,
+		Group:           group,
+		Role:            roles,
+		Source:          authapi.OIDCUserSource,
+		ExternalIssuer:  identity.Issuer,
+		ExternalSubject: identity.Subject,
+	}
+	if localUser != nil {
+		// the user name may be changed in the provider, and the phone number isn't asserted by it
+		user.Name, user.PhoneNum = localUser.Name, localUser.PhoneNum
+	}
+	return provisionExternalUser(o, user, localUser)
+}
+
+// getOIDCUser finds the local user of the identity, it's nil if the user should be created. The oidc user
+// created before the subject is recorded is linked to the subject at its next login by the user name
+func getOIDCUser(o orm.Ormer, identity *oidcclient.Identity) (*authdb.User, error) {
+	userDB, err := authdb.GetUserByExternalID(o, identity.Issuer, identity.Subject)
+	if err == nil {
+		if userDB.Source != authapi.OIDCUserSource {
+			return nil, ErrOIDCUserConflict
+		}
+		return &userDB, nil
+	}
+	if err != orm.ErrNoRows {
+		return nil, err
+	}
+
+	userDB, err = authdb.GetUserByName(o, identity.Username)
+	if err == orm.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if userDB.Source != authapi.OIDCUserSource || len(userDB.ExternalSubject) != 0 {
+		glog.Errorf("user[%v] isn't the oidc subject %v of %v", userDB.Name, identity.Subject, identity.Issuer)
+		return nil, ErrOIDCUserConflict
+	}
+	glog.Infof("link oidc user[%v/%v] to subject %v of %v", userDB.Name, userDB.UUID, identity.Subject, identity.Issuer)
+	if err = authdb.UpdateUserExternalID(o, userDB.UUID, identity.Issuer, identity.Subject); err != nil {
+		return nil, err
+	}
+	userDB.ExternalIssuer, userDB.ExternalSubject = identity.Issuer, identity.Subject
+	return &userDB, nil
+}
+
+// CheckOIDCMFA rejects the login if mfa is required by the group of the user, but the provider
+// doesn't assert it by the amr or acr of the id token
+func CheckOIDCMFA(user *authapi.User, identity *oidcclient.Identity) error {
+	required, err := IsMFARequired(user)
+	if err != nil || !required {
+		return err
+	}
+	for _, v := range identity.AMR {
+		if v == oidcMFAAMRValue {
+			return nil
+		}
+	}
+	if len(identity.ACR) != 0 {
+		for _, v := range strings.Fields(config.GetConfig().String(oidcMFAACRValuesKey)) {
+			if v == identity.ACR {
+				return nil
+			}
+		}
+	}
+	glog.Errorf("mfa is required for oidc user[%v], but acr is %q and amr is %v", user.Name, identity.ACR, identity.AMR)
+	return ErrOIDCMFARequired
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/astaxie/beego/orm"
+
+	authapi "imanager/pkg/api/auth"
+	"imanager/pkg/config"
+	authdb "imanager/pkg/db/auth"
+	"imanager/pkg/oidcclient"
+)
+
+func setTestConfig(t *testing.T, key, value string) {
+	old := config.GetConfig().String(key)
+	if err := config.GetConfig().Set(key, value); err != nil {
+		t.Fatalf("set config %v failed, err: %v", key, err)
+	}
+	t.Cleanup(func() { _ = config.GetConfig().Set(key, old) })
+}
+
+func testOIDCIdentity(subject, username string) *oidcclient.Identity {
+	return &oidcclient.Identity{
+		Issuer:   "https://idp.example.com",
+		Subject:  subject,
+		Username: username,
+		Groups:   []string{"dev"},
+	}
+}
+
+// createTestMappedRole creates the role r1 after the builtin roles, since the users of op service role
+// are always in the op service group
+func createTestMappedRole(t *testing.T, o orm.Ormer) {
+	for _, v := range []string{"op_service", "admin", "user", "r1"} {
+		createTestRole(t, o, v)
+	}
+}
+
+func TestProvisionOIDCUser(t *testing.T) {
+	o := setupTestDB(t)
+	createTestMappedRole(t, o)
+	createTestUser(t, o, "local", "g1")
+	setTestConfig(t, oidcGroupMappingKey, `[{"claim_group":"dev","group":"g1","roles":["r1"]}]`)
+
+	user, err := ProvisionOIDCUser(testOIDCIdentity("sub1", "alice"))
+	if err != nil || user.Name != "alice" || user.Source != authapi.OIDCUserSource {
+		t.Fatalf("oidc user should be created: %+v, err: %v", user, err)
+	}
+	// the user name is changed in the provider
+	renamed, err := ProvisionOIDCUser(testOIDCIdentity("sub1", "alice2"))
+	if err != nil || renamed.UUID != user.UUID || renamed.Name != "alice" {
+		t.Logf("oidc user should be matched by the subject: %+v, err: %v", renamed, err)
+		t.Fail()
+	}
+
+	cases := []struct {
+		name     string
+		identity *oidcclient.Identity
+	}{
+		{"other subject of the same name", testOIDCIdentity("sub2", "alice")},
+		{"local user", testOIDCIdentity("sub3", "local")},
+	}
+	for _, c := range cases {
+		if _, err = ProvisionOIDCUser(c.identity); err != ErrOIDCUserConflict {
+			t.Logf("%v: user shouldn't be taken over, err: %v", c.name, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestProvisionOIDCUserCreatedBefore(t *testing.T) {
+	o := setupTestDB(t)
+	createTestMappedRole(t, o)
+	setTestConfig(t, oidcGroupMappingKey, `[{"claim_group":"dev","group":"g1","roles":["r1"]}]`)
+	legacy := createTestUser(t, o, "bob", "g1")
+	legacy.Source = authapi.OIDCUserSource
+	if _, err := o.Update(&legacy, "source"); err != nil {
+		t.Fatalf("update source of user failed, err: %v", err)
+	}
+
+	user, err := ProvisionOIDCUser(testOIDCIdentity("sub1", "bob"))
+	if err != nil || user.UUID != legacy.UUID {
+		t.Fatalf("oidc user created before should be linked: %+v, err: %v", user, err)
+	}
+	linked, err := authdb.GetUserByExternalID(o, "https://idp.example.com", "sub1")
+	if err != nil || linked.UUID != legacy.UUID {
+		t.Logf("subject of the user should be recorded: %+v, err: %v", linked, err)
+		t.Fail()
+	}
+	if _, err = authdb.GetUserByExternalID(o, "https://other.example.com", "sub1"); err != orm.ErrNoRows {
+		t.Logf("subject of the other issuer shouldn't match, err: %v", err)
+		t.Fail()
+	}
+}
+
+func TestCheckOIDCMFA(t *testing.T) {
+	o := setupTestDB(t)
+	userDB := createTestUser(t, o, "u1", "g1")
+	userDB.Group.MFAPolicy = authapi.MFAPolicyRequired
+	if _, err := o.Update(userDB.Group, "mfa_policy"); err != nil {
+		t.Fatalf("update mfa policy of group failed, err: %v", err)
+	}
+	user := transformUserDB2API(userDB)
+	setTestConfig(t, oidcMFAACRValuesKey, "urn:example:mfa gold")
+
+	cases := []struct {
+		name     string
+		identity *oidcclient.Identity
+		err      error
+	}{
+		{"no amr", &oidcclient.Identity{}, ErrOIDCMFARequired},
+		{"password only", &oidcclient.Identity{AMR: []string{"pwd"}}, ErrOIDCMFARequired},
+		{"other acr", &oidcclient.Identity{ACR: "silver"}, ErrOIDCMFARequired},
+		{"mfa amr", &oidcclient.Identity{AMR: []string{"pwd", "mfa"}}, nil},
+		{"mfa acr", &oidcclient.Identity{ACR: "gold"}, nil},
+	}
+	for _, c := range cases {
+		if err := CheckOIDCMFA(&user, c.identity); err != c.err {
+			t.Logf("%v: err should be %v, got %v", c.name, c.err, err)
+			t.Fail()
+		}
+	}
+
+	userDB.Group.MFAPolicy = authapi.MFAPolicyOptional
+	if _, err := o.Update(userDB.Group, "mfa_policy"); err != nil {
+		t.Fatalf("update mfa policy of group failed, err: %v", err)
+	}
+	if err := CheckOIDCMFA(&user, &oidcclient.Identity{}); err != nil {
+		t.Logf("mfa shouldn't be required by the optional policy, err: %v", err)
+		t.Fail()
+	}
+}
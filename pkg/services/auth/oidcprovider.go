@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/golang/glog"
+
+	authapi "imanager/pkg/api/auth"
+	authdb "imanager/pkg/db/auth"
+	"imanager/pkg/oidcclient"
+)
+
+const (
+	authorizationCodeDuration      = 5 * time.Minute
+	authorizationCodeCleanInterval = time.Hour
+)
+
+var (
+	ErrIssuerNotURL             = errors.New("TokenIssuer should be the external url of imanager to serve openid connect")
+	ErrInvalidAuthorizationCode = errors.New("authorization code is invalid or expired")
+)
+
+func init() {
+	go func() {
+		for range time.Tick(authorizationCodeCleanInterval) {
+			num, err := authdb.DeleteExpiredOAuthAuthorizationCodes(orm.NewOrm(), time.Now())
+			if err != nil {
+				glog.Errorf("delete expired authorization codes failed, err: %v", err)
+				continue
+			}
+			glog.Infof("delete %v expired authorization codes", num)
+		}
+	}()
+}
+
+// GetOIDCDiscovery returns the metadata of imanager as an openid connect provider
+func GetOIDCDiscovery() (*authapi.OIDCDiscovery, error) {
+	issuer := TokenIssuer()
+	u, err := url.Parse(issuer)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || len(u.Host) == 0 {
+		return nil, ErrIssuerNotURL
+	}
+	base := strings.TrimSuffix(issuer, "/")
+	algs := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, v := range signingKeys.publicKeys() {
+		if alg := v.Method.Alg(); !seen[alg] {
+			seen[alg] = true
+			algs = append(algs, alg)
+		}
+	}
+	return &authapi.OIDCDiscovery{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             base + authapi.OAuthAuthorizeURL,
+		TokenEndpoint:                     base + authapi.OAuthTokenURL,
+		UserInfoEndpoint:                  base + authapi.OAuthUserInfoURL,
+		JWKSURI:                           base + authapi.JWKSURL,
+		ScopesSupported:                   []string{authapi.OpenIDScope, authapi.ProfileScope, authapi.EmailScope, authapi.GroupsScope},
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{authapi.AuthorizationCodeGrantType, authapi.RefreshTokenGrantType, authapi.ClientCredentialsGrantType, authapi.DeviceCodeGrantType},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  algs,
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported: []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce",
+			"preferred_username", "name", "email", "group", "roles"},
+		DeviceAuthorizationEndpoint: base + authapi.OAuthDeviceAuthorizationURL,
+	}, nil
+}
+
+// OAuthTokenScope returns the scope of the access token issued to the client, it can always read the userinfo,
+// and the other actions should be requested explicitly
+func OAuthTokenScope(user *authapi.User, actions []string) (*authapi.TokenScope, error) {
+	actions = append([]string{authapi.UserInfoResource + ":" + authapi.ReadVerb}, actions...)
+	return ValidTokenScope(user, authapi.ReqTokenScope{Actions: actions})
+}
+
+// CreateAuthorizationCode issues the code to the client after the user logins, the code challenge is the S256 one of pkce
+func CreateAuthorizationCode(clientID string, user *authapi.User, redirectURI, scope, nonce, codeChallenge string) (string, error) {
+	code, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	_, err = authdb.CreateOAuthAuthorizationCode(orm.NewOrm(), authdb.OAuthAuthorizationCode{
+		CodeHash:      hashToken(code),
+		ClientID:      clientID,
+		UserUUID:      user.UUID,
+		RedirectURI:   redirectURI,
+		Scope:         scope,
+		Nonce:         nonce,
+		CodeChallenge: codeChallenge,
+		AuthTime:      now,
+		ExpiresAt:     now.Add(authorizationCodeDuration),
+	})
+	if err != nil {
+		glog.Errorf("create authorization code of user[%v] for client %v failed, err: %v", user.Name, clientID, err)
+		return "", err
+	}
+	return code, nil
+}
+
+// AuthorizationGrant is what the user granted to the client by the authorization code
+type AuthorizationGrant struct {
+	User     *authapi.User
+	Scope    string
+	Nonce    string
+	AuthTime time.Time
+}
+
+// ExchangeAuthorizationCode consumes the code, the redirect uri and the client should be the same as the authorization,
+// and the code verifier should match the code challenge
+func ExchangeAuthorizationCode(clientID, code, redirectURI, codeVerifier string) (*AuthorizationGrant, error) {
+	if len(code) == 0 {
+		return nil, ErrInvalidAuthorizationCode
+	}
+	o := orm.NewOrm()
+	authCode, err := authdb.UseOAuthAuthorizationCode(o, hashToken(code))
+	if err == orm.ErrNoRows {
+		return nil, ErrInvalidAuthorizationCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	if authCode.ExpiresAt.Before(time.Now()) || authCode.ClientID != clientID || authCode.RedirectURI != redirectURI {
+		return nil, ErrInvalidAuthorizationCode
+	}
+	if len(authCode.CodeChallenge) != 0 || len(codeVerifier) != 0 {
+		challenge := oidcclient.CodeChallenge(codeVerifier)
+		if len(codeVerifier) == 0 || subtle.ConstantTimeCompare([]byte(challenge), []byte(authCode.CodeChallenge)) != 1 {
+			return nil, ErrInvalidAuthorizationCode
+		}
+	}
+	user, err := authdb.GetUserByUUID(o, authCode.UserUUID)
+	if err == orm.ErrNoRows {
+		return nil, ErrInvalidAuthorizationCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	user.Password = ""
+	userAPI := transformUserDB2API(user)
+	return &AuthorizationGrant{
+		User:     &userAPI,
+		Scope:    authCode.Scope,
+		Nonce:    authCode.Nonce,
+		AuthTime: authCode.AuthTime,
+	}, nil
+}
+
+// getUserInfo returns the claims of the openid connect scopes
+func getUserInfo(user *authapi.User, scopes []string) authapi.UserInfo {
+	res := authapi.UserInfo{Subject: user.UUID}
+	if authapi.HasScope(scopes, authapi.ProfileScope) {
+		res.PreferredUsername = user.Name
+		res.Name = user.TruthName
+	}
+	if authapi.HasScope(scopes, authapi.EmailScope) {
+		res.Email = user.Email
+	}
+	if authapi.HasScope(scopes, authapi.GroupsScope) {
+		if user.Group != nil {
+			res.Group = user.Group.Name
+		}
+		res.Roles = make([]string, 0, len(user.Role))
+		for _, v := range user.Role {
+			res.Roles = append(res.Roles, v.Name)
+		}
+	}
+	return res
+}
+
+// CreateIDToken signs the id token of the user for the client by the key which signs the access tokens
+func CreateIDToken(grant *AuthorizationGrant, clientID string, scopes []string, issuedAt, expiresAt time.Time) (string, error) {
+	key, err := signingKeys.signingKey()
+	if err != nil {
+		return "", err
+	}
+	userInfo := getUserInfo(grant.User, scopes)
+	claim := jwt.MapClaims{
+		"iss":       TokenIssuer(),
+		"sub":       userInfo.Subject,
+		"aud":       clientID,
+		"azp":       clientID,
+		"iat":       issuedAt.Unix(),
+		"exp":       expiresAt.Unix(),
+		"auth_time": grant.AuthTime.Unix(),
+	}
+	if len(grant.Nonce) != 0 {
+		claim["nonce"] = grant.Nonce
+	}
+	if len(userInfo.PreferredUsername) != 0 {
+		claim["preferred_username"] = userInfo.PreferredUsername
+		claim["name"] = userInfo.Name
+	}
+	if len(userInfo.Email) != 0 {
+		claim["email"] = userInfo.Email
+	}
+	if userInfo.Roles != nil {
+		claim["group"] = userInfo.Group
+		claim["roles"] = userInfo.Roles
+	}
+	token := jwt.NewWithClaims(key.Method, claim)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.PrivateKey)
+}
+
+// GetUserInfo returns the claims of the scopes granted to the oauth client which the access token is issued to,
+// the token issued by imanager itself has all the claims
+func GetUserInfo(info *authapi.RespToken) (*authapi.UserInfo, error) {
+	user, err := authdb.GetUserByUUID(orm.NewOrm(), info.UserID)
+	if err != nil {
+		return nil, err
+	}
+	user.Password = ""
+	userAPI := transformUserDB2API(user)
+	scopes := info.OpenIDScopes
+	if len(info.ClientID) == 0 {
+		scopes = []string{authapi.ProfileScope, authapi.EmailScope, authapi.GroupsScope}
+	}
+	res := getUserInfo(&userAPI, scopes)
+	return &res, nil
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/golang/glog"
+
+	"imanager/pkg/config"
+	authdb "imanager/pkg/db/auth"
+	"imanager/pkg/encrypt"
+	"imanager/pkg/encrypt/verifier"
+)
+
+const (
+	// argon2id or bcrypt
+	passwordHashAlgorithmKey = "PasswordHashAlgorithm"
+	// always, harbor or never
+	reversiblePasswordPolicyKey = "ReversiblePasswordPolicy"
+
+	// keep the reversible password for all users
+	ReversiblePasswordAlways = "always"
+	// keep the reversible password only if the users are synced to harbor
+	ReversiblePasswordHarbor = "harbor"
+	// never keep the reversible password, GetUserSecret doesn't work any more
+	ReversiblePasswordNever = "never"
+
+	defaultReversiblePasswordPolicy = ReversiblePasswordHarbor
+)
+
+var (
+	ErrNoReversiblePassword = errors.New("the reversible password of user isn't kept by the policy")
+	ErrExternalUserPassword = errors.New("the password of user is managed by the external identity provider")
+)
+
+func passwordHashAlgorithm() string {
+	algorithm := config.GetConfig().String(passwordHashAlgorithmKey)
+	switch algorithm {
+	case verifier.Argon2idAlgorithm, verifier.BcryptAlgorithm:
+		return algorithm
+	}
+	return verifier.DefaultAlgorithm
+}
+
+func keepReversiblePassword() bool {
+	switch config.GetConfig().String(reversiblePasswordPolicyKey) {
+	case ReversiblePasswordAlways:
+		return true
+	case ReversiblePasswordNever:
+		return false
+	case "", ReversiblePasswordHarbor:
+		return len(harborAddress) != 0
+	default:
+		glog.Errorf("unknown reversible password policy, use %v", defaultReversiblePasswordPolicy)
+		return len(harborAddress) != 0
+	}
+}
+
+// hashPassword returns the one-way hash and the reversible password which is empty if it shouldn't be kept
+func hashPassword(password string) (string, string, error) {
+	hash, err := verifier.Hash(password, passwordHashAlgorithm())
+	if err != nil {
+		return "", "", err
+	}
+	if !keepReversiblePassword() {
+		return hash, "", nil
+	}
+	reversible, err := encrypt.Encrypt(password, encrypt.CpabeType, encrypt.OpServiceRole)
+	if err != nil {
+		return "", "", err
+	}
+	return hash, reversible, nil
+}
+
+// decryptReversiblePassword returns empty if the reversible password isn't kept
+func decryptReversiblePassword(password string) (string, error) {
+	if len(password) == 0 {
+		return "", nil
+	}
+	return encrypt.Decrypt(password, encrypt.CpabeType, encrypt.OpServiceRole)
+}
+
+// verifyUserPassword checks the password by the hash, the users who only have the reversible password
+// are migrated to the hash after the password is verified
+func verifyUserPassword(o orm.Ormer, user authdb.User, password string) (bool, error) {
+	if len(user.PasswordHash) == 0 {
+		stored, err := decryptReversiblePassword(user.Password)
+		if err != nil {
+			return false, err
+		}
+		if len(stored) == 0 || subtle.ConstantTimeCompare([]byte(stored), []byte(password)) != 1 {
+			return false, nil
+		}
+		migrateUserPassword(o, user, password)
+		return true, nil
+	}
+
+	ok, err := verifier.Verify(password, user.PasswordHash)
+	if err != nil || !ok {
+		return false, err
+	}
+	keep := keepReversiblePassword()
+	if verifier.NeedsRehash(user.PasswordHash, passwordHashAlgorithm()) || (!keep && len(user.Password) != 0) ||
+		(keep && len(user.Password) == 0) {
+		migrateUserPassword(o, user, password)
+	}
+	return true, nil
+}
+
+// migrateUserPassword saves the password by current algorithm and policy, the login succeeds even if it fails
+func migrateUserPassword(o orm.Ormer, user authdb.User, password string) {
+	hash, reversible, err := hashPassword(password)
+	if err != nil {
+		glog.Errorf("hash password of user[%v/%v] failed, err: %v", user.Name, user.UUID, err)
+		return
+	}
+	if err = authdb.UpdateUserPassword(o, user.UUID, hash, reversible); err != nil {
+		glog.Errorf("migrate password of user[%v/%v] failed, err: %v", user.Name, user.UUID, err)
+		return
+	}
+	glog.Infof("password of user[%v/%v] is migrated to %v, reversible password is kept: %v", user.Name, user.UUID,
+		passwordHashAlgorithm(), len(reversible) != 0)
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/golang/glog"
+
+	authapi "imanager/pkg/api/auth"
+	authdb "imanager/pkg/db/auth"
+)
+
+// the days after which the passwords expire if the group doesn't set it, 0 means the passwords never expire
+const passwordMaxAgeKey = "PasswordMaxAge"
+
+var ErrInvalidOldPassword = errors.New("old password is invalid")
+
+// passwordMaxAge returns the max age of the group, or the global one
+func passwordMaxAge(group *authdb.Group) time.Duration {
+	days := 0
+	if group != nil {
+		days = group.PasswordMaxAge
+	}
+	if days <= 0 {
+		days = getPositiveInt(passwordMaxAgeKey, 0)
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// IsPasswordChangeRequired is true if the password was reset by the others or it's expired by the policy of the group.
+// The password of the external user is managed by the identity provider, it's never required
+func IsPasswordChangeRequired(user *authapi.User) (bool, error) {
+	o := orm.NewOrm()
+	userDB, err := authdb.GetUserByUUID(o, user.UUID)
+	if err != nil {
+		return false, err
+	}
+	if userDB.Source != authapi.LocalUserSource {
+		return false, nil
+	}
+	if userDB.MustChangePassword {
+		return true, nil
+	}
+	var group *authdb.Group
+	if userDB.Group != nil {
+		groupDB, err := authdb.GetGroupByID(o, userDB.Group.Id)
+		if err != nil {
+			return false, err
+		}
+		group = &groupDB
+	}
+	maxAge := passwordMaxAge(group)
+	if maxAge == 0 {
+		return false, nil
+	}
+	// the users created before the changed time is recorded haven't changed the password since the creation
+	changedAt := userDB.PasswordChangedAt
+	if changedAt.IsZero() {
+		changedAt = userDB.CreateTimestamp
+	}
+	return changedAt.Add(maxAge).Before(time.Now()), nil
+}
+
+// ChangePassword changes the password of the user himself after checking the old one
+func ChangePassword(name, oldPassword, password string) error {
+	o := orm.NewOrm()
+	userDB, err := authdb.GetUserByName(o, name)
+	if err != nil {
+		return err
+	}
+	if userDB.Source != authapi.LocalUserSource {
+		return ErrExternalUserPassword
+	}
+	isValid, err := verifyUserPassword(o, userDB, oldPassword)
+	if err != nil {
+		glog.Errorf("verify password of user[%v] failed, err: %v", name, err)
+		return err
+	}
+	if !isValid {
+		return ErrInvalidOldPassword
+	}
+	// MustChangePassword is false, the user changes the password himself so it needn't be changed again
+	_, err = UpdateUser(&authapi.User{UUID: userDB.UUID, Name: userDB.Name, Password: password})
+	return err
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+
+	authapi "imanager/pkg/api/auth"
+	authdb "imanager/pkg/db/auth"
+)
+
+func TestPasswordMaxAge(t *testing.T) {
+	setTestConfig(t, passwordMaxAgeKey, "")
+	if age := passwordMaxAge(nil); age != 0 {
+		t.Logf("passwords should never expire by default, max age: %v", age)
+		t.Fail()
+	}
+	setTestConfig(t, passwordMaxAgeKey, "30")
+	cases := []struct {
+		group *authdb.Group
+		days  int
+	}{
+		{nil, 30},
+		{&authdb.Group{}, 30},
+		{&authdb.Group{PasswordMaxAge: 7}, 7},
+	}
+	for _, c := range cases {
+		if age := passwordMaxAge(c.group); age != time.Duration(c.days)*24*time.Hour {
+			t.Logf("max age of group %+v should be %v days, got %v", c.group, c.days, age)
+			t.Fail()
+		}
+	}
+}
+
+func TestIsPasswordChangeRequired(t *testing.T) {
+	o := setupTestDB(t)
+	setTestConfig(t, passwordMaxAgeKey, "30")
+	createTestUser(t, o, "long", "g2")
+	if _, err := o.QueryTable(authdb.Group{}).Filter("name", "g2").Update(orm.Params{"password_max_age": 60}); err != nil {
+		t.Fatalf("update password max age of group failed, err: %v", err)
+	}
+	expired := time.Now().Add(-31 * 24 * time.Hour)
+
+	cases := []struct {
+		name       string
+		group      string
+		source     string
+		changedAt  time.Time
+		mustChange bool
+		required   bool
+	}{
+		{"new user", "g1", authapi.LocalUserSource, time.Time{}, false, false},
+		{"changed recently", "g1", authapi.LocalUserSource, time.Now(), false, false},
+		{"expired", "g1", authapi.LocalUserSource, expired, false, true},
+		{"longer max age of group", "g2", authapi.LocalUserSource, expired, false, false},
+		{"reset by the others", "g1", authapi.LocalUserSource, time.Now(), true, true},
+		{"external user", "g1", authapi.OIDCUserSource, expired, true, false},
+	}
+	for i, c := range cases {
+		user := createTestUser(t, o, "u"+string(rune('a'+i)), c.group)
+		_, err := o.QueryTable(authdb.User{}).Filter("uuid", user.UUID).Update(orm.Params{
+			"source":               c.source,
+			"password_changed_at":  c.changedAt,
+			"must_change_password": c.mustChange,
+		})
+		if err != nil {
+			t.Fatalf("update user failed, err: %v", err)
+		}
+		required, err := IsPasswordChangeRequired(&authapi.User{UUID: user.UUID})
+		if err != nil || required != c.required {
+			t.Logf("%v: password change required should be %v, got %v, err: %v", c.name, c.required, required, err)
+			t.Fail()
+		}
+	}
+}
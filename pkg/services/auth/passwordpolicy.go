@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/golang/glog"
+
+	authapi "imanager/pkg/api/auth"
+	"imanager/pkg/config"
+	authdb "imanager/pkg/db/auth"
+	"imanager/pkg/encrypt/verifier"
+	"imanager/pkg/passwordpolicy"
+)
+
+const (
+	passwordMinLengthKey     = "PasswordMinLength"
+	passwordMaxLengthKey     = "PasswordMaxLength"
+	passwordRequireUpperKey  = "PasswordRequireUpper"
+	passwordRequireLowerKey  = "PasswordRequireLower"
+	passwordRequireDigitKey  = "PasswordRequireDigit"
+	passwordRequireSymbolKey = "PasswordRequireSymbol"
+	// the allowed characters other than the ascii letters and digits, passwordpolicy.DefaultSymbols by default
+	passwordSymbolsKey = "PasswordSymbols"
+	// the file of the forbidden words, one word per line
+	passwordDictionaryFileKey = "PasswordDictionaryFile"
+	passwordRejectUsernameKey = "PasswordRejectUsername"
+	// the number of the previous passwords which can't be reused, 0 disables the history
+	passwordHistorySizeKey = "PasswordHistorySize"
+)
+
+// passwordDictionary caches the dictionary file until it's modified
+var passwordDictionary = struct {
+	sync.Mutex
+	path       string
+	modTime    time.Time
+	dictionary map[string]bool
+}{}
+
+func getBool(key string, defaultValue bool) bool {
+	value, err := config.GetConfig().Bool(key)
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func loadPasswordDictionary(path string) map[string]bool {
+	if len(path) == 0 {
+		return nil
+	}
+	passwordDictionary.Lock()
+	defer passwordDictionary.Unlock()
+	stat, err := os.Stat(path)
+	if err != nil {
+		glog.Errorf("stat password dictionary %v failed, err: %v", path, err)
+		return passwordDictionary.dictionary
+	}
+	if path == passwordDictionary.path && stat.ModTime().Equal(passwordDictionary.modTime) {
+		return passwordDictionary.dictionary
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		glog.Errorf("open password dictionary %v failed, err: %v", path, err)
+		return passwordDictionary.dictionary
+	}
+	defer f.Close()
+	dictionary := make(map[string]bool)
+	if err = passwordpolicy.LoadDictionary(f, dictionary); err != nil {
+		glog.Errorf("load password dictionary %v failed, err: %v", path, err)
+		return passwordDictionary.dictionary
+	}
+	glog.Infof("load %v words from password dictionary %v", len(dictionary), path)
+	passwordDictionary.path, passwordDictionary.modTime, passwordDictionary.dictionary = path, stat.ModTime(), dictionary
+	return dictionary
+}
+
+// GetPasswordPolicy returns the policy of the config, the unset rules are the same as passwordpolicy.Default
+func GetPasswordPolicy() *passwordpolicy.Policy {
+	c := config.GetConfig()
+	res := passwordpolicy.Default()
+	res.MinLength = getPositiveInt(passwordMinLengthKey, res.MinLength)
+	res.MaxLength = getPositiveInt(passwordMaxLengthKey, res.MaxLength)
+	res.RequireUpper = getBool(passwordRequireUpperKey, res.RequireUpper)
+	res.RequireLower = getBool(passwordRequireLowerKey, res.RequireLower)
+	res.RequireDigit = getBool(passwordRequireDigitKey, res.RequireDigit)
+	res.RequireSymbol = getBool(passwordRequireSymbolKey, res.RequireSymbol)
+	if symbols := c.String(passwordSymbolsKey); len(symbols) != 0 {
+		res.Symbols = symbols
+	}
+	res.Dictionary = loadPasswordDictionary(c.String(passwordDictionaryFileKey))
+	res.RejectUsername = getBool(passwordRejectUsernameKey, res.RejectUsername)
+	if size, err := c.Int(passwordHistorySizeKey); err == nil && size >= 0 {
+		res.HistorySize = size
+	}
+	return res
+}
+
+// ValidPassword checks the new password of the user by the policy, the user is found by the uuid or name,
+// and the previous passwords are checked if the user exists. *passwordpolicy.Error is returned if the
+// password violates the policy
+func ValidPassword(password string, user *authapi.User) error {
+	policy := GetPasswordPolicy()
+	o := orm.NewOrm()
+	var userDB authdb.User
+	var err error
+	if len(user.UUID) != 0 {
+		userDB, err = authdb.GetUserByUUID(o, user.UUID)
+	} else {
+		userDB, err = authdb.GetUserByName(o, user.Name)
+	}
+	if err != nil && err != orm.ErrNoRows {
+		return err
+	}
+	name := user.Name
+	if len(name) == 0 {
+		name = userDB.Name
+	}
+	if err = policy.Check(password, name); err != nil {
+		return err
+	}
+	if policy.HistorySize == 0 || len(userDB.UUID) == 0 {
+		return nil
+	}
+
+	// the current password is one of the last passwords
+	hashes := []string{userDB.PasswordHash}
+	if policy.HistorySize > 1 {
+		histories, err := authdb.ListPasswordHistory(o, userDB.UUID, policy.HistorySize-1)
+		if err != nil {
+			return err
+		}
+		for _, v := range histories {
+			hashes = append(hashes, v.PasswordHash)
+		}
+	}
+	for _, v := range hashes {
+		if len(v) == 0 {
+			continue
+		}
+		if ok, _ := verifier.Verify(password, v); ok {
+			return policy.HistoryError()
+		}
+	}
+	return nil
+}
+
+// recordPasswordHistory keeps the replaced password hash of the user
+func recordPasswordHistory(o orm.Ormer, userUUID, passwordHash string) error {
+	size := GetPasswordPolicy().HistorySize
+	// the current password is checked besides the history
+	if size <= 1 || len(passwordHash) == 0 {
+		return nil
+	}
+	_, err := authdb.CreatePasswordHistory(o, authdb.PasswordHistory{UserUUID: userUUID, PasswordHash: passwordHash})
+	if err != nil {
+		return err
+	}
+	return authdb.TrimPasswordHistory(o, userUUID, size-1)
+}
@@ -0,0 +1,236 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/golang/glog"
+	uuid "github.com/satori/go.uuid"
+
+	authapi "imanager/pkg/api/auth"
+	"imanager/pkg/config"
+	authdb "imanager/pkg/db/auth"
+	"imanager/pkg/mail"
+)
+
+const (
+	// the page where the user sets the new password, the token is appended as the query parameter token,
+	// it's the reset page of imanager under TokenIssuer by default
+	passwordResetURLKey = "PasswordResetURL"
+	// minutes
+	passwordResetDurationKey     = "PasswordResetDuration"
+	defaultPasswordResetDuration = 30
+	// the audience of the reset token, so that it's never accepted as an access token
+	passwordResetAudience = "imanager:password_reset"
+
+	// a link is sent to the user at most once in the interval
+	passwordResetSendInterval  = time.Minute
+	passwordResetCleanInterval = time.Hour
+)
+
+var ErrInvalidPasswordResetToken = errors.New("password reset link is invalid, expired or already used")
+
+func init() {
+	go func() {
+		for range time.Tick(passwordResetCleanInterval) {
+			num, err := authdb.DeleteExpiredPasswordResetTokens(orm.NewOrm(), time.Now())
+			if err != nil {
+				glog.Errorf("delete expired password reset tokens failed, err: %v", err)
+				continue
+			}
+			glog.Infof("delete %v expired password reset tokens", num)
+		}
+	}()
+}
+
+func passwordResetURL(token string) (string, error) {
+	base := config.GetConfig().String(passwordResetURLKey)
+	if len(base) == 0 {
+		issuer := TokenIssuer()
+		u, err := url.Parse(issuer)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || len(u.Host) == 0 {
+			return "", fmt.Errorf("%v or TokenIssuer should be an url to send the password reset link", passwordResetURLKey)
+		}
+		base = strings.TrimSuffix(issuer, "/") + authapi.ResetPasswordURL
+	}
+	sep := "?"
+	if strings.Contains(base, "?") {
+		sep = "&"
+	}
+	return base + sep + url.Values{"token": {token}}.Encode(), nil
+}
+
+// SendPasswordResetLink mails the signed reset link to the local user in background, only the error of the mail
+// config is returned. Nothing is sent if the user isn't exist, is managed by the external identity provider or
+// has no email, so that the caller can't tell whether the user exists by the result or the response time
+func SendPasswordResetLink(name string) error {
+	sender, err := getMailSender()
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := sendPasswordResetLink(sender, name); err != nil {
+			glog.Errorf("send password reset link to user[%v] failed, err: %v", name, err)
+		}
+	}()
+	return nil
+}
+
+func sendPasswordResetLink(sender mail.Sender, name string) error {
+	o := orm.NewOrm()
+	user, err := authdb.GetUserByName(o, name)
+	if err == orm.ErrNoRows {
+		glog.Infof("password reset of user[%v] is ignored, user isn't exist", name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.Source != authapi.LocalUserSource || len(user.Email) == 0 {
+		glog.Infof("password reset of user[%v/%v] is ignored, source: %q, email: %q", user.Name, user.UUID, user.Source, user.Email)
+		return nil
+	}
+
+	now := time.Now()
+	num, err := authdb.CountPasswordResetTokensSince(o, user.UUID, now.Add(-passwordResetSendInterval))
+	if err != nil {
+		return err
+	}
+	if num != 0 {
+		glog.Infof("password reset of user[%v/%v] is ignored, a link was just sent", user.Name, user.UUID)
+		return nil
+	}
+
+	duration := time.Duration(getPositiveInt(passwordResetDurationKey, defaultPasswordResetDuration)) * time.Minute
+	tokenss, err := signPasswordResetToken(o, user.UUID, now, duration)
+	if err != nil {
+		return err
+	}
+	link, err := passwordResetURL(tokenss)
+	if err != nil {
+		return err
+	}
+
+	err = sender.Send(&mail.Message{
+		To:      []string{user.Email},
+		Subject: "Reset your imanager password",
+		Body: fmt.Sprintf("Hi %v,\n\nA password reset was requested for your imanager account %v. "+
+			"Open the link below in %v minutes to set a new password, the link can only be used once:\n\n%v\n\n"+
+			"If you didn't request it, please ignore this mail, your password isn't changed.\n",
+			user.TruthName, user.Name, int(duration.Minutes()), link),
+	})
+	if err != nil {
+		return err
+	}
+	glog.Infof("send password reset link to user[%v/%v]", user.Name, user.UUID)
+	return nil
+}
+
+// signPasswordResetToken records the reset token of the user, and signs it with the audience of password reset
+func signPasswordResetToken(o orm.Ormer, userUUID string, now time.Time, duration time.Duration) (string, error) {
+	key, err := signingKeys.signingKey()
+	if err != nil {
+		return "", err
+	}
+	resetToken, err := authdb.CreatePasswordResetToken(o, authdb.PasswordResetToken{
+		TokenID:   uuid.NewV4().String(),
+		UserUUID:  userUUID,
+		ExpiresAt: now.Add(duration),
+	})
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(key.Method, jwt.MapClaims{
+		"iss": TokenIssuer(),
+		"aud": passwordResetAudience,
+		"sub": userUUID,
+		"jti": resetToken.TokenID,
+		"iat": now.Unix(),
+		"exp": resetToken.ExpiresAt.Unix(),
+	})
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.PrivateKey)
+}
+
+// parsePasswordResetToken checks the signature, expiry and audience of the token, and returns the user uuid and the token id
+func parsePasswordResetToken(tokenss string) (string, string, error) {
+	token, err := jwt.Parse(tokenss, keyFunc)
+	if err != nil || !token.Valid {
+		return "", "", ErrInvalidPasswordResetToken
+	}
+	claim, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !claim.VerifyAudience(passwordResetAudience, true) {
+		return "", "", ErrInvalidPasswordResetToken
+	}
+	sub, _ := claim["sub"].(string)
+	jti, _ := claim["jti"].(string)
+	if len(sub) == 0 || len(jti) == 0 {
+		return "", "", ErrInvalidPasswordResetToken
+	}
+	return sub, jti, nil
+}
+
+// CheckPasswordResetToken returns the user of the link without using it, the link used or invalidated
+// by the password change is rejected
+func CheckPasswordResetToken(tokenss string) (*authapi.User, error) {
+	userUUID, tokenID, err := parsePasswordResetToken(tokenss)
+	if err != nil {
+		return nil, err
+	}
+	o := orm.NewOrm()
+	_, err = authdb.GetValidPasswordResetToken(o, tokenID, userUUID, time.Now())
+	if err == orm.ErrNoRows {
+		return nil, ErrInvalidPasswordResetToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	user, err := authdb.GetUserByUUID(o, userUUID)
+	if err == orm.ErrNoRows {
+		return nil, ErrInvalidPasswordResetToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	user.Password = ""
+	res := transformUserDB2API(user)
+	return &res, nil
+}
+
+// ResetPassword uses the link once and updates the password as UpdateUser in the same transaction, so the link
+// is still valid if the password isn't updated. The password should be validated by the caller
+func ResetPassword(tokenss, password string) (*authapi.User, error) {
+	userUUID, tokenID, err := parsePasswordResetToken(tokenss)
+	if err != nil {
+		return nil, err
+	}
+	o := orm.NewOrm()
+	if err = o.Begin(); err != nil {
+		return nil, err
+	}
+	err = authdb.UsePasswordResetToken(o, tokenID, userUUID, time.Now())
+	if err == orm.ErrNoRows {
+		_ = o.Rollback()
+		return nil, ErrInvalidPasswordResetToken
+	}
+	if err != nil {
+		_ = o.Rollback()
+		return nil, err
+	}
+	userDB, err := updateUser(o, &authapi.User{UUID: userUUID, Password: password})
+	if err != nil {
+		_ = o.Rollback()
+		glog.Errorf("reset password of user[%v] failed, err: %v", userUUID, err)
+		return nil, err
+	}
+	_ = o.Commit()
+	glog.Infof("user[%v/%v] resets the password", userDB.Name, userDB.UUID)
+	userDB.Password = ""
+	user := transformUserDB2API(userDB)
+	return &user, nil
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+
+	authdb "imanager/pkg/db/auth"
+)
+
+func TestPasswordResetTokenAudience(t *testing.T) {
+	o := setupTestDB(t)
+	useTestSigningKeys(t, testRSAKey(t, "1"))
+	user := createTestUser(t, o, "u1", "g1")
+
+	resetToken, err := signPasswordResetToken(o, user.UUID, time.Now(), time.Hour)
+	if err != nil {
+		t.Fatalf("sign password reset token failed, err: %v", err)
+	}
+	if _, err = ParseToken(resetToken); err == nil {
+		t.Logf("password reset token shouldn't be accepted as access token")
+		t.Fail()
+	}
+	info := testIssuedToken(user, time.Now())
+	accessToken, err := CreateToken(&info)
+	if err != nil {
+		t.Fatalf("create token failed, err: %v", err)
+	}
+	if _, err = CheckPasswordResetToken(accessToken); err != ErrInvalidPasswordResetToken {
+		t.Logf("access token shouldn't be accepted as password reset token, err: %v", err)
+		t.Fail()
+	}
+	if res, err := CheckPasswordResetToken(resetToken); err != nil || res.UUID != user.UUID {
+		t.Logf("password reset token should be valid: %+v, err: %v", res, err)
+		t.Fail()
+	}
+}
+
+func TestPasswordResetTokenExpiry(t *testing.T) {
+	o := setupTestDB(t)
+	useTestSigningKeys(t, testRSAKey(t, "1"))
+	user := createTestUser(t, o, "u1", "g1")
+
+	expired, err := signPasswordResetToken(o, user.UUID, time.Now().Add(-time.Hour), 30*time.Minute)
+	if err != nil {
+		t.Fatalf("sign password reset token failed, err: %v", err)
+	}
+	if _, err = CheckPasswordResetToken(expired); err != ErrInvalidPasswordResetToken {
+		t.Logf("expired password reset token should be invalid, err: %v", err)
+		t.Fail()
+	}
+	if _, err = ResetPassword(expired, "Passw0rd!"); err != ErrInvalidPasswordResetToken {
+		t.Logf("expired password reset token shouldn't reset the password, err: %v", err)
+		t.Fail()
+	}
+
+	// the record in db is checked even if the signed token isn't expired
+	tokenss, err := signPasswordResetToken(o, user.UUID, time.Now(), time.Hour)
+	if err != nil {
+		t.Fatalf("sign password reset token failed, err: %v", err)
+	}
+	_, err = o.QueryTable(authdb.PasswordResetToken{}).Filter("user_uuid", user.UUID).Update(orm.Params{
+		"expires_at": time.Now().Add(-time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("update expiry of password reset token failed, err: %v", err)
+	}
+	if _, err = CheckPasswordResetToken(tokenss); err != ErrInvalidPasswordResetToken {
+		t.Logf("password reset token expired in db should be invalid, err: %v", err)
+		t.Fail()
+	}
+}
+
+// harbor isn't configured in the tests, so the password of the local user can't be updated
+func TestPasswordResetTokenUsedOnce(t *testing.T) {
+	o := setupTestDB(t)
+	useTestSigningKeys(t, testRSAKey(t, "1"))
+	user := createTestUser(t, o, "u1", "g1")
+
+	tokenss, err := signPasswordResetToken(o, user.UUID, time.Now(), time.Hour)
+	if err != nil {
+		t.Fatalf("sign password reset token failed, err: %v", err)
+	}
+	if _, err = ResetPassword(tokenss, "Passw0rd!"); err == nil {
+		t.Fatalf("password shouldn't be updated without harbor")
+	}
+	// the link isn't used if the password isn't updated
+	if _, err = CheckPasswordResetToken(tokenss); err != nil {
+		t.Fatalf("password reset token should be valid after the reset failed, err: %v", err)
+	}
+
+	claims, err := parsePasswordResetTokenForTest(tokenss)
+	if err != nil {
+		t.Fatalf("parse password reset token failed, err: %v", err)
+	}
+	if err = authdb.UsePasswordResetToken(o, claims, user.UUID, time.Now()); err != nil {
+		t.Fatalf("use password reset token failed, err: %v", err)
+	}
+	if _, err = CheckPasswordResetToken(tokenss); err != ErrInvalidPasswordResetToken {
+		t.Logf("used password reset token should be invalid, err: %v", err)
+		t.Fail()
+	}
+	if _, err = ResetPassword(tokenss, "Passw0rd!"); err != ErrInvalidPasswordResetToken {
+		t.Logf("used password reset token shouldn't reset the password, err: %v", err)
+		t.Fail()
+	}
+}
+
+func parsePasswordResetTokenForTest(tokenss string) (string, error) {
+	_, tokenID, err := parsePasswordResetToken(tokenss)
+	return tokenID, err
+}
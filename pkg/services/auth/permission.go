@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"errors"
+
+	"github.com/astaxie/beego/orm"
+
+	authapi "imanager/pkg/api/auth"
+	authdb "imanager/pkg/db/auth"
+)
+
+var ErrRoleNotExist = errors.New("some roles aren't exist")
+
+// GetPermissions returns the permissions of the roles. The roles are read from db instead of the token,
+// so that the changes of the role permissions apply to the issued tokens at once
+func GetPermissions(roles []authapi.RoleInUser) (*authapi.Permissions, error) {
+	ids := make([]int, 0, len(roles))
+	seen := make(map[int]bool, len(roles))
+	for _, v := range roles {
+		if !seen[v.ID] {
+			seen[v.ID] = true
+			ids = append(ids, v.ID)
+		}
+	}
+	rolesDB, err := authdb.ListRoleByIDs(orm.NewOrm(), ids)
+	if err != nil {
+		return nil, err
+	}
+	if len(rolesDB) != len(ids) {
+		return nil, ErrRoleNotExist
+	}
+	res := &authapi.Permissions{}
+	for _, v := range rolesDB {
+		role := transformRoleDB2API(v)
+		res.Add(role.Permissions, role.AllGroups)
+	}
+	return res, nil
+}
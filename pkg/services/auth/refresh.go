@@ -0,0 +1,235 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/golang/glog"
+	uuid "github.com/satori/go.uuid"
+
+	authapi "imanager/pkg/api/auth"
+	"imanager/pkg/config"
+	authdb "imanager/pkg/db/auth"
+)
+
+const (
+	// minutes
+	refreshTokenDurationKey     = "RefreshTokenDuration"
+	defaultRefreshTokenDuration = 7 * 24 * 60
+
+	refreshTokenCleanInterval = time.Hour
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("refresh token is invalid or expired")
+	// ErrRefreshTokenClient is returned if the refresh token is used by the other client, it's invalid for the client
+	ErrRefreshTokenClient = errors.New("refresh token isn't issued to the client")
+	ErrRefreshTokenReused = errors.New("refresh token was already used, all tokens of this login are revoked")
+)
+
+func init() {
+	go func() {
+		for range time.Tick(refreshTokenCleanInterval) {
+			num, err := authdb.DeleteExpiredRefreshTokens(orm.NewOrm(), time.Now())
+			if err != nil {
+				glog.Errorf("delete expired refresh tokens failed, err: %v", err)
+				continue
+			}
+			glog.Infof("delete %v expired refresh tokens", num)
+		}
+	}()
+}
+
+func refreshTokenDuration() time.Duration {
+	duration, err := config.GetConfig().Int(refreshTokenDurationKey)
+	if err != nil || duration <= 0 {
+		duration = defaultRefreshTokenDuration
+	}
+	return time.Duration(duration) * time.Minute
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssuedRefreshToken is the refresh token and the attributes kept by its family
+type IssuedRefreshToken struct {
+	Token    string
+	FamilyID string
+	Scope    *authapi.TokenScope
+	// ClientID and OpenIDScopes are the oauth client which the family is issued to and the scopes granted to it
+	ClientID     string
+	OpenIDScopes []string
+}
+
+// CreateRefreshToken issues a refresh token in a new family for user
+func CreateRefreshToken(userUUID string, scope *authapi.TokenScope) (*IssuedRefreshToken, error) {
+	return CreateClientRefreshToken("", userUUID, scope, nil)
+}
+
+// CreateClientRefreshToken issues a refresh token to the oauth client, only the client can use it
+func CreateClientRefreshToken(clientID, userUUID string, scope *authapi.TokenScope, openIDScopes []string) (*IssuedRefreshToken, error) {
+	res := &IssuedRefreshToken{
+		FamilyID:     uuid.NewV4().String(),
+		Scope:        scope,
+		ClientID:     clientID,
+		OpenIDScopes: openIDScopes,
+	}
+	scopeStr := ""
+	if !scope.IsEmpty() {
+		data, _ := json.Marshal(scope)
+		scopeStr = string(data)
+	}
+	var err error
+	res.Token, err = createRefreshToken(orm.NewOrm(), authdb.RefreshToken{
+		FamilyID:    res.FamilyID,
+		UserUUID:    userUUID,
+		ClientID:    clientID,
+		Scope:       scopeStr,
+		OpenIDScope: strings.Join(openIDScopes, " "),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// createRefreshToken issues a new token in the family which keeps the attributes of family
+func createRefreshToken(o orm.Ormer, family authdb.RefreshToken) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	_, err = authdb.CreateRefreshToken(o, authdb.RefreshToken{
+		TokenHash:   hashToken(token),
+		FamilyID:    family.FamilyID,
+		UserUUID:    family.UserUUID,
+		ClientID:    family.ClientID,
+		Scope:       family.Scope,
+		OpenIDScope: family.OpenIDScope,
+		ExpiresAt:   time.Now().Add(refreshTokenDuration()),
+	})
+	if err != nil {
+		glog.Errorf("create refresh token for user[%v] failed, err: %v", family.UserUUID, err)
+		return "", err
+	}
+	return token, nil
+}
+
+// RevokeRefreshTokenFamily revokes the refresh tokens of the login whose access token isn't issued,
+// it only logs the error since the caller is already failing
+func RevokeRefreshTokenFamily(familyID string) {
+	if err := authdb.RevokeRefreshTokenFamily(orm.NewOrm(), familyID); err != nil {
+		glog.Errorf("revoke refresh token family %v failed, err: %v", familyID, err)
+	}
+}
+
+// UseRefreshToken consumes the refresh token issued by imanager itself, see UseClientRefreshToken
+func UseRefreshToken(refreshToken string) (*authapi.User, *IssuedRefreshToken, error) {
+	return UseClientRefreshToken(refreshToken, "")
+}
+
+// UseClientRefreshToken consumes the refresh token and rotates it, the whole family is revoked
+// if a used refresh token is replayed. The token issued to the other client is rejected without being consumed
+func UseClientRefreshToken(refreshToken, clientID string) (*authapi.User, *IssuedRefreshToken, error) {
+	if len(refreshToken) == 0 {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+	o := orm.NewOrm()
+	token, err := authdb.GetRefreshTokenByHash(o, hashToken(refreshToken))
+	if err == orm.ErrNoRows {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if token.Revoked || token.ExpiresAt.Before(time.Now()) {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+	if token.ClientID != clientID {
+		glog.Errorf("refresh token of client %q is used by client %q", token.ClientID, clientID)
+		return nil, nil, ErrRefreshTokenClient
+	}
+
+	isFirstUse := false
+	if !token.Used {
+		isFirstUse, err = authdb.MarkRefreshTokenUsed(o, token.Id)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if !isFirstUse {
+		glog.Warningf("refresh token of user[%v] is reused, revoke the family %v", token.UserUUID, token.FamilyID)
+		if err = authdb.RevokeRefreshTokenFamily(o, token.FamilyID); err != nil {
+			glog.Errorf("revoke refresh token family %v failed, err: %v", token.FamilyID, err)
+			return nil, nil, err
+		}
+		// the access tokens of the session may be stolen too
+		if session, err := authdb.GetSession(o, token.FamilyID); err == nil {
+			if err = revokeSession(o, session); err != nil {
+				return nil, nil, err
+			}
+		}
+		return nil, nil, ErrRefreshTokenReused
+	}
+
+	if err = useSession(o, token.FamilyID); err != nil {
+		return nil, nil, err
+	}
+
+	user, err := authdb.GetUserByUUID(o, token.UserUUID)
+	if err == orm.ErrNoRows {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	// the group membership in ldap may be changed after login
+	if user.Source == authapi.LDAPUserSource {
+		valid, err := checkLDAPUser(o, user)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !valid {
+			if err = authdb.RevokeRefreshTokenFamily(o, token.FamilyID); err != nil {
+				return nil, nil, err
+			}
+			return nil, nil, ErrInvalidRefreshToken
+		}
+	}
+	res := &IssuedRefreshToken{
+		FamilyID:     token.FamilyID,
+		ClientID:     token.ClientID,
+		OpenIDScopes: strings.Fields(token.OpenIDScope),
+	}
+	if len(token.Scope) != 0 {
+		res.Scope = &authapi.TokenScope{}
+		if err = json.Unmarshal([]byte(token.Scope), res.Scope); err != nil {
+			return nil, nil, err
+		}
+	}
+	res.Token, err = createRefreshToken(o, token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user.Password = ""
+	out := transformUserDB2API(user)
+	return &out, res, nil
+}
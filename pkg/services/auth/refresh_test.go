@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+
+	authapi "imanager/pkg/api/auth"
+	authdb "imanager/pkg/db/auth"
+)
+
+func TestUseRefreshTokenRotates(t *testing.T) {
+	o := setupTestDB(t)
+	user := createTestUser(t, o, "u1", "g1")
+	scope := &authapi.TokenScope{Actions: []string{"user:read"}}
+
+	issued, err := CreateRefreshToken(user.UUID, scope)
+	if err != nil {
+		t.Fatalf("create refresh token failed, err: %v", err)
+	}
+	refreshed, next, err := UseRefreshToken(issued.Token)
+	if err != nil {
+		t.Fatalf("use refresh token failed, err: %v", err)
+	}
+	if refreshed.Name != "u1" || len(refreshed.Password) != 0 {
+		t.Logf("refresh token should return the user without password: %+v", refreshed)
+		t.Fail()
+	}
+	if next.Token == issued.Token || next.FamilyID != issued.FamilyID {
+		t.Logf("refresh token should be rotated in the same family, old: %+v, new: %+v", issued, next)
+		t.Fail()
+	}
+	if next.Scope == nil || len(next.Scope.Actions) != 1 || next.Scope.Actions[0] != "user:read" {
+		t.Logf("scope should be kept by the family: %+v", next.Scope)
+		t.Fail()
+	}
+
+	if _, _, err = UseRefreshToken(next.Token); err != nil {
+		t.Logf("rotated refresh token should be valid, err: %v", err)
+		t.Fail()
+	}
+}
+
+func TestUseRefreshTokenReuse(t *testing.T) {
+	o := setupTestDB(t)
+	user := createTestUser(t, o, "u1", "g1")
+	issued, err := CreateRefreshToken(user.UUID, nil)
+	if err != nil {
+		t.Fatalf("create refresh token failed, err: %v", err)
+	}
+	_, next, err := UseRefreshToken(issued.Token)
+	if err != nil {
+		t.Fatalf("use refresh token failed, err: %v", err)
+	}
+
+	// the replayed token revokes the whole family, including the token rotated by the legitimate client
+	if _, _, err = UseRefreshToken(issued.Token); err != ErrRefreshTokenReused {
+		t.Logf("reused refresh token should be detected, err: %v", err)
+		t.Fail()
+	}
+	if _, _, err = UseRefreshToken(next.Token); err != ErrInvalidRefreshToken {
+		t.Logf("refresh token of the revoked family should be invalid, err: %v", err)
+		t.Fail()
+	}
+	if _, _, err = UseRefreshToken(issued.Token); err != ErrInvalidRefreshToken {
+		t.Logf("revoked refresh token should be invalid, err: %v", err)
+		t.Fail()
+	}
+}
+
+func TestUseRefreshTokenInvalid(t *testing.T) {
+	o := setupTestDB(t)
+	user := createTestUser(t, o, "u1", "g1")
+	issued, err := CreateRefreshToken(user.UUID, nil)
+	if err != nil {
+		t.Fatalf("create refresh token failed, err: %v", err)
+	}
+	_, err = o.QueryTable(authdb.RefreshToken{}).Filter("family_id", issued.FamilyID).Update(orm.Params{
+		"expires_at": time.Now().Add(-time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("expire refresh token failed, err: %v", err)
+	}
+
+	for _, token := range []string{"", "unknown", issued.Token} {
+		if _, _, err = UseRefreshToken(token); err != ErrInvalidRefreshToken {
+			t.Logf("refresh token %q should be invalid, err: %v", token, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestUseRefreshTokenOfRemovedLDAPUser(t *testing.T) {
+	o := setupTestDB(t)
+	user := createTestUser(t, o, "u1", "g1")
+	user.Source = authapi.LDAPUserSource
+	if _, err := o.Update(&user, "source"); err != nil {
+		t.Fatalf("update source of user failed, err: %v", err)
+	}
+	issued, err := CreateRefreshToken(user.UUID, nil)
+	if err != nil {
+		t.Fatalf("create refresh token failed, err: %v", err)
+	}
+
+	// the ldap user can't be found after ldap is disabled
+	if _, _, err = UseRefreshToken(issued.Token); err != ErrInvalidRefreshToken {
+		t.Logf("refresh token of the ldap user not found should be invalid, err: %v", err)
+		t.Fail()
+	}
+	num, err := o.QueryTable(authdb.RefreshToken{}).Filter("family_id", issued.FamilyID).Filter("revoked", false).Count()
+	if err != nil || num != 0 {
+		t.Logf("refresh token family of the ldap user not found should be revoked, %v not revoked, err: %v", num, err)
+		t.Fail()
+	}
+}
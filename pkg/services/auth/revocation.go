@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/golang/glog"
+
+	authapi "imanager/pkg/api/auth"
+	authdb "imanager/pkg/db/auth"
+)
+
+const revokedTokenCleanInterval = time.Hour
+
+var ErrTokenRevoked = errors.New("token is revoked")
+
+func init() {
+	go func() {
+		for range time.Tick(revokedTokenCleanInterval) {
+			num, err := authdb.DeleteExpiredRevokedTokens(orm.NewOrm(), time.Now())
+			if err != nil {
+				glog.Errorf("delete expired revoked tokens failed, err: %v", err)
+				continue
+			}
+			glog.Infof("delete %v expired revoked tokens", num)
+		}
+	}()
+}
+
+// ValidateToken parses the token and checks it isn't revoked, the personal access token is accepted too
+func ValidateToken(tokenss string) (authapi.RespToken, error) {
+	if IsPersonalAccessToken(tokenss) {
+		return validatePersonalAccessToken(tokenss)
+	}
+	info, err := ParseToken(tokenss)
+	if err != nil {
+		return info, err
+	}
+	revoked, err := IsTokenRevoked(info)
+	if err != nil {
+		return authapi.RespToken{}, err
+	}
+	if revoked {
+		return authapi.RespToken{}, ErrTokenRevoked
+	}
+	if len(info.SessionID) != 0 {
+		if err = useSession(orm.NewOrm(), info.SessionID); err != nil {
+			return authapi.RespToken{}, err
+		}
+	}
+	return info, nil
+}
+
+// IsTokenRevoked checks the revocation store in db, so that it works across all replicas
+func IsTokenRevoked(info authapi.RespToken) (bool, error) {
+	o := orm.NewOrm()
+	tokenIDs := make([]string, 0, 2)
+	for _, id := range []string{info.TokenID, info.SessionID} {
+		if len(id) != 0 {
+			tokenIDs = append(tokenIDs, id)
+		}
+	}
+	if len(tokenIDs) != 0 && authdb.IsTokenIDRevoked(o, tokenIDs...) {
+		return true, nil
+	}
+	revoked, err := isRevokedByUser(o, info, info.UserID)
+	if err != nil || revoked {
+		return revoked, err
+	}
+	// the impersonation ends if the tokens of the caller are revoked
+	if info.Act != nil {
+		return isRevokedByUser(o, info, info.Act.Subject)
+	}
+	return false, nil
+}
+
+// isRevokedByUser checks the revocation of all the tokens of the user, see revokeUserTokens
+func isRevokedByUser(o orm.Ormer, info authapi.RespToken, userUUID string) (bool, error) {
+	revokedAt, err := authdb.GetUserRevokedAt(o, userUUID)
+	if err != nil {
+		glog.Errorf("get revoked time of user[%v] failed, err: %v", userUUID, err)
+		return false, err
+	}
+	issuedAt := info.IssuedAt.Truncate(time.Second)
+	if issuedAt.After(revokedAt) {
+		return false, nil
+	}
+	return issuedAt.Before(revokedAt) || !authdb.IsTokenExempt(o, info.TokenID, userUUID), nil
+}
+
+// exemptNewToken records the token issued in the same second as the revocation of its user or actor,
+// so that it isn't revoked as the tokens issued before the revocation in that second
+func exemptNewToken(info *authapi.RespToken) error {
+	o := orm.NewOrm()
+	subjects := []string{info.UserID}
+	if info.Act != nil {
+		subjects = append(subjects, info.Act.Subject)
+	}
+	for _, v := range subjects {
+		revokedAt, err := authdb.GetUserRevokedAt(o, v)
+		if err != nil {
+			glog.Errorf("get revoked time of user[%v] failed, err: %v", v, err)
+			return err
+		}
+		if !info.IssuedAt.Truncate(time.Second).Equal(revokedAt) {
+			continue
+		}
+		_, err = authdb.CreateRevokedToken(o, authdb.RevokedToken{
+			TokenID:   info.TokenID,
+			UserUUID:  v,
+			RevokedAt: revokedAt,
+			ExpiresAt: info.ExpiresAt,
+			Exempt:    true,
+		})
+		if err != nil {
+			glog.Errorf("exempt token %v of user[%v] from revocation failed, err: %v", info.TokenID, v, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// RevokeToken revokes the token and the session it belongs to
+func RevokeToken(info authapi.RespToken) error {
+	o := orm.NewOrm()
+	if len(info.SessionID) != 0 {
+		session, err := authdb.GetSession(o, info.SessionID)
+		if err == nil {
+			return revokeSession(o, session)
+		}
+		if err != orm.ErrNoRows {
+			return err
+		}
+	}
+	if len(info.TokenID) != 0 {
+		_, err := authdb.CreateRevokedToken(o, authdb.RevokedToken{
+			TokenID:   info.TokenID,
+			UserUUID:  info.UserID,
+			RevokedAt: time.Now(),
+			ExpiresAt: info.ExpiresAt,
+		})
+		if err != nil {
+			glog.Errorf("revoke token %v of user[%v/%v] failed, err: %v", info.TokenID, info.Name, info.UserID, err)
+			return err
+		}
+	}
+	if len(info.SessionID) != 0 {
+		err := authdb.RevokeRefreshTokenFamily(o, info.SessionID)
+		if err != nil {
+			glog.Errorf("revoke refresh tokens %v of user[%v/%v] failed, err: %v", info.SessionID, info.Name, info.UserID, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// RevokeUserTokens revokes all the tokens and refresh tokens of the user issued before now
+func RevokeUserTokens(userUUID string) error {
+	return revokeUserTokens(orm.NewOrm(), userUUID)
+}
+
+// revokeUserTokens revokes the tokens issued before now, and all the refresh tokens of the user.
+// The time is saved in seconds as the iat of the tokens, all the tokens issued in this second are revoked
+// except the ones issued after the revocation, such as the token of the login which syncs the ldap user,
+// which are exempted by exemptNewToken
+func revokeUserTokens(o orm.Ormer, userUUID string) error {
+	revokedAt := time.Now().Truncate(time.Second)
+	glog.Infof("revoke all tokens of user[%v] issued before %v", userUUID, revokedAt)
+	err := authdb.RevokeUserTokens(o, userUUID, revokedAt)
+	if err != nil {
+		glog.Errorf("revoke tokens of user[%v] failed, err: %v", userUUID, err)
+		return err
+	}
+	err = authdb.RevokeRefreshTokensByUser(o, userUUID)
+	if err != nil {
+		glog.Errorf("revoke refresh tokens of user[%v] failed, err: %v", userUUID, err)
+		return err
+	}
+	err = authdb.RevokeSessionsByUser(o, userUUID)
+	if err != nil {
+		glog.Errorf("revoke sessions of user[%v] failed, err: %v", userUUID, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+
+	authapi "imanager/pkg/api/auth"
+	authdb "imanager/pkg/db/auth"
+)
+
+func testIssuedToken(user authdb.User, issuedAt time.Time) authapi.RespToken {
+	return authapi.RespToken{
+		TokenID:   uuid.NewV4().String(),
+		UserID:    user.UUID,
+		Name:      user.Name,
+		IssuedAt:  issuedAt,
+		ExpiresAt: issuedAt.Add(time.Hour),
+	}
+}
+
+func TestRevokeUserTokens(t *testing.T) {
+	o := setupTestDB(t)
+	user := createTestUser(t, o, "u1", "g1")
+	other := createTestUser(t, o, "u2", "g1")
+
+	before := testIssuedToken(user, time.Now().Add(-time.Second))
+	if err := RevokeUserTokens(user.UUID); err != nil {
+		t.Fatalf("revoke tokens of user failed, err: %v", err)
+	}
+	revokedAt, err := authdb.GetUserRevokedAt(o, user.UUID)
+	if err != nil {
+		t.Fatalf("get revoked time of user failed, err: %v", err)
+	}
+	// the stateless token issued in the same second before the revocation can't be told from its iat
+	sameSecond := testIssuedToken(user, revokedAt.Add(100*time.Millisecond))
+	// the token of the next login is issued right after the revocation, maybe in the same second
+	after := testIssuedToken(user, revokedAt.Add(200*time.Millisecond))
+	if err = exemptNewToken(&after); err != nil {
+		t.Fatalf("exempt token failed, err: %v", err)
+	}
+	nextSecond := testIssuedToken(user, revokedAt.Add(time.Second))
+
+	cases := []struct {
+		name    string
+		info    authapi.RespToken
+		revoked bool
+	}{
+		{"issued before", before, true},
+		{"issued in the same second before", sameSecond, true},
+		{"issued after", after, false},
+		{"issued in the next second", nextSecond, false},
+		{"other user", testIssuedToken(other, before.IssuedAt), false},
+	}
+	for _, c := range cases {
+		revoked, err := IsTokenRevoked(c.info)
+		if err != nil || revoked != c.revoked {
+			t.Logf("%v: revoked should be %v, got %v, err: %v", c.name, c.revoked, revoked, err)
+			t.Fail()
+		}
+	}
+
+	// the exemption only applies to the revocation which the token is issued after
+	if err = RevokeUserTokens(user.UUID); err != nil {
+		t.Fatalf("revoke tokens of user failed, err: %v", err)
+	}
+	if revoked, err := IsTokenRevoked(after); err != nil || !revoked {
+		t.Logf("exempted token should be revoked again, err: %v", err)
+		t.Fail()
+	}
+}
+
+func TestRevokeToken(t *testing.T) {
+	o := setupTestDB(t)
+	user := createTestUser(t, o, "u1", "g1")
+	now := time.Now()
+	token, sibling := testIssuedToken(user, now), testIssuedToken(user, now)
+	if err := RevokeToken(token); err != nil {
+		t.Fatalf("revoke token failed, err: %v", err)
+	}
+	if revoked, err := IsTokenRevoked(token); err != nil || !revoked {
+		t.Logf("token should be revoked, err: %v", err)
+		t.Fail()
+	}
+	if revoked, err := IsTokenRevoked(sibling); err != nil || revoked {
+		t.Logf("the other token of the user shouldn't be revoked, err: %v", err)
+		t.Fail()
+	}
+
+	// the access tokens of the session are revoked with the session
+	issued, err := CreateRefreshToken(user.UUID, nil)
+	if err != nil {
+		t.Fatalf("create refresh token failed, err: %v", err)
+	}
+	first, second := testIssuedToken(user, now), testIssuedToken(user, now)
+	first.SessionID, second.SessionID = issued.FamilyID, issued.FamilyID
+	if err = CreateSession(&first, 0, "127.0.0.1", "test"); err != nil {
+		t.Fatalf("create session failed, err: %v", err)
+	}
+	if err = RevokeToken(first); err != nil {
+		t.Fatalf("revoke token failed, err: %v", err)
+	}
+	if revoked, err := IsTokenRevoked(second); err != nil || !revoked {
+		t.Logf("token of the revoked session should be revoked, err: %v", err)
+		t.Fail()
+	}
+	if _, _, err = UseRefreshToken(issued.Token); err != ErrInvalidRefreshToken {
+		t.Logf("refresh token of the revoked session should be invalid, err: %v", err)
+		t.Fail()
+	}
+}
+
+func TestRevokeActorTokens(t *testing.T) {
+	o := setupTestDB(t)
+	user := createTestUser(t, o, "u1", "g1")
+	actor := createTestUser(t, o, "admin", "g1")
+	info := testIssuedToken(user, time.Now().Add(-time.Second))
+	info.Act = &authapi.Actor{Subject: actor.UUID, Name: actor.Name}
+
+	if err := RevokeUserTokens(actor.UUID); err != nil {
+		t.Fatalf("revoke tokens of actor failed, err: %v", err)
+	}
+	if revoked, err := IsTokenRevoked(info); err != nil || !revoked {
+		t.Logf("impersonation should end when the tokens of the actor are revoked, err: %v", err)
+		t.Fail()
+	}
+	info.Act = nil
+	if revoked, err := IsTokenRevoked(info); err != nil || revoked {
+		t.Logf("token of the user shouldn't be revoked, err: %v", err)
+		t.Fail()
+	}
+}
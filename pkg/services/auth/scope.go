@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"fmt"
+
+	"github.com/astaxie/beego/orm"
+
+	authapi "imanager/pkg/api/auth"
+	authdb "imanager/pkg/db/auth"
+)
+
+// ValidTokenScope checks the requested scope and returns the scope of the token, nil means no restriction.
+// Only the user with the permissions of all groups can restrict the token to a group other than his own group
+func ValidTokenScope(user *authapi.User, req authapi.ReqTokenScope) (*authapi.TokenScope, error) {
+	scope := &authapi.TokenScope{
+		Actions: req.Actions,
+		Group:   req.Group,
+	}
+	if scope.IsEmpty() {
+		return nil, nil
+	}
+	for _, action := range scope.Actions {
+		if err := authapi.ValidAction(action); err != nil {
+			return nil, err
+		}
+	}
+	if len(scope.Group) == 0 {
+		return scope, nil
+	}
+	permissions, err := GetPermissions(user.Role)
+	if err != nil {
+		return nil, err
+	}
+	if len(permissions.AllGroups) != 0 {
+		_, err := authdb.GetGroupByName(orm.NewOrm(), scope.Group)
+		if err == orm.ErrNoRows {
+			return nil, fmt.Errorf("group %v isn't exist", scope.Group)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return scope, nil
+	}
+	if user.Group == nil || user.Group.Name != scope.Group {
+		return nil, fmt.Errorf("token can only be restricted to the user's group")
+	}
+	return scope, nil
+}
+
+// GetUserGroup returns the group of the user found by name or uuid, the password isn't decrypted
+func GetUserGroup(name, uuid string) (*authapi.GroupInUser, error) {
+	var user authdb.User
+	var err error
+	o := orm.NewOrm()
+	if len(name) != 0 {
+		user, err = authdb.GetUserByName(o, name)
+	} else if len(uuid) != 0 {
+		user, err = authdb.GetUserByUUID(o, uuid)
+	} else {
+		return nil, fmt.Errorf("find user by name or uuid failed")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.Group == nil {
+		return nil, fmt.Errorf("user[%v/%v] has no group", user.Name, user.UUID)
+	}
+	return &authapi.GroupInUser{
+		ID:         user.Group.Id,
+		Name:       user.Group.Name,
+		Annotation: user.Group.Annotation,
+	}, nil
+}
@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/golang/glog"
+
+	authapi "imanager/pkg/api/auth"
+	authdb "imanager/pkg/db/auth"
+)
+
+const (
+	sessionTouchInterval = time.Minute
+	sessionCleanInterval = time.Hour
+
+	userAgentMaxLen = 512
+)
+
+var ErrSessionIdle = errors.New("session is idle for too long, login again")
+
+// checkedSessions records when the sessions were checked by this replica, the session checked in
+// sessionTouchInterval isn't read or updated again. The revoked session is still rejected by IsTokenRevoked
+var checkedSessions = &sessionCache{checkedAt: map[string]time.Time{}}
+
+type sessionCache struct {
+	sync.Mutex
+	checkedAt map[string]time.Time
+}
+
+func (c *sessionCache) isRecent(sessionID string, now time.Time) bool {
+	c.Lock()
+	defer c.Unlock()
+	checkedAt, ok := c.checkedAt[sessionID]
+	return ok && now.Sub(checkedAt) < sessionTouchInterval
+}
+
+func (c *sessionCache) set(sessionID string, now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+	c.checkedAt[sessionID] = now
+}
+
+func (c *sessionCache) remove(sessionID string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.checkedAt, sessionID)
+}
+
+func (c *sessionCache) prune(now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+	for k, v := range c.checkedAt {
+		if now.Sub(v) >= sessionTouchInterval {
+			delete(c.checkedAt, k)
+		}
+	}
+}
+
+func init() {
+	go func() {
+		for range time.Tick(sessionCleanInterval) {
+			checkedSessions.prune(time.Now())
+			num, err := authdb.DeleteExpiredSessions(orm.NewOrm(), time.Now())
+			if err != nil {
+				glog.Errorf("delete expired sessions failed, err: %v", err)
+				continue
+			}
+			glog.Infof("delete %v expired sessions", num)
+		}
+	}()
+}
+
+// CreateSession records the login which issues the token, idleTimeout is in minutes
+func CreateSession(info *authapi.RespToken, idleTimeout int, clientIP, userAgent string) error {
+	if len(userAgent) > userAgentMaxLen {
+		userAgent = userAgent[:userAgentMaxLen]
+	}
+	_, err := authdb.CreateSession(orm.NewOrm(), authdb.Session{
+		SessionID:       info.SessionID,
+		UserUUID:        info.UserID,
+		ClientIP:        clientIP,
+		UserAgent:       userAgent,
+		IssuedAt:        info.IssuedAt,
+		LastSeenAt:      info.IssuedAt,
+		ExpiresAt:       info.IssuedAt.Add(refreshTokenDuration()),
+		AccessExpiresAt: info.ExpiresAt,
+		IdleTimeout:     idleTimeout,
+	})
+	if err != nil {
+		glog.Errorf("create session %v of user[%v/%v] failed, err: %v", info.SessionID, info.Name, info.UserID, err)
+	}
+	return err
+}
+
+// RenewSession updates the session when the token is refreshed
+func RenewSession(info *authapi.RespToken, idleTimeout int, clientIP, userAgent string) error {
+	if len(userAgent) > userAgentMaxLen {
+		userAgent = userAgent[:userAgentMaxLen]
+	}
+	o := orm.NewOrm()
+	session, err := authdb.GetSession(o, info.SessionID)
+	if err == orm.ErrNoRows {
+		// the session of the login before sessions are recorded
+		return CreateSession(info, idleTimeout, clientIP, userAgent)
+	}
+	if err != nil {
+		return err
+	}
+	accessExpiresAt := session.AccessExpiresAt
+	if info.ExpiresAt.After(accessExpiresAt) {
+		accessExpiresAt = info.ExpiresAt
+	}
+	err = authdb.RenewSession(o, info.SessionID, clientIP, userAgent, info.IssuedAt,
+		info.IssuedAt.Add(refreshTokenDuration()), accessExpiresAt, idleTimeout)
+	if err != nil {
+		glog.Errorf("renew session %v of user[%v/%v] failed, err: %v", info.SessionID, info.Name, info.UserID, err)
+	}
+	return err
+}
+
+// useSession ends the session if it's idle for too long, otherwise updates its last seen time.
+// It's called by every request, so the session is checked at most once per sessionTouchInterval
+func useSession(o orm.Ormer, sessionID string) error {
+	now := time.Now()
+	if checkedSessions.isRecent(sessionID, now) {
+		return nil
+	}
+	session, err := authdb.GetSession(o, sessionID)
+	if err == orm.ErrNoRows {
+		// the session of the login before sessions are recorded
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if session.Revoked {
+		return ErrTokenRevoked
+	}
+	if session.IdleTimeout > 0 && now.Sub(session.LastSeenAt) > time.Duration(session.IdleTimeout)*time.Minute {
+		glog.Infof("session %v of user[%v] is idle since %v", sessionID, session.UserUUID, session.LastSeenAt)
+		if err = revokeSession(o, session); err != nil {
+			return err
+		}
+		return ErrSessionIdle
+	}
+	if now.Sub(session.LastSeenAt) >= sessionTouchInterval {
+		err = authdb.TouchSession(o, sessionID, now, sessionTouchInterval)
+		if err != nil {
+			glog.Errorf("update last seen time of session %v failed, err: %v", sessionID, err)
+			return nil
+		}
+	}
+	checkedSessions.set(sessionID, now)
+	return nil
+}
+
+func ListSessions(userName, currentSessionID string) ([]authapi.Session, int64, error) {
+	o := orm.NewOrm()
+	user, err := authdb.GetUserByName(o, userName)
+	if err != nil {
+		return nil, 0, err
+	}
+	sessions, num, err := authdb.ListActiveSessionsByUser(o, user.UUID, time.Now())
+	if err != nil {
+		return nil, 0, err
+	}
+	res := make([]authapi.Session, 0, len(sessions))
+	for _, v := range sessions {
+		res = append(res, authapi.Session{
+			ID:         v.SessionID,
+			ClientIP:   v.ClientIP,
+			UserAgent:  v.UserAgent,
+			IssuedAt:   v.IssuedAt,
+			LastSeenAt: v.LastSeenAt,
+			ExpiresAt:  v.ExpiresAt,
+			Current:    v.SessionID == currentSessionID,
+		})
+	}
+	return res, num, nil
+}
+
+// RevokeSession terminates the session of the user, orm.ErrNoRows is returned if the user has no such session
+func RevokeSession(userName, sessionID string) error {
+	o := orm.NewOrm()
+	user, err := authdb.GetUserByName(o, userName)
+	if err != nil {
+		return err
+	}
+	session, err := authdb.GetSession(o, sessionID)
+	if err != nil {
+		return err
+	}
+	if session.UserUUID != user.UUID {
+		return orm.ErrNoRows
+	}
+	return revokeSession(o, session)
+}
+
+// revokeSession revokes the refresh tokens and all the access tokens issued in the session
+func revokeSession(o orm.Ormer, session authdb.Session) error {
+	glog.Infof("revoke session %v of user[%v]", session.SessionID, session.UserUUID)
+	checkedSessions.remove(session.SessionID)
+	_, err := authdb.CreateRevokedToken(o, authdb.RevokedToken{
+		TokenID:   session.SessionID,
+		UserUUID:  session.UserUUID,
+		RevokedAt: time.Now(),
+		ExpiresAt: session.AccessExpiresAt,
+	})
+	if err != nil {
+		glog.Errorf("revoke access tokens of session %v failed, err: %v", session.SessionID, err)
+		return err
+	}
+	if err = authdb.RevokeRefreshTokenFamily(o, session.SessionID); err != nil {
+		glog.Errorf("revoke refresh tokens of session %v failed, err: %v", session.SessionID, err)
+		return err
+	}
+	return authdb.RevokeSession(o, session.SessionID)
+}
+
+// RevokeAllSessions terminates all the sessions of the user
+func RevokeAllSessions(userName string) error {
+	user, err := authdb.GetUserByName(orm.NewOrm(), userName)
+	if err != nil {
+		return err
+	}
+	return RevokeUserTokens(user.UUID)
+}
@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+
+	authapi "imanager/pkg/api/auth"
+	authdb "imanager/pkg/db/auth"
+)
+
+// createTestSession logs in the user, it returns the refresh token and the access token of the session
+func createTestSession(t *testing.T, user authdb.User, idleTimeout int) (*IssuedRefreshToken, authapi.RespToken) {
+	issued, err := CreateRefreshToken(user.UUID, nil)
+	if err != nil {
+		t.Fatalf("create refresh token failed, err: %v", err)
+	}
+	info := testIssuedToken(user, time.Now())
+	info.SessionID = issued.FamilyID
+	if err = CreateSession(&info, idleTimeout, "127.0.0.1", "test"); err != nil {
+		t.Fatalf("create session failed, err: %v", err)
+	}
+	return issued, info
+}
+
+// setSessionLastSeen moves the last seen time back, the session is removed from checkedSessions
+// as if sessionTouchInterval had passed
+func setSessionLastSeen(t *testing.T, o orm.Ormer, sessionID string, lastSeenAt time.Time) {
+	checkedSessions.remove(sessionID)
+	_, err := o.QueryTable(authdb.Session{}).Filter("session_id", sessionID).Update(orm.Params{
+		"last_seen_at": lastSeenAt,
+	})
+	if err != nil {
+		t.Fatalf("update last seen time of session failed, err: %v", err)
+	}
+}
+
+func TestUseSessionIdle(t *testing.T) {
+	o := setupTestDB(t)
+	user := createTestUser(t, o, "u1", "g1")
+	issued, info := createTestSession(t, user, 5)
+
+	if err := useSession(o, info.SessionID); err != nil {
+		t.Fatalf("active session should be used, err: %v", err)
+	}
+
+	setSessionLastSeen(t, o, info.SessionID, time.Now().Add(-6*time.Minute))
+	if err := useSession(o, info.SessionID); err != ErrSessionIdle {
+		t.Logf("idle session should end, err: %v", err)
+		t.Fail()
+	}
+	// the tokens of the idle session are revoked
+	if revoked, err := IsTokenRevoked(info); err != nil || !revoked {
+		t.Logf("access token of the idle session should be revoked, err: %v", err)
+		t.Fail()
+	}
+	if _, _, err := UseRefreshToken(issued.Token); err != ErrInvalidRefreshToken {
+		t.Logf("refresh token of the idle session should be invalid, err: %v", err)
+		t.Fail()
+	}
+	if err := useSession(o, info.SessionID); err != ErrTokenRevoked {
+		t.Logf("ended session shouldn't be used, err: %v", err)
+		t.Fail()
+	}
+}
+
+func TestUseSessionThrottled(t *testing.T) {
+	o := setupTestDB(t)
+	user := createTestUser(t, o, "u1", "g1")
+	_, info := createTestSession(t, user, 5)
+
+	lastSeenAt := time.Now().Add(-2 * time.Minute).Truncate(time.Second)
+	setSessionLastSeen(t, o, info.SessionID, lastSeenAt)
+	if err := useSession(o, info.SessionID); err != nil {
+		t.Fatalf("active session should be used, err: %v", err)
+	}
+	session, err := authdb.GetSession(o, info.SessionID)
+	if err != nil || !session.LastSeenAt.After(lastSeenAt) {
+		t.Fatalf("last seen time should be updated: %v, err: %v", session.LastSeenAt, err)
+	}
+
+	// the session checked just now isn't read or updated again
+	_, err = o.QueryTable(authdb.Session{}).Filter("session_id", info.SessionID).Update(orm.Params{
+		"last_seen_at": lastSeenAt,
+	})
+	if err != nil {
+		t.Fatalf("update last seen time of session failed, err: %v", err)
+	}
+	if err = useSession(o, info.SessionID); err != nil {
+		t.Fatalf("active session should be used, err: %v", err)
+	}
+	if session, err = authdb.GetSession(o, info.SessionID); err != nil || !session.LastSeenAt.Equal(lastSeenAt) {
+		t.Logf("last seen time shouldn't be updated in the touch interval: %v, err: %v", session.LastSeenAt, err)
+		t.Fail()
+	}
+}
+
+func TestUseSessionWithoutIdleTimeout(t *testing.T) {
+	o := setupTestDB(t)
+	user := createTestUser(t, o, "u1", "g1")
+	_, info := createTestSession(t, user, 0)
+
+	setSessionLastSeen(t, o, info.SessionID, time.Now().Add(-24*time.Hour))
+	if err := useSession(o, info.SessionID); err != nil {
+		t.Logf("session without idle timeout should never be idle, err: %v", err)
+		t.Fail()
+	}
+	// the session of the login before sessions are recorded
+	if err := useSession(o, "unknown"); err != nil {
+		t.Logf("unknown session should be ignored, err: %v", err)
+		t.Fail()
+	}
+}
+
+func TestRefreshIdleSession(t *testing.T) {
+	o := setupTestDB(t)
+	user := createTestUser(t, o, "u1", "g1")
+	issued, info := createTestSession(t, user, 5)
+
+	setSessionLastSeen(t, o, info.SessionID, time.Now().Add(-6*time.Minute))
+	if _, _, err := UseRefreshToken(issued.Token); err != ErrSessionIdle {
+		t.Logf("refresh token of the idle session should be rejected, err: %v", err)
+		t.Fail()
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	o := setupTestDB(t)
+	u1 := createTestUser(t, o, "u1", "g1")
+	createTestUser(t, o, "u2", "g1")
+	issued, info := createTestSession(t, u1, 0)
+	_, other := createTestSession(t, u1, 0)
+
+	sessions, num, err := ListSessions("u1", info.SessionID)
+	if err != nil || num != 2 {
+		t.Fatalf("user should have 2 sessions: %+v, err: %v", sessions, err)
+	}
+	for _, v := range sessions {
+		if v.Current != (v.ID == info.SessionID) {
+			t.Logf("only the session of the token should be current: %+v", v)
+			t.Fail()
+		}
+	}
+
+	if err = RevokeSession("u2", info.SessionID); err != orm.ErrNoRows {
+		t.Logf("session of the other user shouldn't be revoked, err: %v", err)
+		t.Fail()
+	}
+	if err = RevokeSession("u1", info.SessionID); err != nil {
+		t.Fatalf("revoke session failed, err: %v", err)
+	}
+	if revoked, err := IsTokenRevoked(info); err != nil || !revoked {
+		t.Logf("access token of the revoked session should be revoked, err: %v", err)
+		t.Fail()
+	}
+	if _, _, err = UseRefreshToken(issued.Token); err != ErrInvalidRefreshToken {
+		t.Logf("refresh token of the revoked session should be invalid, err: %v", err)
+		t.Fail()
+	}
+	if revoked, err := IsTokenRevoked(other); err != nil || revoked {
+		t.Logf("the other session shouldn't be revoked, err: %v", err)
+		t.Fail()
+	}
+	if sessions, num, err = ListSessions("u1", ""); err != nil || num != 1 || sessions[0].ID != other.SessionID {
+		t.Logf("revoked session shouldn't be listed: %+v, err: %v", sessions, err)
+		t.Fail()
+	}
+}
@@ -1,38 +1,212 @@
 package auth
 
 import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/golang/glog"
+	uuid "github.com/satori/go.uuid"
 
 	authapi "imanager/pkg/api/auth"
+	"imanager/pkg/config"
+	"imanager/pkg/encrypt"
 )
 
-var mySigningKey = []byte("token.secret")
+const (
+	// the kid of the key which signs new tokens, the largest kid is used if it's empty
+	signingKeyIDKey = "JWTSigningKeyID"
+	tokenIssuerKey  = "TokenIssuer"
+	// comma separated audiences of the tokens
+	tokenAudienceKey = "TokenAudience"
 
-func CreateToken(info authapi.RespToken) (tokenss string, err error) {
+	defaultTokenIssuer   = "imanager"
+	defaultTokenAudience = "imanager"
+
+	keyReloadInterval = time.Minute
+)
+
+var signingKeys = &keySet{}
+
+type keySet struct {
+	sync.RWMutex
+	active *encrypt.SigningKey
+	keys   map[string]*encrypt.SigningKey
+	// the kid order of keys, used to keep the jwks stable
+	ids []string
+}
+
+func init() {
+	if err := signingKeys.reload(); err != nil {
+		glog.Errorf("load token signing keys failed, err: %v", err)
+	}
+	go func() {
+		for range time.Tick(keyReloadInterval) {
+			if err := signingKeys.reload(); err != nil {
+				glog.Errorf("reload token signing keys failed, err: %v", err)
+			}
+		}
+	}()
+}
+
+// ReloadSigningKeys loads the keys at once instead of waiting for the next reload, such as after the key dir is changed
+func ReloadSigningKeys() error {
+	return signingKeys.reload()
+}
+
+func (s *keySet) reload() error {
+	keys, err := encrypt.LoadSigningKeys()
+	if err != nil {
+		return err
+	}
+	activeID := config.GetConfig().String(signingKeyIDKey)
+
+	var active *encrypt.SigningKey
+	m := make(map[string]*encrypt.SigningKey, len(keys))
+	ids := make([]string, 0, len(keys))
+	for k := range keys {
+		key := &keys[k]
+		m[key.ID] = key
+		ids = append(ids, key.ID)
+		if !key.CanSign() {
+			continue
+		}
+		if activeID == "" || key.ID == activeID {
+			// keys are sorted by id, so the last one is the largest
+			active = key
+		}
+	}
+	if active == nil {
+		glog.Errorf("no signing key is found in %v, the kid in config is %q", encrypt.SigningKeyDir, activeID)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if active != nil && (s.active == nil || s.active.ID != active.ID) {
+		glog.Infof("token signing key is changed to %v/%v", active.ID, active.Method.Alg())
+	}
+	s.active, s.keys, s.ids = active, m, ids
+	return nil
+}
+
+func (s *keySet) signingKey() (*encrypt.SigningKey, error) {
+	s.RLock()
+	defer s.RUnlock()
+	if s.active == nil {
+		return nil, errors.New("no signing key is available")
+	}
+	return s.active, nil
+}
+
+func (s *keySet) verifyingKey(id string) (*encrypt.SigningKey, error) {
+	s.RLock()
+	defer s.RUnlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", id)
+	}
+	return key, nil
+}
+
+func (s *keySet) publicKeys() []*encrypt.SigningKey {
+	s.RLock()
+	defer s.RUnlock()
+	res := make([]*encrypt.SigningKey, 0, len(s.ids))
+	for _, id := range s.ids {
+		res = append(res, s.keys[id])
+	}
+	return res
+}
+
+func TokenIssuer() string {
+	issuer := config.GetConfig().String(tokenIssuerKey)
+	if len(issuer) == 0 {
+		return defaultTokenIssuer
+	}
+	return issuer
+}
+
+func tokenAudience() []string {
+	audience := config.GetConfig().String(tokenAudienceKey)
+	if len(audience) == 0 {
+		return []string{defaultTokenAudience}
+	}
+	res := make([]string, 0)
+	for _, v := range strings.Split(audience, ",") {
+		if v = strings.TrimSpace(v); len(v) != 0 {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// CreateToken signs the info, the token id, issuer and audience of info are filled if they are empty
+func CreateToken(info *authapi.RespToken) (tokenss string, err error) {
+	key, err := signingKeys.signingKey()
+	if err != nil {
+		return "", err
+	}
+	if len(info.TokenID) == 0 {
+		info.TokenID = uuid.NewV4().String()
+	}
+	if err = exemptNewToken(info); err != nil {
+		return "", err
+	}
+	if len(info.Issuer) == 0 {
+		info.Issuer = TokenIssuer()
+	}
+	if len(info.Audience) == 0 {
+		info.Audience = tokenAudience()
+	}
 	//自定义claim
 	claim := jwt.MapClaims{
 		"info": info,
+		"jti":  info.TokenID,
+		"iss":  info.Issuer,
+		"aud":  info.Audience,
+		"sub":  info.UserID,
 		"nbf":  info.IssuedAt.Unix(),
 		"iat":  info.IssuedAt.Unix(),
 		"exp":  info.ExpiresAt.Unix(),
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claim)
+	if info.Act != nil {
+		claim["act"] = info.Act
+	}
+	token := jwt.NewWithClaims(key.Method, claim)
+	token.Header["kid"] = key.ID
 
-	tokenss, err = token.SignedString(mySigningKey)
+	tokenss, err = token.SignedString(key.PrivateKey)
 	return
 }
 
+func keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if len(kid) == 0 {
+		return nil, errors.New("kid is empty")
+	}
+	key, err := signingKeys.verifyingKey(kid)
+	if err != nil {
+		return nil, err
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %v for key %v", token.Method.Alg(), kid)
+	}
+	return key.PublicKey, nil
+}
+
 func ParseToken(tokenss string) (authapi.RespToken, error) {
 	if len(tokenss) == 0 {
 		return authapi.RespToken{}, fmt.Errorf("token is empty")
 	}
-	token, err := jwt.Parse(tokenss, func(token *jwt.Token) (interface{}, error) {
-		return mySigningKey, nil
-	})
+	token, err := jwt.Parse(tokenss, keyFunc)
 	if err != nil {
 		return authapi.RespToken{}, err
 	}
@@ -47,6 +221,10 @@ func ParseToken(tokenss string) (authapi.RespToken, error) {
 		return authapi.RespToken{}, err
 	}
 
+	// the other jwt signed by imanager, such as the id token and the password reset token, has no info
+	if claim["info"] == nil {
+		return authapi.RespToken{}, errors.New("token has no info")
+	}
 	body, err := json.Marshal(claim["info"])
 	if err != nil {
 		return authapi.RespToken{}, err
@@ -60,3 +238,41 @@ func ParseToken(tokenss string) (authapi.RespToken, error) {
 
 	return res, nil
 }
+
+// GetJSONWebKeySet returns the public keys which can verify the tokens, including the retired ones
+func GetJSONWebKeySet() authapi.JSONWebKeySet {
+	keys := signingKeys.publicKeys()
+	res := authapi.JSONWebKeySet{Keys: make([]authapi.JSONWebKey, 0, len(keys))}
+	for _, key := range keys {
+		jwk := authapi.JSONWebKey{
+			Use: "sig",
+			Kid: key.ID,
+			Alg: key.Method.Alg(),
+		}
+		switch k := key.PublicKey.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (k.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = k.Curve.Params().Name
+			jwk.X = base64.RawURLEncoding.EncodeToString(padBytes(k.X.Bytes(), size))
+			jwk.Y = base64.RawURLEncoding.EncodeToString(padBytes(k.Y.Bytes(), size))
+		default:
+			continue
+		}
+		res.Keys = append(res.Keys, jwk)
+	}
+	return res
+}
+
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	res := make([]byte, size)
+	copy(res[size-len(b):], b)
+	return res
+}
@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+
+	authapi "imanager/pkg/api/auth"
+	"imanager/pkg/encrypt"
+)
+
+// useTestSigningKeys replaces the signing keys, the last key which can sign is the active one
+func useTestSigningKeys(t *testing.T, keys ...encrypt.SigningKey) {
+	old := &keySet{active: signingKeys.active, keys: signingKeys.keys, ids: signingKeys.ids}
+	s := &keySet{keys: map[string]*encrypt.SigningKey{}}
+	for i := range keys {
+		key := &keys[i]
+		s.keys[key.ID] = key
+		s.ids = append(s.ids, key.ID)
+		if key.CanSign() {
+			s.active = key
+		}
+	}
+	signingKeys.Lock()
+	signingKeys.active, signingKeys.keys, signingKeys.ids = s.active, s.keys, s.ids
+	signingKeys.Unlock()
+	t.Cleanup(func() {
+		signingKeys.Lock()
+		signingKeys.active, signingKeys.keys, signingKeys.ids = old.active, old.keys, old.ids
+		signingKeys.Unlock()
+	})
+}
+
+func testRSAKey(t *testing.T, id string) encrypt.SigningKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate rsa key failed, err: %v", err)
+	}
+	return encrypt.SigningKey{ID: id, Method: jwt.SigningMethodRS256, PrivateKey: key, PublicKey: &key.PublicKey}
+}
+
+func testECKey(t *testing.T, id string) encrypt.SigningKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate ecdsa key failed, err: %v", err)
+	}
+	return encrypt.SigningKey{ID: id, Method: jwt.SigningMethodES256, PrivateKey: key, PublicKey: &key.PublicKey}
+}
+
+func testTokenInfo() *authapi.RespToken {
+	now := time.Now()
+	return &authapi.RespToken{
+		UserID:    "uuid1",
+		Name:      "u1",
+		IssuedAt:  now,
+		ExpiresAt: now.Add(time.Hour),
+		Group:     &authapi.GroupInUser{ID: 1, Name: "g1"},
+		Role:      []authapi.RoleInUser{{ID: 3, Name: "user"}},
+	}
+}
+
+func TestCreateAndParseToken(t *testing.T) {
+	for _, key := range []encrypt.SigningKey{testRSAKey(t, "1"), testECKey(t, "2")} {
+		useTestSigningKeys(t, key)
+		tokenss, err := CreateToken(testTokenInfo())
+		if err != nil {
+			t.Fatalf("create token by %v failed, err: %v", key.Method.Alg(), err)
+		}
+		token, _ := jwt.Parse(tokenss, keyFunc)
+		if token == nil || token.Header["alg"] != key.Method.Alg() || token.Header["kid"] != key.ID {
+			t.Logf("token should be signed by %v/%v", key.ID, key.Method.Alg())
+			t.Fail()
+		}
+		info, err := ParseToken(tokenss)
+		if err != nil || info.Name != "u1" || info.UserID != "uuid1" || len(info.TokenID) == 0 ||
+			info.Issuer != TokenIssuer() || info.Group == nil || info.Group.Name != "g1" {
+			t.Logf("parse token of %v failed, info: %+v, err: %v", key.Method.Alg(), info, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestParseTokenWithRotatedKeys(t *testing.T) {
+	oldKey, newKey := testRSAKey(t, "1"), testECKey(t, "2")
+	useTestSigningKeys(t, oldKey)
+	oldToken, err := CreateToken(testTokenInfo())
+	if err != nil {
+		t.Fatalf("create token failed, err: %v", err)
+	}
+
+	// the retired key only verifies the tokens
+	retired := oldKey
+	retired.PrivateKey = nil
+	useTestSigningKeys(t, retired, newKey)
+	if _, err = ParseToken(oldToken); err != nil {
+		t.Logf("token of the retired key should be valid, err: %v", err)
+		t.Fail()
+	}
+	newToken, err := CreateToken(testTokenInfo())
+	if err != nil {
+		t.Fatalf("create token failed, err: %v", err)
+	}
+	if token, _ := jwt.Parse(newToken, keyFunc); token == nil || token.Header["kid"] != "2" {
+		t.Logf("new token should be signed by the new key")
+		t.Fail()
+	}
+
+	// the token of the removed key is invalid
+	useTestSigningKeys(t, newKey)
+	if _, err = ParseToken(oldToken); err == nil {
+		t.Logf("token of the removed key should be invalid")
+		t.Fail()
+	}
+}
+
+func TestParseTokenRejectsOtherAlgorithm(t *testing.T) {
+	key := testRSAKey(t, "1")
+	useTestSigningKeys(t, key)
+	// a token signed by hmac with the public key must not be accepted
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"info": testTokenInfo()})
+	token.Header["kid"] = key.ID
+	tokenss, err := token.SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatalf("sign token failed, err: %v", err)
+	}
+	if _, err = ParseToken(tokenss); err == nil {
+		t.Logf("token of the other algorithm should be rejected")
+		t.Fail()
+	}
+	token = jwt.NewWithClaims(key.Method, jwt.MapClaims{"info": testTokenInfo()})
+	if tokenss, err = token.SignedString(key.PrivateKey); err != nil {
+		t.Fatalf("sign token failed, err: %v", err)
+	}
+	if _, err = ParseToken(tokenss); err == nil {
+		t.Logf("token without kid should be rejected")
+		t.Fail()
+	}
+}
+
+func TestGetJSONWebKeySet(t *testing.T) {
+	rsaKey, ecKey := testRSAKey(t, "1"), testECKey(t, "2")
+	useTestSigningKeys(t, rsaKey, ecKey)
+	jwks := GetJSONWebKeySet()
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("jwks should have 2 keys: %+v", jwks)
+	}
+
+	rsaJWK := jwks.Keys[0]
+	n, _ := base64.RawURLEncoding.DecodeString(rsaJWK.N)
+	e, _ := base64.RawURLEncoding.DecodeString(rsaJWK.E)
+	rsaPublic := rsaKey.PublicKey.(*rsa.PublicKey)
+	if rsaJWK.Kid != "1" || rsaJWK.Kty != "RSA" || rsaJWK.Alg != "RS256" || rsaJWK.Use != "sig" ||
+		new(big.Int).SetBytes(n).Cmp(rsaPublic.N) != 0 || new(big.Int).SetBytes(e).Int64() != int64(rsaPublic.E) {
+		t.Logf("invalid rsa jwk: %+v", rsaJWK)
+		t.Fail()
+	}
+
+	ecJWK := jwks.Keys[1]
+	x, _ := base64.RawURLEncoding.DecodeString(ecJWK.X)
+	y, _ := base64.RawURLEncoding.DecodeString(ecJWK.Y)
+	ecPublic := ecKey.PublicKey.(*ecdsa.PublicKey)
+	if ecJWK.Kid != "2" || ecJWK.Kty != "EC" || ecJWK.Alg != "ES256" || ecJWK.Crv != "P-256" ||
+		len(x) != 32 || len(y) != 32 || new(big.Int).SetBytes(x).Cmp(ecPublic.X) != 0 || new(big.Int).SetBytes(y).Cmp(ecPublic.Y) != 0 {
+		t.Logf("invalid ec jwk: %+v", ecJWK)
+		t.Fail()
+	}
+	if len(ecJWK.N) != 0 || len(rsaJWK.X) != 0 {
+		t.Logf("jwk shouldn't have the fields of the other key type")
+		t.Fail()
+	}
+}
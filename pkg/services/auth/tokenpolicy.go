@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+
+	authapi "imanager/pkg/api/auth"
+	"imanager/pkg/config"
+	authdb "imanager/pkg/db/auth"
+)
+
+const (
+	// minutes, the token policy for all users, the group and the roles of the user can make it stricter
+	tokenDefaultDurationKey = "TokenDefaultDuration"
+	tokenMaxDurationKey     = "TokenMaxDuration"
+	tokenIdleTimeoutKey     = "TokenIdleTimeout"
+	defaultTokenMaxDuration = 24 * 60
+	// reject the token request over the max duration instead of clamping it
+	rejectTokenDurationOverMaxKey = "RejectTokenDurationOverMax"
+)
+
+// GetTokenPolicy merges the token policies of the config, the user's group and roles, the strictest one wins
+func GetTokenPolicy(user *authapi.User) (authapi.EffectiveTokenPolicy, error) {
+	res := authapi.EffectiveTokenPolicy{
+		TokenPolicy: authapi.TokenPolicy{
+			DefaultDuration: getPositiveInt(tokenDefaultDurationKey, authapi.DefaultExpireTime),
+			MaxDuration:     getPositiveInt(tokenMaxDurationKey, defaultTokenMaxDuration),
+			IdleTimeout:     getPositiveInt(tokenIdleTimeoutKey, 0),
+		},
+	}
+	res.RejectOverMax, _ = config.GetConfig().Bool(rejectTokenDurationOverMaxKey)
+
+	o := orm.NewOrm()
+	if user.Group != nil {
+		group, err := authdb.GetGroupByID(o, user.Group.ID)
+		if err != nil {
+			return res, err
+		}
+		res.Restrict(transformTokenPolicyDB2API(group.TokenDefaultDuration, group.TokenMaxDuration, group.TokenIdleTimeout))
+	}
+	roleIDs := make([]int, 0, len(user.Role))
+	for _, v := range user.Role {
+		roleIDs = append(roleIDs, v.ID)
+	}
+	roles, err := authdb.ListRoleByIDs(o, roleIDs)
+	if err != nil {
+		return res, err
+	}
+	for _, v := range roles {
+		res.Restrict(transformTokenPolicyDB2API(v.TokenDefaultDuration, v.TokenMaxDuration, v.TokenIdleTimeout))
+	}
+	return res, nil
+}
+
+// GetUserTokenPolicy returns the token policy applied to the user
+func GetUserTokenPolicy(name string) (authapi.EffectiveTokenPolicy, error) {
+	user, err := authdb.GetUserByName(orm.NewOrm(), name)
+	if err != nil {
+		return authapi.EffectiveTokenPolicy{}, err
+	}
+	user.Password = ""
+	userAPI := transformUserDB2API(user)
+	return GetTokenPolicy(&userAPI)
+}
+
+// TokenDuration returns the duration of the token for the requested minutes, an error is returned
+// if the requested duration is over the max duration and the policy rejects it
+func TokenDuration(policy authapi.EffectiveTokenPolicy, requested int) (time.Duration, error) {
+	duration, exceeded := policy.Duration(requested)
+	if exceeded && policy.RejectOverMax {
+		return 0, fmt.Errorf("token duration %v is longer than the max duration %v", requested, policy.MaxDuration)
+	}
+	return time.Duration(duration) * authapi.BaseDuration, nil
+}
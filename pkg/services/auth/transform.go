@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"strings"
+
 	authapi "imanager/pkg/api/auth"
 	apiutil "imanager/pkg/api/util"
 	authdb "imanager/pkg/db/auth"
@@ -15,11 +17,14 @@ func transformUserDB2API(in authdb.User) authapi.User {
 		TruthName: in.TruthName,
 		Email:     in.Email,
 		PhoneNum:  in.PhoneNum,
+		Source:    in.Source,
 		Role:      make([]authapi.RoleInUser, 0, len(in.Role)),
 		BaseModel: apiutil.BaseModel{
 			CreateTimestamp: in.CreateTimestamp,
 			UpdateTimestamp: in.UpdateTimestamp,
 		},
+		PasswordChangedAt:  in.PasswordChangedAt,
+		MustChangePassword: in.MustChangePassword,
 	}
 	if in.Group != nil {
 		res.Group = &authapi.GroupInUser{
@@ -46,11 +51,14 @@ func transformUserAPI2DB(in authapi.User) authdb.User {
 		TruthName: in.TruthName,
 		Email:     in.Email,
 		PhoneNum:  in.PhoneNum,
+		Source:    in.Source,
 		Role:      make([]*authdb.Role, 0, len(in.Role)),
 		BaseModel: dbutil.BaseModel{
 			CreateTimestamp: in.CreateTimestamp,
 			UpdateTimestamp: in.UpdateTimestamp,
 		},
+		// the changed time is only set when the password is changed
+		MustChangePassword: in.MustChangePassword,
 	}
 	if in.Group != nil {
 		res.Group = &authdb.Group{
@@ -79,14 +87,17 @@ func transformUserDBs2APIs(input []authdb.User) []authapi.User {
 
 func transformGroupDB2API(in authdb.Group) authapi.Group {
 	res := authapi.Group{
-		ID:         in.Id,
-		Name:       in.Name,
-		Annotation: in.Annotation,
-		Builtin:    in.Builtin,
+		ID:          in.Id,
+		Name:        in.Name,
+		Annotation:  in.Annotation,
+		Builtin:     in.Builtin,
+		MFAPolicy:   in.MFAPolicy,
+		TokenPolicy: transformTokenPolicyDB2API(in.TokenDefaultDuration, in.TokenMaxDuration, in.TokenIdleTimeout),
 		BaseModel: apiutil.BaseModel{
 			CreateTimestamp: in.CreateTimestamp,
 			UpdateTimestamp: in.UpdateTimestamp,
 		},
+		PasswordMaxAge: in.PasswordMaxAge,
 	}
 	if in.Role != nil && len(in.Role) != 0 {
 		res.Role = make([]authapi.RoleInGroup, 0, len(in.Role))
@@ -117,10 +128,17 @@ func transformGroupAPI2DB(in authapi.Group) authdb.Group {
 		Name:       in.Name,
 		Annotation: in.Annotation,
 		Builtin:    in.Builtin,
+		MFAPolicy:  in.MFAPolicy,
 		BaseModel: dbutil.BaseModel{
 			CreateTimestamp: in.CreateTimestamp,
 			UpdateTimestamp: in.UpdateTimestamp,
 		},
+		PasswordMaxAge: in.PasswordMaxAge,
+	}
+	if in.TokenPolicy != nil {
+		res.TokenDefaultDuration = in.TokenPolicy.DefaultDuration
+		res.TokenMaxDuration = in.TokenPolicy.MaxDuration
+		res.TokenIdleTimeout = in.TokenPolicy.IdleTimeout
 	}
 	for in.User != nil && len(in.User) != 0 {
 		res.User = make([]*authdb.User, 0, len(in.User))
@@ -154,19 +172,26 @@ func transformGroupDBs2APIs(in []authdb.Group) []authapi.Group {
 }
 
 func transformRoleDB2API(in authdb.Role) authapi.Role {
-	return authapi.Role{
-		ID:         in.Id,
-		Name:       in.Name,
-		Annotation: in.Annotation,
+	res := authapi.Role{
+		ID:          in.Id,
+		Name:        in.Name,
+		Annotation:  in.Annotation,
+		TokenPolicy: transformTokenPolicyDB2API(in.TokenDefaultDuration, in.TokenMaxDuration, in.TokenIdleTimeout),
 		BaseModel: apiutil.BaseModel{
 			CreateTimestamp: in.CreateTimestamp,
 			UpdateTimestamp: in.UpdateTimestamp,
 		},
+		Permissions: splitFields(in.Permissions, " "),
+		AllGroups:   in.AllGroups,
 	}
+	if in.PermissionsSet {
+		return res
+	}
+	return res.WithBuiltinPermissions()
 }
 
 func transformRoleAPI2DB(in authapi.Role) authdb.Role {
-	return authdb.Role{
+	res := authdb.Role{
 		Id:         in.ID,
 		Name:       in.Name,
 		Annotation: in.Annotation,
@@ -174,7 +199,17 @@ func transformRoleAPI2DB(in authapi.Role) authdb.Role {
 			CreateTimestamp: in.CreateTimestamp,
 			UpdateTimestamp: in.UpdateTimestamp,
 		},
+		Permissions: strings.Join(in.Permissions, " "),
+		AllGroups:   in.AllGroups,
+		// nil permissions aren't changed, the empty ones remove all permissions
+		PermissionsSet: in.Permissions != nil,
+	}
+	if in.TokenPolicy != nil {
+		res.TokenDefaultDuration = in.TokenPolicy.DefaultDuration
+		res.TokenMaxDuration = in.TokenPolicy.MaxDuration
+		res.TokenIdleTimeout = in.TokenPolicy.IdleTimeout
 	}
+	return res
 }
 
 func transformRoleDBs2APIs(in []authdb.Role) []authapi.Role {
@@ -184,3 +219,15 @@ func transformRoleDBs2APIs(in []authdb.Role) []authapi.Role {
 	}
 	return res
 }
+
+func transformTokenPolicyDB2API(defaultDuration, maxDuration, idleTimeout int) *authapi.TokenPolicy {
+	res := &authapi.TokenPolicy{
+		DefaultDuration: defaultDuration,
+		MaxDuration:     maxDuration,
+		IdleTimeout:     idleTimeout,
+	}
+	if res.IsEmpty() {
+		return nil
+	}
+	return res
+}
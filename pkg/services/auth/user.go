@@ -3,8 +3,8 @@ package auth
 import (
 	"errors"
 	"fmt"
-	"regexp"
 	"strings"
+	"time"
 
 	"github.com/astaxie/beego/orm"
 	"github.com/golang/glog"
@@ -14,6 +14,7 @@ import (
 	"imanager/pkg/api/dataselect"
 	authdb "imanager/pkg/db/auth"
 	"imanager/pkg/encrypt"
+	"imanager/pkg/passwordpolicy"
 	"imanager/pkg/services/util"
 )
 
@@ -26,19 +27,28 @@ var (
 func ValidUserPasswordAndGetRoles(name, password string) (bool, *authapi.User, error) {
 	o := orm.NewOrm()
 	user, err := authdb.GetUserByName(o, name)
-	if err == orm.ErrNoRows {
-		return false, nil, nil
-	}
-	if err != nil {
+	if err != nil && err != orm.ErrNoRows {
 		return false, nil, err
 	}
-	user.Password, err = encrypt.Decrypt(user.Password, encrypt.CpabeType, encrypt.OpServiceRole)
+	if isLDAPEnabled() {
+		if err == orm.ErrNoRows {
+			return validLDAPUser(o, name, password, nil)
+		}
+		if user.Source == authapi.LDAPUserSource {
+			return validLDAPUser(o, name, password, &user)
+		}
+	}
+	if err == orm.ErrNoRows || user.Source != authapi.LocalUserSource {
+		return false, nil, nil
+	}
+	isValid, err := verifyUserPassword(o, user, password)
 	if err != nil {
-		return false, nil, fmt.Errorf("decrypt failed, %v", err)
+		return false, nil, fmt.Errorf("verify password failed, %v", err)
 	}
-	if user.Password != password {
+	if !isValid {
 		return false, nil, nil
 	}
+	user.Password = ""
 	out := transformUserDB2API(user)
 
 	return true, &out, nil
@@ -49,35 +59,28 @@ func IsAllowUserUpdate(user *authapi.User, info *authapi.RespToken) error {
 	o := orm.NewOrm()
 
 	// 用户角色校验
-	var userUpPermission, infoUpPermission = authapi.UserRole, authapi.UserRole
-	for _, v := range user.Role {
-		tmp, err := authdb.GetRoleByID(o, v.ID)
-		if err != nil {
-			return fmt.Errorf("get role from db failed, %v", err)
-		}
-		if !userUpPermission.IsLargerPermission(authapi.RoleType(tmp.Id)) {
-			userUpPermission = authapi.RoleType(tmp.Id)
-		}
+	infoPermissions, err := GetPermissions(info.Role)
+	if err != nil {
+		return fmt.Errorf("get permissions of roles failed, %v", err)
 	}
-	for _, v := range info.Role {
-		if !infoUpPermission.IsLargerPermission(authapi.RoleType(v.ID)) {
-			infoUpPermission = authapi.RoleType(v.ID)
-		}
+	userPermissions, err := GetPermissions(user.Role)
+	if err != nil {
+		return fmt.Errorf("get permissions of roles failed, %v", err)
 	}
-	if !infoUpPermission.IsLargerPermission(userUpPermission) {
+	if !infoPermissions.Covers(userPermissions) {
 		return errors.New("no permission to modify role")
 	}
 
 	// 组校验
-	if infoUpPermission == authapi.OpServiceRole || user.Group == nil {
+	if infoPermissions.Allows(authapi.UserResource, authapi.UpdateVerb, false) || user.Group == nil {
 		return nil
 	}
 
 	var oldUser authdb.User
-	if len(user.Name) != 0 {
-		oldUser, err = authdb.GetUserByName(o, user.Name)
-	} else if len(user.UUID) != 0 {
+	if len(user.UUID) != 0 {
 		oldUser, err = authdb.GetUserByUUID(o, user.UUID)
+	} else if len(user.Name) != 0 {
+		oldUser, err = authdb.GetUserByName(o, user.Name)
 	}
 	if err != nil {
 		return fmt.Errorf("get user detail failed, %v", err)
@@ -106,7 +109,7 @@ func GetUserByName(name string) (*authapi.User, error) {
 		return nil, err
 	}
 	//user.Password = ""
-	user.Password, err = encrypt.Decrypt(user.Password, encrypt.CpabeType, encrypt.OpServiceRole)
+	user.Password, err = decryptReversiblePassword(user.Password)
 	if err != nil {
 		glog.Errorf("decrypt password failed for %v/%v, err: %v", user.Name, user.UUID, err)
 		return nil, err
@@ -116,82 +119,156 @@ func GetUserByName(name string) (*authapi.User, error) {
 }
 
 func UpdateUser(user *authapi.User) (*authapi.User, error) {
+	o := orm.NewOrm()
+	err := o.Begin()
+	if err != nil {
+		return user, err
+	}
+	userDB, err := updateUser(o, user)
+	if err != nil {
+		_ = o.Rollback()
+		return nil, err
+	}
+	_ = o.Commit()
+
+	//userDB.Password = ""
+	userDB.Password, err = decryptReversiblePassword(userDB.Password)
+	if err != nil {
+		glog.Errorf("decrypt password failed for %v/%v, err: %v", user.Name, user.UUID, err)
+		return nil, err
+	}
+	newUser := transformUserDB2API(userDB)
+	return &newUser, nil
+}
+
+// updateUser updates the user in the transaction of o, the caller rolls back if an error is returned
+func updateUser(o orm.Ormer, user *authapi.User) (authdb.User, error) {
 	var err error
 	newPassword := user.Password
+	var passwordHash, reversiblePassword string
 	if len(user.Password) != 0 {
-		user.Password, err = encrypt.Encrypt(user.Password, encrypt.CpabeType, encrypt.OpServiceRole)
+		passwordHash, reversiblePassword, err = hashPassword(user.Password)
 		if err != nil {
-			glog.Errorf("encrypt password failed for %v/%v, err: %v", user.Name, user.UUID, err)
-			return nil, err
+			glog.Errorf("hash password failed for %v/%v, err: %v", user.Name, user.UUID, err)
+			return authdb.User{}, err
 		}
+		// the password columns are set after the user is patched, so that the reversible one can be cleared
+		user.Password = ""
 	}
 
 	userDB := transformUserAPI2DB(*user)
-	o := orm.NewOrm()
-	err = o.Begin()
-	if err != nil {
-		return user, err
-	}
-
 	var oldUser authdb.User
 	if userDB.UUID != "" {
 		oldUser, err = authdb.GetUserByUUID(o, userDB.UUID)
 	} else if userDB.Name != "" {
 		oldUser, err = authdb.GetUserByName(o, userDB.Name)
 	} else {
-		_ = o.Commit()
 		glog.Errorf("find user by name or uuid failed")
-		return user, fmt.Errorf("find user by name or uuid failed")
+		return authdb.User{}, fmt.Errorf("find user by name or uuid failed")
 	}
 	if err != nil {
-		_ = o.Rollback()
-		return user, err
+		return authdb.User{}, err
+	}
+
+	if oldUser.Source != authapi.LocalUserSource && len(newPassword) != 0 {
+		return authdb.User{}, ErrExternalUserPassword
 	}
 
 	err = util.Patch(&oldUser, &userDB)
 	if err != nil {
-		_ = o.Rollback()
-		return user, err
+		return authdb.User{}, err
 	}
+	// the source can't be changed by api
+	userDB.Source = oldUser.Source
 	if len(userDB.Role) == 0 {
 		userDB.Role = oldUser.Role
 	}
 
 	userDB, err = authdb.UpdateUser(o, userDB)
 	if err != nil {
-		_ = o.Rollback()
 		glog.Errorf("update user[%v/%v] failed, err: %v", user.Name, user.UUID, err)
-		return nil, err
+		return authdb.User{}, err
+	}
+	if len(newPassword) != 0 {
+		err = authdb.UpdateUserPassword(o, userDB.UUID, passwordHash, reversiblePassword)
+		if err != nil {
+			glog.Errorf("update password of user[%v/%v] failed, err: %v", user.Name, user.UUID, err)
+			return authdb.User{}, err
+		}
+		userDB.PasswordHash, userDB.Password = passwordHash, reversiblePassword
+		// the password reset by the others should be changed by the user at next login
+		userDB.PasswordChangedAt, userDB.MustChangePassword = time.Now(), user.MustChangePassword
+		err = authdb.UpdateUserPasswordChanged(o, userDB.UUID, userDB.PasswordChangedAt, userDB.MustChangePassword)
+		if err != nil {
+			glog.Errorf("update password changed time of user[%v/%v] failed, err: %v", user.Name, user.UUID, err)
+			return authdb.User{}, err
+		}
 	}
 
-	// update in harbor
-	userForHarbor := transformUserDB2API(userDB)
-	oldUserForHarbor := transformUserDB2API(oldUser)
+	// the tokens carry the old roles and group, or the password is leaked
+	if len(newPassword) != 0 || isRoleOrGroupChanged(oldUser, userDB) {
+		err = revokeUserTokens(o, userDB.UUID)
+		if err != nil {
+			return authdb.User{}, err
+		}
+		err = revokePersonalAccessTokens(o, userDB.UUID)
+		if err != nil {
+			return authdb.User{}, err
+		}
+	}
 	if len(newPassword) != 0 {
-		userForHarbor.Password = newPassword
-		oldUserForHarbor.Password, err = encrypt.Decrypt(oldUserForHarbor.Password, encrypt.CpabeType, encrypt.OpServiceRole)
+		// the password reset links sent before are useless now
+		err = authdb.DeletePasswordResetTokensByUser(o, userDB.UUID)
 		if err != nil {
-			glog.Errorf("encrypt old user password for harbor failed for %v/%v, err: %v", user.Name, user.UUID, err)
-			return nil, err
+			return authdb.User{}, err
+		}
+		err = recordPasswordHistory(o, userDB.UUID, oldUser.PasswordHash)
+		if err != nil {
+			glog.Errorf("record password history of user[%v/%v] failed, err: %v", user.Name, user.UUID, err)
+			return authdb.User{}, err
 		}
 	}
-	err = updateUserInHarbor(&oldUserForHarbor, &userForHarbor)
-	if err != nil {
-		glog.Errorf("update user in harbor failed, err: %v", err)
-		_ = o.Rollback()
-		return user, err
+
+	// update in harbor, the external users aren't created in harbor, see createExternalUser
+	if oldUser.Source == authapi.LocalUserSource {
+		userForHarbor := transformUserDB2API(userDB)
+		oldUserForHarbor := transformUserDB2API(oldUser)
+		if len(newPassword) != 0 {
+			userForHarbor.Password = newPassword
+			// the old password is empty if it isn't kept, then it's always updated in harbor
+			oldUserForHarbor.Password, err = decryptReversiblePassword(oldUserForHarbor.Password)
+			if err != nil {
+				glog.Errorf("encrypt old user password for harbor failed for %v/%v, err: %v", user.Name, user.UUID, err)
+				return authdb.User{}, err
+			}
+		}
+		err = updateUserInHarbor(&oldUserForHarbor, &userForHarbor)
+		if err != nil {
+			glog.Errorf("update user in harbor failed, err: %v", err)
+			return authdb.User{}, err
+		}
 	}
 
-	_ = o.Commit()
+	return userDB, nil
+}
 
-	//userDB.Password = ""
-	userDB.Password, err = encrypt.Decrypt(userDB.Password, encrypt.CpabeType, encrypt.OpServiceRole)
-	if err != nil {
-		glog.Errorf("decrypt password failed for %v/%v, err: %v", user.Name, user.UUID, err)
-		return nil, err
+func isRoleOrGroupChanged(oldUser, user authdb.User) bool {
+	if oldUser.Group != nil && user.Group != nil && oldUser.Group.Id != user.Group.Id {
+		return true
 	}
-	newUser := transformUserDB2API(userDB)
-	return &newUser, nil
+	if len(oldUser.Role) != len(user.Role) {
+		return true
+	}
+	roleIDs := make(map[int]bool, len(oldUser.Role))
+	for _, v := range oldUser.Role {
+		roleIDs[v.Id] = true
+	}
+	for _, v := range user.Role {
+		if !roleIDs[v.Id] {
+			return true
+		}
+	}
+	return false
 }
 
 func DeleteUserByName(name string) error {
@@ -202,20 +279,62 @@ func DeleteUserByName(name string) error {
 		return err
 	}
 
+	user, err := authdb.GetUserByName(o, name)
+	if err != nil {
+		_ = o.Rollback()
+		return err
+	}
 	err = authdb.DeleteUserByName(o, name)
 	if err != nil {
 		glog.Errorf("delete user in db failed, user: %v, err: %v", name, err)
 		_ = o.Rollback()
 		return err
 	}
-
-	err = deleteUserInHarbor(name)
+	err = revokeUserTokens(o, user.UUID)
+	if err != nil {
+		_ = o.Rollback()
+		return err
+	}
+	err = authdb.DeletePersonalAccessTokensByUser(o, user.UUID)
 	if err != nil {
-		glog.Errorf("delete user in harbor failed, user: %v, err: %v", name, err)
+		glog.Errorf("delete personal access tokens of user[%v] failed, err: %v", name, err)
+		_ = o.Rollback()
+		return err
+	}
+	err = authdb.DeleteUserMFA(o, user.UUID)
+	if err != nil {
+		glog.Errorf("delete mfa of user[%v] failed, err: %v", name, err)
+		_ = o.Rollback()
+		return err
+	}
+	err = authdb.DeletePasswordHistoryByUser(o, user.UUID)
+	if err != nil {
+		glog.Errorf("delete password history of user[%v] failed, err: %v", name, err)
+		_ = o.Rollback()
+		return err
+	}
+	err = authdb.DeleteLoginFailure(o, userLoginSubjectPrefix+name)
+	if err != nil {
+		glog.Errorf("delete login failures of user[%v] failed, err: %v", name, err)
+		_ = o.Rollback()
+		return err
+	}
+	err = authdb.DeleteSessionsByUser(o, user.UUID)
+	if err != nil {
+		glog.Errorf("delete sessions of user[%v] failed, err: %v", name, err)
 		_ = o.Rollback()
 		return err
 	}
 
+	if user.Source == authapi.LocalUserSource {
+		err = deleteUserInHarbor(name)
+		if err != nil {
+			glog.Errorf("delete user in harbor failed, user: %v, err: %v", name, err)
+			_ = o.Rollback()
+			return err
+		}
+	}
+
 	_ = o.Commit()
 	return nil
 }
@@ -223,9 +342,10 @@ func DeleteUserByName(name string) error {
 func CreateUser(user *authapi.User) (*authapi.User, error) {
 	var err error
 	user.UUID = uuid.NewV4().String()
-	encryptPassword, err := encrypt.Encrypt(user.Password, encrypt.CpabeType, encrypt.OpServiceRole)
+	user.Source = authapi.LocalUserSource
+	passwordHash, reversiblePassword, err := hashPassword(user.Password)
 	if err != nil {
-		glog.Errorf("encrypt password failed for %v/%v, err: %v", user.Name, user.UUID, err)
+		glog.Errorf("hash password failed for %v/%v, err: %v", user.Name, user.UUID, err)
 		return nil, err
 	}
 
@@ -245,7 +365,9 @@ func CreateUser(user *authapi.User) (*authapi.User, error) {
 
 
 	userDB := transformUserAPI2DB(*user)
-	userDB.Password = encryptPassword
+	userDB.Password = reversiblePassword
+	userDB.PasswordHash = passwordHash
+	userDB.PasswordChangedAt = time.Now()
 	userDB, err = authdb.CreateUser(o, userDB)
 	if err != nil {
 		_ = o.Rollback()
@@ -284,8 +406,6 @@ func ListUserByUserID(userIDs []string, query *dataselect.DataSelectQuery) ([]au
 	return res, nums, nil
 }
 
-var PasswordRegexp  = "^[a-zA-Z0-9_-]{8,18}$"
-
 func InitUser(name string) (*authapi.User, error) {
 	var err error
 	o := orm.NewOrm()
@@ -299,26 +419,36 @@ func InitUser(name string) (*authapi.User, error) {
 		glog.Errorf("get user from db failed, name: %v, err: %v", name, err)
 		return nil, err
 	}
-	isMatch, _ := regexp.MatchString(PasswordRegexp, user.Password)
-	if !isMatch {
+	// the password is initialized as plain text in db, the format of the policy tells it from the encrypted one
+	policy := GetPasswordPolicy()
+	format := &passwordpolicy.Policy{MinLength: policy.MinLength, MaxLength: policy.MaxLength, Symbols: policy.Symbols}
+	if len(user.PasswordHash) != 0 || format.Check(user.Password, "") != nil {
 		_ = o.Rollback()
-		return nil, fmt.Errorf("user password doesn't match the format, maybe it already was encrypted, please check it in db")
+		return nil, fmt.Errorf("user password doesn't match the password policy, maybe it already was encrypted, please check it in db")
 	}
-	user.Password, err = encrypt.Encrypt(user.Password, encrypt.CpabeType, encrypt.OpServiceRole)
+	passwordHash, reversiblePassword, err := hashPassword(user.Password)
 	if err != nil {
 		_ = o.Rollback()
-		glog.Errorf("encrypt password failed for %v/%v, err: %v", user.Name, user.UUID, err)
+		glog.Errorf("hash password failed for %v/%v, err: %v", user.Name, user.UUID, err)
 		return nil, err
 	}
 	if len(user.UUID) == 0 {
 		user.UUID = uuid.NewV4().String()
 	}
+	user.PasswordChangedAt = time.Now()
 	user, err = authdb.UpdateUser(o, user)
 	if err != nil {
 		_ = o.Rollback()
 		glog.Errorf("update user[%v/%v] failed, err: %v", user.Name, user.UUID, err)
 		return nil, err
 	}
+	err = authdb.UpdateUserPassword(o, user.UUID, passwordHash, reversiblePassword)
+	if err != nil {
+		_ = o.Rollback()
+		glog.Errorf("update password of user[%v/%v] failed, err: %v", user.Name, user.UUID, err)
+		return nil, err
+	}
+	user.Password = ""
 	userApi := transformUserDB2API(user)
 	_ = o.Commit()
 	return &userApi, nil
@@ -337,13 +467,18 @@ func UnInitUser(name string) (*authapi.User, error) {
 		glog.Errorf("get user from db failed, name: %v, err: %v", name, err)
 		return nil, err
 	}
+	if len(user.Password) == 0 {
+		_ = o.Rollback()
+		return nil, ErrNoReversiblePassword
+	}
 	user.Password, err = encrypt.Decrypt(user.Password, encrypt.CpabeType, encrypt.OpServiceRole)
 	if err != nil {
 		_ = o.Rollback()
 		glog.Errorf("encrypt password failed for %v/%v, err: %v", user.Name, user.UUID, err)
 		return nil, err
 	}
-	user, err = authdb.UpdateUser(o, user)
+	// the plain password is checked by InitUser, the hash is made again then
+	err = authdb.UpdateUserPassword(o, user.UUID, "", user.Password)
 	if err != nil {
 		_ = o.Rollback()
 		glog.Errorf("update user[%v/%v] failed, err: %v", user.Name, user.UUID, err)
@@ -361,6 +496,9 @@ func GetUserSecret(name string) (*authapi.UserSecret, error) {
 		glog.Errorf("get user from db failed, name: %v, err: %v", name, err)
 		return nil, err
 	}
+	if len(user.Password) == 0 {
+		return nil, ErrNoReversiblePassword
+	}
 	user.Password, err = encrypt.Decrypt(user.Password, encrypt.CpabeType, encrypt.OpServiceRole)
 	if err != nil {
 		glog.Errorf("decrypt password failed for %v/%v, err: %v", user.Name, user.UUID, err)
@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/astaxie/beego/orm"
+
+	authapi "imanager/pkg/api/auth"
+	authdb "imanager/pkg/db/auth"
+)
+
+// the external users aren't in harbor, which isn't configured in the tests
+func TestUpdateAndDeleteExternalUser(t *testing.T) {
+	o := setupTestDB(t)
+	user := createTestUser(t, o, "u1", "g1")
+	user.Source = authapi.OIDCUserSource
+	if _, err := o.Update(&user, "source"); err != nil {
+		t.Fatalf("update source of user failed, err: %v", err)
+	}
+
+	updated, err := UpdateUser(&authapi.User{UUID: user.UUID, Email: "u1@example.com"})
+	if err != nil || updated.Email != "u1@example.com" {
+		t.Fatalf("update external user failed: %+v, err: %v", updated, err)
+	}
+	if err = DeleteUserByName("u1"); err != nil {
+		t.Fatalf("delete external user failed, err: %v", err)
+	}
+	if _, err = authdb.GetUserByName(o, "u1"); err != orm.ErrNoRows {
+		t.Logf("external user should be deleted, err: %v", err)
+		t.Fail()
+	}
+}
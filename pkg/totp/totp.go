@@ -0,0 +1,95 @@
+// Package totp implements the time-based one-time password in rfc6238 with the defaults of
+// the authenticator apps: HMAC-SHA1, 6 digits and 30 seconds period
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	Period = 30
+	Digits = 6
+	// the number of periods before and after now which are accepted, to tolerate the clock drift
+	Skew = 1
+
+	secretSize = 20
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a random base32 encoded secret
+func GenerateSecret() (string, error) {
+	b := make([]byte, secretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// Step returns the time step of t
+func Step(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// GenerateCode returns the code of the secret at the time step
+func GenerateCode(secret string, step int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", fmt.Errorf("invalid secret, %v", err)
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, value%mod), nil
+}
+
+// Validate checks the code at t, the code of the step which isn't after lastStep is rejected so that
+// a code can't be replayed. The matched step is returned
+func Validate(secret, code string, t time.Time, lastStep int64) (int64, bool) {
+	if len(code) != Digits {
+		return 0, false
+	}
+	now := Step(t)
+	for step := now - Skew; step <= now+Skew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		expected, err := GenerateCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// ProvisioningURI returns the otpauth uri which is shown as qr code to the authenticator apps
+func ProvisioningURI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(Period))
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
@@ -0,0 +1,73 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// the sha1 secret in rfc6238 appendix B
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestGenerateCode(t *testing.T) {
+	cases := []struct {
+		unix   int64
+		expect string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, c := range cases {
+		code, err := GenerateCode(rfcSecret, Step(time.Unix(c.unix, 0)))
+		if err != nil || code != c.expect {
+			t.Logf("time: %v, expect: %v, got: %v, err: %v", c.unix, c.expect, code, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, _ := GenerateCode(rfcSecret, Step(now))
+	step, ok := Validate(rfcSecret, code, now, 0)
+	if !ok || step != Step(now) {
+		t.Logf("code of now should be valid, step: %v, ok: %v", step, ok)
+		t.Fail()
+	}
+	if _, ok = Validate(rfcSecret, code, now, step); ok {
+		t.Logf("used code should not be valid again")
+		t.Fail()
+	}
+	if _, ok = Validate(rfcSecret, code, now.Add(Period*time.Second), 0); !ok {
+		t.Logf("code of last period should be valid")
+		t.Fail()
+	}
+	if _, ok = Validate(rfcSecret, code, now.Add(3*Period*time.Second), 0); ok {
+		t.Logf("code out of skew should not be valid")
+		t.Fail()
+	}
+	if _, ok = Validate(rfcSecret, "12345", now, 0); ok {
+		t.Logf("code with wrong length should not be valid")
+		t.Fail()
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Logf("generate secret failed, %v", err)
+		t.Fail()
+		return
+	}
+	if _, err = GenerateCode(secret, 1); err != nil {
+		t.Logf("generated secret %v can't generate code, %v", secret, err)
+		t.Fail()
+	}
+	uri := ProvisioningURI("imanager", "alice", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/imanager:alice?") || !strings.Contains(uri, "secret="+secret) {
+		t.Logf("unexpected provisioning uri %v", uri)
+		t.Fail()
+	}
+}